@@ -0,0 +1,59 @@
+package auth
+
+import (
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"time"
+)
+
+// AuditEntry 审计记录
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Method  string    `json:"method"`
+	Applet  string    `json:"applet"`
+	Action  string    `json:"action"`
+	Role    Role      `json:"role"`
+	Remote  string    `json:"remote"`
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+type Auditor interface {
+	Audit(entry AuditEntry)
+}
+
+// LogAuditor 使用logrus输出审计日志
+type LogAuditor struct {
+	logger *log.Logger
+}
+
+func (a *LogAuditor) Audit(e AuditEntry) {
+	entry := a.logger.WithFields(log.Fields{
+		"audit":   true,
+		"subject": e.Subject,
+		"method":  e.Method,
+		"sandbox": e.Applet,
+		"action":  e.Action,
+		"role":    e.Role.String(),
+		"remote":  e.Remote,
+		"allowed": e.Allowed,
+	}).WithTime(e.Time)
+	if e.Allowed {
+		entry.Info("audit")
+	} else {
+		entry.WithField("reason", e.Reason).Warn("audit")
+	}
+}
+
+// DefaultAuditor 写入标准日志
+var DefaultAuditor Auditor = &LogAuditor{logger: log.StandardLogger()}
+
+// NewFileAuditor 审计日志单独写入文件(json格式)
+func NewFileAuditor(file string) Auditor {
+	logger := log.New()
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.SetOutput(&lumberjack.Logger{Filename: file, MaxSize: 100})
+	logger.SetLevel(log.InfoLevel)
+	return &LogAuditor{logger: logger}
+}
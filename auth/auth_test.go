@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/base64"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"lightbox/ext/cryptlib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles([]interface{}{"viewer", "billing:deployer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles.Of("billing") != RoleDeployer || roles.Of("other") != RoleViewer {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	roles, err = ParseRoles(map[string]interface{}{"*": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles.Of("any") != RoleAdmin {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	if _, err = ParseRoles("root"); err == nil {
+		t.Error("unknown role should be rejected")
+	}
+}
+
+type recordAuditor []AuditEntry
+
+func (r *recordAuditor) Audit(entry AuditEntry) {
+	*r = append(*r, entry)
+}
+
+func TestGuard(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("secret"))
+	cfg := &Config{
+		Tokens: []TokenConfig{{Subject: "ci", Token: "t1", Roles: "billing:deployer"}},
+		JWT:    &JWTConfig{Key: key},
+	}
+	a, err := cfg.Authenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &recordAuditor{}
+	g := NewGuard(a).WithAuditor(audit)
+	router := mux.NewRouter()
+	router.Handle("/applet/{sandbox}/exec", g.Protect(RoleDeployer, "exec", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := FromContext(r.Context())
+		_, _ = w.Write([]byte(id.Subject))
+	})))
+	viewerToken, err := cryptlib.JWTSigning("HS256", key, jwt.MapClaims{"sub": "bob", "roles": []string{"viewer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		token string
+		code  int
+	}{
+		{"/applet/billing/exec", "", http.StatusUnauthorized},
+		{"/applet/billing/exec", "bad", http.StatusUnauthorized},
+		{"/applet/billing/exec", "t1", http.StatusOK},
+		{"/applet/orders/exec", "t1", http.StatusForbidden},
+		{"/applet/billing/exec", viewerToken, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s with %q: expect %d got %d", tt.path, tt.token, tt.code, w.Code)
+		}
+	}
+	if len(*audit) != len(tests) {
+		t.Errorf("expect %d audit entries, got %d", len(tests), len(*audit))
+	}
+}
+
+func TestNilGuard(t *testing.T) {
+	var g *Guard
+	w := httptest.NewRecorder()
+	g.Protect(RoleViewer, "log", http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("nil guard should deny, got %d", w.Code)
+	}
+}
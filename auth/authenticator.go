@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"lightbox/ext/cryptlib"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoCredential      = errors.New("no credential")
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Identity 认证后的调用者身份
+type Identity struct {
+	Subject string `json:"subject"`
+	Method  string `json:"method"`
+	Roles   Roles  `json:"roles"`
+}
+
+func (i *Identity) Can(applet string, role Role) bool {
+	if i == nil {
+		return false
+	}
+	return i.Roles.Of(applet) >= role
+}
+
+// Authenticator 认证器,凭据不存在时返回 ErrNoCredential,以便继续尝试下一个认证器
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// Chain 依次尝试多个认证器
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredential) {
+			continue
+		}
+		return id, err
+	}
+	return nil, ErrNoCredential
+}
+
+// bearerToken 从Authorization头或者access_token参数中读取令牌(websocket客户端不方便设置header)
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+			return strings.TrimSpace(h[7:])
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// TokenAuthenticator 静态API令牌
+type TokenAuthenticator struct {
+	tokens map[string]*Identity
+}
+
+func NewTokenAuthenticator(tokens ...TokenConfig) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{tokens: map[string]*Identity{}}
+	for _, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token of %s is empty", t.Subject)
+		}
+		roles, err := ParseRoles(t.Roles)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens[t.Token] = &Identity{Subject: t.Subject, Method: "token", Roles: roles}
+	}
+	return a, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" || strings.Count(token, ".") == 2 {
+		//JWT 交给JWTAuthenticator处理
+		return nil, ErrNoCredential
+	}
+	for k, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(token)) == 1 {
+			return id, nil
+		}
+	}
+	return nil, ErrInvalidCredential
+}
+
+// JWTAuthenticator 使用与cryptlib jwt.parse 相同的方式校验JWT
+type JWTAuthenticator struct {
+	JWTConfig
+}
+
+func NewJWTAuthenticator(cfg JWTConfig) (*JWTAuthenticator, error) {
+	if cfg.Key == "" {
+		return nil, errors.New("jwt key is empty")
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = "sub"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	return &JWTAuthenticator{JWTConfig: cfg}, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredential
+	}
+	claims, err := cryptlib.JWTParseWithBase64Key(token, a.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %v", ErrInvalidCredential, claims["iss"])
+	}
+	roles, err := ParseRoles(claims[a.RolesClaim])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+	}
+	subject, _ := claims[a.SubjectClaim].(string)
+	return &Identity{Subject: subject, Method: "jwt", Roles: roles}, nil
+}
+
+// MTLSAuthenticator 使用客户端证书的CommonName识别身份(证书链已经由tls.Config校验)
+type MTLSAuthenticator struct {
+	identities map[string]*Identity
+}
+
+func NewMTLSAuthenticator(clients ...ClientCertConfig) (*MTLSAuthenticator, error) {
+	a := &MTLSAuthenticator{identities: map[string]*Identity{}}
+	for _, c := range clients {
+		roles, err := ParseRoles(c.Roles)
+		if err != nil {
+			return nil, err
+		}
+		a.identities[c.CommonName] = &Identity{Subject: c.CommonName, Method: "mtls", Roles: roles}
+	}
+	return a, nil
+}
+
+func (a *MTLSAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredential
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if id, ok := a.identities[cn]; ok {
+		return id, nil
+	}
+	return nil, fmt.Errorf("%w: unknown client certificate %s", ErrInvalidCredential, cn)
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext 获取请求中已认证的身份
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+type TokenConfig struct {
+	Subject string      `json:"subject" yaml:"subject"`
+	Token   string      `json:"token" yaml:"token"`
+	Roles   interface{} `json:"roles" yaml:"roles"`
+}
+
+type JWTConfig struct {
+	//Key base64编码的签名密钥(与cryptlib jwt.parse 一致)
+	Key          string `json:"key" yaml:"key"`
+	Issuer       string `json:"issuer" yaml:"issuer"`
+	SubjectClaim string `json:"subject_claim" yaml:"subject_claim"`
+	RolesClaim   string `json:"roles_claim" yaml:"roles_claim"`
+}
+
+type ClientCertConfig struct {
+	CommonName string      `json:"common_name" yaml:"common_name"`
+	Roles      interface{} `json:"roles" yaml:"roles"`
+}
+
+type MTLSConfig struct {
+	//ClientCA 校验客户端证书的CA文件(PEM)
+	ClientCA string             `json:"client_ca" yaml:"client_ca"`
+	Clients  []ClientCertConfig `json:"clients" yaml:"clients"`
+}
+
+// Config 认证配置,通常从yml文件中加载
+type Config struct {
+	Tokens         []TokenConfig `json:"tokens" yaml:"tokens"`
+	JWT            *JWTConfig    `json:"jwt" yaml:"jwt"`
+	MTLS           *MTLSConfig   `json:"mtls" yaml:"mtls"`
+	AuditFile      string        `json:"audit_file" yaml:"audit_file"`
+	AllowedOrigins []string      `json:"allowed_origins" yaml:"allowed_origins"`
+}
+
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse auth config %s error:%w", file, err)
+	}
+	return cfg, nil
+}
+
+// Authenticator 根据配置创建认证器链
+func (c *Config) Authenticator() (Authenticator, error) {
+	var chain Chain
+	if c.MTLS != nil && len(c.MTLS.Clients) > 0 {
+		a, err := NewMTLSAuthenticator(c.MTLS.Clients...)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if c.JWT != nil {
+		a, err := NewJWTAuthenticator(*c.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if len(c.Tokens) > 0 {
+		a, err := NewTokenAuthenticator(c.Tokens...)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no authenticator configured")
+	}
+	return chain, nil
+}
+
+// TLSConfig 配置了mTLS时,返回要求(并校验)客户端证书的tls.Config
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if c.MTLS == nil || c.MTLS.ClientCA == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(c.MTLS.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", c.MTLS.ClientCA)
+	}
+	//不强制要求证书,未提供证书的客户端仍然可以使用令牌认证
+	return &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}, nil
+}
+
+// Guard 根据配置创建Guard
+func (c *Config) Guard() (*Guard, error) {
+	a, err := c.Authenticator()
+	if err != nil {
+		return nil, err
+	}
+	auditor := DefaultAuditor
+	if c.AuditFile != "" {
+		auditor = NewFileAuditor(c.AuditFile)
+	}
+	return NewGuard(a).WithAuditor(auditor), nil
+}
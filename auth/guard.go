@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"lightbox/httputil"
+	"net/http"
+	"time"
+)
+
+const sandboxName = "sandbox"
+
+// Guard 认证并按applet授权http请求
+type Guard struct {
+	authenticator Authenticator
+	auditor       Auditor
+}
+
+func NewGuard(authenticator Authenticator) *Guard {
+	return &Guard{authenticator: authenticator, auditor: DefaultAuditor}
+}
+
+func (g *Guard) WithAuditor(auditor Auditor) *Guard {
+	g.auditor = auditor
+	return g
+}
+
+// Protect 要求调用者在当前applet(路由变量 sandbox)上至少拥有role角色
+// 未配置认证器的Guard拒绝所有请求
+func (g *Guard) Protect(role Role, action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		applet := mux.Vars(r)[sandboxName]
+		entry := AuditEntry{
+			Time:   time.Now(),
+			Applet: applet,
+			Action: action,
+			Role:   role,
+			Remote: r.RemoteAddr,
+		}
+		var (
+			id  *Identity
+			err = ErrNoCredential
+		)
+		if g != nil && g.authenticator != nil {
+			id, err = g.authenticator.Authenticate(r)
+		}
+		if err != nil {
+			entry.Reason = err.Error()
+			g.audit(entry)
+			code := http.StatusUnauthorized
+			if !errors.Is(err, ErrNoCredential) && !errors.Is(err, ErrInvalidCredential) {
+				code = http.StatusInternalServerError
+			}
+			httputil.WriteJSON(w, code, &httputil.JSONResponse[interface{}]{Code: code, Message: err.Error()})
+			return
+		}
+		entry.Subject, entry.Method = id.Subject, id.Method
+		if !id.Can(applet, role) {
+			entry.Reason = "require role " + role.String()
+			g.audit(entry)
+			httputil.WriteJSON(w, http.StatusForbidden, &httputil.JSONResponse[interface{}]{
+				Code:    http.StatusForbidden,
+				Message: "permission denied, " + entry.Reason,
+			})
+			return
+		}
+		entry.Allowed = true
+		//只读操作不记录审计日志
+		if role >= RoleDeployer {
+			g.audit(entry)
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// Middleware mux中间件形式的Protect
+func (g *Guard) Middleware(role Role, action string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return g.Protect(role, action, next)
+	}
+}
+
+func (g *Guard) audit(entry AuditEntry) {
+	if g != nil && g.auditor != nil {
+		g.auditor.Audit(entry)
+	} else {
+		DefaultAuditor.Audit(entry)
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role 角色，数值越大权限越高(admin > deployer > viewer)
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleDeployer
+	RoleAdmin
+)
+
+// AnyApplet 匹配所有applet的作用域
+const AnyApplet = "*"
+
+var (
+	roleNameMap = map[Role]string{
+		RoleNone:     "none",
+		RoleViewer:   "viewer",
+		RoleDeployer: "deployer",
+		RoleAdmin:    "admin",
+	}
+	roleValueMap = map[string]Role{
+		"none":     RoleNone,
+		"viewer":   RoleViewer,
+		"deployer": RoleDeployer,
+		"admin":    RoleAdmin,
+	}
+)
+
+func ParseRole(name string) (Role, error) {
+	if r, ok := roleValueMap[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return r, nil
+	}
+	return RoleNone, fmt.Errorf("unknown role:%s", name)
+}
+
+func (r Role) String() string {
+	if s, ok := roleNameMap[r]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	if s, ok := roleNameMap[r]; ok {
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unknown role value:%d", r)
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	v, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// Roles applet名称到角色的映射,`*`表示所有applet
+type Roles map[string]Role
+
+// Of 返回指定applet上的角色(取applet角色与全局角色中较高者)
+func (r Roles) Of(applet string) Role {
+	role := r[AnyApplet]
+	if applet != "" {
+		if ar, ok := r[applet]; ok && ar > role {
+			role = ar
+		}
+	}
+	return role
+}
+
+// ParseRoles 解析角色声明,支持 map{applet:role} 以及 ["applet:role","role"] 两种格式
+func ParseRoles(v interface{}) (Roles, error) {
+	roles := Roles{}
+	switch rv := v.(type) {
+	case nil:
+	case string:
+		return ParseRoles(strings.Split(rv, ","))
+	case []string:
+		for _, s := range rv {
+			if err := roles.add(s); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for _, itm := range rv {
+			s, ok := itm.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid role declaration:%v", itm)
+			}
+			if err := roles.add(s); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for applet, itm := range rv {
+			s, ok := itm.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid role of %s:%v", applet, itm)
+			}
+			role, err := ParseRole(s)
+			if err != nil {
+				return nil, err
+			}
+			roles[applet] = role
+		}
+	case map[string]string:
+		for applet, s := range rv {
+			role, err := ParseRole(s)
+			if err != nil {
+				return nil, err
+			}
+			roles[applet] = role
+		}
+	default:
+		return nil, fmt.Errorf("unsupported role declaration:%T", v)
+	}
+	return roles, nil
+}
+
+func (r Roles) add(decl string) error {
+	decl = strings.TrimSpace(decl)
+	if decl == "" {
+		return nil
+	}
+	applet, name := AnyApplet, decl
+	if idx := strings.LastIndex(decl, ":"); idx > 0 {
+		applet, name = decl[:idx], decl[idx+1:]
+	}
+	role, err := ParseRole(name)
+	if err != nil {
+		return err
+	}
+	if role > r[applet] {
+		r[applet] = role
+	}
+	return nil
+}
@@ -71,33 +71,10 @@ func All() map[string]interface{} {
 	return result
 }
 
+// Parse 使用getter解析src中的占位符(语法见Interpolate)
 func Parse(src string, getter func(any) (string, bool)) (string, error) {
 	envOnce.Do(parseEnv)
-	var newSrc []rune
-	var start int
-	var end int
-	srcRune := []rune(src)
-	for idx := 0; idx < len(srcRune); idx++ {
-		r := srcRune[idx]
-		if r == '{' {
-			start = idx + 1
-		} else if r == '}' {
-			end = idx
-		} else {
-			if start == 0 {
-				newSrc = append(newSrc, r)
-			}
-		}
-		if end > start {
-			envName := src[start:end]
-			if envVal, ok := getter(envName); ok {
-				newSrc = append(newSrc, ([]rune)(envVal)...)
-			} else {
-				newSrc = append(newSrc, ([]rune)("!"+envName)...)
-			}
-			start = 0
-			end = 0
-		}
-	}
-	return string(newSrc), nil
+	return Interpolate(src, func(name string) (any, bool) {
+		return getter(name)
+	})
 }
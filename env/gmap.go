@@ -38,32 +38,8 @@ func (e *Environment) GetTyped(name, typeName string) (any, bool) {
 	k := fmt.Sprintf(typeKeyFmt, typeName, name)
 	return e.Load(k)
 }
+
+// Parse 使用环境中的变量解析src中的占位符(语法见Interpolate)
 func (e *Environment) Parse(src string) (string, error) {
-	var newSrc []rune
-	var start int
-	var end int
-	srcRune := []rune(src)
-	for idx := 0; idx < len(srcRune); idx++ {
-		r := srcRune[idx]
-		if r == '{' {
-			start = idx + 1
-		} else if r == '}' {
-			end = idx
-		} else {
-			if start == 0 {
-				newSrc = append(newSrc, r)
-			}
-		}
-		if end > start {
-			envName := src[start:end]
-			if envVal, ok := e.Get(envName); ok {
-				newSrc = append(newSrc, ([]rune)(envVal.(string))...)
-			} else {
-				newSrc = append(newSrc, ([]rune)("!"+envName)...)
-			}
-			start = 0
-			end = 0
-		}
-	}
-	return string(newSrc), nil
+	return Interpolate(src, e.Get)
 }
@@ -0,0 +1,253 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// 占位符语法:
+//
+//	{name}          变量的值,非字符串的值使用fmt格式化
+//	{name:-default} 变量不存在或为空时使用默认值
+//	{name:?message} 变量不存在或为空时返回错误
+//	{{ }}           转义为{ },占位符内的}总是结束占位符
+//	{db.{profile}}  嵌套,先解析内层的占位符;名称中的.可以访问map类型的值
+//
+// 默认值及错误信息中也可以使用占位符,只有在使用时才解析
+
+// ErrUndefined 变量未定义
+var ErrUndefined = errors.New("undefined variable")
+
+// UndefinedError 变量未定义或{name:?message}
+type UndefinedError struct {
+	Name    string
+	Message string
+}
+
+func (e *UndefinedError) Error() string {
+	if e.Message != "" {
+		return e.Name + ": " + e.Message
+	}
+	return "undefined variable " + e.Name
+}
+
+func (e *UndefinedError) Is(target error) bool {
+	return target == ErrUndefined
+}
+
+// SyntaxError 占位符语法错误
+type SyntaxError struct {
+	Src string
+	Pos int //字符位置
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("parse %q at %d: %s", e.Src, e.Pos, e.Msg)
+}
+
+type node struct {
+	text string
+	name []node //占位符的名称,为nil时为文本
+	op   rune   //0,'-','?'
+	arg  []node
+	pos  int
+}
+
+type parser struct {
+	src []rune
+	pos int
+}
+
+func (p *parser) peek(offset int) rune {
+	if p.pos+offset < len(p.src) {
+		return p.src[p.pos+offset]
+	}
+	return 0
+}
+
+// parse 解析文本,inside为true时遇到}或:-/:?返回(由调用者处理)
+func (p *parser) parse(inside, inName bool) ([]node, error) {
+	var (
+		nodes []node
+		text  []rune
+	)
+	flush := func() {
+		if len(text) > 0 {
+			nodes = append(nodes, node{text: string(text)})
+			text = nil
+		}
+	}
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		switch {
+		case r == '{' && p.peek(1) == '{':
+			text = append(text, '{')
+			p.pos += 2
+		case r == '{':
+			flush()
+			n, err := p.placeholder()
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, n)
+		case r == '}' && inside:
+			flush()
+			return nodes, nil
+		case r == '}' && p.peek(1) == '}':
+			text = append(text, '}')
+			p.pos += 2
+		case r == ':' && inName && (p.peek(1) == '-' || p.peek(1) == '?'):
+			flush()
+			return nodes, nil
+		default:
+			text = append(text, r)
+			p.pos++
+		}
+	}
+	flush()
+	return nodes, nil
+}
+
+func (p *parser) placeholder() (node, error) {
+	n := node{pos: p.pos}
+	p.pos++
+	var err error
+	if n.name, err = p.parse(true, true); err != nil {
+		return n, err
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == ':' {
+		n.op = p.src[p.pos+1]
+		p.pos += 2
+		if n.arg, err = p.parse(true, false); err != nil {
+			return n, err
+		}
+	}
+	if p.pos >= len(p.src) {
+		return n, &SyntaxError{Src: string(p.src), Pos: n.pos, Msg: "unclosed placeholder"}
+	}
+	p.pos++ //}
+	if n.name == nil {
+		n.name = []node{}
+	}
+	return n, nil
+}
+
+type evaluator struct {
+	src    string
+	lookup func(string) (any, bool)
+}
+
+func (e *evaluator) eval(nodes []node) (string, error) {
+	var b strings.Builder
+	for _, n := range nodes {
+		if n.name == nil {
+			b.WriteString(n.text)
+			continue
+		}
+		name, err := e.eval(n.name)
+		if err != nil {
+			return "", err
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return "", &SyntaxError{Src: e.src, Pos: n.pos, Msg: "empty variable name"}
+		}
+		value, ok := e.resolve(name)
+		s := ""
+		if ok {
+			s = Format(value)
+		}
+		switch {
+		case n.op == '-' && s == "":
+			if s, err = e.eval(n.arg); err != nil {
+				return "", err
+			}
+		case n.op == '?' && s == "":
+			msg, err := e.eval(n.arg)
+			if err != nil {
+				return "", err
+			}
+			if msg == "" {
+				msg = "required"
+			}
+			return "", &UndefinedError{Name: name, Message: msg}
+		case n.op == 0 && !ok:
+			return "", &UndefinedError{Name: name}
+		}
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+// resolve 查找变量,不存在时按.拆分后从map类型的值中查找
+func (e *evaluator) resolve(name string) (any, bool) {
+	if v, ok := e.lookup(name); ok {
+		return v, true
+	}
+	for i := strings.LastIndex(name, "."); i > 0; i = strings.LastIndex(name[:i], ".") {
+		v, ok := e.lookup(name[:i])
+		if !ok {
+			continue
+		}
+		for _, key := range strings.Split(name[i+1:], ".") {
+			if v, ok = mapValue(v, key); !ok {
+				break
+			}
+		}
+		if ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func mapValue(m any, key string) (any, bool) {
+	switch mm := m.(type) {
+	case map[string]any:
+		v, ok := mm[key]
+		return v, ok
+	case map[any]any:
+		v, ok := mm[key]
+		return v, ok
+	}
+	rv := reflect.ValueOf(m)
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		v := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+		if v.IsValid() {
+			return v.Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// Format 变量值转换为字符串
+func Format(v any) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case []byte:
+		return string(vv)
+	case fmt.Stringer:
+		return vv.String()
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// Interpolate 解析src中的占位符,变量不存在(且未指定默认值)时返回错误
+func Interpolate(src string, lookup func(name string) (any, bool)) (string, error) {
+	if !strings.ContainsAny(src, "{}") {
+		return src, nil
+	}
+	p := &parser{src: []rune(src)}
+	nodes, err := p.parse(false, false)
+	if err != nil {
+		return "", err
+	}
+	return (&evaluator{src: src, lookup: lookup}).eval(nodes)
+}
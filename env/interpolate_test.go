@@ -0,0 +1,59 @@
+package env
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	e := &Environment{}
+	e.Set("profile", "prod")
+	e.Set("port", 8080)
+	e.Set("timeout", 3*time.Second)
+	e.Set("empty", "")
+	e.Set("db.prod.dsn", "mysql://prod")
+	e.Set("redis", map[string]interface{}{"prod": map[string]interface{}{"addr": "10.0.0.1:6379"}})
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr error
+	}{
+		{name: "plain", src: "application.yml", want: "application.yml"},
+		{name: "simple", src: "application_{profile}.yml", want: "application_prod.yml"},
+		{name: "non string", src: "{port}/{timeout}", want: "8080/3s"},
+		{name: "default", src: "{tenant:-public}", want: "public"},
+		{name: "default when empty", src: "{empty:-x}", want: "x"},
+		{name: "default not used", src: "{profile:-test}", want: "prod"},
+		{name: "nested default", src: "{tenant:-{profile}}", want: "prod"},
+		{name: "nested name", src: "{db.{profile}.dsn}", want: "mysql://prod"},
+		{name: "map value", src: "{redis.{profile}.addr}", want: "10.0.0.1:6379"},
+		{name: "escape", src: "{{profile}} {{{profile}}}", want: "{profile} {prod}"},
+		{name: "undefined", src: "db_{tenant}.tengo", wantErr: ErrUndefined},
+		{name: "required", src: "{tenant:?tenant is required}", wantErr: ErrUndefined},
+		{name: "unused default error", src: "{profile:-{tenant}}", want: "prod"},
+		{name: "unclosed", src: "config_{profile", wantErr: &SyntaxError{}},
+		{name: "empty name", src: "{}", wantErr: &SyntaxError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.Parse(tt.src)
+			if tt.wantErr != nil {
+				var se *SyntaxError
+				if _, ok := tt.wantErr.(*SyntaxError); ok && !errors.As(err, &se) {
+					t.Errorf("Parse(%q) error = %v, want syntax error", tt.src, err)
+				} else if !ok && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Parse(%q) error = %v, want %v", tt.src, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Parse(%q) = %q, %v, want %q", tt.src, got, err, tt.want)
+			}
+		})
+	}
+	if _, err := e.Parse("{tenant:?tenant is required}"); err == nil || err.Error() != "tenant: tenant is required" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
@@ -1,67 +1,371 @@
 package env
 
 import (
+	"container/list"
 	"go/types"
 	"golang.org/x/sync/singleflight"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// EvictReason 缓存对象被淘汰的原因
+type EvictReason int
+
+const (
+	EvictExplicit EvictReason = iota //Evict/EvictWith/Close
+	EvictExpired                     //超过TTL
+	EvictIdle                        //超过空闲时间未访问
+	EvictSize                        //超过最大数量,淘汰最久未使用的
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictIdle:
+		return "idle"
+	case EvictSize:
+		return "size"
+	default:
+		return "explicit"
+	}
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Hits       uint64
+	Misses     uint64
+	LoadErrors uint64
+	Evictions  uint64
+	Size       int
+}
+
+type cacheEntry[TResult any | types.Nil] struct {
+	name     string
+	value    TResult
+	err      error //WithErrorTTL时缓存的构建错误
+	created  time.Time
+	accessed time.Time
+	elem     *list.Element
+}
+
+// GroupCache 按名称缓存构建的对象(数据库连接池、channel等),同名对象的并发构建只执行一次
 type GroupCache[TResult any | types.Nil, TOption any | types.Nil] struct {
-	group singleflight.Group
-	cache map[string]TResult
-	build func(TOption) (TResult, error)
-	mx    sync.Mutex
+	group    singleflight.Group
+	cache    map[string]*cacheEntry[TResult]
+	lru      *list.List //最近访问的在前
+	build    func(TOption) (TResult, error)
+	mx       sync.Mutex
+	ttl      time.Duration
+	idle     time.Duration
+	errTTL   time.Duration
+	maxSize  int
+	onEvict  func(string, TResult, EvictReason)
+	stop     chan struct{}
+	janitor  sync.Once
+	now      func() time.Time
+	hits     uint64
+	misses   uint64
+	loadErrs uint64
+	evicted  uint64
+}
+
+// WithTTL 对象创建后超过ttl被淘汰
+func (g *GroupCache[TResult, TOption]) WithTTL(ttl time.Duration) *GroupCache[TResult, TOption] {
+	g.mx.Lock()
+	g.ttl = ttl
+	g.mx.Unlock()
+	g.startJanitor()
+	return g
+}
+
+// WithIdleTimeout 对象超过idle未被访问时被淘汰
+func (g *GroupCache[TResult, TOption]) WithIdleTimeout(idle time.Duration) *GroupCache[TResult, TOption] {
+	g.mx.Lock()
+	g.idle = idle
+	g.mx.Unlock()
+	g.startJanitor()
+	return g
+}
+
+// WithErrorTTL 构建失败的错误缓存ttl(默认不缓存,每次Get都重新构建)
+func (g *GroupCache[TResult, TOption]) WithErrorTTL(ttl time.Duration) *GroupCache[TResult, TOption] {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.errTTL = ttl
+	return g
+}
+
+// WithMaxSize 最多缓存size个对象,超过时淘汰最久未使用的
+func (g *GroupCache[TResult, TOption]) WithMaxSize(size int) *GroupCache[TResult, TOption] {
+	g.mx.Lock()
+	g.maxSize = size
+	evicted := g.shrink()
+	g.mx.Unlock()
+	g.notify(evicted, EvictSize)
+	return g
+}
+
+// OnEvict 对象被淘汰时调用(Delete除外),在锁外执行
+func (g *GroupCache[TResult, TOption]) OnEvict(fn func(name string, value TResult, reason EvictReason)) *GroupCache[TResult, TOption] {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.onEvict = fn
+	return g
+}
+
+func (g *GroupCache[TResult, TOption]) init() {
+	if g.cache == nil {
+		g.cache = make(map[string]*cacheEntry[TResult])
+		g.lru = list.New()
+	}
+}
+
+// remove 从缓存中删除(需持有锁)
+func (g *GroupCache[TResult, TOption]) remove(e *cacheEntry[TResult]) {
+	delete(g.cache, e.name)
+	g.lru.Remove(e.elem)
+}
+
+// shrink 淘汰超过maxSize的对象(需持有锁)
+func (g *GroupCache[TResult, TOption]) shrink() []*cacheEntry[TResult] {
+	var evicted []*cacheEntry[TResult]
+	g.init()
+	for g.maxSize > 0 && g.lru.Len() > g.maxSize {
+		e := g.lru.Back().Value.(*cacheEntry[TResult])
+		g.remove(e)
+		evicted = append(evicted, e)
+	}
+	return evicted
+}
+
+// expired 对象是否过期(需持有锁)
+func (g *GroupCache[TResult, TOption]) expired(e *cacheEntry[TResult], now time.Time) (EvictReason, bool) {
+	switch {
+	case e.err != nil:
+		return EvictExpired, now.Sub(e.created) >= g.errTTL
+	case g.ttl > 0 && now.Sub(e.created) >= g.ttl:
+		return EvictExpired, true
+	case g.idle > 0 && now.Sub(e.accessed) >= g.idle:
+		return EvictIdle, true
+	}
+	return 0, false
+}
+
+// notify 调用淘汰回调,构建失败的缓存不回调
+func (g *GroupCache[TResult, TOption]) notify(entries []*cacheEntry[TResult], reason EvictReason) {
+	g.mx.Lock()
+	fn := g.onEvict
+	g.mx.Unlock()
+	for _, e := range entries {
+		if e.err != nil {
+			continue
+		}
+		atomic.AddUint64(&g.evicted, 1)
+		if fn != nil {
+			fn(e.name, e.value, reason)
+		}
+	}
+}
+
+// Expire 淘汰所有过期的对象(设置TTL或空闲时间后定期执行)
+func (g *GroupCache[TResult, TOption]) Expire() {
+	now := g.now()
+	evicted := map[EvictReason][]*cacheEntry[TResult]{}
+	g.mx.Lock()
+	g.init()
+	for _, e := range g.cache {
+		if reason, ok := g.expired(e, now); ok {
+			g.remove(e)
+			evicted[reason] = append(evicted[reason], e)
+		}
+	}
+	g.mx.Unlock()
+	for reason, entries := range evicted {
+		g.notify(entries, reason)
+	}
+}
+
+func (g *GroupCache[TResult, TOption]) startJanitor() {
+	g.janitor.Do(func() {
+		go func() {
+			ticker := time.NewTicker(g.janitorInterval())
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					g.Expire()
+				case <-g.stop:
+					return
+				}
+			}
+		}()
+	})
 }
 
+// janitorInterval TTL/空闲时间的一半,在1秒到1分钟之间
+func (g *GroupCache[TResult, TOption]) janitorInterval() time.Duration {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	d := time.Minute
+	for _, v := range []time.Duration{g.ttl / 2, g.idle / 2} {
+		if v > 0 && v < d {
+			d = v
+		}
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// Delete 删除对象,不调用淘汰回调
 func (g *GroupCache[TResult, TOption]) Delete(name string) {
 	g.mx.Lock()
 	defer g.mx.Unlock()
-	if g.cache != nil {
-		delete(g.cache, name)
+	if e, ok := g.cache[name]; ok {
+		g.remove(e)
 	}
 }
+
+// Evict 淘汰对象并调用淘汰回调
+func (g *GroupCache[TResult, TOption]) Evict(name string) bool {
+	g.mx.Lock()
+	e, ok := g.cache[name]
+	if ok {
+		g.remove(e)
+	}
+	g.mx.Unlock()
+	if ok {
+		g.notify([]*cacheEntry[TResult]{e}, EvictExplicit)
+	}
+	return ok && e.err == nil
+}
+
+// EvictWith 淘汰所有对象,cleanFn代替淘汰回调
 func (g *GroupCache[TResult, TOption]) EvictWith(cleanFn func(string, TResult)) {
 	g.mx.Lock()
 	defer g.mx.Unlock()
-	if g.cache != nil {
-		for key, itm := range g.cache {
-			cleanFn(key, itm)
+	for key, e := range g.cache {
+		if e.err == nil {
+			atomic.AddUint64(&g.evicted, 1)
+			cleanFn(key, e.value)
 		}
 	}
-	g.cache = map[string]TResult{}
+	g.cache = nil
+	g.init()
 }
+
+// Close 停止定期淘汰并淘汰所有对象(调用淘汰回调)
+func (g *GroupCache[TResult, TOption]) Close() {
+	g.janitor.Do(func() {})
+	g.mx.Lock()
+	select {
+	case <-g.stop:
+	default:
+		close(g.stop)
+	}
+	entries := make([]*cacheEntry[TResult], 0, len(g.cache))
+	for _, e := range g.cache {
+		entries = append(entries, e)
+	}
+	g.cache = nil
+	g.init()
+	g.mx.Unlock()
+	g.notify(entries, EvictExplicit)
+}
+
+// Range 遍历已缓存的对象(遍历的是快照,fn中可以安全地访问缓存)
+func (g *GroupCache[TResult, TOption]) Range(fn func(string, TResult) bool) {
+	g.mx.Lock()
+	snapshot := make(map[string]TResult, len(g.cache))
+	for k, e := range g.cache {
+		if e.err == nil {
+			snapshot[k] = e.value
+		}
+	}
+	g.mx.Unlock()
+	for k, v := range snapshot {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// Stats 命中、构建失败及淘汰的统计
+func (g *GroupCache[TResult, TOption]) Stats() CacheStats {
+	g.mx.Lock()
+	size := len(g.cache)
+	g.mx.Unlock()
+	return CacheStats{
+		Hits:       atomic.LoadUint64(&g.hits),
+		Misses:     atomic.LoadUint64(&g.misses),
+		LoadErrors: atomic.LoadUint64(&g.loadErrs),
+		Evictions:  atomic.LoadUint64(&g.evicted),
+		Size:       size,
+	}
+}
+
+// lookup 获取未过期的对象,过期的对象被删除并返回(需持有锁)
+func (g *GroupCache[TResult, TOption]) lookup(name string, now time.Time) (*cacheEntry[TResult], *cacheEntry[TResult], EvictReason) {
+	g.init()
+	e, ok := g.cache[name]
+	if !ok {
+		return nil, nil, 0
+	}
+	if reason, expired := g.expired(e, now); expired {
+		g.remove(e)
+		return nil, e, reason
+	}
+	e.accessed = now
+	g.lru.MoveToFront(e.elem)
+	return e, nil, 0
+}
+
 func (g *GroupCache[TResult, TOption]) Get(name string, option TOption) (TResult, error) {
 	var ret TResult
 	result, err, _ := g.group.Do(name, func() (interface{}, error) {
+		now := g.now()
 		g.mx.Lock()
-		defer g.mx.Unlock()
-		var (
-			v  TResult
-			e  error
-			ok bool
-		)
-		if g.cache == nil {
-			g.cache = make(map[string]TResult)
+		e, expired, reason := g.lookup(name, now)
+		g.mx.Unlock()
+		if expired != nil {
+			g.notify([]*cacheEntry[TResult]{expired}, reason)
 		}
-		v, ok = g.cache[name]
-		if ok {
-			return v, nil
+		if e != nil {
+			atomic.AddUint64(&g.hits, 1)
+			return e.value, e.err
 		}
-
-		v, e = g.build(option)
-		if e == nil {
-			g.cache[name] = v
-			return v, nil
-		} else {
-			return v, e
+		atomic.AddUint64(&g.misses, 1)
+		v, err := g.build(option)
+		if err != nil {
+			atomic.AddUint64(&g.loadErrs, 1)
+		}
+		g.mx.Lock()
+		var evicted []*cacheEntry[TResult]
+		if err == nil || g.errTTL > 0 {
+			g.init()
+			if old, ok := g.cache[name]; ok {
+				g.remove(old)
+			}
+			e = &cacheEntry[TResult]{name: name, value: v, err: err, created: now, accessed: now}
+			e.elem = g.lru.PushFront(e)
+			g.cache[name] = e
+			evicted = g.shrink()
 		}
+		g.mx.Unlock()
+		g.notify(evicted, EvictSize)
+		return v, err
 	})
-	ret = result.(TResult)
+	ret, _ = result.(TResult)
 	return ret, err
 }
 
 func NewCache[TResult any, TOption any](builder func(TOption) (TResult, error)) *GroupCache[TResult, TOption] {
 	return &GroupCache[TResult, TOption]{
 		build: builder,
+		stop:  make(chan struct{}),
+		now:   time.Now,
 	}
 }
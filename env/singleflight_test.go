@@ -1,10 +1,14 @@
 package env
 
 import (
+	"errors"
 	"fmt"
 	"github.com/cookieY/sqlx"
 	_ "github.com/go-sql-driver/mysql"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNewCache(t *testing.T) {
@@ -27,3 +31,102 @@ func TestNewCache2(T *testing.T) {
 	db, err = c.Get("local", "")
 	fmt.Println(db, err)
 }
+
+func TestGroupCache_Expire(t *testing.T) {
+	now := time.Now()
+	evicted := map[string]EvictReason{}
+	c := NewCache(func(opt string) (string, error) {
+		return opt, nil
+	}).OnEvict(func(name string, v string, reason EvictReason) {
+		evicted[name] = reason
+	})
+	c.now = func() time.Time { return now }
+	c.WithTTL(time.Hour).WithIdleTimeout(10 * time.Minute)
+	defer c.Close()
+	_, _ = c.Get("a", "a")
+	_, _ = c.Get("b", "b")
+	now = now.Add(6 * time.Minute)
+	_, _ = c.Get("a", "")
+	now = now.Add(6 * time.Minute)
+	c.Expire()
+	if evicted["b"] != EvictIdle || len(evicted) != 1 {
+		t.Fatalf("b should be evicted for idle: %v", evicted)
+	}
+	now = now.Add(time.Hour)
+	if v, _ := c.Get("a", "a2"); v != "a2" || evicted["a"] != EvictExpired {
+		t.Errorf("a should be rebuilt after ttl, got %s %v", v, evicted)
+	}
+}
+
+func TestGroupCache_MaxSize(t *testing.T) {
+	var evicted []string
+	c := NewCache(func(opt string) (string, error) {
+		return opt, nil
+	}).WithMaxSize(2).OnEvict(func(name string, v string, reason EvictReason) {
+		if reason == EvictSize {
+			evicted = append(evicted, name)
+		}
+	})
+	_, _ = c.Get("a", "a")
+	_, _ = c.Get("b", "b")
+	_, _ = c.Get("a", "")
+	_, _ = c.Get("c", "c")
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("least recently used b should be evicted: %v", evicted)
+	}
+	if !c.Evict("a") || c.Evict("a") {
+		t.Error("evict should remove a once")
+	}
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 2 || stats.Size != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestGroupCache_Errors(t *testing.T) {
+	now := time.Now()
+	calls := 0
+	c := NewCache(func(opt string) (string, error) {
+		calls++
+		return "", errors.New("connect failed")
+	})
+	c.now = func() time.Time { return now }
+	_, _ = c.Get("a", "")
+	_, _ = c.Get("a", "")
+	if calls != 2 {
+		t.Errorf("errors should not be cached by default, calls=%d", calls)
+	}
+	c.WithErrorTTL(time.Minute)
+	_, _ = c.Get("a", "")
+	if _, err := c.Get("a", ""); err == nil || calls != 3 {
+		t.Errorf("error should be cached within error ttl, calls=%d err=%v", calls, err)
+	}
+	now = now.Add(time.Minute)
+	_, _ = c.Get("a", "")
+	if calls != 4 || c.Stats().LoadErrors != 4 {
+		t.Errorf("error should expire after error ttl, calls=%d stats=%+v", calls, c.Stats())
+	}
+}
+
+func TestGroupCache_Singleflight(t *testing.T) {
+	var calls int32
+	c := NewCache(func(opt string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return opt, nil
+	})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, _ := c.Get("a", "a"); v != "a" {
+				t.Errorf("unexpected value %s", v)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("builder should be called once, got %d", calls)
+	}
+}
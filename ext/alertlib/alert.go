@@ -0,0 +1,265 @@
+package alertlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"lightbox/ext/maillib"
+	"lightbox/loghub"
+	"lightbox/sandbox"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	alertsConfigKey = "alerts"
+	EngineKey       = "alert_engine"
+
+	ActionScript  = "script"
+	ActionMail    = "mail"
+	ActionWebhook = "webhook"
+
+	defaultActionTimeout = time.Minute
+	defaultMailSubject   = "[lego alert] {{.Rule}} {{.Group}}"
+	defaultMailBody      = "rule: {{.Rule}}\ngroup: {{.Group}}\ncount: {{.Count}} in {{.Window}}\nfired at: {{.FiredAt}}\n\n{{json .Sample}}\n"
+)
+
+var (
+	hub     loghub.LogSubscriber
+	hubOnce sync.Once
+)
+
+// logSubscriber 所有applet的告警共用一个日志订阅
+func logSubscriber() loghub.LogSubscriber {
+	hubOnce.Do(func() {
+		hub = loghub.NewLogSubscriber()
+	})
+	return hub
+}
+
+// rulesFromConfig 读取application.yml中的alerts节点
+func rulesFromConfig(app *sandbox.Applet) ([]loghub.AlertRule, error) {
+	v, ok := app.Config()[alertsConfigKey]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var rules []loghub.AlertRule
+	if err = yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid alerts config:%s", err)
+	}
+	for i, r := range rules {
+		//未指定sandboxes时只匹配本applet的日志
+		if len(r.Sandboxes) == 0 {
+			rules[i].Sandboxes = []string{app.Name}
+		}
+		for _, a := range r.Actions {
+			if err = checkAction(a); err != nil {
+				return nil, fmt.Errorf("alert rule %s: %s", r.Name, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func checkAction(a loghub.AlertAction) error {
+	var required []string
+	switch a.Type {
+	case ActionScript:
+		required = []string{"script"}
+	case ActionMail:
+		required = []string{"host", "to"}
+	case ActionWebhook:
+		required = []string{"url"}
+	default:
+		return fmt.Errorf("unknown action type %s", a.Type)
+	}
+	for _, name := range required {
+		if a.Params[name] == nil {
+			return fmt.Errorf("%s action require %s", a.Type, name)
+		}
+	}
+	return nil
+}
+
+// notifier 依次执行规则的所有动作
+func notifier(app *sandbox.Applet) func(loghub.AlertRule, loghub.Alert) error {
+	return func(rule loghub.AlertRule, alert loghub.Alert) error {
+		app.Logger().Infof("alert %s fired, count:%d group:%s", alert.Rule, alert.Count, alert.Group)
+		var errs []string
+		for _, a := range rule.Actions {
+			if err := runAction(app, a, alert); err != nil {
+				app.Logger().Warnf("alert %s %s action error:%s", alert.Rule, a.Type, err)
+				errs = append(errs, a.Type+":"+err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, ";"))
+		}
+		return nil
+	}
+}
+
+func runAction(app *sandbox.Applet, a loghub.AlertAction, alert loghub.Alert) error {
+	timeout := durationParam(a.Params, "timeout", defaultActionTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	switch a.Type {
+	case ActionScript:
+		return runScript(ctx, app, a, alert)
+	case ActionMail:
+		return sendMail(a, alert)
+	case ActionWebhook:
+		return postWebhook(ctx, a, alert)
+	}
+	return fmt.Errorf("unknown action type %s", a.Type)
+}
+
+// toMap 转换告警为脚本可用的map
+func toMap(alert loghub.Alert) (map[string]interface{}, error) {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// runScript 执行applet脚本,告警内容通过全局变量alert传入
+func runScript(ctx context.Context, app *sandbox.Applet, a loghub.AlertAction, alert loghub.Alert) error {
+	m, err := toMap(alert)
+	if err != nil {
+		return err
+	}
+	_, err = app.RunFileContext(ctx, stringParam(a.Params, "script"), map[string]interface{}{"alert": m})
+	return err
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) string {
+		data, _ := json.MarshalIndent(v, "", "  ")
+		return string(data)
+	},
+}
+
+func render(text string, alert loghub.Alert) (string, error) {
+	tpl, err := template.New("alert").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := &strings.Builder{}
+	err = tpl.Execute(buf, alert)
+	return buf.String(), err
+}
+
+// sendMail 通过smtp发送告警邮件,subject/body为text/template模板,password支持环境变量
+func sendMail(a loghub.AlertAction, alert loghub.Alert) error {
+	subject, err := render(stringParamOr(a.Params, "subject", defaultMailSubject), alert)
+	if err != nil {
+		return err
+	}
+	body, err := render(stringParamOr(a.Params, "body", defaultMailBody), alert)
+	if err != nil {
+		return err
+	}
+	user := stringParam(a.Params, "username")
+	from := stringParamOr(a.Params, "from", user)
+	port := intParam(a.Params, "port", 25)
+	client, err := maillib.NewSMTPClient(stringParam(a.Params, "host"), port, user, os.ExpandEnv(stringParam(a.Params, "password")))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	header := map[string][]string{
+		maillib.HeaderFrom:   {from},
+		maillib.HeaderTo:     listParam(a.Params, "to"),
+		maillib.EMailSubject: {subject},
+	}
+	if cc := listParam(a.Params, "cc"); len(cc) > 0 {
+		header[maillib.HeaderCc] = cc
+	}
+	return client.SendText(header, body)
+}
+
+// postWebhook 以json POST告警,headers支持环境变量
+func postWebhook(ctx context.Context, a loghub.AlertAction, alert loghub.Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	target := stringParam(a.Params, "url")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if headers, ok := a.Params["headers"].(map[string]interface{}); ok {
+		for k, v := range headers {
+			req.Header.Set(k, os.ExpandEnv(fmt.Sprint(v)))
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post alert to %s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+func stringParam(params map[string]interface{}, name string) string {
+	return stringParamOr(params, name, "")
+}
+
+func stringParamOr(params map[string]interface{}, name, def string) string {
+	if v, ok := params[name]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return def
+}
+
+func intParam(params map[string]interface{}, name string, def int) int {
+	if v, ok := params[name].(int); ok {
+		return v
+	}
+	return def
+}
+
+func durationParam(params map[string]interface{}, name string, def time.Duration) time.Duration {
+	if v, ok := params[name]; ok && v != nil {
+		if d, err := time.ParseDuration(fmt.Sprint(v)); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+// listParam 字符串或字符串列表
+func listParam(params map[string]interface{}, name string) []string {
+	switch v := params[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, s := range v {
+			result = append(result, fmt.Sprint(s))
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package alertlib
+
+import (
+	"encoding/json"
+	"github.com/d5/tengo/v2"
+	"lightbox/loghub"
+	"lightbox/sandbox"
+)
+
+// status 返回告警规则的状态
+//
+//snippet:name=alert.status;prefix=status;body=status();desc=告警规则的状态(匹配/触发/抑制次数);
+func status(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	v, ok := app.Context.Get(EngineKey)
+	if !ok {
+		return &tengo.Array{}, nil
+	}
+	data, err := json.Marshal(v.(*loghub.AlertEngine).Status())
+	if err != nil {
+		return nil, err
+	}
+	var result []interface{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return tengo.FromInterface(result)
+}
+
+var appModule = map[string]sandbox.UserFunction{
+	"status": status,
+}
+
+// Entry 在application.yml的alerts节点中定义告警规则,applet初始化时开始订阅日志
+var Entry = sandbox.NewRegistry("alert", nil, appModule).
+	WithHook(sandbox.NewHook(sandbox.SigInitialized, func(app *sandbox.Applet) error {
+		rules, err := rulesFromConfig(app)
+		if err != nil || len(rules) == 0 {
+			return err
+		}
+		engine, err := loghub.NewAlertEngine(rules, notifier(app))
+		if err != nil {
+			return err
+		}
+		if err = engine.Start(logSubscriber()); err != nil {
+			return err
+		}
+		app.Context.Set(EngineKey, engine)
+		app.WithHook(sandbox.NewHook(sandbox.SigStop, func(applet *sandbox.Applet) error {
+			engine.Stop()
+			app.Context.Delete(EngineKey)
+			return nil
+		}))
+		return nil
+	}))
@@ -1,6 +1,7 @@
 package amqplib
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"github.com/streadway/amqp"
 	"lightbox/ext/util"
 	"lightbox/sandbox"
+	"lightbox/tracing"
 	"os"
 	"strconv"
 	"sync"
@@ -53,6 +55,7 @@ func (w *ChannelWrapper) IndexGet(key tengo.Object) (tengo.Object, error) {
 				}
 			}},
 			"consume": &tengo.UserFunction{Value: w.consume},
+			"publish": &tengo.UserFunction{Value: w.publish},
 		}
 	})
 	if v, ok := w.props[k]; ok {
@@ -129,7 +132,18 @@ func (w *ChannelWrapper) consume(args ...tengo.Object) (ret tengo.Object, err er
 			continue
 		}
 
-		err = c.Run()
+		span := tracing.StartWithParent("amqp consume "+queue, tracing.KindConsumer, extractSpan(msg.Headers))
+		span.SetAttr("messaging.system", "rabbitmq")
+		span.SetAttr("messaging.source", queue)
+		span.SetAttr("messaging.rabbitmq.delivery_tag", msg.DeliveryTag)
+		err = w.app.RunCompiledWith(tracing.ContextWithSpan(context.Background(), span), log.Fields{
+			"amqp_queue":        queue,
+			"amqp_consumer":     consumer,
+			"amqp_delivery_tag": msg.DeliveryTag,
+			"amqp_message_id":   msg.MessageId,
+		}, c)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			log.Error(err)
 		}
@@ -260,3 +274,76 @@ func (w *ChannelWrapper) exchangeDeclare(args ...tengo.Object) (tengo.Object, er
 	}
 	return nil, nil
 }
+
+// extractSpan 从消息头读取上游的span
+func extractSpan(headers amqp.Table) tracing.SpanContext {
+	tp, _ := headers[tracing.HeaderTraceparent].(string)
+	sc, _ := tracing.ParseTraceparent(tp)
+	return sc
+}
+
+//snippet:name=channel.publish;prefix=publish;body=publish(${exchange},${key},${body},{content_type:"text/plain",headers:{}})/*exchange,key,body,options*/;
+func (w *ChannelWrapper) publish(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) < 3 {
+		return nil, errors.New("require least 3 args")
+	}
+	exchange, _ := tengo.ToString(args[0])
+	key, _ := tengo.ToString(args[1])
+	body, ok := tengo.ToByteSlice(args[2])
+	if !ok {
+		return nil, errors.New("body require a string or bytes")
+	}
+	var (
+		mandatory bool
+		immediate bool
+		msg       = amqp.Publishing{Body: body, Headers: amqp.Table{}}
+	)
+	if len(args) > 3 {
+		var opts map[string]interface{}
+		switch m := args[3].(type) {
+		case *tengo.Map:
+			opts = util.ToMap[interface{}](m.Value)
+		case *tengo.ImmutableMap:
+			opts = util.ToMap[interface{}](m.Value)
+		}
+		for k, v := range opts {
+			switch k {
+			case "content_type":
+				msg.ContentType = fmt.Sprint(v)
+			case "message_id":
+				msg.MessageId = fmt.Sprint(v)
+			case "correlation_id":
+				msg.CorrelationId = fmt.Sprint(v)
+			case "reply_to":
+				msg.ReplyTo = fmt.Sprint(v)
+			case "persistent":
+				if b, _ := v.(bool); b {
+					msg.DeliveryMode = amqp.Persistent
+				}
+			case "headers":
+				if headers, ok := v.(map[string]interface{}); ok {
+					for hk, hv := range headers {
+						msg.Headers[hk] = hv
+					}
+				}
+			case "mandatory":
+				mandatory, _ = v.(bool)
+			case "immediate":
+				immediate, _ = v.(bool)
+			}
+		}
+	}
+	span := tracing.Start(context.Background(), "amqp publish "+exchange, tracing.KindProducer)
+	defer span.End()
+	span.SetAttr("messaging.system", "rabbitmq")
+	span.SetAttr("messaging.destination", exchange)
+	span.SetAttr("messaging.rabbitmq.routing_key", key)
+	if tp := span.Context().Traceparent(); tp != "" {
+		msg.Headers[tracing.HeaderTraceparent] = tp
+	}
+	if err := w.Channel.Publish(exchange, key, mandatory, immediate, msg); err != nil {
+		span.SetError(err)
+		return util.Error(err), nil
+	}
+	return nil, nil
+}
@@ -1,6 +1,7 @@
 package amqplib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/d5/tengo/v2"
@@ -37,7 +38,10 @@ func (w *ConnectionWrapper) IndexGet(key tengo.Object) (tengo.Object, error) {
 				}
 				return &ChannelWrapper{Channel: ch, app: w.app, conn: w.Connection}, nil
 			}},
-			"close":     &tengo.UserFunction{Value: stdlib.FuncARE(w.Close)},
+			"close": &tengo.UserFunction{Value: stdlib.FuncARE(func() error {
+				w.app.UnregisterHealthCheck(w.checkName())
+				return w.Close()
+			})},
 			"is_closed": &tengo.UserFunction{Value: stdlib.FuncARB(w.IsClosed)},
 			"connect":   &tengo.UserFunction{Value: w.connect},
 			"stat": &tengo.UserFunction{Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
@@ -65,9 +69,31 @@ func (w *ConnectionWrapper) connect(args ...tengo.Object) (ret tengo.Object, err
 	if err != nil {
 		return util.Error(err), nil
 	}
+	w.registerHealth()
 	return nil, nil
 }
 
+// checkName 健康检查名称(不包含用户名密码)
+func (w *ConnectionWrapper) checkName() string {
+	if uri, err := amqp.ParseURI(w.url); err == nil {
+		return fmt.Sprintf("amqp:%s:%d%s", uri.Host, uri.Port, uri.Vhost)
+	}
+	return "amqp"
+}
+
+// registerHealth 注册连接状态的就绪检查
+func (w *ConnectionWrapper) registerHealth() {
+	if w.app == nil {
+		return
+	}
+	w.app.RegisterHealthCheck(w.checkName(), sandbox.HealthReady, func(ctx context.Context) error {
+		if w.Connection == nil || w.IsClosed() {
+			return errors.New("connection closed")
+		}
+		return nil
+	})
+}
+
 func (w *ConnectionWrapper) waitClose(args ...tengo.Object) (ret tengo.Object, err error) {
 	if w.Connection != nil {
 		c := make(chan *amqp.Error)
@@ -83,5 +109,6 @@ func Dial(url string, app *sandbox.Applet) (*ConnectionWrapper, error) {
 		return nil, err
 	}
 	wrapper := &ConnectionWrapper{Connection: conn, url: url, app: app}
+	wrapper.registerHealth()
 	return wrapper, nil
 }
@@ -0,0 +1,166 @@
+package badgerlib
+
+import (
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"os"
+	"time"
+)
+
+func backupInfo(bf *kvstore.BackupFile) tengo.Object {
+	full := tengo.FalseValue
+	if bf.Full {
+		full = tengo.TrueValue
+	}
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"file":    &tengo.String{Value: bf.File},
+		"full":    full,
+		"since":   &tengo.Int{Value: int64(bf.Since)},
+		"version": &tengo.Int{Value: int64(bf.Version)},
+		"size":    &tengo.Int{Value: bf.Size},
+		"time":    &tengo.Time{Value: bf.Time},
+	}}
+}
+
+// toBackupOptions 参数:{incremental:bool,keep:int,max_age:"720h"}
+func toBackupOptions(o tengo.Object) (kvstore.BackupOptions, error) {
+	var opt kvstore.BackupOptions
+	m, ok := tengo.ToInterface(o).(map[string]interface{})
+	if !ok {
+		return opt, tengo.ErrInvalidArgumentType{Name: "option", Expected: "map", Found: o.TypeName()}
+	}
+	opt.Incremental, _ = m["incremental"].(bool)
+	if keep, ok := m["keep"].(int64); ok {
+		opt.Keep = int(keep)
+	}
+	if s, ok := m["max_age"].(string); ok && s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return opt, err
+		}
+		opt.MaxAge = d
+	}
+	return opt, nil
+}
+
+// backupMethods badgerClient上与备份/导入导出相关的方法
+func (b *badgerClient) backupMethods() map[string]tengo.Object {
+	return map[string]tengo.Object{
+		//snippet:name=badger.backup_to;prefix=backup_to;body=backup_to(${1:dir},{incremental:true,keep:${2:7}});
+		"backup_to": &tengo.UserFunction{Name: "backup_to", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 && len(args) != 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			dir, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "dir", Expected: "string", Found: args[0].TypeName()}
+			}
+			dir, err := b.path(dir)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			var opt kvstore.BackupOptions
+			if len(args) == 2 {
+				var err error
+				if opt, err = toBackupOptions(args[1]); err != nil {
+					return nil, err
+				}
+			}
+			bf, err := kvstore.Backup(b.DB, dir, opt)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return backupInfo(bf), nil
+		}},
+		//snippet:name=badger.dump_json;prefix=dump_json;body=dump_json(${1:file});
+		"dump_json": &tengo.UserFunction{Name: "dump_json", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 && len(args) != 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			file, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "file", Expected: "string", Found: args[0].TypeName()}
+			}
+			var prefix []byte
+			if len(args) == 2 {
+				if prefix, ok = tengo.ToByteSlice(args[1]); !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "prefix", Expected: "string/bytes", Found: args[1].TypeName()}
+				}
+			}
+			file, err := b.path(file)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			f, err := os.Create(file)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			defer f.Close()
+			n, err := kvstore.Dump(b.DB, f, prefix)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: int64(n)}, nil
+		}},
+		//snippet:name=badger.load_json;prefix=load_json;body=load_json(${1:file});
+		"load_json": &tengo.UserFunction{Name: "load_json", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			file, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "file", Expected: "string", Found: args[0].TypeName()}
+			}
+			file, err := b.path(file)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			defer f.Close()
+			n, err := kvstore.LoadDump(b.DB, f)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: int64(n)}, nil
+		}},
+	}
+}
+
+// restore 把备份恢复到一个新的数据库并打开，参数(src,name,dir)
+//
+//snippet:name=badger.restore;prefix=restore;body=restore(${1:backup},${2:name},${3:dir});
+func restore(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 3 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	var v [3]string
+	for i, name := range []string{"backup", "name", "dir"} {
+		str, ok := tengo.ToString(args[i])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: name, Expected: "string", Found: args[i].TypeName()}
+		}
+		v[i] = str
+	}
+	s, err := scopeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	for _, i := range []int{0, 2} {
+		if v[i], err = s.path(v[i]); err != nil {
+			return util.Error(err), nil
+		}
+	}
+	name := s.name(v[1])
+	db, err := kvstore.RestoreTo(name, v[2], v[0])
+	if err != nil {
+		return util.Error(err), nil
+	}
+	client := wrapBadgerClient(db, name)
+	client.scope = s
+	return client, nil
+}
@@ -9,8 +9,10 @@ import (
 	"github.com/dgraph-io/badger/v3"
 	"lightbox/ext/util"
 	"lightbox/kvstore"
+	"lightbox/sandbox"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -34,8 +36,11 @@ type badgerClient struct {
 	tengo.ObjectImpl
 	methodIndex map[string]tengo.Object
 	sync.Once
-	Name string
-	Dir  string
+	Name        string
+	Dir         string
+	counters    map[string]*counterObject
+	counterLock sync.Mutex
+	scope       *scope //通过applet打开时的命名空间
 }
 
 func (b *badgerClient) IndexGet(key tengo.Object) (tengo.Object, error) {
@@ -314,6 +319,10 @@ func (b *badgerClient) init() {
 				if backupName == "" {
 					return errors.New("backup file name is empty")
 				}
+				backupName, err := b.path(backupName)
+				if err != nil {
+					return err
+				}
 				fi, err := os.Stat(backupName)
 				if err == nil {
 					if fi.IsDir() {
@@ -497,6 +506,16 @@ func (b *badgerClient) init() {
 
 			}},
 	}
+	for k, v := range b.txnMethods() {
+		b.methodIndex[k] = v
+	}
+	for k, v := range b.backupMethods() {
+		b.methodIndex[k] = v
+	}
+	for k, v := range b.maintenanceMethods() {
+		b.methodIndex[k] = v
+	}
+	b.methodIndex["watch"] = &tengo.UserFunction{Name: "watch", Value: b.watch}
 }
 
 func newBadgerClient(db *badger.DB, name string, options badger.Options) *badgerClient {
@@ -512,70 +531,91 @@ func wrapBadgerClient(db *badger.DB, name string) *badgerClient {
 	return &badgerClient{
 		DB:   db,
 		Name: name,
-		Dir:  name,
+		Dir:  db.Opts().Dir,
+	}
+}
+
+// path 脚本传入的文件路径，applet打开的数据库限制在applet目录中
+func (b *badgerClient) path(p string) (string, error) {
+	if b.scope == nil {
+		return p, nil
 	}
+	return b.scope.path(p)
 }
 
-func openBadger(args ...tengo.Object) (tengo.Object, error) {
+func openBadger(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
 	if len(args) == 0 || len(args) > 2 {
 		return tengo.FromInterface(errors.New("arguments (name string,[name string/op option])"))
 	}
-	//无参数，打开默认的KV存储，路径在当前路径的data目录
-	//if len(args) == 0 {
-	//	if db, err := OpenDefaultBadgerDB(); err == nil {
-	//		return db, nil
-	//	} else {
-	//		return tengo.FromInterface(err)
-	//	}
-	//}
-	//打开一个指定名称的存储(之前已经打开过的,直接创建新的风险比较高,没想好怎么修改)
+	s, err := scopeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok {
+		return tengo.FromInterface(errors.New("name must be a  string"))
+	}
+	//打开一个指定名称的存储，名称同时作为目录
 	if len(args) == 1 {
-		name, ok := tengo.ToString(args[0])
-		if !ok {
-			return tengo.FromInterface(errors.New("name must be a  string"))
-		}
-		if db, err := OpenBadgerDB(name, kvstore.DefaultOptions(name)); err == nil {
+		if db, err := s.open(name, kvstore.DefaultOptions(name)); err == nil {
 			return db, nil
 		} else {
 			return tengo.FromInterface(err)
 		}
 	}
 	//打开指定目录，指定名称的kv存储
-	if len(args) == 2 {
-		name, ok := tengo.ToString(args[0])
+	var opt badger.Options
+	arg1 := tengo.ToInterface(args[1])
+
+	switch v := arg1.(type) {
+	case string:
+		opt = kvstore.DefaultOptions(v)
+	case *util.ReflectProxy:
+		o, ok := v.Self.(*badger.Options)
 		if !ok {
-			return tengo.FromInterface(errors.New("name must be a  string"))
+			return util.Error(&tengo.ErrInvalidArgumentType{Name: "option", Expected: "badger.Option", Found: fmt.Sprintf("%v", v.Self)}), nil
 		}
-		var opt *badger.Options
-		arg1 := tengo.ToInterface(args[1])
-
-		switch v := arg1.(type) {
-		case string:
-			o := kvstore.DefaultOptions(v)
-			opt = &o
-		case *util.ReflectProxy:
-			opt, ok = v.Self.(*badger.Options)
-			if !ok {
-				return util.Error(&tengo.ErrInvalidArgumentType{Name: "option", Expected: "badger.Option", Found: fmt.Sprintf("%v", v.Self)}), nil
-			}
-		case map[string]interface{}:
-			o := badger.DefaultOptions(name)
-			if err := util.StructFromObject(args[1], &o); err != nil {
-				return util.Error(err), nil
-			}
-			o.ValueDir = o.Dir
-			opt = &o
-		default:
-			return util.Error(&tengo.ErrInvalidArgumentType{Name: "option", Expected: "badger.Option", Found: fmt.Sprintf("%v", v)}), nil
-		}
-		if db, err := OpenBadgerDB(name, *opt); err == nil {
-			return db, nil
-		} else {
-			return tengo.FromInterface(err)
+		opt = *o
+	case map[string]interface{}:
+		opt = badger.DefaultOptions(name)
+		if err := util.StructFromObject(args[1], &opt); err != nil {
+			return util.Error(err), nil
 		}
+		opt.ValueDir = opt.Dir
+	default:
+		return util.Error(&tengo.ErrInvalidArgumentType{Name: "option", Expected: "badger.Option", Found: fmt.Sprintf("%v", v)}), nil
+	}
+	if db, err := s.open(name, opt); err == nil {
+		return db, nil
+	} else {
+		return tengo.FromInterface(err)
 	}
+}
 
-	return tengo.FromInterface(errors.New("unknown arguments count"))
+// open 在applet的命名空间中打开数据库，目录限制在applet目录中
+func (s *scope) open(name string, options badger.Options) (*badgerClient, error) {
+	if !options.InMemory && options.Dir != "" && !strings.HasPrefix(name, ":") {
+		dir, err := s.path(options.Dir)
+		if err != nil {
+			return nil, err
+		}
+		valueDir := dir
+		if options.ValueDir != "" && options.ValueDir != options.Dir {
+			if valueDir, err = s.path(options.ValueDir); err != nil {
+				return nil, err
+			}
+		}
+		options.Dir, options.ValueDir = dir, valueDir
+	}
+	client, err := OpenBadgerDB(s.name(name), options)
+	if err != nil {
+		return nil, err
+	}
+	if opt := s.defaultMaintenance(); opt != nil {
+		_ = kvstore.SetMaintenance(client.Name, *opt)
+	}
+	client.scope = s
+	return client, nil
 }
 
 func OpenBadgerDB(name string, options badger.Options) (*badgerClient, error) {
@@ -0,0 +1,297 @@
+package badgerlib
+
+import (
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"github.com/dgraph-io/badger/v3"
+	"lightbox/ext/util"
+	"lightbox/kvstore"
+	"lightbox/kvstore/docstore"
+	"lightbox/sandbox"
+)
+
+// collectionObject docstore.Collection 的脚本封装
+type collectionObject struct {
+	tengo.ObjectImpl
+	*docstore.Collection
+	methodIndex map[string]tengo.Object
+}
+
+func (c *collectionObject) TypeName() string {
+	return "badger-collection"
+}
+
+func (c *collectionObject) String() string {
+	return fmt.Sprintf("collection<%s>", c.Name())
+}
+
+func (c *collectionObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	if m, ok := c.methodIndex[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+func toDoc(o tengo.Object) (docstore.Doc, error) {
+	doc, ok := tengo.ToInterface(o).(map[string]interface{})
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "doc", Expected: "map", Found: o.TypeName()}
+	}
+	return doc, nil
+}
+
+// toQuery 查询参数:{field,eq,gt,gte,lt,lte,prefix,desc,offset,limit}
+func toQuery(o tengo.Object) (docstore.Query, error) {
+	var q docstore.Query
+	m, ok := tengo.ToInterface(o).(map[string]interface{})
+	if !ok {
+		return q, tengo.ErrInvalidArgumentType{Name: "query", Expected: "map", Found: o.TypeName()}
+	}
+	for k, v := range m {
+		switch k {
+		case "field":
+			q.Field = fmt.Sprint(v)
+		case "eq":
+			q.Eq = v
+		case "gt":
+			q.Gt = v
+		case "gte":
+			q.Gte = v
+		case "lt":
+			q.Lt = v
+		case "lte":
+			q.Lte = v
+		case "prefix":
+			p := fmt.Sprint(v)
+			q.Prefix = &p
+		case "desc":
+			q.Desc, _ = v.(bool)
+		case "offset":
+			n, _ := v.(int64)
+			q.Offset = int(n)
+		case "limit":
+			n, _ := v.(int64)
+			q.Limit = int(n)
+		default:
+			return q, fmt.Errorf("unknown query option %s", k)
+		}
+	}
+	return q, nil
+}
+
+func toFields(args ...tengo.Object) ([]string, error) {
+	var fields []string
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case *tengo.Array:
+			for _, v := range a.Value {
+				s, ok := tengo.ToString(v)
+				if !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "index", Expected: "string", Found: v.TypeName()}
+				}
+				fields = append(fields, s)
+			}
+		default:
+			s, ok := tengo.ToString(arg)
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "index", Expected: "string", Found: arg.TypeName()}
+			}
+			fields = append(fields, s)
+		}
+	}
+	return fields, nil
+}
+
+// openCollection 参数(name[,indexes])
+func openCollection(db *badger.DB, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) == 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "name", Expected: "string", Found: args[0].TypeName()}
+	}
+	fields, err := toFields(args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := docstore.Open(db, name, fields...)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return newCollectionObject(c), nil
+}
+
+func newCollectionObject(c *docstore.Collection) *collectionObject {
+	obj := &collectionObject{Collection: c}
+	idArg := func(args []tengo.Object) (string, error) {
+		if len(args) == 0 {
+			return "", tengo.ErrWrongNumArguments
+		}
+		id, ok := tengo.ToString(args[0])
+		if !ok {
+			return "", tengo.ErrInvalidArgumentType{Name: "id", Expected: "string", Found: args[0].TypeName()}
+		}
+		return id, nil
+	}
+	queryArg := func(args []tengo.Object) (docstore.Query, error) {
+		if len(args) == 0 {
+			return docstore.Query{}, nil
+		}
+		if len(args) > 1 {
+			return docstore.Query{}, tengo.ErrWrongNumArguments
+		}
+		return toQuery(args[0])
+	}
+	obj.methodIndex = map[string]tengo.Object{
+		//snippet:name=collection.insert;prefix=insert;body=insert(${1:doc});
+		"insert": &tengo.UserFunction{Name: "insert", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			doc, err := toDoc(args[0])
+			if err != nil {
+				return nil, err
+			}
+			id, err := c.Insert(doc)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.String{Value: id}, nil
+		}},
+		//snippet:name=collection.update;prefix=update;body=update(${1:id},${2:fields});
+		"update": &tengo.UserFunction{Name: "update", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			id, err := idArg(args)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			doc, err := toDoc(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return util.Error(c.Update(id, doc)), nil
+		}},
+		//snippet:name=collection.delete;prefix=delete;body=delete(${1:id});
+		"delete": &tengo.UserFunction{Name: "delete", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			id, err := idArg(args)
+			if err != nil {
+				return nil, err
+			}
+			return util.Error(c.Delete(id)), nil
+		}},
+		//snippet:name=collection.get;prefix=get;body=get(${1:id});
+		"get": &tengo.UserFunction{Name: "get", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			id, err := idArg(args)
+			if err != nil {
+				return nil, err
+			}
+			doc, err := c.Get(id)
+			if err == docstore.ErrNotFound {
+				return tengo.UndefinedValue, nil
+			}
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return tengo.FromInterface(doc)
+		}},
+		//snippet:name=collection.find;prefix=find;body=find({field:${1:field},eq:${2:value}});
+		"find": &tengo.UserFunction{Name: "find", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			q, err := queryArg(args)
+			if err != nil {
+				return nil, err
+			}
+			docs, err := c.Find(q)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			arr := &tengo.Array{Value: make([]tengo.Object, 0, len(docs))}
+			for _, doc := range docs {
+				o, err := tengo.FromInterface(doc)
+				if err != nil {
+					return nil, err
+				}
+				arr.Value = append(arr.Value, o)
+			}
+			return arr, nil
+		}},
+		//snippet:name=collection.count;prefix=count;body=count({field:${1:field},eq:${2:value}});
+		"count": &tengo.UserFunction{Name: "count", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			q, err := queryArg(args)
+			if err != nil {
+				return nil, err
+			}
+			n, err := c.Count(q)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: int64(n)}, nil
+		}},
+		//snippet:name=collection.ensure_index;prefix=ensure_index;body=ensure_index(${1:field});
+		"ensure_index": &tengo.UserFunction{Name: "ensure_index", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			fields, err := toFields(args...)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range fields {
+				if err = c.EnsureIndex(f); err != nil {
+					return util.Error(err), nil
+				}
+			}
+			return tengo.TrueValue, nil
+		}},
+		"drop_index": &tengo.UserFunction{Name: "drop_index", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			fields, err := toFields(args...)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range fields {
+				if err = c.DropIndex(f); err != nil {
+					return util.Error(err), nil
+				}
+			}
+			return tengo.TrueValue, nil
+		}},
+		"indexes": &tengo.UserFunction{Name: "indexes", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			arr := &tengo.Array{}
+			for _, f := range c.Indexes() {
+				arr.Value = append(arr.Value, &tengo.String{Value: f})
+			}
+			return arr, nil
+		}},
+	}
+	return obj
+}
+
+var docModule = map[string]sandbox.UserFunction{
+	//snippet:name=docstore.collection;prefix=collection;body=collection(${1:db},${2:name},[${3:indexes}]);desc=open a json document collection on a badger db
+	"collection": func(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		if len(args) < 2 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+		var db *badger.DB
+		switch v := args[0].(type) {
+		case *badgerClient:
+			db = v.DB
+		default:
+			name, ok := tengo.ToString(v)
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "db", Expected: "badger-client/string", Found: v.TypeName()}
+			}
+			if name, err := ScopedName(app, name); err != nil {
+				return util.Error(err), nil
+			} else if db, err = kvstore.Get(name); err != nil {
+				return util.Error(err), nil
+			}
+		}
+		return openCollection(db, args[1:]...)
+	},
+}
+
+var DocEntry = sandbox.NewRegistry("docstore", nil, docModule)
@@ -0,0 +1,143 @@
+package badgerlib
+
+import (
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"time"
+)
+
+// toMaintenance 参数:{gc_interval:"10m",discard_ratio:0.5,flatten_interval:"24h",flatten_workers:1}，未设置的字段沿用base
+func toMaintenance(o tengo.Object, base kvstore.MaintenanceOptions) (kvstore.MaintenanceOptions, error) {
+	m, ok := tengo.ToInterface(o).(map[string]interface{})
+	if !ok {
+		return base, tengo.ErrInvalidArgumentType{Name: "option", Expected: "map", Found: o.TypeName()}
+	}
+	duration := func(key string, d *time.Duration) error {
+		if s, ok := m[key].(string); ok {
+			v, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			*d = v
+		}
+		return nil
+	}
+	if err := duration("gc_interval", &base.GCInterval); err != nil {
+		return base, err
+	}
+	if err := duration("flatten_interval", &base.FlattenInterval); err != nil {
+		return base, err
+	}
+	switch v := m["discard_ratio"].(type) {
+	case float64:
+		base.DiscardRatio = v
+	case int64:
+		base.DiscardRatio = float64(v)
+	}
+	if v, ok := m["flatten_workers"].(int64); ok {
+		base.FlattenWorkers = int(v)
+	}
+	return base, nil
+}
+
+func statsObject(st kvstore.DBStats) tengo.Object {
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"name":         &tengo.String{Value: st.Name},
+		"dir":          &tengo.String{Value: st.Dir},
+		"lsm_size":     &tengo.Int{Value: st.LSMSize},
+		"vlog_size":    &tengo.Int{Value: st.VLogSize},
+		"tables":       &tengo.Int{Value: int64(st.Tables)},
+		"gc_runs":      &tengo.Int{Value: st.GCRuns},
+		"gc_rewrites":  &tengo.Int{Value: st.GCRewrites},
+		"last_gc":      &tengo.Time{Value: st.LastGC},
+		"flattens":     &tengo.Int{Value: st.Flattens},
+		"last_flatten": &tengo.Time{Value: st.LastFlatten},
+		"last_error":   &tengo.String{Value: st.LastError},
+	}}
+}
+
+// maintenanceMethods badgerClient上的维护方法，只对通过kvstore打开的数据库有效
+func (b *badgerClient) maintenanceMethods() map[string]tengo.Object {
+	return map[string]tengo.Object{
+		//snippet:name=badger.stats;prefix=stats;body=stats();desc=lsm/vlog size and gc statistics;
+		"stats": &tengo.UserFunction{Name: "stats", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			st, err := kvstore.Stats(b.Name)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return statsObject(st), nil
+		}},
+		//snippet:name=badger.gc;prefix=gc;body=gc();desc=run value log gc now;
+		"gc": &tengo.UserFunction{Name: "gc", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			n, err := kvstore.RunGC(b.Name)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: int64(n)}, nil
+		}},
+		//snippet:name=badger.flatten;prefix=flatten;body=flatten();desc=compact lsm tree into one level;
+		"flatten": &tengo.UserFunction{Name: "flatten", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			workers := 0
+			if len(args) == 1 {
+				var ok bool
+				if workers, ok = tengo.ToInt(args[0]); !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "workers", Expected: "int", Found: args[0].TypeName()}
+				}
+			}
+			return util.Error(kvstore.Flatten(b.Name, workers)), nil
+		}},
+		//snippet:name=badger.maintenance;prefix=maintenance;body=maintenance({gc_interval:"${1:10m}",discard_ratio:${2:0.5}});
+		"maintenance": &tengo.UserFunction{Name: "maintenance", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			opt, err := toMaintenance(args[0], kvstore.DefaultMaintenance())
+			if err != nil {
+				return nil, err
+			}
+			return util.Error(kvstore.SetMaintenance(b.Name, opt)), nil
+		}},
+	}
+}
+
+// stats applet可访问的已打开数据库(自己的及共享的)的统计
+//
+//snippet:name=badger.stats;prefix=stats;body=stats();
+func stats(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	s, err := scopeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	arr := &tengo.Array{}
+	for _, st := range kvstore.AllStats() {
+		if s.owns(st.Name) {
+			arr.Value = append(arr.Value, statsObject(st))
+		}
+	}
+	return arr, nil
+}
+
+// maintenance 设置applet之后打开的数据库的默认维护选项
+//
+//snippet:name=badger.maintenance;prefix=maintenance;body=maintenance({gc_interval:"${1:10m}",discard_ratio:${2:0.5}});
+func maintenance(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	s, err := scopeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	base := kvstore.DefaultMaintenance()
+	if opt := s.defaultMaintenance(); opt != nil {
+		base = *opt
+	}
+	opt, err := toMaintenance(args[0], base)
+	if err != nil {
+		return nil, err
+	}
+	s.setDefaultMaintenance(opt)
+	return tengo.TrueValue, nil
+}
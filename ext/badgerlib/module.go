@@ -8,30 +8,7 @@ import (
 	"lightbox/sandbox"
 )
 
-//todo: lock directory to applet base
-
 var module = map[string]tengo.Object{
-
-	//snippet:name=badger.take(),prefix=take;body=take($1);desc=take a badger db,usage=badger.take("db_name")
-	"take": &tengo.UserFunction{
-		Name: "take",
-		Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
-			if len(args) != 1 {
-				return util.Error(tengo.ErrWrongNumArguments), nil
-			}
-			if arg, ok := tengo.ToString(args[0]); ok {
-				if db, err := kvstore.Get(arg); err == nil {
-					return wrapBadgerClient(db, arg), nil
-				}
-			}
-			return tengo.FromInterface(fmt.Errorf("badger %v not found", args[0]))
-		},
-	},
-	//snippet:name=badger.open();prefix=open;body=open();desc=get default badger database;
-	//snippet:name=badger.open(name);prefix=open;body=open($1);desc=open a exists badger database,if badger not exists,use name as dir and create a badger client;
-	//snippet:name=badger.open;prefix=open;body=open(${1:name},${2:dir});desc=open a badger database;
-	//snippet:name=badger.open;prefix=open;body=open(${1:name},${2:option});desc=open a badger database;
-	"open": &tengo.UserFunction{Name: "open", Value: openBadger},
 	//snippet:name=badger.option;prefix=option;body=option(${1:path});desc=create a badger option;
 	"option": &tengo.UserFunction{Name: "option", Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
 		if len(args) == 0 {
@@ -61,6 +38,7 @@ var module = map[string]tengo.Object{
 			kvstore.SetSmallSize()
 			return nil, nil
 		}},
+	"is_conflict": &tengo.UserFunction{Name: "is_conflict", Value: isConflict},
 	"large_size": &tengo.UserFunction{Name: "large_size",
 		Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
 			kvstore.SetLargeSize()
@@ -69,4 +47,37 @@ var module = map[string]tengo.Object{
 	},
 }
 
-var Entry = sandbox.NewRegistry("badger", module, nil)
+// appModule 数据库名称及目录按applet隔离，名称加上"{applet}/"前缀，目录限制在applet根目录中，
+// 共享的名称及额外允许的目录在applet配置的badger节点中设置(见ScopeConfig)
+var appModule = map[string]sandbox.UserFunction{
+	//snippet:name=badger.take(),prefix=take;body=take($1);desc=take a badger db,usage=badger.take("db_name")
+	"take": take,
+	//snippet:name=badger.open(name);prefix=open;body=open($1);desc=open a exists badger database,if badger not exists,use name as dir and create a badger client;
+	//snippet:name=badger.open;prefix=open;body=open(${1:name},${2:dir});desc=open a badger database;
+	//snippet:name=badger.open;prefix=open;body=open(${1:name},${2:option});desc=open a badger database;
+	"open":        openBadger,
+	"restore":     restore,
+	"stats":       stats,
+	"maintenance": maintenance,
+}
+
+func take(app *sandbox.Applet, args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 1 {
+		return util.Error(tengo.ErrWrongNumArguments), nil
+	}
+	s, err := scopeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	if arg, ok := tengo.ToString(args[0]); ok {
+		name := s.name(arg)
+		if db, err := kvstore.Get(name); err == nil {
+			client := wrapBadgerClient(db, name)
+			client.scope = s
+			return client, nil
+		}
+	}
+	return tengo.FromInterface(fmt.Errorf("badger %v not found", args[0]))
+}
+
+var Entry = sandbox.NewRegistry("badger", module, appModule)
@@ -0,0 +1,178 @@
+package badgerlib
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	scopeConfigKey  = "badger"
+	scopeContextKey = "__badger_scope"
+)
+
+// ScopeConfig applet配置中的badger节点
+//
+//	badger:
+//	  shared: [DEFAULT_CRON_STORAGE]  # 不加applet前缀、进程内共享的数据库名称
+//	  allow_dirs: [/data/shared]      # applet根目录之外允许使用的目录
+type ScopeConfig struct {
+	Shared    []string `yaml:"shared"`
+	AllowDirs []string `yaml:"allow_dirs"`
+}
+
+// scope applet的数据库命名空间及目录限制
+type scope struct {
+	app       *sandbox.Applet
+	shared    map[string]bool
+	allowDirs []string
+
+	mu          sync.Mutex
+	maintenance *kvstore.MaintenanceOptions //applet级别的默认维护选项
+
+	stopMu  sync.Mutex
+	stopSeq uint64
+	stops   map[uint64]func() //applet停止时执行的清理(计数器、未提交的事务等)
+}
+
+// scopeLock 避免并发首次使用时重复创建scope及停止hook
+var scopeLock sync.Mutex
+
+func scopeOf(app *sandbox.Applet) (*scope, error) {
+	scopeLock.Lock()
+	defer scopeLock.Unlock()
+	if v, ok := app.Context.Get(scopeContextKey); ok {
+		if s, ok := v.(*scope); ok {
+			return s, nil
+		}
+	}
+	cfg := ScopeConfig{}
+	if v, ok := app.Config()[scopeConfigKey]; ok && v != nil {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid badger config:%s", err)
+		}
+	}
+	s := &scope{app: app, shared: map[string]bool{}, stops: map[uint64]func(){}}
+	for _, name := range cfg.Shared {
+		s.shared[name] = true
+	}
+	for _, dir := range cfg.AllowDirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(app.BaseDir(), dir)
+		}
+		s.allowDirs = append(s.allowDirs, filepath.Clean(dir))
+	}
+	app.Context.Set(scopeContextKey, s)
+	app.WithHook(sandbox.NewHook(sandbox.SigStop, func(applet *sandbox.Applet) error {
+		s.stop()
+		return nil
+	}))
+	return s, nil
+}
+
+// onStop 注册applet停止时执行的清理(后注册的先执行)，返回的函数用于提前注销(已自行清理时)
+func (s *scope) onStop(fn func()) (cancel func()) {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	s.stopSeq++
+	id := s.stopSeq
+	s.stops[id] = fn
+	return func() {
+		s.stopMu.Lock()
+		delete(s.stops, id)
+		s.stopMu.Unlock()
+	}
+}
+
+func (s *scope) stop() {
+	s.stopMu.Lock()
+	stops := s.stops
+	s.stops = map[uint64]func(){}
+	s.stopMu.Unlock()
+	ids := make([]uint64, 0, len(stops))
+	for id := range stops {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	for _, id := range ids {
+		stops[id]()
+	}
+}
+
+// name 数据库在kvstore中的名称，非共享的名称加上"{applet}/"前缀，内存数据库为":{applet}/name"
+func (s *scope) name(name string) string {
+	if s.shared[name] {
+		return name
+	}
+	if strings.HasPrefix(name, ":") {
+		return ":" + s.app.Name + "/" + name[1:]
+	}
+	return s.app.Name + "/" + name
+}
+
+// owns 数据库是否属于applet(含共享的数据库)
+func (s *scope) owns(name string) bool {
+	return s.shared[name] || strings.HasPrefix(name, s.app.Name+"/") || strings.HasPrefix(name, ":"+s.app.Name+"/")
+}
+
+// path 把路径限制在applet根目录或allow_dirs中
+func (s *scope) path(p string) (string, error) {
+	resolved, err := s.app.ResolvePath(p)
+	if err == nil || !filepath.IsAbs(p) {
+		return resolved, err
+	}
+	for _, dir := range s.allowDirs {
+		if resolved, e := sandbox.ResolvePathIn(dir, p); e == nil {
+			return resolved, nil
+		}
+	}
+	return "", err
+}
+
+func (s *scope) defaultMaintenance() *kvstore.MaintenanceOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maintenance
+}
+
+func (s *scope) setDefaultMaintenance(opt kvstore.MaintenanceOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maintenance = &opt
+}
+
+// ScopedName 返回applet中的数据库名称在kvstore中的实际名称
+func ScopedName(app *sandbox.Applet, name string) (string, error) {
+	s, err := scopeOf(app)
+	if err != nil {
+		return "", err
+	}
+	return s.name(name), nil
+}
+
+// ResolvePath 把路径解析到applet根目录(或配置允许的目录)下，超出范围时返回sandbox.ErrPathEscape
+func ResolvePath(app *sandbox.Applet, p string) (string, error) {
+	s, err := scopeOf(app)
+	if err != nil {
+		return "", err
+	}
+	return s.path(p)
+}
+
+// OnStop 注册applet停止时执行的清理(后注册的先执行)，返回的函数用于提前注销
+func OnStop(app *sandbox.Applet, fn func()) (cancel func(), err error) {
+	s, err := scopeOf(app)
+	if err != nil {
+		return nil, err
+	}
+	return s.onStop(fn), nil
+}
@@ -0,0 +1,100 @@
+package badgerlib
+
+import (
+	"errors"
+	"github.com/d5/tengo/v2"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newScopeApp(t *testing.T, name, config string) *sandbox.Applet {
+	dir := t.TempDir()
+	if config != "" {
+		if err := os.WriteFile(filepath.Join(dir, "application.yml"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app, err := sandbox.NewWithDir(name, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func str(s string) tengo.Object {
+	return &tengo.String{Value: s}
+}
+
+func TestScopeOpenAndTake(t *testing.T) {
+	a := newScopeApp(t, "scope_a", "badger:\n  shared: [scope_common]\n")
+	b := newScopeApp(t, "scope_b", "badger:\n  shared: [scope_common]\n")
+
+	ret, err := openBadger(a, str("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, ok := ret.(*badgerClient)
+	if !ok {
+		t.Fatalf("open failed:%v", ret)
+	}
+	t.Cleanup(func() { _ = kvstore.Close(client.Name) })
+	if client.Name != "scope_a/data" {
+		t.Fatalf("unexpected name %s", client.Name)
+	}
+	if want := filepath.Join(a.BaseDir(), "data"); client.DB.Opts().Dir != want {
+		t.Fatalf("expect dir %s, got %s", want, client.DB.Opts().Dir)
+	}
+	if ret, _ = take(a, str("data")); ret.TypeName() != "badger-client" {
+		t.Fatalf("owner should take its db:%v", ret)
+	}
+	if ret, _ = take(b, str("data")); ret.TypeName() == "badger-client" {
+		t.Fatal("another applet should not take the db")
+	}
+
+	ret, _ = openBadger(a, str(":scope_common"))
+	shared := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(shared.Name) })
+	if ret, _ = take(b, str(":scope_common")); ret.TypeName() == "badger-client" {
+		t.Fatal("in-memory name should be scoped unless configured")
+	}
+	ret, _ = openBadger(a, str("scope_common"), str("common"))
+	common := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(common.Name) })
+	if ret, _ = take(b, str("scope_common")); ret.TypeName() != "badger-client" {
+		t.Fatalf("shared db should be visible:%v", ret)
+	}
+
+	arr, _ := stats(b)
+	for _, st := range arr.(*tengo.Array).Value {
+		if name, _ := tengo.ToString(st.(*tengo.ImmutableMap).Value["name"]); name != "scope_common" {
+			t.Fatalf("stats should not expose %s", name)
+		}
+	}
+}
+
+func TestScopePathEscape(t *testing.T) {
+	outside := t.TempDir()
+	app := newScopeApp(t, "scope_c", "badger:\n  allow_dirs: ["+outside+"]\n")
+	for _, dir := range []string{"../other", "/etc/badger"} {
+		ret, _ := openBadger(app, str("x"), str(dir))
+		if _, ok := ret.(*badgerClient); ok {
+			t.Fatalf("%s should be rejected", dir)
+		}
+	}
+	if _, err := ResolvePath(app, "../other"); !errors.Is(err, sandbox.ErrPathEscape) {
+		t.Fatalf("expect escape error, got %v", err)
+	}
+	p, err := ResolvePath(app, filepath.Join(outside, "bak"))
+	if err != nil || p != filepath.Join(outside, "bak") {
+		t.Fatalf("allow_dirs not applied:%s %v", p, err)
+	}
+	ret, _ := openBadger(app, str(":mem"))
+	client := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(client.Name) })
+	if _, err = client.path("../x"); !errors.Is(err, sandbox.ErrPathEscape) {
+		t.Fatalf("client path should be confined, got %v", err)
+	}
+}
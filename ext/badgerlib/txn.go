@@ -0,0 +1,680 @@
+package badgerlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"github.com/dgraph-io/badger/v3"
+	log "github.com/sirupsen/logrus"
+	"lightbox/ext/util"
+	"lightbox/sandbox"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MaxTxnRetry 事务冲突(badger.ErrConflict)时的最大重试次数
+var MaxTxnRetry = 32
+
+var (
+	// ErrCompiledCallback 脚本函数无法在Go中回调，使用txn(ctx, script)或 txn(ctx) 返回的事务对象
+	ErrCompiledCallback = errors.New("script function callback is not supported, use txn(ctx, script) or tx := db.txn(ctx) ... tx.commit()")
+	// ErrTxnScript 事务脚本需要applet，只有通过badger模块打开的数据库可以使用
+	ErrTxnScript = errors.New("txn script requires a db opened by badger module")
+	// ErrTxnContext 手动事务需要脚本的ctx，脚本结束时discard未提交的事务
+	ErrTxnContext = errors.New("manual transaction requires ctx, use tx := db.txn(ctx)")
+	// ErrManagedTxn txn(script)中的事务在脚本结束后自动提交
+	ErrManagedTxn = errors.New("transaction is committed by txn(script), commit/discard is not allowed")
+)
+
+// txnPlaceHolders 事务脚本中可用的变量，脚本给result赋值作为txn的返回值
+var txnPlaceHolders = util.PlaceHolders{"tx": nil, "args": nil, "result": nil}
+
+// UpdateRetry 执行读写事务，遇到冲突时退避后自动重试
+func UpdateRetry(db *badger.DB, fn func(txn *badger.Txn) error) error {
+	var err error
+	for i := 0; i <= MaxTxnRetry; i++ {
+		if err = db.Update(fn); err != badger.ErrConflict {
+			return err
+		}
+		time.Sleep(time.Duration(rand.Intn(i+1)) * time.Millisecond)
+	}
+	return err
+}
+
+func (b *badgerClient) UpdateRetry(fn func(txn *badger.Txn) error) error {
+	return UpdateRetry(b.DB, fn)
+}
+
+// SetWithTTL 写入一个会在ttl之后过期的key
+func (b *badgerClient) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return b.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte(key), value).WithTTL(ttl))
+	})
+}
+
+// CompareAndSet 当key的当前值等于old时写入value，old为nil表示key必须不存在，value为nil表示删除
+func (b *badgerClient) CompareAndSet(key string, old, value []byte) (bool, error) {
+	swapped := false
+	err := b.UpdateRetry(func(txn *badger.Txn) error {
+		swapped = false
+		cur, err := txnGet(txn, []byte(key))
+		if err != nil {
+			return err
+		}
+		if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+			return nil
+		}
+		if value == nil {
+			err = txn.Delete([]byte(key))
+		} else {
+			err = txn.Set([]byte(key), value)
+		}
+		swapped = err == nil
+		return err
+	})
+	return swapped, err
+}
+
+// Incr 在事务中原子的增加计数器并返回新值
+func (b *badgerClient) Incr(key string, delta int64) (int64, error) {
+	var n int64
+	err := b.UpdateRetry(func(txn *badger.Txn) error {
+		cur, err := txnGet(txn, []byte(key))
+		if err != nil {
+			return err
+		}
+		if n, err = decodeCounter(cur); err != nil {
+			return err
+		}
+		n += delta
+		return txn.Set([]byte(key), encodeCounter(n))
+	})
+	return n, err
+}
+
+// Load 使用WriteBatch批量写入，ttl为0表示不过期
+func (b *badgerClient) Load(data map[string][]byte, ttl time.Duration) error {
+	wb := b.NewWriteBatch()
+	defer wb.Cancel()
+	for k, v := range data {
+		entry := badger.NewEntry([]byte(k), v)
+		if ttl > 0 {
+			entry = entry.WithTTL(ttl)
+		}
+		if err := wb.SetEntry(entry); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// txnGet 读取key，不存在时返回nil
+func txnGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	itm, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return itm.ValueCopy(nil)
+}
+
+// 计数器保存为十进制字符串
+func encodeCounter(n int64) []byte {
+	return []byte(strconv.FormatInt(n, 10))
+}
+
+func decodeCounter(v []byte) (int64, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("counter value %q is not an integer", v)
+	}
+	return n, nil
+}
+
+func mergeCounter(existing, value []byte) []byte {
+	a, _ := decodeCounter(existing)
+	b, _ := decodeCounter(value)
+	return encodeCounter(a + b)
+}
+
+// toTTL int按秒处理，字符串按 time.ParseDuration 处理
+func toTTL(o tengo.Object) (time.Duration, error) {
+	switch v := o.(type) {
+	case *tengo.Int:
+		return time.Duration(v.Value) * time.Second, nil
+	case *tengo.String:
+		return time.ParseDuration(v.Value)
+	}
+	return 0, tengo.ErrInvalidArgumentType{Name: "ttl", Expected: "int(seconds)/duration string", Found: o.TypeName()}
+}
+
+// toValue 值为undefined时返回nil
+func toValue(name string, o tengo.Object) ([]byte, error) {
+	if o == tengo.UndefinedValue {
+		return nil, nil
+	}
+	v, ok := tengo.ToByteSlice(o)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: name, Expected: "string/bytes", Found: o.TypeName()}
+	}
+	return v, nil
+}
+
+func bytesOrUndefined(v []byte) tengo.Object {
+	if v == nil {
+		return tengo.UndefinedValue
+	}
+	return &tengo.Bytes{Value: v}
+}
+
+// txn 参数为脚本文件时在事务中执行脚本(变量tx、args)，冲突时重新执行脚本；参数为Go函数时同样自动重试；
+// txn(ctx)返回事务对象，由脚本自行commit，脚本执行结束时未提交的事务自动discard
+//
+//snippet:name=badger.txn(script);prefix=txn;body=txn(ctx,${1:script},${2:args});desc=run script with tx in a transaction, retry on conflict
+//snippet:name=badger.txn;prefix=txn;body=txn(ctx);
+func (b *badgerClient) txn(args ...tengo.Object) (tengo.Object, error) {
+	exec, args := sandbox.ExecArg(args)
+	if len(args) == 0 {
+		if exec == nil {
+			return util.Error(ErrTxnContext), nil
+		}
+		return b.manualTxn(exec), nil
+	}
+	if len(args) > 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	switch fn := args[0].(type) {
+	case *tengo.String:
+		if b.scope == nil {
+			return util.Error(ErrTxnScript), nil
+		}
+		var scriptArgs tengo.Object = tengo.UndefinedValue
+		if len(args) == 2 {
+			scriptArgs = args[1]
+		}
+		return b.txnScript(exec, fn.Value, scriptArgs)
+	case *tengo.CompiledFunction:
+		return util.Error(ErrCompiledCallback), nil
+	}
+	fn := args[0]
+	if !fn.CanCall() {
+		return nil, tengo.ErrInvalidArgumentType{Name: "fn", Expected: "script/callable", Found: fn.TypeName()}
+	}
+	var ret tengo.Object
+	err := b.UpdateRetry(func(txn *badger.Txn) error {
+		r, err := fn.Call(newTxnObject(txn, true))
+		if err != nil {
+			return err
+		}
+		if e, ok := r.(*tengo.Error); ok {
+			msg, _ := tengo.ToString(e.Value)
+			return errors.New(msg)
+		}
+		ret = r
+		return nil
+	})
+	if err != nil {
+		return util.Error(err), nil
+	}
+	if ret == nil {
+		return tengo.UndefinedValue, nil
+	}
+	return ret, nil
+}
+
+// txnScript 每次尝试都重新执行脚本，脚本出错时放弃事务；传入ctx时脚本的日志附加外层脚本的字段
+func (b *badgerClient) txnScript(exec *sandbox.Exec, script string, args tengo.Object) (tengo.Object, error) {
+	app := b.scope.app
+	ctx := context.Background()
+	if exec != nil {
+		ctx = exec.Context()
+	}
+	var (
+		ret     tengo.Object = tengo.TrueValue
+		attempt int
+	)
+	err := b.UpdateRetry(func(txn *badger.Txn) error {
+		attempt++
+		compiled, err := app.GetCompiled(script, txnPlaceHolders)
+		if err != nil {
+			return err
+		}
+		for k, v := range map[string]interface{}{"tx": newTxnObject(txn, true), "args": args, "result": tengo.UndefinedValue} {
+			if err = compiled.Set(k, v); err != nil {
+				return err
+			}
+		}
+		if err = app.RunCompiledWith(ctx, log.Fields{
+			"badger_db":          b.Name,
+			"badger_txn_attempt": attempt,
+		}, compiled); err != nil {
+			return err
+		}
+		if r := compiled.Get("result").Object(); r != tengo.UndefinedValue {
+			ret = r
+		}
+		return nil
+	})
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return ret, nil
+}
+
+// manualTxn 脚本执行结束时(包括可取消的RunContext)discard未提交的事务，避免阻塞badger的读水位和GC
+func (b *badgerClient) manualTxn(exec *sandbox.Exec) *txnObject {
+	t := newTxnObject(b.NewTransaction(true), false)
+	exec.OnEnd(t.txn.Discard)
+	return t
+}
+
+type txnObject struct {
+	tengo.ObjectImpl
+	txn         *badger.Txn
+	managed     bool //由txn(script)/txn(fn)提交
+	methodIndex map[string]tengo.Object
+}
+
+func (t *txnObject) TypeName() string {
+	return "badger-txn"
+}
+
+func (t *txnObject) String() string {
+	return "badger_txn<>"
+}
+
+func (t *txnObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	if m, ok := t.methodIndex[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+// finish 提交或放弃手动事务
+func (t *txnObject) finish(commit bool) error {
+	if commit {
+		return t.txn.Commit()
+	}
+	t.txn.Discard()
+	return nil
+}
+
+func newTxnObject(txn *badger.Txn, managed bool) *txnObject {
+	t := &txnObject{txn: txn, managed: managed}
+	t.methodIndex = map[string]tengo.Object{
+		//snippet:name=badger.tx.get;prefix=get;body=get(${1:key});
+		"get": &tengo.UserFunction{Name: "get", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			key, ok := tengo.ToByteSlice(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string/bytes", Found: args[0].TypeName()}
+			}
+			v, err := txnGet(t.txn, key)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return bytesOrUndefined(v), nil
+		}},
+		//snippet:name=badger.tx.set;prefix=set;body=set(${1:key},${2:value});
+		"set": &tengo.UserFunction{Name: "set", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 2 && len(args) != 3 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			entry, err := toEntry(args...)
+			if err != nil {
+				return nil, err
+			}
+			return util.Error(t.txn.SetEntry(entry)), nil
+		}},
+		//snippet:name=badger.tx.del;prefix=del;body=del(${1:key});
+		"del": &tengo.UserFunction{Name: "del", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			for _, arg := range args {
+				key, ok := tengo.ToByteSlice(arg)
+				if !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string/bytes", Found: arg.TypeName()}
+				}
+				if err := t.txn.Delete(key); err != nil {
+					return util.Error(err), nil
+				}
+			}
+			return tengo.TrueValue, nil
+		}},
+		//snippet:name=badger.tx.commit;prefix=commit;body=commit();
+		"commit": &tengo.UserFunction{Name: "commit", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if t.managed {
+				return nil, ErrManagedTxn
+			}
+			return util.Error(t.finish(true)), nil
+		}},
+		//snippet:name=badger.tx.discard;prefix=discard;body=discard();
+		"discard": &tengo.UserFunction{Name: "discard", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if t.managed {
+				return nil, ErrManagedTxn
+			}
+			return util.Error(t.finish(false)), nil
+		}},
+	}
+	return t
+}
+
+// toEntry 参数(key,value[,ttl])
+func toEntry(args ...tengo.Object) (*badger.Entry, error) {
+	key, ok := tengo.ToByteSlice(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string/bytes", Found: args[0].TypeName()}
+	}
+	value, ok := tengo.ToByteSlice(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "value", Expected: "string/bytes", Found: args[1].TypeName()}
+	}
+	entry := badger.NewEntry(key, value)
+	if len(args) > 2 {
+		ttl, err := toTTL(args[2])
+		if err != nil {
+			return nil, err
+		}
+		entry = entry.WithTTL(ttl)
+	}
+	return entry, nil
+}
+
+// counterObject 基于badger MergeOperator的计数器，add不需要事务，适合高频累加
+// 合并前key上保存的是增量，只能通过counter.get读取
+type counterObject struct {
+	tengo.ObjectImpl
+	key         string
+	op          *badger.MergeOperator
+	stopOnce    sync.Once
+	methodIndex map[string]tengo.Object
+}
+
+func (c *counterObject) TypeName() string {
+	return "badger-counter"
+}
+
+func (c *counterObject) String() string {
+	return fmt.Sprintf("badger_counter<%s>", c.key)
+}
+
+func (c *counterObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	if m, ok := c.methodIndex[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+// counter 返回key对应的合并计数器，同一个key复用一个MergeOperator
+//
+//snippet:name=badger.counter;prefix=counter;body=counter(${1:key});
+func (b *badgerClient) counter(key string) *counterObject {
+	b.counterLock.Lock()
+	defer b.counterLock.Unlock()
+	if c, ok := b.counters[key]; ok {
+		return c
+	}
+	if b.counters == nil {
+		b.counters = map[string]*counterObject{}
+	}
+	c := &counterObject{key: key, op: b.GetMergeOperator([]byte(key), mergeCounter, 200*time.Millisecond)}
+	//MergeOperator在stop之前一直有后台协程，applet停止时一并停止
+	cancel := func() {}
+	stop := func() {
+		c.stopOnce.Do(func() {
+			cancel()
+			b.counterLock.Lock()
+			delete(b.counters, key)
+			b.counterLock.Unlock()
+			c.op.Stop()
+		})
+	}
+	if b.scope != nil {
+		cancel = b.scope.onStop(stop)
+	}
+	c.methodIndex = map[string]tengo.Object{
+		"add": &tengo.UserFunction{Name: "add", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			delta := int64(1)
+			if len(args) == 1 {
+				var ok bool
+				if delta, ok = tengo.ToInt64(args[0]); !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "delta", Expected: "int", Found: args[0].TypeName()}
+				}
+			} else if len(args) > 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			return util.Error(c.op.Add(encodeCounter(delta))), nil
+		}},
+		"get": &tengo.UserFunction{Name: "get", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			v, err := c.op.Get()
+			if err != nil && err != badger.ErrKeyNotFound {
+				return util.Error(err), nil
+			}
+			n, err := decodeCounter(v)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: n}, nil
+		}},
+		"stop": &tengo.UserFunction{Name: "stop", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			stop()
+			return nil, nil
+		}},
+	}
+	b.counters[key] = c
+	return c
+}
+
+// batchObject WriteBatch封装，用于批量导入
+type batchObject struct {
+	tengo.ObjectImpl
+	wb          *badger.WriteBatch
+	count       int64
+	methodIndex map[string]tengo.Object
+}
+
+func (w *batchObject) TypeName() string {
+	return "badger-batch"
+}
+
+func (w *batchObject) String() string {
+	return fmt.Sprintf("badger_batch<count:%d>", w.count)
+}
+
+func (w *batchObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	if m, ok := w.methodIndex[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+//snippet:name=badger.batch;prefix=batch;body=batch();
+func (b *badgerClient) batch() *batchObject {
+	w := &batchObject{wb: b.NewWriteBatch()}
+	w.methodIndex = map[string]tengo.Object{
+		"set": &tengo.UserFunction{Name: "set", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 2 && len(args) != 3 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			entry, err := toEntry(args...)
+			if err != nil {
+				return nil, err
+			}
+			if err = w.wb.SetEntry(entry); err != nil {
+				return util.Error(err), nil
+			}
+			w.count++
+			return tengo.TrueValue, nil
+		}},
+		"del": &tengo.UserFunction{Name: "del", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			for _, arg := range args {
+				key, ok := tengo.ToByteSlice(arg)
+				if !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string/bytes", Found: arg.TypeName()}
+				}
+				if err := w.wb.Delete(key); err != nil {
+					return util.Error(err), nil
+				}
+				w.count++
+			}
+			return tengo.TrueValue, nil
+		}},
+		"count": &tengo.UserFunction{Name: "count", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			return &tengo.Int{Value: w.count}, nil
+		}},
+		"flush": &tengo.UserFunction{Name: "flush", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			return util.Error(w.wb.Flush()), nil
+		}},
+		"cancel": &tengo.UserFunction{Name: "cancel", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			w.wb.Cancel()
+			return nil, nil
+		}},
+	}
+	return w
+}
+
+// txnMethods badgerClient上与事务/TTL/批量写入相关的方法
+func (b *badgerClient) txnMethods() map[string]tengo.Object {
+	return map[string]tengo.Object{
+		//snippet:name=badger.set_with_ttl;prefix=set_with_ttl;body=set_with_ttl(${1:key},${2:value},"${3:10m}");
+		"set_with_ttl": &tengo.UserFunction{Name: "set_with_ttl", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 3 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			entry, err := toEntry(args...)
+			if err != nil {
+				return nil, err
+			}
+			return util.Error(b.Update(func(txn *badger.Txn) error {
+				return txn.SetEntry(entry)
+			})), nil
+		}},
+		"txn": &tengo.UserFunction{Name: "txn", Value: b.txn},
+		//snippet:name=badger.cas;prefix=cas;body=cas(${1:key},${2:old},${3:new});
+		"cas": &tengo.UserFunction{Name: "cas", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 3 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			key, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string", Found: args[0].TypeName()}
+			}
+			old, err := toValue("old", args[1])
+			if err != nil {
+				return nil, err
+			}
+			value, err := toValue("new", args[2])
+			if err != nil {
+				return nil, err
+			}
+			swapped, err := b.CompareAndSet(key, old, value)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return tengo.FromInterface(swapped)
+		}},
+		//snippet:name=badger.incr;prefix=incr;body=incr(${1:key},${2:1});
+		"incr": &tengo.UserFunction{Name: "incr", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 && len(args) != 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			key, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string", Found: args[0].TypeName()}
+			}
+			delta := int64(1)
+			if len(args) == 2 {
+				if delta, ok = tengo.ToInt64(args[1]); !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: "delta", Expected: "int", Found: args[1].TypeName()}
+				}
+			}
+			n, err := b.Incr(key, delta)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: n}, nil
+		}},
+		"counter": &tengo.UserFunction{Name: "counter", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			key, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string", Found: args[0].TypeName()}
+			}
+			return b.counter(key), nil
+		}},
+		//snippet:name=badger.collection;prefix=collection;body=collection(${1:name},[${2:indexes}]);
+		"collection": &tengo.UserFunction{Name: "collection", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			return openCollection(b.DB, args...)
+		}},
+		"batch": &tengo.UserFunction{Name: "batch", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			return b.batch(), nil
+		}},
+		//snippet:name=badger.load;prefix=load;body=load(${1:map});
+		"load": &tengo.UserFunction{Name: "load", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 && len(args) != 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			kvs, ok := args[0].(*tengo.Map)
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "data", Expected: "map", Found: args[0].TypeName()}
+			}
+			var ttl time.Duration
+			if len(args) == 2 {
+				var err error
+				if ttl, err = toTTL(args[1]); err != nil {
+					return nil, err
+				}
+			}
+			data := make(map[string][]byte, len(kvs.Value))
+			for k, v := range kvs.Value {
+				val, ok := tengo.ToByteSlice(v)
+				if !ok {
+					return nil, tengo.ErrInvalidArgumentType{Name: k, Expected: "string/bytes", Found: v.TypeName()}
+				}
+				data[k] = val
+			}
+			if err := b.Load(data, ttl); err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.Int{Value: int64(len(data))}, nil
+		}},
+	}
+}
+
+// isConflict 判断脚本得到的错误是否为事务冲突
+//
+//snippet:name=badger.is_conflict;prefix=is_conflict;body=is_conflict(${1:err});
+func isConflict(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	if e, ok := args[0].(*tengo.Error); ok {
+		if s, ok := tengo.ToString(e.Value); ok && s == badger.ErrConflict.Error() {
+			return tengo.TrueValue, nil
+		}
+	}
+	return tengo.FalseValue, nil
+}
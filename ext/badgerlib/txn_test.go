@@ -0,0 +1,279 @@
+package badgerlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"github.com/dgraph-io/badger/v3"
+	"lightbox/kvstore"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openMemClient(t *testing.T) *badgerClient {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return wrapBadgerClient(db, "mem")
+}
+
+func call(t *testing.T, o tengo.Object, method string, args ...tengo.Object) tengo.Object {
+	m, err := o.IndexGet(&tengo.String{Value: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := m.Call(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestIncrConcurrent(t *testing.T) {
+	b := openMemClient(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := b.Incr("n", 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := b.Incr("n", 0)
+	if err != nil || n != 400 {
+		t.Fatalf("expect 400, got %d %v", n, err)
+	}
+	err = b.View(func(txn *badger.Txn) error {
+		v, err := txnGet(txn, []byte("n"))
+		if err == nil && string(v) != "400" {
+			err = fmt.Errorf("expect decimal counter, got %q", v)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Update(func(txn *badger.Txn) error { return txn.Set([]byte("s"), []byte("abc")) }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.Incr("s", 1); err == nil {
+		t.Fatal("incr on non-integer value should fail")
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	b := openMemClient(t)
+	if ok, _ := b.CompareAndSet("k", nil, []byte("v1")); !ok {
+		t.Fatal("set on missing key should succeed")
+	}
+	if ok, _ := b.CompareAndSet("k", nil, []byte("v2")); ok {
+		t.Fatal("key exists, cas with nil should fail")
+	}
+	if ok, _ := b.CompareAndSet("k", []byte("v1"), []byte("v2")); !ok {
+		t.Fatal("cas v1->v2 should succeed")
+	}
+	if ok, _ := b.CompareAndSet("k", []byte("v2"), nil); !ok {
+		t.Fatal("cas delete should succeed")
+	}
+	if v, _ := b.Get("k"); v != nil {
+		t.Fatalf("expect deleted, got %s", v)
+	}
+}
+
+// inRun 在脚本执行中调用fn,参数为本次执行的ctx
+func inRun(t *testing.T, fn func(ctx tengo.Object)) {
+	app := newScopeApp(t, "txn_run", "")
+	_, err := app.Run([]byte(`fn(ctx)`), map[string]interface{}{"fn": &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+		fn(args[0])
+		return nil, nil
+	}}}, "run.tengo")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxnObject(t *testing.T) {
+	b := openMemClient(t)
+	b.Do(b.init)
+	inRun(t, func(ctx tengo.Object) { testTxnObject(t, b, ctx) })
+}
+
+func testTxnObject(t *testing.T, b *badgerClient, ctx tengo.Object) {
+	tx1 := call(t, b, "txn", ctx)
+	tx2 := call(t, b, "txn", ctx)
+	call(t, tx1, "get", &tengo.String{Value: "k"})
+	call(t, tx2, "get", &tengo.String{Value: "k"})
+	call(t, tx1, "set", &tengo.String{Value: "k"}, &tengo.String{Value: "1"})
+	call(t, tx2, "set", &tengo.String{Value: "k"}, &tengo.String{Value: "2"})
+	if ret := call(t, tx1, "commit"); ret != tengo.TrueValue {
+		t.Fatalf("commit: %v", ret)
+	}
+	ret := call(t, tx2, "commit")
+	if c, _ := isConflict(ret); c != tengo.TrueValue {
+		t.Fatalf("expect conflict, got %v", ret)
+	}
+
+	// Go函数回调会在冲突时重试
+	ret = call(t, b, "txn", &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+		return call(t, args[0], "set", &tengo.String{Value: "k"}, &tengo.String{Value: "3"}), nil
+	}})
+	if ret != tengo.TrueValue {
+		t.Fatalf("txn: %v", ret)
+	}
+	if v, _ := b.Get("k"); string(v) != "3" {
+		t.Fatalf("expect 3, got %s", v)
+	}
+	if _, ok := call(t, b, "txn", &tengo.CompiledFunction{}).(*tengo.Error); !ok {
+		t.Fatal("compiled function callback should return error")
+	}
+}
+
+func TestSetWithTTLAndLoad(t *testing.T) {
+	b := openMemClient(t)
+	b.Do(b.init)
+	call(t, b, "set_with_ttl", &tengo.String{Value: "t"}, &tengo.String{Value: "v"}, &tengo.String{Value: "1h"})
+	err := b.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get([]byte("t"))
+		if err != nil {
+			return err
+		}
+		if exp := time.Unix(int64(itm.ExpiresAt()), 0); time.Until(exp) < 59*time.Minute {
+			t.Errorf("unexpected expires %v", exp)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := call(t, b, "load", &tengo.Map{Value: map[string]tengo.Object{
+		"a": &tengo.String{Value: "1"},
+		"b": &tengo.String{Value: "2"},
+	}})
+	if n, _ := tengo.ToInt(ret); n != 2 {
+		t.Fatalf("load: %v", ret)
+	}
+	w := call(t, b, "batch")
+	call(t, w, "set", &tengo.String{Value: "c"}, &tengo.String{Value: "3"})
+	call(t, w, "del", &tengo.String{Value: "a"})
+	if ret := call(t, w, "flush"); ret != tengo.TrueValue {
+		t.Fatalf("flush: %v", ret)
+	}
+	data, _ := b.BatchGet([]string{"a", "b", "c"})
+	if len(data) != 2 || string(data["c"]) != "3" {
+		t.Fatalf("unexpected %v", data)
+	}
+}
+
+func TestMergeCounter(t *testing.T) {
+	b := openMemClient(t)
+	c := b.counter("hits")
+	for i := 0; i < 10; i++ {
+		call(t, c, "add", &tengo.Int{Value: 2})
+	}
+	if n := call(t, c, "get"); n.(*tengo.Int).Value != 20 {
+		t.Fatalf("expect 20, got %v", n)
+	}
+	if b.counter("hits") != c {
+		t.Fatal("counter should be reused")
+	}
+	call(t, c, "stop")
+	c = b.counter("hits")
+	defer call(t, c, "stop")
+	call(t, c, "add")
+	if n := call(t, c, "get"); n.(*tengo.Int).Value != 21 {
+		t.Fatalf("expect 21, got %v", n)
+	}
+}
+
+func TestTxnScript(t *testing.T) {
+	app := newScopeApp(t, "txn_script", "")
+	script := `
+v := tx.get("n")
+n := is_undefined(v) ? 0 : int(string(v))
+tx.set("n", string(n + args.delta))
+result = n + args.delta
+`
+	if err := os.WriteFile(filepath.Join(app.BaseDir(), "incr.tengo"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(app.BaseDir(), "commit.tengo"), []byte(`tx.commit()`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ret, _ := openBadger(app, str(":txn"))
+	b := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(b.Name) })
+
+	args := &tengo.Map{Value: map[string]tengo.Object{"delta": &tengo.Int{Value: 1}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ret := call(t, b, "txn", str("incr.tengo"), args); ret.TypeName() != "int" {
+				t.Errorf("txn script: %v", ret)
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := b.Get("n"); string(n) != "8" {
+		t.Fatalf("expect 8, got %s", n)
+	}
+	if _, ok := call(t, b, "txn", str("commit.tengo")).(*tengo.Error); !ok {
+		t.Fatal("commit inside txn script should fail")
+	}
+	if _, ok := call(t, openMemClient(t), "txn", str("incr.tengo")).(*tengo.Error); !ok {
+		t.Fatal("txn script requires applet")
+	}
+}
+
+func TestManualTxnDiscardedAtRunEnd(t *testing.T) {
+	app := newScopeApp(t, "txn_manual", "")
+	ret, _ := openBadger(app, str(":txn"))
+	b := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(b.Name) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//可取消的ctx(如/exec)在tengo创建的协程中执行,同样在结束时discard
+	for _, c := range []context.Context{context.Background(), ctx} {
+		compiled, err := app.RunContext(c, []byte(`tx := db.txn(ctx); tx.set("k", "v")`), map[string]interface{}{"db": b}, "manual.tengo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := compiled.Get("tx").Object().(*txnObject)
+		if err = tx.txn.Set([]byte("k2"), []byte("v")); !errors.Is(err, badger.ErrDiscardedTxn) {
+			t.Fatalf("pending txn should be discarded at run end, got %v", err)
+		}
+	}
+	if _, ok := call(t, b, "txn").(*tengo.Error); !ok {
+		t.Fatal("manual txn without ctx should fail")
+	}
+}
+
+func TestCounterStoppedWithApplet(t *testing.T) {
+	app := newScopeApp(t, "txn_counter", "")
+	ret, _ := openBadger(app, str(":txn"))
+	b := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(b.Name) })
+
+	c := b.counter("hits")
+	call(t, c, "add")
+	app.Shutdown("test")
+	b.counterLock.Lock()
+	defer b.counterLock.Unlock()
+	if len(b.counters) != 0 {
+		t.Fatal("counters should be stopped with applet")
+	}
+}
@@ -0,0 +1,247 @@
+package badgerlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	log "github.com/sirupsen/logrus"
+	"lightbox/ext/util"
+	"lightbox/sandbox"
+	"sync"
+	"sync/atomic"
+)
+
+// watchPlaceHolders 变更处理脚本中可用的变量
+var watchPlaceHolders = util.PlaceHolders{"key": "", "value": []byte{}, "version": 0, "deleted": false}
+
+var (
+	// ErrWatchScript 脚本处理变更时需要applet，只有通过badger模块打开的数据库可以使用
+	ErrWatchScript = errors.New("watch script requires a db opened by badger module")
+	// ErrWatchCallback 脚本函数无法在Go中回调，使用脚本文件处理变更
+	ErrWatchCallback = errors.New("script function callback is not supported, use a script file")
+)
+
+// watcher 订阅key前缀的变更，处理出错时记录日志并继续
+type watcher struct {
+	tengo.ObjectImpl
+	db       string
+	prefixes []string
+	handle   func(kv *pb.KV) error
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+	unwatch  func() //从applet停止时的清理中注销
+
+	delivered int64
+	failed    int64
+}
+
+func (w *watcher) TypeName() string {
+	return "badger-watcher"
+}
+
+func (w *watcher) String() string {
+	return fmt.Sprintf("watcher<%s %v>", w.db, w.prefixes)
+}
+
+func (w *watcher) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	switch name {
+	//snippet:name=watcher.stop;prefix=stop;body=stop();
+	case "stop":
+		return &tengo.UserFunction{Name: "stop", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			w.stop()
+			return tengo.TrueValue, nil
+		}}, nil
+	case "running":
+		return &tengo.UserFunction{Name: "running", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			select {
+			case <-w.done:
+				return tengo.FalseValue, nil
+			default:
+				return tengo.TrueValue, nil
+			}
+		}}, nil
+	case "delivered":
+		return &tengo.Int{Value: atomic.LoadInt64(&w.delivered)}, nil
+	case "failed":
+		return &tengo.Int{Value: atomic.LoadInt64(&w.failed)}, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+// stop 取消订阅并等待正在处理的变更完成
+func (w *watcher) stop() {
+	w.stopOnce.Do(func() {
+		w.cancel()
+		if w.unwatch != nil {
+			w.unwatch()
+		}
+	})
+	<-w.done
+}
+
+func (w *watcher) dispatch(kv *pb.KV) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return w.handle(kv)
+}
+
+// toPrefixes 参数为字符串/bytes或数组，空字符串订阅所有key
+func toPrefixes(o tengo.Object) ([]string, error) {
+	var prefixes []string
+	add := func(v tengo.Object) error {
+		b, ok := tengo.ToByteSlice(v)
+		if !ok {
+			return tengo.ErrInvalidArgumentType{Name: "prefix", Expected: "string/bytes", Found: v.TypeName()}
+		}
+		prefixes = append(prefixes, string(b))
+		return nil
+	}
+	switch v := o.(type) {
+	case *tengo.Array:
+		for _, p := range v.Value {
+			if err := add(p); err != nil {
+				return nil, err
+			}
+		}
+	case *tengo.ImmutableArray:
+		for _, p := range v.Value {
+			if err := add(p); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if err := add(o); err != nil {
+			return nil, err
+		}
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	return prefixes, nil
+}
+
+// deleted 订阅中不包含删除标记，删除的key只有空值，因此写入空值也会被当作删除
+func deleted(kv *pb.KV) bool {
+	return len(kv.Value) == 0
+}
+
+// scriptHandler 每个变更执行一次脚本，脚本中可使用key/value/version/deleted
+func (b *badgerClient) scriptHandler(app *sandbox.Applet, script string) func(kv *pb.KV) error {
+	return func(kv *pb.KV) error {
+		compiled, err := app.GetCompiled(script, watchPlaceHolders)
+		if err != nil {
+			return err
+		}
+		for k, v := range map[string]interface{}{
+			"key":     string(kv.Key),
+			"value":   kv.Value,
+			"version": int64(kv.Version),
+			"deleted": deleted(kv),
+		} {
+			if err = compiled.Set(k, v); err != nil {
+				return err
+			}
+		}
+		return app.RunCompiledWith(context.Background(), log.Fields{
+			"badger_db":  b.Name,
+			"badger_key": string(kv.Key),
+		}, compiled)
+	}
+}
+
+// callbackHandler 回调参数为{key,value,version,deleted}，返回error对象视为处理失败
+func callbackHandler(fn tengo.Object) func(kv *pb.KV) error {
+	return func(kv *pb.KV) error {
+		arg := &tengo.ImmutableMap{Value: map[string]tengo.Object{
+			"key":     &tengo.String{Value: string(kv.Key)},
+			"value":   &tengo.Bytes{Value: kv.Value},
+			"version": &tengo.Int{Value: int64(kv.Version)},
+			"deleted": tengo.FalseValue,
+		}}
+		if deleted(kv) {
+			arg.Value["deleted"] = tengo.TrueValue
+		}
+		ret, err := fn.Call(arg)
+		if err != nil {
+			return err
+		}
+		if e, ok := ret.(*tengo.Error); ok {
+			msg, _ := tengo.ToString(e.Value)
+			return errors.New(msg)
+		}
+		return nil
+	}
+}
+
+// Watch 订阅key前缀的变更(订阅在后台协程中建立，之后的写入才会通知)，applet停止时自动取消
+func (b *badgerClient) Watch(prefixes []string, handle func(kv *pb.KV) error) *watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{db: b.Name, prefixes: prefixes, handle: handle, cancel: cancel, done: make(chan struct{})}
+	if b.scope != nil {
+		w.unwatch = b.scope.onStop(w.stop)
+	}
+	matches := make([]pb.Match, 0, len(prefixes))
+	for _, p := range prefixes {
+		matches = append(matches, pb.Match{Prefix: []byte(p)})
+	}
+	go func() {
+		defer close(w.done)
+		err := b.Subscribe(ctx, func(kvs *badger.KVList) error {
+			for _, kv := range kvs.Kv {
+				if err := w.dispatch(kv); err != nil {
+					atomic.AddInt64(&w.failed, 1)
+					log.Errorf("badger %s watch %s error:%s", w.db, kv.Key, err)
+				} else {
+					atomic.AddInt64(&w.delivered, 1)
+				}
+			}
+			//返回错误会结束订阅，处理失败只记录
+			return nil
+		}, matches)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Errorf("badger %s watch stopped:%s", w.db, err)
+		}
+	}()
+	return w
+}
+
+// watch 参数(prefixes,script|callback)，
+// 注意：badger的订阅不区分删除与写入空值，deleted为true只表示value为空，需要区分时不要写入空值
+//
+//snippet:name=badger.watch;prefix=watch;body=watch([${1:prefix}],${2:script});desc=run script with key/value/version/deleted on changes (deleted means the value is empty, empty values are reported as deleted)
+func (b *badgerClient) watch(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	prefixes, err := toPrefixes(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var handle func(kv *pb.KV) error
+	switch fn := args[1].(type) {
+	case *tengo.String:
+		if b.scope == nil {
+			return util.Error(ErrWatchScript), nil
+		}
+		handle = b.scriptHandler(b.scope.app, fn.Value)
+	case *tengo.CompiledFunction:
+		return util.Error(ErrWatchCallback), nil
+	default:
+		if !fn.CanCall() {
+			return nil, tengo.ErrInvalidArgumentType{Name: "handler", Expected: "script/callable", Found: fn.TypeName()}
+		}
+		handle = callbackHandler(fn)
+	}
+	return b.Watch(prefixes, handle), nil
+}
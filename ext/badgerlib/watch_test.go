@@ -0,0 +1,146 @@
+package badgerlib
+
+import (
+	"github.com/d5/tengo/v2"
+	"lightbox/kvstore"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchScript(t *testing.T) {
+	app := newScopeApp(t, "watch_a", "")
+	script := `
+probe := import("probe")
+x := 1 / (key == "user:boom" ? 0 : 1)
+probe.record(key, deleted ? "<deleted>" : string(value), version > 0)
+`
+	if err := os.WriteFile(filepath.Join(app.BaseDir(), "on_change.tengo"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	m := tengo.NewModuleMap()
+	m.AddBuiltinModule("probe", map[string]tengo.Object{
+		"record": &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+			k, _ := tengo.ToString(args[0])
+			v, _ := tengo.ToString(args[1])
+			if args[2] != tengo.TrueValue {
+				t.Errorf("version missing for %s", k)
+			}
+			mu.Lock()
+			got = append(got, k+"="+v)
+			mu.Unlock()
+			return nil, nil
+		}},
+	})
+	app.WithModule(m)
+
+	ret, _ := openBadger(app, str(":watch"))
+	client := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(client.Name) })
+	ret, err := client.watch(&tengo.Array{Value: []tengo.Object{str("user:")}}, str("on_change.tengo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := ret.(*watcher)
+	time.Sleep(50 * time.Millisecond)
+
+	_ = client.Set(map[string][]byte{"user:boom": []byte("x")})
+	_ = client.Set(map[string][]byte{"user:1": []byte("tom"), "other": []byte("skip")})
+	_ = client.Delete([]string{"user:1"})
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 2 && atomic.LoadInt64(&w.delivered) == 2
+	})
+	if got[0] != "user:1=tom" || got[1] != "user:1=<deleted>" {
+		t.Fatalf("unexpected changes %v", got)
+	}
+	if atomic.LoadInt64(&w.failed) != 1 || atomic.LoadInt64(&w.delivered) != 2 {
+		t.Fatalf("expect 1 failed and 2 delivered, got %d %d", w.failed, w.delivered)
+	}
+
+	app.Shutdown("test")
+	select {
+	case <-w.done:
+	default:
+		t.Fatal("watcher should stop with applet")
+	}
+}
+
+func TestWatchCallback(t *testing.T) {
+	b := openMemClient(t)
+	changes := make(chan string, 10)
+	ret, err := b.watch(str("k"), &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+		m := args[0].(*tengo.ImmutableMap)
+		k, _ := tengo.ToString(m.Value["key"])
+		changes <- k
+		return nil, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := ret.(*watcher)
+	time.Sleep(50 * time.Millisecond)
+	_ = b.Set(map[string][]byte{"k1": []byte("v")})
+	select {
+	case k := <-changes:
+		if k != "k1" {
+			t.Fatalf("unexpected key %s", k)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+	w.stop()
+	_ = b.Set(map[string][]byte{"k2": []byte("v")})
+	time.Sleep(50 * time.Millisecond)
+	if len(changes) != 0 {
+		t.Fatal("stopped watcher should not deliver")
+	}
+	if ret, _ = b.watch(str("k"), str("on_change.tengo")); ret.TypeName() != "error" {
+		t.Fatalf("script handler requires applet, got %v", ret)
+	}
+}
+
+func TestWatchStopUnregisters(t *testing.T) {
+	app := newScopeApp(t, "watch_b", "")
+	ret, _ := openBadger(app, str(":watch"))
+	client := ret.(*badgerClient)
+	t.Cleanup(func() { _ = kvstore.Close(client.Name) })
+	noop := &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) { return nil, nil }}
+	for i := 0; i < 5; i++ {
+		ret, _ = client.watch(str("k"), noop)
+		ret.(*watcher).stop()
+	}
+	client.scope.stopMu.Lock()
+	n := len(client.scope.stops)
+	client.scope.stopMu.Unlock()
+	if n != 0 {
+		t.Fatalf("stopped watchers should be unregistered, got %d", n)
+	}
+
+	ret, _ = client.watch(str("k"), noop)
+	w := ret.(*watcher)
+	app.Shutdown("test")
+	select {
+	case <-w.done:
+	default:
+		t.Fatal("watcher should stop with applet")
+	}
+}
@@ -1,6 +1,7 @@
 package canallib
 
 import (
+	"context"
 	"github.com/go-mysql-org/go-mysql/canal"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
@@ -67,7 +68,12 @@ func (h *ScriptEventHandler) OnRow(rowsEvent *canal.RowsEvent) error {
 		}
 
 		_ = compiled.Set(Action, event.Action)
-		return compiled.Run()
+		return h.Applet.RunCompiledWith(context.Background(), log.Fields{
+			"canal_event":  "row",
+			"canal_schema": rowsEvent.Table.Schema,
+			"canal_table":  rowsEvent.Table.Name,
+			"canal_action": rowsEvent.Action,
+		}, compiled)
 	}, func(err error) bool {
 		log.Errorf("on row event error:%s", err)
 		return h.Handler.IgnoreError
@@ -90,7 +96,11 @@ func (h *ScriptEventHandler) OnRotate(event *replication.RotateEvent) error {
 		if err = compiled.Set(Event, wrappedEvent); err != nil {
 			return err
 		}
-		return compiled.Run()
+		return h.Applet.RunCompiledWith(context.Background(), log.Fields{
+			"canal_event":  "rotate",
+			"canal_binlog": string(event.NextLogName),
+			"canal_pos":    event.Position,
+		}, compiled)
 	}, h.Handler.IgnoreError)
 }
 func (h *ScriptEventHandler) OnTableChanged(schema string, table string) error {
@@ -109,7 +119,11 @@ func (h *ScriptEventHandler) OnTableChanged(schema string, table string) error {
 		if err = compiled.Set(Table, table); err != nil {
 			return err
 		}
-		return compiled.Run()
+		return h.Applet.RunCompiledWith(context.Background(), log.Fields{
+			"canal_event":  "table_changed",
+			"canal_schema": schema,
+			"canal_table":  table,
+		}, compiled)
 	}, func(err error) bool {
 		log.Errorf("on table changed event error:%s", err)
 		return h.Handler.IgnoreError
@@ -150,7 +164,12 @@ func (h *ScriptEventHandler) OnDDL(nextPos mysql.Position, queryEvent *replicati
 		if err != nil {
 			return err
 		}
-		return compiled.Run()
+		return h.Applet.RunCompiledWith(context.Background(), log.Fields{
+			"canal_event":  "ddl",
+			"canal_schema": string(queryEvent.Schema),
+			"canal_binlog": nextPos.Name,
+			"canal_pos":    nextPos.Pos,
+		}, compiled)
 	}, func(err error) bool {
 		log.Errorf("on ddl event error: %s", err)
 		return h.Handler.IgnoreError
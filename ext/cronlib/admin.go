@@ -0,0 +1,146 @@
+package cronlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"lightbox/sandbox"
+	"sort"
+)
+
+// ServiceInfo cron服务及其任务(供管理API使用)
+type ServiceInfo struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Jobs        []JobDetail `json:"jobs"`
+}
+
+// Services applet拥有的所有cron服务
+func Services(app *sandbox.Applet) []ServiceInfo {
+	var services []ServiceInfo
+	cronServices.Range(func(key, value any) bool {
+		if s, ok := value.(*CronService); ok && s.app != nil && s.app.Name == app.Name {
+			services = append(services, ServiceInfo{Name: s.Name, Description: s.Description, Jobs: s.Jobs()})
+		}
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services
+}
+
+// GetService 获取applet拥有的cron服务
+func GetService(app *sandbox.Applet, name string) (*CronService, error) {
+	if v, ok := cronServices.Load(name); ok {
+		if s, ok := v.(*CronService); ok && s.app != nil && s.app.Name == app.Name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("cron service %s not found", name)
+}
+
+func (s *CronService) setPaused(job JobDetail) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.paused == nil {
+		s.paused = map[string]JobDetail{}
+	}
+	job.Paused = true
+	s.paused[job.Name] = job
+}
+
+// Jobs 所有任务(包括已暂停的任务)
+func (s *CronService) Jobs() []JobDetail {
+	jobs := s.List()
+	s.mutex.Lock()
+	for _, job := range s.paused {
+		jobs = append(jobs, job)
+	}
+	s.mutex.Unlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+	return jobs
+}
+
+func (s *CronService) findExecutor(name string) *Executor {
+	for _, entry := range s.Entries() {
+		if executor, ok := entry.Job.(*Executor); ok && executor.Name == name {
+			return executor
+		}
+	}
+	return nil
+}
+
+// Trigger 立即执行一次任务(异步执行,不影响调度)
+func (s *CronService) Trigger(name string) error {
+	executor := s.findExecutor(name)
+	if executor == nil {
+		return fmt.Errorf("job %s not found or paused", name)
+	}
+	s.app.Logger().Infof("trigger job %s", executor)
+	go executor.Run()
+	return nil
+}
+
+// Pause 暂停任务,暂停状态会持久化
+func (s *CronService) Pause(name string) error {
+	executor := s.findExecutor(name)
+	if executor == nil {
+		return fmt.Errorf("job %s not found or paused", name)
+	}
+	s.RemoveByName(name)
+	job := executor.JobDetail
+	s.setPaused(job)
+	job.Paused = true
+	return s.saveJob(job)
+}
+
+// Resume 恢复已暂停的任务
+func (s *CronService) Resume(name string) error {
+	s.mutex.Lock()
+	job, ok := s.paused[name]
+	delete(s.paused, name)
+	s.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("job %s not paused", name)
+	}
+	job.Paused = false
+	return s.DoSchedule(job, true)
+}
+
+// RemoveJob 删除任务(包括存储中的任务)
+func (s *CronService) RemoveJob(name string) error {
+	ids := s.RemoveByName(name)
+	s.mutex.Lock()
+	_, paused := s.paused[name]
+	delete(s.paused, name)
+	s.mutex.Unlock()
+	if len(ids) == 0 && !paused {
+		return fmt.Errorf("job %s not found", name)
+	}
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(fmt.Sprintf(JobConfigKey, s.Name, name)))
+	})
+}
+
+func (s *CronService) saveJob(job JobDetail) error {
+	if s.db == nil {
+		return nil
+	}
+	val, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(&badger.Entry{
+			Key:      []byte(fmt.Sprintf(JobConfigKey, s.Name, job.Name)),
+			Value:    val,
+			UserMeta: byte(1),
+		})
+	})
+}
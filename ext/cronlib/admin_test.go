@@ -0,0 +1,45 @@
+package cronlib
+
+import (
+	"lightbox/kvstore"
+	"testing"
+)
+
+func TestCronService_PauseResume(t *testing.T) {
+	db, err := kvstore.Open("admin_test", kvstore.DefaultOptions(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = kvstore.Close("admin_test") })
+	svc, err := NewCronServiceWithDB(&ServiceConfig{Name: "admin_test", app: app}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = svc.DoSchedule(JobDetail{Name: "job1", Cron: "@every 1h"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if services := Services(app); len(services) == 0 {
+		t.Error("expect services of app")
+	}
+	if err = svc.Pause("job1"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := svc.Jobs(); len(jobs) != 1 || !jobs[0].Paused || len(svc.List()) != 0 {
+		t.Errorf("job should be paused: %+v", jobs)
+	}
+	if err = svc.Trigger("job1"); err == nil {
+		t.Error("paused job should not be triggered")
+	}
+	if err = svc.Resume("job1"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := svc.List(); len(jobs) != 1 || jobs[0].Paused {
+		t.Errorf("job should be resumed: %+v", jobs)
+	}
+	if err = svc.RemoveJob("job1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Jobs()) != 0 {
+		t.Error("job should be removed")
+	}
+}
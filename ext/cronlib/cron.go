@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/robfig/cron/v3"
 	"lightbox/ext/util"
+	"lightbox/kvstore"
 	"lightbox/sandbox"
 	"sync"
 	"time"
@@ -36,6 +38,8 @@ type (
 		Script  string                 `json:"script"`
 		CmdArgs []string               `json:"cmdArgs"`
 		Args    map[string]interface{} `json:"args"`
+		//Backup 备份kvstore(如cron自身的存储 DEFAULT_CRON_STORAGE)
+		Backup *kvstore.BackupPlan `json:"backup,omitempty"`
 	}
 	ExecEntries []ExecEntry
 	JobDetail   struct {
@@ -56,13 +60,16 @@ type (
 		Next time.Time
 		//上次执行时间
 		Prev time.Time
+		//Paused 已暂停(暂停的任务不会被调度,恢复时重新调度)
+		Paused bool `json:"paused,omitempty"`
 	}
 	CronService struct {
 		*cron.Cron
 		db *badger.DB
 		ServiceConfig
-		log   cron.Logger
-		mutex *sync.Mutex
+		log    cron.Logger
+		mutex  *sync.Mutex
+		paused map[string]JobDetail
 	}
 )
 
@@ -76,6 +83,16 @@ func (e ExecEntry) ToTengoObject() tengo.Object {
 		obj["cmdArgs"], _ = util.ToImmutableArray(e.CmdArgs...)
 	}
 	obj["args"], _ = tengo.FromInterface(e.Args)
+	if e.Backup != nil {
+		incremental, _ := tengo.FromInterface(e.Backup.Incremental)
+		obj["backup"] = &tengo.ImmutableMap{Value: map[string]tengo.Object{
+			"db":          &tengo.String{Value: e.Backup.DB},
+			"dir":         &tengo.String{Value: e.Backup.Dir},
+			"incremental": incremental,
+			"keep":        &tengo.Int{Value: int64(e.Backup.Keep)},
+			"max_age":     &tengo.String{Value: e.Backup.MaxAge},
+		}}
+	}
 	return &tengo.ImmutableMap{Value: obj}
 }
 
@@ -90,7 +107,7 @@ func (entries ExecEntries) ToTengoSlice() *tengo.Array {
 //Restore restore job from database
 func (s *CronService) Restore() error {
 	if s.db == nil {
-		s.app.Logger.Infof("%s store db is nil,store exit", s.Name)
+		s.app.Logger().Infof("%s store db is nil,store exit", s.Name)
 		return nil
 	}
 	var jobData [][]byte
@@ -105,28 +122,33 @@ func (s *CronService) Restore() error {
 				return nil
 			})
 			if err != nil {
-				s.app.Logger.Error("restore job data from database error", err)
+				s.app.Logger().Error("restore job data from database error", err)
 				return err
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		s.app.Logger.Error("restore service error", s.Name, err)
+		s.app.Logger().Error("restore service error", s.Name, err)
 	}
 	for _, data := range jobData {
 		job := &JobDetail{}
 		err = json.Unmarshal(data, job)
 		if err != nil {
-			s.app.Logger.Error("unmarshal job  error", err)
+			s.app.Logger().Error("unmarshal job  error", err)
 			return err
 		}
+		if job.Paused {
+			s.setPaused(*job)
+			s.app.Logger().Infof("restore paused job:%s", job.Name)
+			continue
+		}
 		err = s.DoSchedule(*job, false)
 		if err != nil {
-			s.app.Logger.Error("restore job  error", err)
+			s.app.Logger().Error("restore job  error", err)
 			return err
 		} else {
-			s.app.Logger.Infof("restore job:%+v", job)
+			s.app.Logger().Infof("restore job:%+v", job)
 		}
 	}
 	return nil
@@ -141,7 +163,7 @@ func (s *CronService) DoSchedule(job JobDetail, store bool) error {
 	}
 	removeIds := s.RemoveByName(job.Name)
 	if len(removeIds) > 0 {
-		s.app.Logger.Info("remove jobs :", removeIds)
+		s.app.Logger().Info("remove jobs :", removeIds)
 	}
 	var (
 		entryId cron.EntryID
@@ -272,6 +294,9 @@ func CronServiceConstructor(proxy *util.Proxy[*CronService]) {
 				return nil
 			}),
 		},
+		"trigger": &tengo.UserFunction{Name: "trigger", Value: stdlib.FuncASRE(s.Trigger)},
+		"pause":   &tengo.UserFunction{Name: "pause", Value: stdlib.FuncASRE(s.Pause)},
+		"resume":  &tengo.UserFunction{Name: "resume", Value: stdlib.FuncASRE(s.Resume)},
 		"list": &tengo.UserFunction{
 			Name: "list",
 			Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
@@ -48,7 +48,7 @@ func NewCronServiceWithDB(config *ServiceConfig, db *badger.DB) (*CronService, e
 			return instance, nil
 		}
 		slog := &logWrapper{
-			Entry: config.app.Logger,
+			Entry: config.app.Logger(),
 		}
 		opts := []cron.Option{
 			cron.WithLogger(slog), cron.WithSeconds(),
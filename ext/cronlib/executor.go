@@ -1,16 +1,28 @@
 package cronlib
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"lightbox/contract"
+	"lightbox/ext/badgerlib"
+	"lightbox/kvstore"
+	"lightbox/metrics"
 	"lightbox/sandbox"
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
+)
+
+var (
+	cronExecutions = metrics.NewCounter("lightbox_cron_executions_total", "Total number of cron job executions.",
+		"sandbox", "service", "job", "status")
+	cronDuration = metrics.NewHistogram("lightbox_cron_duration_seconds", "Cron job execution duration in seconds.", nil,
+		"sandbox", "service", "job")
 )
 
 type Executor struct {
@@ -52,27 +64,43 @@ func (e *Executor) Run() {
 	}
 }
 func (e *Executor) run() {
+	start := time.Now()
+	status := "ok"
+	defer func() {
+		cronExecutions.With(e.app.Name, e.service.Name, e.Name, status).Inc()
+		cronDuration.With(e.app.Name, e.service.Name, e.Name).Observe(time.Since(start).Seconds())
+	}()
 	if e.Script != "" {
-		if _, err := e.app.RunFile(e.JobDetail.Script, map[string]interface{}{}); err != nil {
-			e.app.Logger.Errorf("run job script %s:%s", e, err)
+		if _, err := e.app.RunFileWith(context.Background(), e.logFields(), e.JobDetail.Script, map[string]interface{}{}); err != nil {
+			e.app.Logger().Errorf("run job script %s:%s", e, err)
+			status = "error"
 			return
 		}
 	}
 	for _, entry := range e.Entry {
-		if entry.Cmd != "" {
+		if entry.Backup != nil {
+			if bf, err := e.backup(entry.Backup); err != nil {
+				e.app.Logger().Errorf("backup %s to %s error:%s", entry.Backup.DB, entry.Backup.Dir, err)
+				status = "error"
+			} else {
+				e.app.Logger().WithFields(e.logFields()).Infof("backup %s to %s/%s", entry.Backup.DB, entry.Backup.Dir, bf.File)
+			}
+		} else if entry.Cmd != "" {
 			out, err := exec.Command(entry.Cmd, entry.CmdArgs...).Output()
 			if err != nil {
-				e.app.Logger.Error("run command ", entry.Cmd, "with output", string(out), "with error", err)
+				e.app.Logger().Error("run command ", entry.Cmd, "with output", string(out), "with error", err)
+				status = "error"
 			} else {
-				e.app.Logger.WithFields(map[string]interface{}{
+				e.app.Logger().WithFields(map[string]interface{}{
 					"cmd":  entry.Cmd,
 					"args": strings.Join(entry.CmdArgs, " "),
 					"out":  string(out),
 				}).Infof("command line executed")
 			}
 		} else if entry.Script != "" {
-			if _, err := e.app.RunFile(entry.Script, entry.Args); err != nil {
-				e.app.Logger.Error("run script ", entry.Script, err)
+			if _, err := e.app.RunFileWith(context.Background(), e.logFields(), entry.Script, entry.Args); err != nil {
+				e.app.Logger().Error("run script ", entry.Script, err)
+				status = "error"
 			}
 		} else {
 			logrus.Infof("unknown job type %s (command or script not set)", e)
@@ -82,6 +110,27 @@ func (e *Executor) run() {
 	logrus.Infof("job %s finished", e)
 }
 
+// backup 备份计划中的数据库名称及目录按任务所属的applet解析
+func (e *Executor) backup(plan *kvstore.BackupPlan) (*kvstore.BackupFile, error) {
+	p := *plan
+	if p.DB == "" || p.Dir == "" {
+		return p.Run()
+	}
+	var err error
+	if p.DB, err = badgerlib.ScopedName(e.app, p.DB); err != nil {
+		return nil, err
+	}
+	if p.Dir, err = badgerlib.ResolvePath(e.app, p.Dir); err != nil {
+		return nil, err
+	}
+	return p.Run()
+}
+
+// logFields 任务脚本输出的日志附加任务名
+func (e *Executor) logFields() logrus.Fields {
+	return logrus.Fields{"cron_service": e.service.Name, "cron_job": e.Name}
+}
+
 func (e *Executor) String() string {
 	return fmt.Sprintf("[%s:%s](%s)(%s)", e.service.Name, e.Name, e.Cron, e.Script)
 }
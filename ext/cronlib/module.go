@@ -1,6 +1,7 @@
 package cronlib
 
 import (
+	"context"
 	"errors"
 	"github.com/d5/tengo/v2"
 	"github.com/d5/tengo/v2/stdlib"
@@ -100,6 +101,13 @@ func boot(app *sandbox.Applet, args ...tengo.Object) (ret tengo.Object, err erro
 	if err != nil {
 		return
 	}
+	//cron服务恢复完成后即为就绪
+	app.RegisterHealthCheck("cron", sandbox.HealthReady, func(ctx context.Context) error {
+		if db.IsClosed() {
+			return errors.New("cron storage closed")
+		}
+		return nil
+	})
 	//再次从恢复的数据库中寻找
 	if instance, ok := cronServices.Load(name); ok && instance != nil {
 		ret = util.NewProxy(instance.(*CronService)).WithConstructor(CronServiceConstructor)
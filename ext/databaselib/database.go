@@ -12,6 +12,7 @@ import (
 	"lightbox/env"
 	"lightbox/ext/util"
 	"lightbox/sandbox"
+	"sync"
 )
 
 type dbOpt struct {
@@ -19,10 +20,20 @@ type dbOpt struct {
 	DSN    string
 }
 
+// knownOptions 已打开的数据库的连接参数,连接池因空闲被关闭后使用名称可以重新打开
+func knownOptions(app *sandbox.Applet) *sync.Map {
+	if v, ok := app.Context.Get(DBOptions); ok {
+		return v.(*sync.Map)
+	}
+	return &sync.Map{}
+}
+
 func Get(app *sandbox.Applet, name string) (*sqlx.DB, error) {
 	if c, ok := app.Context.Get(DBCache); ok {
 		if cache, ok := c.(*env.GroupCache[*sqlx.DB, dbOpt]); ok {
-			return cache.Get(name, dbOpt{})
+			opt, _ := knownOptions(app).Load(name)
+			o, _ := opt.(dbOpt)
+			return cache.Get(name, o)
 		}
 	}
 	return nil, fmt.Errorf("database %s not exists", name)
@@ -31,19 +42,32 @@ func Get(app *sandbox.Applet, name string) (*sqlx.DB, error) {
 func GetOrOpen(app *sandbox.Applet, name string, driver string, dsn string) (*sqlx.DB, error) {
 	if c, ok := app.Context.Get(DBCache); ok {
 		if cache, ok := c.(*env.GroupCache[*sqlx.DB, dbOpt]); ok {
-			return cache.Get(name, dbOpt{Driver: driver, DSN: dsn})
+			db, err := cache.Get(name, dbOpt{Driver: driver, DSN: dsn})
+			if err == nil {
+				knownOptions(app).LoadOrStore(name, dbOpt{Driver: driver, DSN: dsn})
+			}
+			return db, err
 		}
 	}
 	return nil, fmt.Errorf("database %s not exists", name)
 
 }
 func Open(app *sandbox.Applet, driver string, dsn string) (*sqlx.DB, error) {
-	return GetOrOpen(app, base64.StdEncoding.EncodeToString([]byte(dsn)), driver, dsn)
+	return GetOrOpen(app, dsnName(dsn), driver, dsn)
 }
 
+// dsnName 未命名连接池在缓存中的名称
+func dsnName(dsn string) string {
+	return base64.StdEncoding.EncodeToString([]byte(dsn))
+}
+
+// Close 关闭连接池并从缓存中删除
 func Close(app *sandbox.Applet, name string) error {
-	if db, err := Get(app, name); err == nil && db != nil {
-		return db.Close()
+	knownOptions(app).Delete(name)
+	if c, ok := app.Context.Get(DBCache); ok {
+		if cache, ok := c.(*env.GroupCache[*sqlx.DB, dbOpt]); ok {
+			cache.Evict(name)
+		}
 	}
 	return nil
 }
@@ -74,7 +98,7 @@ func open(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
 		a, b := args[0].(*tengo.String), args[1].(*tengo.String)
 		if a != nil && b != nil {
 			if db, err := Open(app, a.Value, b.Value); err == nil {
-				return newDatabaseObject(app, db, b.Value), nil
+				return newDatabaseObject(app, db, dsnName(b.Value)), nil
 			} else {
 				return util.Error(err), nil
 			}
@@ -2,6 +2,7 @@ package databaselib
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"io/ioutil"
 	"lightbox/ext/util"
 	"lightbox/sandbox"
+	"lightbox/tracing"
 	"os"
 	"strings"
 	"time"
@@ -23,6 +25,14 @@ type Database struct {
 	db   *sqlx.DB
 }
 
+// conn 每次调用时从缓存获取连接池,连接池因空闲/ttl被关闭后按名称重新打开,同时刷新空闲时间
+func (d *Database) conn() (*sqlx.DB, error) {
+	if _, ok := d.app.Context.Get(DBCache); !ok {
+		return d.db, nil
+	}
+	return Get(d.app, d.name)
+}
+
 //snippet:name=database.close;prefix=close;body=close();desc=close database connection;
 func (d *Database) Close(args ...tengo.Object) (tengo.Object, error) {
 	return nil, Close(d.app, d.name)
@@ -30,6 +40,10 @@ func (d *Database) Close(args ...tengo.Object) (tengo.Object, error) {
 
 //snippet:name=database.exec_file;prefix=exec_file;body=exec_file($1);desc=exec sql file(multiline);
 func (d *Database) ExecFile(sql string) error {
+	db, err := d.conn()
+	if err != nil {
+		return err
+	}
 	sqlFile, err := os.Open(sql)
 	if err != nil {
 		return err
@@ -60,7 +74,7 @@ func (d *Database) ExecFile(sql string) error {
 		}
 		if !isPrefix && strings.HasSuffix(sline, ";") {
 			// run sql here
-			result, err := d.db.Exec(string(currentQuery[0 : len(currentQuery)-1]))
+			result, err := db.Exec(string(currentQuery[0 : len(currentQuery)-1]))
 			if err != nil {
 				return err
 			} else {
@@ -78,6 +92,17 @@ func (d *Database) ExecFile(sql string) error {
 //snippet:name=database.exec;prefix=exec;body=exec(${1:sql},${2:params});
 //snippet:name=database.exec;prefix=exec;body=exec(${1:sql},{${2:map}});
 func (d *Database) Exec(args ...tengo.Object) (tengo.Object, error) {
+	span := d.startSpan("SQL exec", args)
+	defer span.End()
+	ret, err := d.exec(args...)
+	if e, ok := ret.(*tengo.Error); ok {
+		span.SetStatus(tracing.StatusError, e.String())
+	}
+	span.SetError(err)
+	return ret, err
+}
+
+func (d *Database) exec(args ...tengo.Object) (tengo.Object, error) {
 	if len(args) < 1 {
 		return nil, errors.New("query function need least 1 argument(query)")
 	}
@@ -98,9 +123,13 @@ func (d *Database) Exec(args ...tengo.Object) (tengo.Object, error) {
 		buf, err = ioutil.ReadFile(query[1:])
 		query = string(buf)
 	}
+	db, err := d.conn()
+	if err != nil {
+		return util.Error(err), nil
+	}
 
 	if len(args) == 1 {
-		result, err = d.db.Exec(query)
+		result, err = db.Exec(query)
 	} else {
 		switch arg := args[1].(type) {
 		case *tengo.Array:
@@ -109,25 +138,25 @@ func (d *Database) Exec(args ...tengo.Object) (tengo.Object, error) {
 			if err != nil {
 				return util.Error(err), nil
 			}
-			result, err = d.db.Exec(query, valueArg...)
+			result, err = db.Exec(query, valueArg...)
 		case *tengo.Map:
 			var mapArg map[string]interface{}
 			mapArg = util.ToMap[any](arg.Value)
 			if err != nil {
 				return util.Error(err), nil
 			}
-			result, err = d.db.NamedExec(query, mapArg)
+			result, err = db.NamedExec(query, mapArg)
 		case *tengo.ImmutableMap:
 			var mapArg map[string]interface{}
 			mapArg = util.ToMap[any](arg.Value)
-			result, err = d.db.NamedExec(query, mapArg)
+			result, err = db.NamedExec(query, mapArg)
 		default:
 			valueArg := util.ToSlice[any](args[1:])
 			query, valueArg, err = sqlx.In(query, valueArg...)
 			if err != nil {
 				return util.Error(err), nil
 			}
-			result, err = d.db.Exec(query, valueArg...)
+			result, err = db.Exec(query, valueArg...)
 		}
 	}
 	if err != nil {
@@ -304,13 +333,17 @@ func (d *Database) SelectxFirst(args ...tengo.Object) (tengo.Object, error) {
 
 //snippet:name=database.set_max_open;prefix=set_max_open;body=set_max_open(${1:number});desc=set max open connection;
 func (d *Database) SetMaxOpen(num int) string {
-	d.db.SetMaxOpenConns(num)
+	if db, err := d.conn(); err == nil {
+		db.SetMaxOpenConns(num)
+	}
 	return "OK"
 }
 
 //snippet:name=database.set_max_idle;prefix=set_max_idle;body=set_max_idle(${1:number});desc=set max idle connection;
 func (d *Database) SetMaxIdle(num int) string {
-	d.db.SetMaxIdleConns(num)
+	if db, err := d.conn(); err == nil {
+		db.SetMaxIdleConns(num)
+	}
 	return "OK"
 }
 
@@ -320,7 +353,11 @@ func (d *Database) SetMaxIdleTime(dur string) error {
 	if err != nil {
 		return err
 	}
-	d.db.SetConnMaxIdleTime(idleTime)
+	db, err := d.conn()
+	if err != nil {
+		return err
+	}
+	db.SetConnMaxIdleTime(idleTime)
 	return nil
 }
 
@@ -330,10 +367,38 @@ func (d *Database) SetMaxLifeTime(dur string) error {
 	if err != nil {
 		return err
 	}
-	d.db.SetConnMaxLifetime(lifeTime)
+	db, err := d.conn()
+	if err != nil {
+		return err
+	}
+	db.SetConnMaxLifetime(lifeTime)
 	return nil
 }
+// startSpan SQL执行的span,语句为第一个参数
+func (d *Database) startSpan(name string, args []tengo.Object) *tracing.Span {
+	span := tracing.Start(context.Background(), name, tracing.KindClient)
+	if span == nil {
+		return nil
+	}
+	span.SetAttr("db.system", d.db.DriverName())
+	span.SetAttr("db.name", d.name)
+	if len(args) > 0 {
+		if query, ok := tengo.ToString(args[0]); ok {
+			span.SetAttr("db.statement", query)
+		}
+	}
+	return span
+}
+
 func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
+	span := d.startSpan("SQL query", args)
+	defer span.End()
+	rows, err := d.query(args)
+	span.SetError(err)
+	return rows, err
+}
+
+func (d *Database) query(args []tengo.Object) (*sqlx.Rows, error) {
 	if len(args) < 1 {
 		return nil, errors.New("query function need least 1 argument(sql)")
 	}
@@ -355,6 +420,10 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 		}
 		query = string(buf)
 	}
+	db, err := d.conn()
+	if err != nil {
+		return nil, err
+	}
 	if len(args) == 2 {
 		switch arg := args[1].(type) {
 		case *tengo.ImmutableArray:
@@ -368,7 +437,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 			if err != nil {
 				return nil, err
 			}
-			if stmt, err = d.db.Preparex(query); err != nil {
+			if stmt, err = db.Preparex(query); err != nil {
 				return nil, err
 			}
 			if rows, err = stmt.Queryx(valueArgs...); err != nil {
@@ -385,7 +454,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 			if err != nil {
 				return nil, err
 			}
-			if stmt, err = d.db.Preparex(query); err != nil {
+			if stmt, err = db.Preparex(query); err != nil {
 				return nil, err
 			}
 			if rows, err = stmt.Queryx(valueArgs...); err != nil {
@@ -397,7 +466,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 				nameStmt *sqlx.NamedStmt
 				argMap   map[string]interface{}
 			)
-			if nameStmt, err = d.db.PrepareNamed(query); err != nil {
+			if nameStmt, err = db.PrepareNamed(query); err != nil {
 				return nil, err
 			}
 			argMap = util.ToMap[any](arg.Value)
@@ -409,7 +478,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 				nameStmt *sqlx.NamedStmt
 				argMap   map[string]interface{}
 			)
-			if nameStmt, err = d.db.PrepareNamed(query); err != nil {
+			if nameStmt, err = db.PrepareNamed(query); err != nil {
 				return nil, err
 			}
 			argMap = util.ToMap[any](arg.Value)
@@ -421,7 +490,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 			var (
 				stmt *sqlx.Stmt
 			)
-			if stmt, err = d.db.Preparex(query); err != nil {
+			if stmt, err = db.Preparex(query); err != nil {
 				return nil, err
 			}
 			argSingle := tengo.ToInterface(args[1])
@@ -441,7 +510,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 		if err != nil {
 			return nil, err
 		}
-		if stmt, err = d.db.Preparex(query); err != nil {
+		if stmt, err = db.Preparex(query); err != nil {
 			return nil, err
 		}
 		if rows, err = stmt.Queryx(valueArgs...); err != nil {
@@ -450,7 +519,7 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 	} else {
 		//没有参数
 		var stmt *sqlx.Stmt
-		if stmt, err = d.db.Preparex(query); err != nil {
+		if stmt, err = db.Preparex(query); err != nil {
 			return nil, err
 		}
 		if rows, err = stmt.Queryx(); err != nil {
@@ -461,7 +530,11 @@ func (d *Database) doQuery(args []tengo.Object) (*sqlx.Rows, error) {
 }
 
 func (d *Database) String() string {
-	return fmt.Sprintf("connection:{name:%s,driver:%s,state:%v}", d.name, d.db.DriverName(), d.db.Stats())
+	db, err := d.conn()
+	if err != nil {
+		return fmt.Sprintf("connection:{name:%s,error:%s}", d.name, err)
+	}
+	return fmt.Sprintf("connection:{name:%s,driver:%s,state:%v}", d.name, db.DriverName(), db.Stats())
 }
 func (d *Database) queryMap(camelCase bool, args ...tengo.Object) (tengo.Object, error) {
 	rows, err := d.doQuery(args)
@@ -0,0 +1,59 @@
+package databaselib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/cookieY/sqlx"
+	"hash/fnv"
+	"lightbox/env"
+	"lightbox/metrics"
+	"lightbox/sandbox"
+)
+
+// dbLabel open(driver,dsn)打开的连接以base64(dsn)命名,指标中使用driver及dsn的hash避免泄露密码
+func dbLabel(name string, db *sqlx.DB) string {
+	if dsn, err := base64.StdEncoding.DecodeString(name); err == nil && len(dsn) > 0 && base64.StdEncoding.EncodeToString(dsn) == name {
+		h := fnv.New32a()
+		_, _ = h.Write(dsn)
+		return fmt.Sprintf("%s-%08x", db.DriverName(), h.Sum32())
+	}
+	return name
+}
+
+// poolCollector 连接池状态(sqlx.DB.Stats)
+func poolCollector(app *sandbox.Applet, c *env.GroupCache[*sqlx.DB, dbOpt]) metrics.Collector {
+	return func() []metrics.Family {
+		labels := []string{"sandbox", "db"}
+		gauge := func(name, help string) metrics.Family {
+			return metrics.Family{Name: name, Help: help, Type: metrics.TypeGauge, Labels: labels}
+		}
+		counter := func(name, help string) metrics.Family {
+			return metrics.Family{Name: name, Help: help, Type: metrics.TypeCounter, Labels: labels}
+		}
+		families := []metrics.Family{
+			gauge("lightbox_db_max_open_connections", "Maximum number of open connections to the database."),
+			gauge("lightbox_db_open_connections", "Number of established connections."),
+			gauge("lightbox_db_in_use_connections", "Number of connections currently in use."),
+			gauge("lightbox_db_idle_connections", "Number of idle connections."),
+			counter("lightbox_db_wait_count_total", "Total number of connections waited for."),
+			counter("lightbox_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection."),
+			counter("lightbox_db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns."),
+			counter("lightbox_db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime."),
+		}
+		c.Range(func(name string, db *sqlx.DB) bool {
+			if db == nil {
+				return true
+			}
+			s := db.Stats()
+			values := []string{app.Name, dbLabel(name, db)}
+			for i, v := range []float64{
+				float64(s.MaxOpenConnections), float64(s.OpenConnections), float64(s.InUse), float64(s.Idle),
+				float64(s.WaitCount), s.WaitDuration.Seconds(), float64(s.MaxIdleClosed), float64(s.MaxLifetimeClosed),
+			} {
+				families[i].Samples = append(families[i].Samples, metrics.Sample{Values: values, Value: v})
+			}
+			return true
+		})
+		return families
+	}
+}
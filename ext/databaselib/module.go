@@ -1,17 +1,52 @@
 package databaselib
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/cookieY/sqlx"
 	"github.com/d5/tengo/v2"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 	"lightbox/env"
+	"lightbox/metrics"
 	"lightbox/sandbox"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
-const DBCache = "__database__cache__"
+const (
+	DBCache   = "__database__cache__"
+	DBOptions = "__database__options__"
+
+	poolConfigKey = "database"
+)
+
+// poolConfig application.yml中database节点,连接池缓存设置(默认不淘汰)
+type poolConfig struct {
+	IdleTimeout time.Duration `yaml:"idle_timeout"` //连接池超过空闲时间未使用时关闭
+	TTL         time.Duration `yaml:"ttl"`          //连接池打开后超过ttl关闭
+	MaxPools    int           `yaml:"max_pools"`    //最多打开的连接池,超过时关闭最久未使用的
+	ErrorTTL    time.Duration `yaml:"error_ttl"`    //打开失败后,在error_ttl内直接返回错误
+}
+
+func poolConfigFromApp(app *sandbox.Applet) (poolConfig, error) {
+	var cfg poolConfig
+	v, ok := app.Config()[poolConfigKey]
+	if !ok || v == nil {
+		return cfg, nil
+	}
+	data, err := yaml.Marshal(v)
+	if err == nil {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("invalid database config:%s", err)
+	}
+	return cfg, nil
+}
 
 func AppModule(app *sandbox.Applet) map[string]tengo.Object {
 	app.Context.Set(DBCache, env.NewCache(func(option dbOpt) (*sqlx.DB, error) {
@@ -81,19 +116,51 @@ var Entry = sandbox.NewRegistry("database",
 			return nil, errors.New("driver or dsn is empty")
 		}
 		return sqlx.Connect(option.Driver, option.DSN)
+	}).OnEvict(func(name string, db *sqlx.DB, reason env.EvictReason) {
+		app.Logger().WithField("reason", reason).Info("auto close database ", name)
+		if db != nil {
+			if err := db.Close(); err != nil {
+				app.Logger().Error("close database error:", err)
+			}
+		}
 	})
+	cfg, err := poolConfigFromApp(app)
+	if err != nil {
+		app.Logger().Error(err)
+	}
+	if cfg.IdleTimeout > 0 {
+		c.WithIdleTimeout(cfg.IdleTimeout)
+	}
+	if cfg.TTL > 0 {
+		c.WithTTL(cfg.TTL)
+	}
+	c.WithMaxSize(cfg.MaxPools).WithErrorTTL(cfg.ErrorTTL)
 	app.Context.Set(DBCache, c)
-	app.WithHook(sandbox.NewHook(sandbox.SigStop, func(applet *sandbox.Applet) error {
-		c.EvictWith(func(name string, db *sqlx.DB) {
-			log.WithField("sandbox", app.Name).Info("auto close database ", name)
-			if db != nil {
-				if err := db.Close(); err != nil {
-					log.WithField("sandbox", app.Name).Error("close database error:", err)
-				}
-
+	app.Context.Set(DBOptions, &sync.Map{})
+	metrics.Register("database/"+app.Name, poolCollector(app, c))
+	//检查所有已打开的数据库连接
+	app.RegisterHealthCheck("database", sandbox.HealthReady, func(ctx context.Context) error {
+		var errs []string
+		c.Range(func(name string, db *sqlx.DB) bool {
+			if db == nil {
+				return true
 			}
+			if err := db.PingContext(ctx); err != nil {
+				errs = append(errs, name+":"+err.Error())
+			}
+			return true
 		})
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, ";"))
+		}
+		return nil
+	})
+	app.WithHook(sandbox.NewHook(sandbox.SigStop, func(applet *sandbox.Applet) error {
+		app.UnregisterHealthCheck("database")
+		metrics.Unregister("database/" + app.Name)
+		c.Close()
 		app.Context.Delete(DBCache)
+		app.Context.Delete(DBOptions)
 		return nil
 	}))
 	return nil
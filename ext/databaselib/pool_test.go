@@ -0,0 +1,76 @@
+package databaselib
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/cookieY/sqlx"
+	"github.com/d5/tengo/v2"
+	"lightbox/env"
+	"lightbox/ext/util"
+	"lightbox/sandbox"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stubDriver 只支持exec的测试驱动
+type stubDriver struct{}
+type stubConn struct{}
+type stubStmt struct{}
+type stubResult struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error)         { return stubConn{}, nil }
+func (stubConn) Prepare(string) (driver.Stmt, error)        { return stubStmt{}, nil }
+func (stubConn) Close() error                               { return nil }
+func (stubConn) Begin() (driver.Tx, error)                  { return nil, errors.New("not supported") }
+func (stubStmt) Close() error                               { return nil }
+func (stubStmt) NumInput() int                              { return -1 }
+func (stubStmt) Exec([]driver.Value) (driver.Result, error) { return stubResult{}, nil }
+func (stubStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (stubResult) LastInsertId() (int64, error) { return 1, nil }
+func (stubResult) RowsAffected() (int64, error) { return 1, nil }
+
+func init() {
+	sql.Register("stub", stubDriver{})
+}
+
+func TestHeldDatabaseSurvivesIdleEviction(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "application.yml"), []byte("database:\n  idle_timeout: 10ms\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := sandbox.NewWithDir("pool_test", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.WithHook(Entry.GetHooks()...)
+	app.Initialize()
+	defer app.Shutdown("test")
+
+	ret, err := open(app, &tengo.String{Value: "main"}, &tengo.String{Value: "stub"}, &tengo.String{Value: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := ret.(*util.ReflectProxy).Self.(*Database)
+	first := d.db
+
+	time.Sleep(20 * time.Millisecond)
+	v, _ := app.Context.Get(DBCache)
+	v.(*env.GroupCache[*sqlx.DB, dbOpt]).Expire()
+	if err = first.Ping(); err == nil {
+		t.Fatal("idle pool should be closed")
+	}
+	if ret, _ = d.exec(&tengo.String{Value: "update t set a=1"}); ret.TypeName() == "error" {
+		t.Fatalf("held database should reopen the pool: %v", ret)
+	}
+
+	Close(app, "main")
+	if ret, _ = d.exec(&tengo.String{Value: "update t set a=1"}); ret.TypeName() != "error" {
+		t.Fatal("closed database should not be reopened")
+	}
+}
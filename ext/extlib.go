@@ -4,6 +4,7 @@ package ext
 //go:generate go run genvscodesnippet.go
 import (
 	"github.com/d5/tengo/v2/stdlib"
+	"lightbox/ext/alertlib"
 	"lightbox/ext/amqplib"
 	"lightbox/ext/badgerlib"
 	"lightbox/ext/canallib"
@@ -11,15 +12,20 @@ import (
 	"lightbox/ext/cryptlib"
 	"lightbox/ext/databaselib"
 	"lightbox/ext/envlib"
+	"lightbox/ext/healthlib"
 	"lightbox/ext/helplib"
 	"lightbox/ext/httplib"
+	"lightbox/ext/kvlib"
 	"lightbox/ext/loglib"
 	"lightbox/ext/maillib"
+	"lightbox/ext/metricslib"
 	"lightbox/ext/osslib"
 	"lightbox/ext/pathlib"
+	"lightbox/ext/queuelib"
 	"lightbox/ext/redislib"
 	"lightbox/ext/syslib"
 	"lightbox/ext/tpllib"
+	"lightbox/ext/tracelib"
 	"lightbox/ext/uuidlib"
 	"lightbox/ext/xlslib"
 	"lightbox/sandbox"
@@ -39,6 +45,9 @@ var RegistryTable = sandbox.NewRegistryTable(
 	httplib.Entry,
 	maillib.SMTPEntry,
 	badgerlib.Entry,
+	badgerlib.DocEntry,
+	queuelib.Entry,
+	kvlib.Entry,
 	canallib.Entry,
 	cronlib.Entry,
 	tpllib.Entry,
@@ -51,4 +60,8 @@ var RegistryTable = sandbox.NewRegistryTable(
 	cryptlib.Entry,
 	helplib.Entry,
 	uuidlib.Entry,
+	healthlib.Entry,
+	alertlib.Entry,
+	tracelib.Entry,
+	metricslib.Entry,
 ).WithSourceModule(SourceModules).WithSourceModule(stdlib.SourceModules).WithModule(stdlib.BuiltinModules)
@@ -0,0 +1,103 @@
+package healthlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/sandbox"
+)
+
+const (
+	healthyVar = "healthy"
+	messageVar = "message"
+)
+
+// scriptCheck 以脚本作为健康检查:脚本执行出错或将全局变量healthy设置为false时检查失败
+func scriptCheck(app *sandbox.Applet, script string) sandbox.HealthCheckFunc {
+	return func(ctx context.Context) error {
+		compiled, err := app.RunFileContext(ctx, script, map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		if healthy := compiled.Get(healthyVar); healthy != nil && healthy.ValueType() == "bool" && !healthy.Bool() {
+			if msg := compiled.Get(messageVar).String(); msg != "" {
+				return errors.New(msg)
+			}
+			return fmt.Errorf("%s unhealthy", script)
+		}
+		return nil
+	}
+}
+
+func register(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "name", Expected: "string", Found: args[0].TypeName()}
+	}
+	script, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "script", Expected: "string", Found: args[1].TypeName()}
+	}
+	kind := sandbox.HealthReady
+	if len(args) == 3 {
+		s, _ := tengo.ToString(args[2])
+		var err error
+		if kind, err = sandbox.ParseHealthKind(s); err != nil {
+			return util.Error(err), nil
+		}
+	}
+	app.RegisterHealthCheck(name, kind, scriptCheck(app, script))
+	return nil, nil
+}
+
+func unregister(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, _ := tengo.ToString(args[0])
+	app.UnregisterHealthCheck(name)
+	return nil, nil
+}
+
+func names(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	return util.ToImmutableArray(app.HealthCheckNames()...)
+}
+
+func check(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	kind := sandbox.HealthReady
+	if len(args) == 1 {
+		s, _ := tengo.ToString(args[0])
+		var err error
+		if kind, err = sandbox.ParseHealthKind(s); err != nil {
+			return util.Error(err), nil
+		}
+	}
+	return reportToObject(app.CheckHealth(context.Background(), kind)), nil
+}
+
+func reportToObject(report sandbox.HealthReport) tengo.Object {
+	var checks []tengo.Object
+	for _, c := range report.Checks {
+		checks = append(checks, &tengo.ImmutableMap{Value: map[string]tengo.Object{
+			"name":     &tengo.String{Value: c.Name},
+			"status":   &tengo.String{Value: c.Status},
+			"error":    &tengo.String{Value: c.Error},
+			"duration": &tengo.String{Value: c.Duration},
+		}})
+	}
+	up := tengo.FalseValue
+	if report.IsUp() {
+		up = tengo.TrueValue
+	}
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"name":   &tengo.String{Value: report.Name},
+		"status": &tengo.String{Value: report.Status},
+		"up":     up,
+		"checks": &tengo.ImmutableArray{Value: checks},
+	}}
+}
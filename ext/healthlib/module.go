@@ -0,0 +1,19 @@
+package healthlib
+
+import (
+	"lightbox/sandbox"
+)
+
+var appModule = map[string]sandbox.UserFunction{
+	//snippet:name=health.register;prefix=register;body=register(${1:name},${2:script});desc=注册健康检查脚本(脚本出错或healthy=false时失败);
+	//snippet:name=health.register_live;prefix=register;body=register(${1:name},${2:script},"live");desc=注册存活检查脚本;
+	"register": register,
+	//snippet:name=health.unregister;prefix=unregister;body=unregister(${1:name});
+	"unregister": unregister,
+	//snippet:name=health.names;prefix=names;body=names();
+	"names": names,
+	//snippet:name=health.check;prefix=check;body=check(${1:"ready"});desc=执行健康检查(live/ready);
+	"check": check,
+}
+
+var Entry = sandbox.NewRegistry("health", nil, appModule)
@@ -12,6 +12,7 @@ import (
 	"lightbox/env"
 	"lightbox/ext/util"
 	"lightbox/sandbox"
+	"lightbox/tracing"
 	"net/http"
 	"net/url"
 	"strings"
@@ -133,10 +134,20 @@ func httpRequestInternal(app *sandbox.Applet, arg *tengo.Map) (tengo.Object, err
 		"header":  request.Header,
 		"payload": aPayload,
 	}).Trace("start http request")
+	span := tracing.Start(request.Context(), "HTTP "+request.Method, tracing.KindClient)
+	defer span.End()
+	span.SetAttr("http.method", request.Method)
+	span.SetAttr("http.url", request.URL.String())
+	tracing.Inject(span, request.Header)
 	response, err := client.Do(request)
 	if err != nil {
+		span.SetError(err)
 		return tengo.FromInterface(err)
 	}
+	span.SetAttr("http.status_code", response.StatusCode)
+	if response.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(tracing.StatusError, response.Status)
+	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
@@ -1,6 +1,7 @@
 package httplib
 
 import (
+	"context"
 	"fmt"
 	"github.com/d5/tengo/v2"
 	"github.com/d5/tengo/v2/stdlib"
@@ -8,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"lightbox/ext/util"
 	"lightbox/sandbox"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -73,6 +75,7 @@ func (s *httpServer) ListenAndServe() error {
 		return err
 	}
 	log.Infof("start listen & serve on %s", s.Addr)
+	defer s.registerHealth()()
 	return s.Server.ListenAndServe()
 }
 func (s *httpServer) ListenAndServeTLS(cert string, key string) error {
@@ -81,9 +84,33 @@ func (s *httpServer) ListenAndServeTLS(cert string, key string) error {
 		return err
 	}
 	log.Infof("start listen & serve with (%s,%s) on %s", cert, key, s.Addr)
+	defer s.registerHealth()()
 	return s.Server.ListenAndServeTLS(cert, key)
 }
 
+// registerHealth 注册端口监听的就绪检查,返回删除检查的函数(不属于applet时不注册)
+func (s *httpServer) registerHealth() func() {
+	if s.app == nil {
+		return func() {}
+	}
+	name := "http:" + s.Addr
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	s.app.RegisterHealthCheck(name, sandbox.HealthReady, func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+	return func() {
+		s.app.UnregisterHealthCheck(name)
+	}
+}
+
 func (s *httpServer) handle(path string, scriptFile string, methods ...string) error {
 	if filepath.Ext(scriptFile) == "" {
 		scriptFile = scriptFile + ".tengo"
@@ -99,7 +126,7 @@ func (s *httpServer) handle(path string, scriptFile string, methods ...string) e
 func (s *httpServer) init() {
 	s.router = mux.NewRouter()
 	s.Handler = s.router
-	logger := log.WithField("sandbox", s.app.Name)
+	logger := s.app.Logger()
 	s.indexGetMap = map[string]tengo.Object{
 		//snippet:name=httpserver.serve;prefix=serve;body=serve();desc=启动http服务;
 		"serve": &tengo.UserFunction{Value: stdlib.FuncARE(s.ListenAndServe)},
@@ -60,3 +60,11 @@ func TestServerRoute(t *testing.T) {
 
 	http.ListenAndServe(":8099", router)
 }
+
+func TestMethodLabel(t *testing.T) {
+	for method, want := range map[string]string{http.MethodGet: "GET", http.MethodPatch: "PATCH", "PROPFIND": "other", "get": "other"} {
+		if got := methodLabel(method); got != want {
+			t.Errorf("methodLabel(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package httplib
+
+import (
+	"context"
+	"fmt"
+	"lightbox/metrics"
+	"lightbox/sandbox"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	httpRequests = metrics.NewCounter("lightbox_http_requests_total", "Total number of HTTP requests handled by scripts.",
+		"sandbox", "route", "method", "status")
+	httpDuration = metrics.NewHistogram("lightbox_http_request_duration_seconds", "HTTP request duration in seconds.", nil,
+		"sandbox", "route", "method")
+)
+
+type observedKey struct{}
+
+// methodLabel 非标准的请求方法统一记为other,避免客户端任意的方法产生大量序列
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// observeRequest 记录请求数、耗时并创建server span(中间件与处理脚本只记录一次)
+// 返回的end在处理完成后调用
+func observeRequest(app *sandbox.Applet, writer http.ResponseWriter, request *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	if request.Context().Value(observedKey{}) != nil {
+		return writer, request, func() {}
+	}
+	start := time.Now()
+	request, fields := requestFields(writer, request)
+	request = request.WithContext(context.WithValue(request.Context(), observedKey{}, true))
+	sw := &statusWriter{ResponseWriter: writer}
+	request, endSpan := traceRequest(sw, request, fields)
+	end := func() {
+		endSpan()
+		route, method := fmt.Sprint(fields["route"]), methodLabel(request.Method)
+		httpRequests.With(app.Name, route, method, strconv.Itoa(sw.status())).Inc()
+		httpDuration.With(app.Name, route, method).Observe(time.Since(start).Seconds())
+	}
+	if isUpgrade(request) {
+		//升级协议的请求会接管连接,不包装writer,状态记为101
+		sw.code = http.StatusSwitchingProtocols
+		return writer, request, end
+	}
+	return sw, request, end
+}
+
+// isUpgrade 是否为websocket等升级协议的请求
+func isUpgrade(request *http.Request) bool {
+	if request.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range request.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
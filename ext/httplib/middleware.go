@@ -13,6 +13,9 @@ type ScriptMiddleWare struct {
 
 func (s *ScriptMiddleWare) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	app := s.server.app
+	writer, request, end := observeRequest(app, writer, request)
+	defer end()
+	request, fields := requestFields(writer, request)
 	compiled, err := app.GetCompiled(s.scriptFile, defaultHttpPlaceHolder)
 	if err != nil {
 		responseError(request.RequestURI, writer, 500, "compile failed", err)
@@ -43,7 +46,7 @@ func (s *ScriptMiddleWare) ServeHTTP(writer http.ResponseWriter, request *http.R
 		responseError(request.RequestURI, writer, 500, "set process error:", err)
 	}
 
-	err = compiled.Run()
+	err = app.RunCompiledWith(request.Context(), fields, compiled)
 	if err != nil {
 		responseError(request.RequestURI, writer, 500, "execute before middle ware "+s.scriptFile+" error: ", err)
 	}
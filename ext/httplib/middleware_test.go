@@ -0,0 +1,70 @@
+package httplib
+
+import (
+	"github.com/gorilla/websocket"
+	"lightbox/sandbox"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWebsocketThroughMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mw.tengo"), []byte(`process()`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := sandbox.NewWithDir("ws_test", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown("test")
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		typ, msg, err := conn.ReadMessage()
+		if err == nil {
+			_ = conn.WriteMessage(typ, msg)
+		}
+	})
+	srv := httptest.NewServer(NewScriptMiddleWare(&httpServer{app: app}, "mw.tengo")(echo))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "hi" {
+		t.Fatalf("expect echo, got %s %v", msg, err)
+	}
+}
+
+func TestObserveUpgradeNotWrapped(t *testing.T) {
+	app, err := sandbox.NewWithDir("observe_test", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rec := httptest.NewRecorder()
+	w, _, end := observeRequest(app, rec, req)
+	end()
+	if w != http.ResponseWriter(rec) {
+		t.Fatalf("upgrade request should not be wrapped, got %T", w)
+	}
+	if w, _, end = observeRequest(app, rec, httptest.NewRequest(http.MethodGet, "/", nil)); w == http.ResponseWriter(rec) {
+		t.Fatal("normal request should record status")
+	}
+	end()
+}
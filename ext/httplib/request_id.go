@@ -0,0 +1,34 @@
+package httplib
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const HeaderRequestID = "X-Request-Id"
+
+type requestFieldsKey struct{}
+
+// requestFields 请求的日志字段(request_id/route/method),请求头X-Request-Id为空时生成新的id并写入响应头
+// 中间件与处理脚本使用同一个请求id,返回的request携带了字段
+func requestFields(writer http.ResponseWriter, request *http.Request) (*http.Request, log.Fields) {
+	if fields, ok := request.Context().Value(requestFieldsKey{}).(log.Fields); ok {
+		return request, fields
+	}
+	id := request.Header.Get(HeaderRequestID)
+	if id == "" {
+		id = uuid.NewV4().String()
+	}
+	writer.Header().Set(HeaderRequestID, id)
+	route := request.URL.Path
+	if r := mux.CurrentRoute(request); r != nil {
+		if tpl, err := r.GetPathTemplate(); err == nil {
+			route = tpl
+		}
+	}
+	fields := log.Fields{"request_id": id, "route": route, "method": request.Method}
+	return request.WithContext(context.WithValue(request.Context(), requestFieldsKey{}, fields)), fields
+}
@@ -27,6 +27,9 @@ func responseError(url string, writer http.ResponseWriter, code int, message str
 }
 
 func (t *ScriptDirHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer, request, end := observeRequest(t.server.app, writer, request)
+	defer end()
+	request, fields := requestFields(writer, request)
 	srcFile := filepath.Join(t.scriptDir, request.URL.Path)
 	log.Infof("request %s,target script: %s:", request.RequestURI, srcFile)
 	if !strings.HasSuffix(srcFile, ".tengo") {
@@ -51,7 +54,7 @@ func (t *ScriptDirHandler) ServeHTTP(writer http.ResponseWriter, request *http.R
 	if err = compiled.Set("w", WrapResponse(writer)); err != nil {
 		responseError(request.RequestURI, writer, 500, "set response object", err)
 	}
-	if err = compiled.Run(); err != nil {
+	if err = t.server.app.RunCompiledWith(request.Context(), fields, compiled); err != nil {
 		responseError(request.RequestURI, writer, 500, "run action", err)
 	}
 }
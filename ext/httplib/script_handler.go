@@ -22,7 +22,10 @@ func NewScriptHandler(scriptFile string, server *httpServer) *ScriptHandler {
 func (s *ScriptHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 
 	app := s.server.app
-	app.Logger.WithField("url", request.RequestURI).WithField("method", request.Method).Debug("handle http request")
+	writer, request, end := observeRequest(app, writer, request)
+	defer end()
+	request, fields := requestFields(writer, request)
+	app.Logger().WithFields(fields).WithField("url", request.RequestURI).Debug("handle http request")
 
 	r := WrapRequest(request)
 	w := WrapResponse(writer)
@@ -38,7 +41,7 @@ func (s *ScriptHandler) ServeHTTP(writer http.ResponseWriter, request *http.Requ
 	for k, v := range vars {
 		varsMap.Value[k] = &tengo.String{Value: v}
 	}
-	_, err := app.RunFile(s.scriptFile, map[string]interface{}{
+	_, err := app.RunFileWith(request.Context(), fields, s.scriptFile, map[string]interface{}{
 		"request":    r,
 		"r":          r,
 		"response":   w,
@@ -0,0 +1,79 @@
+package httplib
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"lightbox/tracing"
+	"net"
+	"net/http"
+)
+
+// statusWriter 记录响应状态码
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// status 未写入时为200
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack websocket等升级协议的请求需要接管连接
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// traceRequest 为处理脚本创建server span,请求头traceparent作为父span,返回的请求ctx携带该span
+// 返回的end在处理完成后调用,未启用追踪时不做任何操作
+func traceRequest(sw *statusWriter, request *http.Request, fields log.Fields) (*http.Request, func()) {
+	if !tracing.Enabled() {
+		return request, func() {}
+	}
+	route := fmt.Sprint(fields["route"])
+	span := tracing.StartWithParent("HTTP "+request.Method+" "+route, tracing.KindServer, tracing.Extract(request.Header))
+	span.SetAttr("http.method", request.Method)
+	span.SetAttr("http.route", route)
+	span.SetAttr("http.target", request.RequestURI)
+	span.SetAttr("http.request_id", fields["request_id"])
+	fields["trace_id"] = span.Context().TraceID.String()
+	return request.WithContext(tracing.ContextWithSpan(request.Context(), span)), func() {
+		span.SetAttr("http.status_code", sw.status())
+		if sw.status() >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(sw.status()))
+		}
+		span.End()
+	}
+}
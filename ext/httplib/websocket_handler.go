@@ -2,6 +2,7 @@ package httplib
 
 import (
 	"errors"
+	"fmt"
 	"github.com/d5/tengo/v2"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
@@ -30,7 +31,8 @@ func (w *WebsocketHandler) ServeHTTP(writer http.ResponseWriter, request *http.R
 			return true
 		},
 	}
-	ws, err := upgrader.Upgrade(writer, request, nil)
+	request, fields := requestFields(writer, request)
+	ws, err := upgrader.Upgrade(writer, request, http.Header{HeaderRequestID: {fmt.Sprint(fields["request_id"])}})
 	if err != nil {
 		log.Error("upgrade http request error:", err)
 		return
@@ -100,7 +102,7 @@ func (w *WebsocketHandler) ServeHTTP(writer http.ResponseWriter, request *http.R
 			return nil, nil
 		}
 	}})
-	err = compiled.Run()
+	err = w.server.app.RunCompiledWith(request.Context(), fields, compiled)
 	if err != nil {
 		log.Error("run script error")
 	}
@@ -0,0 +1,160 @@
+package kvlib
+
+import (
+	"bytes"
+	"context"
+	"github.com/dgraph-io/badger/v3"
+	"lightbox/ext/badgerlib"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"time"
+)
+
+// badgerDriver 基于kvstore打开的badger数据库，关闭驱动不关闭数据库
+type badgerDriver struct {
+	db *badger.DB
+}
+
+// NewBadgerDriver 使用已打开的badger数据库
+func NewBadgerDriver(db *badger.DB) Driver {
+	return &badgerDriver{db: db}
+}
+
+// newBadgerDriver 数据库名称及目录按applet隔离(见badgerlib.ScopeConfig)
+func newBadgerDriver(app *sandbox.Applet, name string, cfg Config) (Driver, error) {
+	if cfg.DB == "" {
+		cfg.DB = name
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = cfg.DB
+	}
+	dbName, err := badgerlib.ScopedName(app, cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := badgerlib.ResolvePath(app, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := kvstore.Open(dbName, kvstore.DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+	return NewBadgerDriver(db), nil
+}
+
+// txnGet 读取值及过期时间，不存在时返回ErrNotFound
+func txnGet(txn *badger.Txn, key string) ([]byte, uint64, error) {
+	itm, err := txn.Get([]byte(key))
+	if err == badger.ErrKeyNotFound {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	v, err := itm.ValueCopy(nil)
+	return v, itm.ExpiresAt(), err
+}
+
+func (b *badgerDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	var v []byte
+	err := b.db.View(func(txn *badger.Txn) (err error) {
+		v, _, err = txnGet(txn, key)
+		return
+	})
+	return v, err
+}
+
+func (b *badgerDriver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry(key, value, ttl))
+	})
+}
+
+func entry(key string, value []byte, ttl time.Duration) *badger.Entry {
+	e := badger.NewEntry([]byte(key), value)
+	if ttl > 0 {
+		e = e.WithTTL(ttl)
+	}
+	return e
+}
+
+func (b *badgerDriver) Delete(ctx context.Context, keys ...string) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		for _, k := range keys {
+			if err := txn.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *badgerDriver) Scan(ctx context.Context, prefix string, limit int) ([]Pair, error) {
+	var entries []Pair
+	err := b.db.View(func(txn *badger.Txn) error {
+		p := []byte(prefix)
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = p
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, Pair{Key: string(it.Item().Key()), Value: v})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (b *badgerDriver) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	var n int64
+	err := badgerlib.UpdateRetry(b.db, func(txn *badger.Txn) error {
+		cur, expiresAt, err := txnGet(txn, key)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if n, err = parseCounter(cur); err != nil {
+			return err
+		}
+		n += delta
+		//与redis INCRBY一致，保留原有的过期时间
+		e := badger.NewEntry([]byte(key), formatCounter(n))
+		e.ExpiresAt = expiresAt
+		return txn.SetEntry(e)
+	})
+	return n, err
+}
+
+func (b *badgerDriver) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	swapped := false
+	err := badgerlib.UpdateRetry(b.db, func(txn *badger.Txn) error {
+		swapped = false
+		cur, _, err := txnGet(txn, key)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if (err == nil) != (old != nil) || !bytes.Equal(cur, old) {
+			return nil
+		}
+		if value == nil {
+			err = txn.Delete([]byte(key))
+		} else {
+			err = txn.SetEntry(entry(key, value, ttl))
+		}
+		swapped = err == nil
+		return err
+	})
+	return swapped, err
+}
+
+// Close 数据库由kvstore管理，不在这里关闭
+func (b *badgerDriver) Close() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+package kvlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lightbox/sandbox"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound key不存在
+	ErrNotFound = errors.New("key not found")
+	// ErrNotInteger incr的值不是十进制整数
+	ErrNotInteger = errors.New("value is not an integer")
+)
+
+// Pair scan返回的键值
+type Pair struct {
+	Key   string
+	Value []byte
+}
+
+// Driver 与存储无关的键值接口，计数器统一保存为十进制字符串
+type Driver interface {
+	// Get key不存在或已过期时返回ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set ttl为0表示不过期
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Scan 返回前缀匹配的键值，按key排序，limit<=0表示不限制
+	Scan(ctx context.Context, prefix string, limit int) ([]Pair, error)
+	Incr(ctx context.Context, key string, delta int64) (int64, error)
+	// CompareAndSet 当前值等于old时写入value，old为nil表示key必须不存在，value为nil表示删除
+	CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+	Close() error
+}
+
+// Config applet配置中kv节点下的一个存储
+//
+//	kv:
+//	  cache: {driver: redis, url: "redis://localhost:6379/0", prefix: "app:"}
+//	  local: {driver: badger, db: data}
+//	  test:  {driver: memory}
+type Config struct {
+	Driver string `yaml:"driver"`
+	//URL redis连接地址
+	URL string `yaml:"url"`
+	//Prefix 所有key附加的前缀(redis)
+	Prefix string `yaml:"prefix"`
+	//DB badger数据库名称(applet命名空间中)，Dir为空时同时作为目录
+	DB  string `yaml:"db"`
+	Dir string `yaml:"dir"`
+}
+
+// Factory 根据配置创建驱动
+type Factory func(app *sandbox.Applet, name string, cfg Config) (Driver, error)
+
+var (
+	factories   = map[string]Factory{}
+	factoriesMu sync.RWMutex
+)
+
+// RegisterDriver 注册驱动，配置中的driver字段为驱动名称
+func RegisterDriver(driver string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[driver] = f
+}
+
+func newDriver(app *sandbox.Applet, name string, cfg Config) (Driver, error) {
+	factoriesMu.RLock()
+	f, ok := factories[cfg.Driver]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("kv %s: unknown driver %q", name, cfg.Driver)
+	}
+	return f(app, name, cfg)
+}
+
+func init() {
+	RegisterDriver("memory", newMemoryDriver)
+	RegisterDriver("badger", newBadgerDriver)
+	RegisterDriver("redis", newRedisDriver)
+}
+
+// parseCounter 空值为0
+func parseCounter(v []byte) (int64, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return n, nil
+}
+
+func formatCounter(n int64) []byte {
+	return []byte(strconv.FormatInt(n, 10))
+}
+
+func sortEntries(entries []Pair, limit int) []Pair {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
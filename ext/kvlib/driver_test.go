@@ -0,0 +1,140 @@
+package kvlib
+
+import (
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testDrivers(t *testing.T) map[string]Driver {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return map[string]Driver{
+		"memory": NewMemoryDriver(),
+		"badger": NewBadgerDriver(db),
+	}
+}
+
+func TestDriverContract(t *testing.T) {
+	ctx := context.Background()
+	for name, d := range testDrivers(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := d.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expect ErrNotFound, got %v", err)
+			}
+			for _, k := range []string{"user:2", "user:1", "user:3", "order:1"} {
+				if err := d.Set(ctx, k, []byte("v-"+k), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if v, err := d.Get(ctx, "user:1"); err != nil || string(v) != "v-user:1" {
+				t.Fatalf("get user:1 = %q, %v", v, err)
+			}
+			entries, err := d.Scan(ctx, "user:", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 || entries[0].Key != "user:1" || entries[1].Key != "user:2" {
+				t.Fatalf("unexpected scan %v", entries)
+			}
+			if err = d.Delete(ctx, "user:1", "user:2"); err != nil {
+				t.Fatal(err)
+			}
+			if entries, _ = d.Scan(ctx, "user:", 0); len(entries) != 1 || string(entries[0].Value) != "v-user:3" {
+				t.Fatalf("unexpected scan after delete %v", entries)
+			}
+
+			if n, err := d.Incr(ctx, "counter", 5); err != nil || n != 5 {
+				t.Fatalf("incr = %d, %v", n, err)
+			}
+			if n, err := d.Incr(ctx, "counter", -2); err != nil || n != 3 {
+				t.Fatalf("incr = %d, %v", n, err)
+			}
+			if v, _ := d.Get(ctx, "counter"); string(v) != "3" {
+				t.Fatalf("counter stored as %q", v)
+			}
+			if _, err := d.Incr(ctx, "order:1", 1); !errors.Is(err, ErrNotInteger) {
+				t.Fatalf("expect ErrNotInteger, got %v", err)
+			}
+
+			if ok, err := d.CompareAndSet(ctx, "lock", nil, []byte("a"), 0); err != nil || !ok {
+				t.Fatalf("cas absent = %v, %v", ok, err)
+			}
+			if ok, _ := d.CompareAndSet(ctx, "lock", nil, []byte("b"), 0); ok {
+				t.Fatal("cas should fail when key exists")
+			}
+			if ok, _ := d.CompareAndSet(ctx, "lock", []byte("x"), []byte("b"), 0); ok {
+				t.Fatal("cas should fail on mismatch")
+			}
+			if ok, err := d.CompareAndSet(ctx, "lock", []byte("a"), nil, 0); err != nil || !ok {
+				t.Fatalf("cas delete = %v, %v", ok, err)
+			}
+			if _, err := d.Get(ctx, "lock"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("lock should be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDriverTTL(t *testing.T) {
+	ctx := context.Background()
+	drivers := testDrivers(t)
+	for _, d := range drivers {
+		if err := d.Set(ctx, "session", []byte("s"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Set(ctx, "hits", []byte("1"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+		//incr保留原有的过期时间
+		if _, err := d.Incr(ctx, "hits", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(2100 * time.Millisecond)
+	for name, d := range drivers {
+		for _, k := range []string{"session", "hits"} {
+			if _, err := d.Get(ctx, k); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("%s: %s should be expired, got %v", name, k, err)
+			}
+		}
+		if entries, _ := d.Scan(ctx, "", 0); len(entries) != 0 {
+			t.Fatalf("%s: expired keys scanned %v", name, entries)
+		}
+	}
+}
+
+func TestDriverConcurrentIncr(t *testing.T) {
+	ctx := context.Background()
+	for name, d := range testDrivers(t) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 25; j++ {
+					if _, err := d.Incr(ctx, "n", 1); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if v, _ := d.Get(ctx, "n"); string(v) != "200" {
+			t.Fatalf("%s: expect 200, got %s", name, v)
+		}
+	}
+}
+
+func TestUnknownDriver(t *testing.T) {
+	if _, err := newDriver(nil, "x", Config{Driver: "nope"}); err == nil {
+		t.Fatal("expect unknown driver error")
+	}
+}
@@ -0,0 +1,134 @@
+package kvlib
+
+import (
+	"bytes"
+	"context"
+	"lightbox/sandbox"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	value    []byte
+	expireAt time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expireAt.IsZero() && !now.Before(i.expireAt)
+}
+
+// memoryDriver 进程内的map，用于测试或单机临时数据，重启后丢失
+type memoryDriver struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+// NewMemoryDriver 创建内存驱动
+func NewMemoryDriver() Driver {
+	return &memoryDriver{items: map[string]memoryItem{}}
+}
+
+func newMemoryDriver(app *sandbox.Applet, name string, cfg Config) (Driver, error) {
+	return NewMemoryDriver(), nil
+}
+
+// get 调用方持有锁，过期的key在读取时删除
+func (m *memoryDriver) get(key string, now time.Time) ([]byte, bool) {
+	itm, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if itm.expired(now) {
+		delete(m.items, key)
+		return nil, false
+	}
+	return itm.value, true
+}
+
+func (m *memoryDriver) set(key string, value []byte, ttl time.Duration, now time.Time) {
+	itm := memoryItem{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		itm.expireAt = now.Add(ttl)
+	}
+	m.items[key] = itm
+}
+
+func (m *memoryDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.get(key, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (m *memoryDriver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value, ttl, time.Now())
+	return nil
+}
+
+func (m *memoryDriver) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.items, k)
+	}
+	return nil
+}
+
+func (m *memoryDriver) Scan(ctx context.Context, prefix string, limit int) ([]Pair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var entries []Pair
+	for k := range m.items {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if v, ok := m.get(k, now); ok {
+			entries = append(entries, Pair{Key: k, Value: append([]byte(nil), v...)})
+		}
+	}
+	return sortEntries(entries, limit), nil
+}
+
+func (m *memoryDriver) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	cur, _ := m.get(key, now)
+	n, err := parseCounter(cur)
+	if err != nil {
+		return 0, err
+	}
+	n += delta
+	//与redis INCRBY一致，保留原有的过期时间
+	itm := m.items[key]
+	itm.value = formatCounter(n)
+	m.items[key] = itm
+	return n, nil
+}
+
+func (m *memoryDriver) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	cur, ok := m.get(key, now)
+	if ok != (old != nil) || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	if value == nil {
+		delete(m.items, key)
+	} else {
+		m.set(key, value, ttl, now)
+	}
+	return true, nil
+}
+
+func (m *memoryDriver) Close() error {
+	return nil
+}
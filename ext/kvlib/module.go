@@ -0,0 +1,366 @@
+package kvlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"lightbox/ext/util"
+	"lightbox/sandbox"
+	"sync"
+	"time"
+)
+
+const (
+	kvConfigKey      = "kv"
+	storesContextKey = "__kv_stores"
+	// defaultScanLimit scan未指定limit时最多返回的数量
+	defaultScanLimit = 100
+)
+
+// stores applet已打开的驱动，applet停止时关闭
+type stores struct {
+	mu      sync.Mutex
+	app     *sandbox.Applet
+	drivers map[string]Driver
+}
+
+// storesLock 避免并发首次使用时重复创建stores
+var storesLock sync.Mutex
+
+func storesOf(app *sandbox.Applet) *stores {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	if v, ok := app.Context.Get(storesContextKey); ok {
+		if s, ok := v.(*stores); ok {
+			return s
+		}
+	}
+	s := &stores{app: app, drivers: map[string]Driver{}}
+	app.Context.Set(storesContextKey, s)
+	app.WithHook(sandbox.NewHook(sandbox.SigStop, func(applet *sandbox.Applet) error {
+		s.close()
+		app.Context.Delete(storesContextKey)
+		return nil
+	}))
+	return s
+}
+
+// config 读取kv节点下名为name的配置
+func (s *stores) config(name string) (Config, error) {
+	var all map[string]Config
+	v, ok := s.app.Config()[kvConfigKey]
+	if ok && v != nil {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return Config{}, err
+		}
+		if err = yaml.Unmarshal(data, &all); err != nil {
+			return Config{}, fmt.Errorf("invalid kv config:%s", err)
+		}
+	}
+	cfg, ok := all[name]
+	if !ok {
+		return cfg, fmt.Errorf("kv %s not configured", name)
+	}
+	return cfg, nil
+}
+
+func (s *stores) open(name string) (Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.drivers[name]; ok {
+		return d, nil
+	}
+	cfg, err := s.config(name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newDriver(s.app, name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.drivers[name] = d
+	return d, nil
+}
+
+func (s *stores) remove(name string) error {
+	s.mu.Lock()
+	d, ok := s.drivers[name]
+	delete(s.drivers, name)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return d.Close()
+}
+
+func (s *stores) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, d := range s.drivers {
+		if err := d.Close(); err != nil {
+			log.WithField("sandbox", s.app.Name).Errorf("close kv %s error:%s", name, err)
+		}
+	}
+	s.drivers = map[string]Driver{}
+}
+
+// open 按配置名称打开存储，同一applet中重复打开返回同一个驱动
+//
+//snippet:name=kv.open;prefix=open;body=open(${1:name});
+func open(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok || name == "" {
+		return nil, tengo.ErrInvalidArgumentType{Name: "name", Expected: "string", Found: args[0].TypeName()}
+	}
+	s := storesOf(app)
+	d, err := s.open(name)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return &storeObject{name: name, driver: d, stores: s}, nil
+}
+
+var appModule = map[string]sandbox.UserFunction{
+	"open": open,
+}
+
+var Entry = sandbox.NewRegistry("kv", nil, appModule)
+
+// storeObject 脚本中的存储，值以字符串读写
+type storeObject struct {
+	tengo.ObjectImpl
+	name   string
+	driver Driver
+	stores *stores
+}
+
+func (o *storeObject) TypeName() string {
+	return "kv-store"
+}
+
+func (o *storeObject) String() string {
+	return fmt.Sprintf("kv<%s>", o.name)
+}
+
+func (o *storeObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	var fn tengo.CallableFunc
+	switch name {
+	//snippet:name=kv.get;prefix=get;body=get(${1:key});desc=value string, undefined if not exists
+	case "get":
+		fn = o.get
+	//snippet:name=kv.set;prefix=set;body=set(${1:key},${2:value},${3:ttl});desc=ttl is int(seconds) or duration string, optional
+	case "set":
+		fn = o.set
+	//snippet:name=kv.del;prefix=del;body=del(${1:keys});
+	case "del":
+		fn = o.del
+	//snippet:name=kv.scan;prefix=scan;body=scan(${1:prefix},${2:100});desc=map of key/value with the prefix
+	case "scan":
+		fn = o.scan
+	//snippet:name=kv.incr;prefix=incr;body=incr(${1:key},${2:1});
+	case "incr":
+		fn = o.incr
+	//snippet:name=kv.cas;prefix=cas;body=cas(${1:key},${2:old},${3:new},${4:ttl});desc=undefined old means key must not exist, undefined new means delete
+	case "cas":
+		fn = o.cas
+	case "close":
+		fn = func(args ...tengo.Object) (tengo.Object, error) {
+			return util.Error(o.stores.remove(o.name)), nil
+		}
+	default:
+		return nil, fmt.Errorf("%s not exists", name)
+	}
+	return &tengo.UserFunction{Name: name, Value: fn}, nil
+}
+
+func toKey(o tengo.Object) (string, error) {
+	k, ok := tengo.ToString(o)
+	if !ok {
+		return "", tengo.ErrInvalidArgumentType{Name: "key", Expected: "string", Found: o.TypeName()}
+	}
+	return k, nil
+}
+
+// toValue undefined为nil，bytes原样保存，其他类型转为字符串
+func toValue(name string, o tengo.Object) ([]byte, error) {
+	switch v := o.(type) {
+	case *tengo.Undefined:
+		return nil, nil
+	case *tengo.Bytes:
+		return v.Value, nil
+	}
+	s, ok := tengo.ToString(o)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: name, Expected: "string", Found: o.TypeName()}
+	}
+	return []byte(s), nil
+}
+
+// toTTL int为秒，字符串如"1m30s"
+func toTTL(o tengo.Object) (time.Duration, error) {
+	switch v := o.(type) {
+	case *tengo.Int:
+		return time.Duration(v.Value) * time.Second, nil
+	case *tengo.String:
+		return time.ParseDuration(v.Value)
+	}
+	return 0, tengo.ErrInvalidArgumentType{Name: "ttl", Expected: "int(seconds)/duration string", Found: o.TypeName()}
+}
+
+func (o *storeObject) get(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	k, err := toKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	v, err := o.driver.Get(context.Background(), k)
+	if errors.Is(err, ErrNotFound) {
+		return tengo.UndefinedValue, nil
+	}
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return &tengo.String{Value: string(v)}, nil
+}
+
+func (o *storeObject) set(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	k, err := toKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	v, err := toValue("value", args[1])
+	if err != nil {
+		return nil, err
+	}
+	var ttl time.Duration
+	if len(args) == 3 {
+		if ttl, err = toTTL(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	return util.Error(o.driver.Set(context.Background(), k, v, ttl)), nil
+}
+
+// del 参数为多个key或key数组
+func (o *storeObject) del(args ...tengo.Object) (tengo.Object, error) {
+	var keys []string
+	for _, a := range args {
+		items := []tengo.Object{a}
+		switch v := a.(type) {
+		case *tengo.Array:
+			items = v.Value
+		case *tengo.ImmutableArray:
+			items = v.Value
+		}
+		for _, i := range items {
+			k, err := toKey(i)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+	}
+	return util.Error(o.driver.Delete(context.Background(), keys...)), nil
+}
+
+func (o *storeObject) scan(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) > 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	prefix := ""
+	limit := defaultScanLimit
+	if len(args) > 0 {
+		p, err := toKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		prefix = p
+	}
+	if len(args) == 2 {
+		n, ok := tengo.ToInt(args[1])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: "limit", Expected: "int", Found: args[1].TypeName()}
+		}
+		limit = n
+	}
+	entries, err := o.driver.Scan(context.Background(), prefix, limit)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	m := make(map[string]tengo.Object, len(entries))
+	for _, e := range entries {
+		m[e.Key] = &tengo.String{Value: string(e.Value)}
+	}
+	return &tengo.Map{Value: m}, nil
+}
+
+func (o *storeObject) incr(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	k, err := toKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	delta := int64(1)
+	if len(args) == 2 {
+		d, ok := tengo.ToInt64(args[1])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: "delta", Expected: "int", Found: args[1].TypeName()}
+		}
+		delta = d
+	}
+	n, err := o.driver.Incr(context.Background(), k, delta)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return &tengo.Int{Value: n}, nil
+}
+
+func (o *storeObject) cas(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	k, err := toKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	old, err := toValue("old", args[1])
+	if err != nil {
+		return nil, err
+	}
+	v, err := toValue("new", args[2])
+	if err != nil {
+		return nil, err
+	}
+	var ttl time.Duration
+	if len(args) == 4 {
+		if ttl, err = toTTL(args[3]); err != nil {
+			return nil, err
+		}
+	}
+	ok, err := o.driver.CompareAndSet(context.Background(), k, old, v, ttl)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	if ok {
+		return tengo.TrueValue, nil
+	}
+	return tengo.FalseValue, nil
+}
@@ -0,0 +1,118 @@
+package kvlib
+
+import (
+	"github.com/d5/tengo/v2"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testConfig = `
+kv:
+  cache: {driver: memory}
+  local: {driver: badger, db: data}
+`
+
+func newTestApp(t *testing.T) *sandbox.Applet {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "application.yml"), []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := sandbox.NewWithDir("kv_test", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.Shutdown("test")
+		_ = kvstore.Close("kv_test/data")
+	})
+	return app
+}
+
+func str(s string) tengo.Object {
+	return &tengo.String{Value: s}
+}
+
+func call(t *testing.T, o tengo.Object, name string, args ...tengo.Object) tengo.Object {
+	fn, err := o.IndexGet(str(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := fn.Call(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := ret.(*tengo.Error); ok {
+		t.Fatalf("%s: %v", name, e)
+	}
+	return ret
+}
+
+func TestOpenByConfigName(t *testing.T) {
+	app := newTestApp(t)
+	for _, name := range []string{"cache", "local"} {
+		s, err := open(app, str(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.(*storeObject); !ok {
+			t.Fatalf("open %s failed:%v", name, s)
+		}
+		if again, _ := open(app, str(name)); again.(*storeObject).driver != s.(*storeObject).driver {
+			t.Fatalf("%s: driver should be cached", name)
+		}
+
+		if ret := call(t, s, "get", str("k")); ret != tengo.UndefinedValue {
+			t.Fatalf("%s: expect undefined, got %v", name, ret)
+		}
+		call(t, s, "set", str("k"), &tengo.Int{Value: 1}, str("1m"))
+		if ret := call(t, s, "get", str("k")); ret.(*tengo.String).Value != "1" {
+			t.Fatalf("%s: unexpected value %v", name, ret)
+		}
+		if ret := call(t, s, "incr", str("k"), &tengo.Int{Value: 9}); ret.(*tengo.Int).Value != 10 {
+			t.Fatalf("%s: unexpected incr %v", name, ret)
+		}
+		if ret := call(t, s, "cas", str("k"), str("10"), str("11")); ret != tengo.TrueValue {
+			t.Fatalf("%s: cas failed", name)
+		}
+		if ret := call(t, s, "cas", str("lock"), tengo.UndefinedValue, str("x")); ret != tengo.TrueValue {
+			t.Fatalf("%s: cas absent failed", name)
+		}
+		m := call(t, s, "scan", str("")).(*tengo.Map).Value
+		if len(m) != 2 || m["k"].(*tengo.String).Value != "11" {
+			t.Fatalf("%s: unexpected scan %v", name, m)
+		}
+		call(t, s, "del", &tengo.Array{Value: []tengo.Object{str("k"), str("lock")}})
+		if m = call(t, s, "scan", str("")).(*tengo.Map).Value; len(m) != 0 {
+			t.Fatalf("%s: unexpected scan after del %v", name, m)
+		}
+	}
+	if _, err := kvstore.Get("kv_test/data"); err != nil {
+		t.Fatal("badger store should be scoped to the applet")
+	}
+	if ret, _ := open(app, str("missing")); ret.TypeName() != "error" {
+		t.Fatalf("expect error for unconfigured store, got %v", ret)
+	}
+}
+
+func TestStoresOfConcurrent(t *testing.T) {
+	app := newTestApp(t)
+	all := make([]*stores, 8)
+	wg := sync.WaitGroup{}
+	for i := range all {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			all[i] = storesOf(app)
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range all {
+		if s != all[0] {
+			t.Fatal("concurrent first use should share one stores")
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package kvlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"lightbox/ext/redislib"
+	"lightbox/sandbox"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scanCount 每次SCAN的建议数量
+const scanCount = 100
+
+// redisDriver 基于go-redis，所有key附加Prefix
+type redisDriver struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisDriver 使用已有的redis连接
+func NewRedisDriver(client *redis.Client, prefix string) Driver {
+	return &redisDriver{client: client, prefix: prefix}
+}
+
+func newRedisDriver(app *sandbox.Applet, name string, cfg Config) (Driver, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("kv %s: redis driver requires url", name)
+	}
+	proxy, err := redislib.NewClient(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisDriver(proxy.Value, cfg.Prefix), nil
+}
+
+func (r *redisDriver) key(k string) string {
+	return r.prefix + k
+}
+
+func (r *redisDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := r.client.Get(ctx, r.key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (r *redisDriver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.key(key), value, ttl).Err()
+}
+
+func (r *redisDriver) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	full := make([]string, len(keys))
+	for i, k := range keys {
+		full[i] = r.key(k)
+	}
+	return r.client.Del(ctx, full...).Err()
+}
+
+// globEscape 转义SCAN MATCH中的通配符
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Scan redis中的key无序，需要扫描出全部匹配的key排序后再按limit截取
+func (r *redisDriver) Scan(ctx context.Context, prefix string, limit int) ([]Pair, error) {
+	match := globEscape(r.key(prefix)) + "*"
+	var (
+		keys   []string
+		cursor uint64
+	)
+	seen := map[string]bool{}
+	for {
+		batch, next, err := r.client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range batch {
+			//SCAN可能返回重复的key
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(keys)
+	var entries []Pair
+	for i := 0; i < len(keys) && (limit <= 0 || len(entries) < limit); i += scanCount {
+		end := i + scanCount
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[i:end]
+		values, err := r.client.MGet(ctx, batch...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for j, k := range batch {
+			//扫描后过期或删除的key
+			s, ok := values[j].(string)
+			if !ok {
+				continue
+			}
+			entries = append(entries, Pair{Key: strings.TrimPrefix(k, r.prefix), Value: []byte(s)})
+		}
+	}
+	return sortEntries(entries, limit), nil
+}
+
+func (r *redisDriver) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := r.client.IncrBy(ctx, r.key(key), delta).Result()
+	if err != nil && strings.Contains(err.Error(), "not an integer") {
+		return 0, ErrNotInteger
+	}
+	return n, err
+}
+
+// CompareAndSet 使用WATCH/MULTI，被其他客户端修改时返回false
+func (r *redisDriver) CompareAndSet(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	k := r.key(key)
+	swapped := false
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		cur, err := tx.Get(ctx, k).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if (err == nil) != (old != nil) || !bytes.Equal(cur, old) {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if value == nil {
+				pipe.Del(ctx, k)
+			} else {
+				pipe.Set(ctx, k, value, ttl)
+			}
+			return nil
+		})
+		swapped = err == nil
+		return err
+	}, k)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	return swapped, err
+}
+
+func (r *redisDriver) Close() error {
+	return r.client.Close()
+}
@@ -8,51 +8,62 @@ import (
 	"lightbox/sandbox"
 )
 
-const (
-	sandboxName = "sandbox"
-)
+// logger 第一个参数为脚本变量ctx时使用本次执行的日志(附加请求id、路由、任务名等字段),如log.info(ctx, "msg")
+func logger(app *sandbox.Applet, args []tengo.Object) (*log.Entry, []tengo.Object) {
+	if exec, rest := sandbox.ExecArg(args); exec != nil {
+		return exec.Logger(), rest
+	}
+	return app.Logger(), args
+}
 
 var module = map[string]sandbox.UserFunction{
+	"query": query,
 
 	//Trace
 	"trace": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Trace)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Trace)(args...)
 	},
 	"trace_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Trace)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Trace)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	"traceln": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Traceln)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Traceln)(args...)
 	},
 	"traceln_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Traceln)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Traceln)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	"tracef": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).Tracef)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncASIs(l.Tracef)(args...)
 	},
 	"tracef_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Tracef)(args[1:]...)
+			return util.FuncASIs(l.WithFields(mm).Tracef)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
@@ -60,44 +71,50 @@ var module = map[string]sandbox.UserFunction{
 
 	//Deebug
 	"debug": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Debug)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Debug)(args...)
 	},
 	"debug_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Debug)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Debug)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	"debugln": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Debugln)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Debugln)(args...)
 	},
 	"debugln_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Debugln)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Debugln)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	"debugf": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).Debugf)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncASIs(l.Debugf)(args...)
 	},
 
 	"debugf_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Debugf)(args[1:]...)
+			return util.FuncASIs(l.WithFields(mm).Debugf)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
@@ -105,29 +122,33 @@ var module = map[string]sandbox.UserFunction{
 
 	//Info
 	"info": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Info)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Info)(args...)
 	},
 	"info_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Info)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Info)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	"infoln": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Infoln)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Infoln)(args...)
 	},
 	"infoln_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Infoln)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Infoln)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
@@ -135,59 +156,67 @@ var module = map[string]sandbox.UserFunction{
 
 	//snippet:name=log.infof;prefix=log.infof;body=log.infof(${1:format},${2:values});
 	"infof": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).Infof)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncASIs(l.Infof)(args...)
 	},
 	"infof_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Infof)(args[1:]...)
+			return util.FuncASIs(l.WithFields(mm).Infof)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	//Error
 	"err": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Error)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Error)(args...)
 	},
 	"err_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Error)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Error)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	"errln": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Errorln)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Errorln)(args...)
 	},
 	"errln_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Errorln)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Errorln)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	//snippet:name=log.errorf;prefix=log.errorf;body=log.errorf(${1:format},${2:arguments});
 	"errf": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).Errorf)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncASIs(l.Errorf)(args...)
 	},
 	"errf_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Errorf)(args[1:]...)
+			return util.FuncASIs(l.WithFields(mm).Errorf)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
@@ -196,45 +225,51 @@ var module = map[string]sandbox.UserFunction{
 
 	//snippet:name=log.warn;prefix=log.warn;body=log.warnf($1,$2);
 	"warn": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Warn)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Warn)(args...)
 	},
 	"warn_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Warn)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Warn)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	//snippet:name=log.warnln;prefix=log.warnln;body=log.warnf($1,$2);
 	"warnln": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).Warnln)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncAIs(l.Warnln)(args...)
 	},
 	"warnln_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncAIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Warnln)(args[1:]...)
+			return util.FuncAIs(l.WithFields(mm).Warnln)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
 	},
 	//snippet:name=log.warnf;prefix=log.warnf;body=log.warnf($1,$2);
 	"warnf": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
-		return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).Warnf)(args...)
+		l, args := logger(sandbox, args)
+		return util.FuncASIs(l.Warnf)(args...)
 	},
 	"warnf_with": func(sandbox *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+		l, args := logger(sandbox, args)
 		if len(args) < 1 {
 			return nil, tengo.ErrWrongNumArguments
 		}
 		m := tengo.ToInterface(args[0])
 		if mm, ok := m.(map[string]interface{}); ok {
-			return util.FuncASIs(log.WithField(sandboxName, sandbox.Name).WithFields(mm).Warnf)(args[1:]...)
+			return util.FuncASIs(l.WithFields(mm).Warnf)(args[1:]...)
 		} else {
 			return nil, errors.New("first argument must be a map")
 		}
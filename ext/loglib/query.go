@@ -0,0 +1,75 @@
+package loglib
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"lightbox/loghub"
+	"lightbox/sandbox"
+	"net/url"
+	"time"
+)
+
+// query 查询当前applet已存储的日志(需要启用日志存储)
+// snippet:name=log.query;prefix=log.query;body=log.query({since:"${1:1h}",level:"${2:error}",limit:${3:100}});
+// 条件: level(字符串或数组),since/until(RFC3339,时长或time),fields(map),filter(过滤表达式),limit,desc
+func query(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	store := loghub.DefaultStore()
+	if store == nil {
+		return nil, errors.New("log store not enabled")
+	}
+	values := url.Values{}
+	if len(args) > 0 {
+		cond, ok := tengo.ToInterface(args[0]).(map[string]interface{})
+		if !ok {
+			return nil, errors.New("query condition must be a map")
+		}
+		for k, v := range cond {
+			switch k {
+			case "fields":
+				fields, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, errors.New("fields must be a map")
+				}
+				for name, value := range fields {
+					values.Add("field", fmt.Sprintf("%s:%v", name, value))
+				}
+			case "desc":
+				if b, _ := v.(bool); b {
+					values.Set("order", "desc")
+				}
+			default:
+				switch vv := v.(type) {
+				case []interface{}:
+					for _, item := range vv {
+						values.Add(k, fmt.Sprint(item))
+					}
+				case time.Time:
+					values.Set(k, vv.Format(time.RFC3339Nano))
+				default:
+					values.Set(k, fmt.Sprint(vv))
+				}
+			}
+		}
+	}
+	q, err := loghub.ParseQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	//只能查询当前applet的日志
+	q.Sandbox = app.Name
+	entries, err := store.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, map[string]interface{}{
+			"level":   e.Level,
+			"time":    e.Time,
+			"message": e.Message,
+			"fields":  e.Fields,
+		})
+	}
+	return tengo.FromInterface(result)
+}
@@ -0,0 +1,293 @@
+package metricslib
+
+import (
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/metrics"
+	"lightbox/sandbox"
+	"time"
+)
+
+func registry(app *sandbox.Applet) (*Registry, error) {
+	if v, ok := app.Context.Get(RegistryKey); ok {
+		return v.(*Registry), nil
+	}
+	return nil, fmt.Errorf("metrics registry of %s not initialized", app.Name)
+}
+
+// labelValues 脚本传入的标签(map)按定义的顺序转换为标签值,未指定的标签为空
+func labelValues(m *metrics.Metric, arg tengo.Object) ([]string, error) {
+	var labels map[string]tengo.Object
+	switch a := arg.(type) {
+	case nil:
+	case *tengo.Map:
+		labels = a.Value
+	case *tengo.ImmutableMap:
+		labels = a.Value
+	default:
+		return nil, tengo.ErrInvalidArgumentType{Name: "labels", Expected: "map", Found: arg.TypeName()}
+	}
+	values := make([]string, len(m.Labels()))
+	found := 0
+	for i, l := range m.Labels() {
+		if v, ok := labels[l]; ok {
+			values[i], _ = tengo.ToString(v)
+			found++
+		}
+	}
+	if found != len(labels) {
+		return nil, fmt.Errorf("metric %s only has labels %v", m.Name(), m.Labels())
+	}
+	return values, nil
+}
+
+// metricFunc 指标的方法,参数为[value][,labels]
+func metricFunc(m *metrics.Metric, name string, withValue bool, fn func(float64, ...string) error, sign float64) *tengo.UserFunction {
+	return &tengo.UserFunction{Name: name, Value: func(args ...tengo.Object) (tengo.Object, error) {
+		value := 1.0
+		var labels tengo.Object
+		if withValue {
+			if len(args) < 1 || len(args) > 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			v, ok := tengo.ToFloat64(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "value", Expected: "float(compatible)", Found: args[0].TypeName()}
+			}
+			value = v
+			args = args[1:]
+		} else if len(args) > 1 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+		if len(args) == 1 {
+			labels = args[0]
+		}
+		values, err := labelValues(m, labels)
+		if err != nil {
+			return util.Error(err), nil
+		}
+		if err = fn(sign*value, values...); err != nil {
+			return util.Error(err), nil
+		}
+		return nil, nil
+	}}
+}
+
+func metricObject(m *metrics.Metric) tengo.Object {
+	obj := map[string]tengo.Object{
+		"name": &tengo.String{Value: m.Name()},
+	}
+	switch m.Type() {
+	case metrics.TypeCounter:
+		obj["inc"] = metricFunc(m, "inc", false, m.Add, 1)
+		obj["add"] = metricFunc(m, "add", true, m.Add, 1)
+	case metrics.TypeGauge:
+		obj["inc"] = metricFunc(m, "inc", false, m.Add, 1)
+		obj["dec"] = metricFunc(m, "dec", false, m.Add, -1)
+		obj["add"] = metricFunc(m, "add", true, m.Add, 1)
+		obj["set"] = metricFunc(m, "set", true, m.Set, 1)
+	case metrics.TypeHistogram, metrics.TypeSummary:
+		obj["observe"] = metricFunc(m, "observe", true, m.Observe, 1)
+	}
+	return &tengo.ImmutableMap{Value: obj}
+}
+
+func toStrings(arg tengo.Object) ([]string, error) {
+	arr, ok := arg.(*tengo.Array)
+	if !ok {
+		if im, ok := arg.(*tengo.ImmutableArray); ok {
+			arr = &tengo.Array{Value: im.Value}
+		} else {
+			return nil, tengo.ErrInvalidArgumentType{Name: "labels", Expected: "array", Found: arg.TypeName()}
+		}
+	}
+	result := make([]string, 0, len(arr.Value))
+	for _, v := range arr.Value {
+		s, _ := tengo.ToString(v)
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func toFloats(name string, arg tengo.Object) ([]float64, error) {
+	arr, ok := arg.(*tengo.Array)
+	if !ok {
+		if im, ok := arg.(*tengo.ImmutableArray); ok {
+			arr = &tengo.Array{Value: im.Value}
+		} else {
+			return nil, tengo.ErrInvalidArgumentType{Name: name, Expected: "array", Found: arg.TypeName()}
+		}
+	}
+	result := make([]float64, 0, len(arr.Value))
+	for _, v := range arr.Value {
+		f, ok := tengo.ToFloat64(v)
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: name, Expected: "float(compatible)", Found: v.TypeName()}
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// define 定义指标,args为name[,extra][,labels]
+func define(app *sandbox.Applet, typ metrics.Type, args []tengo.Object) (tengo.Object, error) {
+	if len(args) < 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "name", Expected: "string", Found: args[0].TypeName()}
+	}
+	opts := metrics.Opts{Name: name, Type: typ}
+	args = args[1:]
+	var err error
+	switch typ {
+	case metrics.TypeHistogram:
+		if len(args) < 1 || len(args) > 2 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+		if opts.Buckets, err = toFloats("buckets", args[0]); err != nil {
+			return nil, err
+		}
+		args = args[1:]
+	case metrics.TypeSummary:
+		if len(args) > 2 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+		if len(args) == 2 {
+			if opts.Objectives, err = toFloats("objectives", args[1]); err != nil {
+				return nil, err
+			}
+			args = args[:1]
+		}
+	default:
+		if len(args) > 1 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+	}
+	if len(args) == 1 {
+		if opts.Labels, err = toStrings(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	r, err := registry(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	m, err := r.Get(opts)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return metricObject(m), nil
+}
+
+// counter 计数器
+//
+//snippet:name=metrics.counter;prefix=counter;body=counter(${1:name},[${2:labels}]);desc=计数器,inc([labels])/add(value[,labels]);
+func counter(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	return define(app, metrics.TypeCounter, args)
+}
+
+// gauge 可增减的数值
+//
+//snippet:name=metrics.gauge;prefix=gauge;body=gauge(${1:name},[${2:labels}]);desc=数值,set/add(value[,labels]),inc/dec([labels]);
+func gauge(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	return define(app, metrics.TypeGauge, args)
+}
+
+// histogram 分布
+//
+//snippet:name=metrics.histogram;prefix=histogram;body=histogram(${1:name},[${2:buckets}],[${3:labels}]);desc=分布,observe(value[,labels]);
+func histogram(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	return define(app, metrics.TypeHistogram, args)
+}
+
+// summary 分位数(最近的观测值)
+//
+//snippet:name=metrics.summary;prefix=summary;body=summary(${1:name},[${2:labels}]);desc=分位数(默认0.5/0.9/0.99),observe(value[,labels]);
+func summary(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	return define(app, metrics.TypeSummary, args)
+}
+
+// push 定期把applet的指标写入文件(applet目录下),applet停止时写入最后一次(用于批处理脚本)
+//
+//snippet:name=metrics.push;prefix=push;body=push(${1:file},"${2:15s}");
+func push(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	file, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "file", Expected: "string", Found: args[0].TypeName()}
+	}
+	var interval time.Duration
+	if len(args) == 2 {
+		s, _ := tengo.ToString(args[1])
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return util.Error(err), nil
+		}
+		interval = d
+	}
+	r, err := registry(app)
+	if err == nil {
+		err = r.Push(file, interval)
+	}
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return nil, nil
+}
+
+// write 立即把applet的指标写入文件(applet目录下)
+//
+//snippet:name=metrics.write;prefix=write;body=write(${1:file});
+func write(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	file, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "file", Expected: "string", Found: args[0].TypeName()}
+	}
+	r, err := registry(app)
+	if err == nil {
+		err = r.WriteFile(file)
+	}
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return nil, nil
+}
+
+var appModule = map[string]sandbox.UserFunction{
+	"counter":   counter,
+	"gauge":     gauge,
+	"histogram": histogram,
+	"summary":   summary,
+	"push":      push,
+	"write":     write,
+}
+
+// Entry 自定义指标与运行时指标一起输出(/metrics),指标名为{applet}:{name}
+var Entry = sandbox.NewRegistry("metrics", nil, appModule).
+	WithHook(sandbox.NewHook(sandbox.SigInitialized, func(app *sandbox.Applet) error {
+		cfg, err := configFromApp(app)
+		if err != nil {
+			return err
+		}
+		r := NewRegistry(app, cfg)
+		app.Context.Set(RegistryKey, r)
+		if cfg.PushFile != "" {
+			if err = r.Push(cfg.PushFile, cfg.PushInterval); err != nil {
+				return err
+			}
+		}
+		app.WithHook(sandbox.NewHook(sandbox.SigStop, func(applet *sandbox.Applet) error {
+			r.Close()
+			app.Context.Delete(RegistryKey)
+			return nil
+		}))
+		return nil
+	}))
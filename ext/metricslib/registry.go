@@ -0,0 +1,177 @@
+package metricslib
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"hash/fnv"
+	"lightbox/metrics"
+	"lightbox/sandbox"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricsConfigKey = "metrics"
+	RegistryKey      = "metrics_registry"
+
+	defaultMaxSeries    = 1000
+	defaultPushInterval = 15 * time.Second
+)
+
+// Config application.yml中的metrics节点
+type Config struct {
+	MaxSeries    int           `yaml:"max_series"`    //每个指标标签组合的最大数量(默认1000)
+	PushFile     string        `yaml:"push_file"`     //定期写入指标快照的文件
+	PushInterval time.Duration `yaml:"push_interval"` //写入间隔(默认15s)
+}
+
+// Registry applet的自定义指标,指标名以"applet名:"为前缀(脚本中的指标名不能包含:,不同applet的指标不会重名)
+type Registry struct {
+	app     *sandbox.Applet
+	cfg     Config
+	prefix  string
+	mx      sync.Mutex
+	names   map[string]bool
+	pushers map[string]*pusher
+}
+
+func configFromApp(app *sandbox.Applet) (Config, error) {
+	cfg := Config{}
+	if v, ok := app.Config()[metricsConfigKey]; ok && v != nil {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return cfg, err
+		}
+		if err = yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid metrics config:%s", err)
+		}
+	}
+	if cfg.MaxSeries <= 0 {
+		cfg.MaxSeries = defaultMaxSeries
+	}
+	return cfg, nil
+}
+
+func NewRegistry(app *sandbox.Applet, cfg Config) *Registry {
+	return &Registry{
+		app:     app,
+		cfg:     cfg,
+		prefix:  sanitize(app.Name) + ":",
+		names:   map[string]bool{},
+		pushers: map[string]*pusher{},
+	}
+}
+
+// sanitize applet名中不能用于指标名的字符替换为_,有替换时加上原名称的hash,避免a-b与a_b使用同一个前缀
+func sanitize(name string) string {
+	b := []byte(name)
+	replaced := false
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+			replaced = true
+		}
+	}
+	if !replaced {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return fmt.Sprintf("%s_%08x", b, h.Sum32())
+}
+
+// Get 获取或创建指标
+func (r *Registry) Get(opts metrics.Opts) (*metrics.Metric, error) {
+	if !metrics.ValidName(opts.Name) || strings.Contains(opts.Name, ":") {
+		return nil, fmt.Errorf("invalid metric name %q", opts.Name)
+	}
+	opts.Name = r.prefix + opts.Name
+	opts.MaxSeries = r.cfg.MaxSeries
+	m, err := metrics.GetOrCreate(opts)
+	if err != nil {
+		return nil, err
+	}
+	r.mx.Lock()
+	r.names[m.Name()] = true
+	r.mx.Unlock()
+	return m, nil
+}
+
+// Push 定期把applet的指标写入文件(applet目录下),同一个文件只启动一次
+func (r *Registry) Push(file string, interval time.Duration) error {
+	if file == "" {
+		return errors.New("require push file")
+	}
+	file, err := r.app.ResolvePath(file)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = defaultPushInterval
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if _, ok := r.pushers[file]; ok {
+		return nil
+	}
+	p := &pusher{file: file, interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
+	r.pushers[file] = p
+	go p.run(r)
+	return nil
+}
+
+// WriteFile 立即把applet的指标写入文件(applet目录下)
+func (r *Registry) WriteFile(file string) error {
+	file, err := r.app.ResolvePath(file)
+	if err != nil {
+		return err
+	}
+	return metrics.WriteFile(file, r.prefix)
+}
+
+// Close 写入最后一次快照并删除applet的所有指标
+func (r *Registry) Close() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for file, p := range r.pushers {
+		p.close()
+		delete(r.pushers, file)
+	}
+	for name := range r.names {
+		metrics.Remove(name)
+	}
+	r.names = map[string]bool{}
+}
+
+type pusher struct {
+	file     string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (p *pusher) run(r *Registry) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			if err := metrics.WriteFile(p.file, r.prefix); err != nil {
+				r.app.Logger().Error("write metrics snapshot error:", err)
+			}
+			return
+		}
+		if err := metrics.WriteFile(p.file, r.prefix); err != nil {
+			r.app.Logger().Error("write metrics snapshot error:", err)
+		}
+	}
+}
+
+func (p *pusher) close() {
+	close(p.stop)
+	<-p.done
+}
@@ -0,0 +1,81 @@
+package metricslib
+
+import (
+	"errors"
+	"lightbox/metrics"
+	"lightbox/sandbox"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T, name string) *Registry {
+	app, err := sandbox.NewWithDir(name, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(app, Config{MaxSeries: defaultMaxSeries})
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestRegistryNamespace(t *testing.T) {
+	a, ab := newTestRegistry(t, "a"), newTestRegistry(t, "a_b")
+	ma, err := a.Get(metrics.Opts{Name: "b_c", Type: metrics.TypeCounter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mab, err := ab.Get(metrics.Opts{Name: "c", Type: metrics.TypeGauge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ma.Name() == mab.Name() {
+		t.Fatalf("metrics of different applets should not collide: %s", ma.Name())
+	}
+	_ = ma.Add(1)
+	_ = mab.Set(2)
+
+	if err = a.WriteFile("metrics.prom"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(a.app.BaseDir(), "metrics.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "a:b_c 1\n") || strings.Contains(string(data), "a_b:c") {
+		t.Errorf("expect only metrics of the applet:\n%s", data)
+	}
+	if err = a.WriteFile("../metrics.prom"); !errors.Is(err, sandbox.ErrPathEscape) {
+		t.Errorf("expect path escape, got %v", err)
+	}
+	if err = a.Push("/tmp/metrics.prom", 0); !errors.Is(err, sandbox.ErrPathEscape) {
+		t.Errorf("expect path escape, got %v", err)
+	}
+
+	a.Close()
+	buf := &strings.Builder{}
+	if err = metrics.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "a:b_c") || !strings.Contains(buf.String(), "a_b:c 2\n") {
+		t.Errorf("close should only remove metrics of the applet:\n%s", buf)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	if got := sanitize("app_1"); got != "app_1" {
+		t.Errorf("valid name should be kept, got %s", got)
+	}
+	seen := map[string]string{}
+	for _, name := range []string{"a_b", "a-b", "a.b", "1ab", "_ab"} {
+		p := sanitize(name)
+		if !metrics.ValidName(p + ":x") {
+			t.Errorf("%s: invalid prefix %s", name, p)
+		}
+		if other, ok := seen[p]; ok {
+			t.Errorf("%s and %s share prefix %s", name, other, p)
+		}
+		seen[p] = name
+	}
+}
@@ -264,7 +264,11 @@ func sha1file(path string) (string, error) {
 func NewFSImporter(fsys fs.FS, environ *env.Environment, t transpile.Transpiler, ext string) ImportFunc {
 	return func(src string) tengo.Importable {
 		if strings.Contains(src, "{") {
-			src, _ = environ.Parse(src)
+			var err error
+			if src, err = environ.Parse(src); err != nil {
+				log.Error("parse import path error:", err)
+				return nil
+			}
 		}
 		fi, err := fs.Stat(fsys, src)
 		if err == nil && fi.IsDir() {
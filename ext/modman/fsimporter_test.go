@@ -43,7 +43,13 @@ func Test_parseEnv(t *testing.T) {
 			args: args{
 				src: "db_{profile}_{tenant}.tengo",
 			},
-			want: "db_test_!tenant.tengo",
+			wantErr: true,
+		}, {
+			name: "default",
+			args: args{
+				src: "db_{profile}_{tenant:-default}.tengo",
+			},
+			want: "db_test_default.tengo",
 		},
 	}
 	for _, tt := range tests {
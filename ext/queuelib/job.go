@@ -0,0 +1,238 @@
+package queuelib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/kvstore/queue"
+	"time"
+)
+
+// decodePayload JSON中的整数解码为int
+func decodePayload(data []byte) (tengo.Object, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return tengo.FromInterface(numbers(v))
+}
+
+func numbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = numbers(e)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = numbers(e)
+		}
+	}
+	return v
+}
+
+// jobFields 任务的脚本表示，payload为JSON解码后的对象
+func jobFields(j *queue.Job) map[string]tengo.Object {
+	payload, err := decodePayload(j.Payload)
+	if err != nil {
+		payload = &tengo.Bytes{Value: j.Payload}
+	}
+	return map[string]tengo.Object{
+		"id":           &tengo.String{Value: j.ID},
+		"queue":        &tengo.String{Value: j.Queue},
+		"payload":      payload,
+		"priority":     &tengo.Int{Value: int64(j.Priority)},
+		"state":        &tengo.String{Value: j.State},
+		"attempts":     &tengo.Int{Value: int64(j.Attempts)},
+		"max_attempts": &tengo.Int{Value: int64(j.MaxAttempts)},
+		"run_at":       &tengo.Time{Value: j.RunAt},
+		"created_at":   &tengo.Time{Value: j.CreatedAt},
+		"last_error":   &tengo.String{Value: j.LastError},
+	}
+}
+
+// jobObject 出队的任务，可以ack/nack
+type jobObject struct {
+	tengo.ObjectImpl
+	store  *queue.Store
+	job    *queue.Job
+	policy queue.RetryPolicy
+	fields map[string]tengo.Object
+}
+
+func newJobObject(s *queue.Store, j *queue.Job, policy queue.RetryPolicy) *jobObject {
+	return &jobObject{store: s, job: j, policy: policy, fields: jobFields(j)}
+}
+
+func (o *jobObject) TypeName() string {
+	return "queue-job"
+}
+
+func (o *jobObject) String() string {
+	return fmt.Sprintf("job<%s/%s attempts:%d>", o.job.Queue, o.job.ID, o.job.Attempts)
+}
+
+func (o *jobObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	switch name {
+	//snippet:name=job.ack;prefix=ack;body=ack();desc=acknowledge the job and remove it
+	case "ack":
+		return &tengo.UserFunction{Name: "ack", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			return util.Error(o.store.Ack(o.job.Queue, o.job.ID, o.job.Attempts)), nil
+		}}, nil
+	//snippet:name=job.nack;prefix=nack;body=nack(${1:reason});desc=mark the job failed, retry with backoff or move to dead letter
+	case "nack":
+		return &tengo.UserFunction{Name: "nack", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			reason := "nack"
+			if len(args) > 0 {
+				reason, _ = tengo.ToString(args[0])
+			}
+			state, err := o.store.Fail(o.job.Queue, o.job.ID, o.job.Attempts, reason, o.policy)
+			if err != nil {
+				return util.Error(err), nil
+			}
+			return &tengo.String{Value: state}, nil
+		}}, nil
+	}
+	if v, ok := o.fields[name]; ok {
+		return v, nil
+	}
+	return tengo.UndefinedValue, nil
+}
+
+// workerObject 脚本中的工作协程
+type workerObject struct {
+	tengo.ObjectImpl
+	*queue.Worker
+	name       string
+	unregister func() //注销applet停止时的清理
+}
+
+func (w *workerObject) TypeName() string {
+	return "queue-worker"
+}
+
+func (w *workerObject) String() string {
+	return fmt.Sprintf("worker<%s>", w.name)
+}
+
+func (w *workerObject) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	switch name {
+	//snippet:name=worker.stop;prefix=stop;body=stop();desc=stop taking jobs and wait running jobs
+	case "stop":
+		return &tengo.UserFunction{Name: "stop", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			w.Stop()
+			w.unregister()
+			return tengo.TrueValue, nil
+		}}, nil
+	case "running":
+		return &tengo.UserFunction{Name: "running", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if w.Stopped() {
+				return tengo.FalseValue, nil
+			}
+			return tengo.TrueValue, nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+// toOptions 选项参数为map
+func toOptions(o tengo.Object) (map[string]tengo.Object, error) {
+	switch m := o.(type) {
+	case *tengo.Map:
+		return m.Value, nil
+	case *tengo.ImmutableMap:
+		return m.Value, nil
+	}
+	return nil, tengo.ErrInvalidArgumentType{Name: "option", Expected: "map", Found: o.TypeName()}
+}
+
+// toDuration int为秒，字符串如"1m30s"
+func toDuration(name string, o tengo.Object) (time.Duration, error) {
+	switch v := o.(type) {
+	case *tengo.Int:
+		return time.Duration(v.Value) * time.Second, nil
+	case *tengo.String:
+		return time.ParseDuration(v.Value)
+	}
+	return 0, tengo.ErrInvalidArgumentType{Name: name, Expected: "int(seconds)/duration string", Found: o.TypeName()}
+}
+
+func toInt(name string, o tengo.Object) (int, error) {
+	n, ok := tengo.ToInt(o)
+	if !ok {
+		return 0, tengo.ErrInvalidArgumentType{Name: name, Expected: "int", Found: o.TypeName()}
+	}
+	return n, nil
+}
+
+// toEnqueueOptions {delay,priority,max_attempts}
+func toEnqueueOptions(o tengo.Object) (queue.EnqueueOptions, error) {
+	var opt queue.EnqueueOptions
+	m, err := toOptions(o)
+	if err != nil {
+		return opt, err
+	}
+	for k, v := range m {
+		switch k {
+		case "delay":
+			opt.Delay, err = toDuration(k, v)
+		case "priority":
+			opt.Priority, err = toInt(k, v)
+		case "max_attempts":
+			opt.MaxAttempts, err = toInt(k, v)
+		default:
+			err = fmt.Errorf("unknown enqueue option %s", k)
+		}
+		if err != nil {
+			return opt, err
+		}
+	}
+	return opt, nil
+}
+
+// toWorkerOptions {concurrency,visibility,poll_interval,backoff,max_backoff}
+func toWorkerOptions(o tengo.Object) (queue.WorkerOptions, error) {
+	var opt queue.WorkerOptions
+	m, err := toOptions(o)
+	if err != nil {
+		return opt, err
+	}
+	for k, v := range m {
+		switch k {
+		case "concurrency":
+			opt.Concurrency, err = toInt(k, v)
+		case "visibility":
+			opt.Visibility, err = toDuration(k, v)
+		case "poll_interval":
+			opt.PollInterval, err = toDuration(k, v)
+		case "backoff":
+			opt.Backoff, err = toDuration(k, v)
+		case "max_backoff":
+			opt.MaxBackoff, err = toDuration(k, v)
+		default:
+			err = fmt.Errorf("unknown worker option %s", k)
+		}
+		if err != nil {
+			return opt, err
+		}
+	}
+	return opt, nil
+}
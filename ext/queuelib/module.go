@@ -0,0 +1,319 @@
+package queuelib
+
+import (
+	"context"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib/json"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"lightbox/ext/badgerlib"
+	"lightbox/ext/util"
+	"lightbox/kvstore"
+	"lightbox/kvstore/queue"
+	"lightbox/sandbox"
+	"sync"
+	"time"
+)
+
+const (
+	queueConfigKey  = "queue"
+	storeContextKey = "__queue_store"
+	storeName       = "queue"
+	defaultDir      = "queue"
+	// defaultVisibility dequeue未指定时的可见性超时
+	defaultVisibility = 5 * time.Minute
+)
+
+// Config applet配置中的queue节点
+//
+//	queue:
+//	  dir: queue  # 队列数据目录，相对applet根目录
+type Config struct {
+	Dir string `yaml:"dir"`
+}
+
+var workerPlaceHolders = util.PlaceHolders{"job": nil, "payload": nil}
+
+// storeLock 避免并发首次使用时重复打开队列
+var storeLock sync.Mutex
+
+// storeOf applet的任务队列存储在applet命名空间中名为queue的badger数据库中
+func storeOf(app *sandbox.Applet) (*queue.Store, error) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if v, ok := app.Context.Get(storeContextKey); ok {
+		if s, ok := v.(*queue.Store); ok {
+			return s, nil
+		}
+	}
+	cfg := Config{}
+	if v, ok := app.Config()[queueConfigKey]; ok && v != nil {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid queue config:%s", err)
+		}
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = defaultDir
+	}
+	dir, err := badgerlib.ResolvePath(app, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	name, err := badgerlib.ScopedName(app, storeName)
+	if err != nil {
+		return nil, err
+	}
+	db, err := kvstore.Open(name, kvstore.DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+	s, err := queue.Open(db)
+	if err != nil {
+		return nil, err
+	}
+	//先于worker注册，applet停止时在worker停止之后关闭
+	if _, err = badgerlib.OnStop(app, func() {
+		storeLock.Lock()
+		defer storeLock.Unlock()
+		app.Context.Set(storeContextKey, nil)
+		if err := s.Close(); err != nil {
+			app.Logger().Errorf("close queue store error:%s", err)
+		}
+	}); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	app.Context.Set(storeContextKey, s)
+	return s, nil
+}
+
+func queueName(args []tengo.Object, min, max int) (string, error) {
+	if len(args) < min || len(args) > max {
+		return "", tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok || name == "" {
+		return "", tengo.ErrInvalidArgumentType{Name: "queue", Expected: "string", Found: args[0].TypeName()}
+	}
+	return name, nil
+}
+
+// enqueue 参数(queue,payload[,{delay,priority,max_attempts}])，返回任务id
+//
+//snippet:name=queue.enqueue;prefix=enqueue;body=enqueue(${1:queue},${2:payload},{delay:"${3:0s}",priority:${4:0}});
+func enqueue(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	name, err := queueName(args, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Encode(args[1])
+	if err != nil {
+		return nil, err
+	}
+	var opt queue.EnqueueOptions
+	if len(args) == 3 {
+		if opt, err = toEnqueueOptions(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	s, err := storeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	j, err := s.Enqueue(name, payload, opt)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return &tengo.String{Value: j.ID}, nil
+}
+
+// dequeue 手动取出任务，需要调用job.ack()确认，参数(queue[,{visibility,backoff,max_backoff}])，没有任务时返回undefined
+//
+//snippet:name=queue.dequeue;prefix=dequeue;body=dequeue(${1:queue},{visibility:"${2:5m}"});
+func dequeue(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	name, err := queueName(args, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	opt := queue.WorkerOptions{Visibility: defaultVisibility}
+	if len(args) == 2 {
+		if opt, err = toWorkerOptions(args[1]); err != nil {
+			return nil, err
+		}
+		if opt.Visibility <= 0 {
+			opt.Visibility = defaultVisibility
+		}
+	}
+	s, err := storeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	j, err := s.Dequeue(name, opt.Visibility, opt.RetryPolicy)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	if j == nil {
+		return tengo.UndefinedValue, nil
+	}
+	return newJobObject(s, j, opt.RetryPolicy), nil
+}
+
+// worker 启动工作协程，每个任务执行一次脚本(脚本中可使用job/payload)，脚本出错时按退避策略重试，applet停止时自动停止
+//
+//snippet:name=queue.worker;prefix=worker;body=worker(${1:queue},${2:script},{concurrency:${3:1},visibility:"${4:5m}",backoff:"${5:1s}"});
+func worker(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	name, err := queueName(args, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+	script, ok := tengo.ToString(args[1])
+	if !ok || script == "" {
+		return nil, tengo.ErrInvalidArgumentType{Name: "script", Expected: "string", Found: args[1].TypeName()}
+	}
+	var opt queue.WorkerOptions
+	if len(args) == 3 {
+		if opt, err = toWorkerOptions(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	s, err := storeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	w := s.Work(name, opt, func(j *queue.Job) error {
+		compiled, err := app.GetCompiled(script, workerPlaceHolders)
+		if err != nil {
+			return err
+		}
+		obj := newJobObject(s, j, opt.RetryPolicy)
+		if err = compiled.Set("job", obj); err != nil {
+			return err
+		}
+		if err = compiled.Set("payload", obj.fields["payload"]); err != nil {
+			return err
+		}
+		return app.RunCompiledWith(context.Background(), log.Fields{
+			"queue":         name,
+			"queue_job":     j.ID,
+			"queue_attempt": j.Attempts,
+		}, compiled)
+	})
+	cancel, err := badgerlib.OnStop(app, w.Stop)
+	if err != nil {
+		w.Stop()
+		return util.Error(err), nil
+	}
+	return &workerObject{Worker: w, name: name, unregister: cancel}, nil
+}
+
+// stats 各状态的任务数{ready,delayed,inflight,dead}
+//
+//snippet:name=queue.stats;prefix=stats;body=stats(${1:queue});
+func stats(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	name, err := queueName(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	s, err := storeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	st, err := s.Stats(name)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"ready":    &tengo.Int{Value: int64(st.Ready)},
+		"delayed":  &tengo.Int{Value: int64(st.Delayed)},
+		"inflight": &tengo.Int{Value: int64(st.Inflight)},
+		"dead":     &tengo.Int{Value: int64(st.Dead)},
+	}}, nil
+}
+
+// peek 查看任务不改变状态，参数(queue[,state="ready",limit=10])
+//
+//snippet:name=queue.peek;prefix=peek;body=peek(${1:queue},"${2:dead}",${3:10});
+func peek(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	name, err := queueName(args, 1, 3)
+	if err != nil {
+		return nil, err
+	}
+	state, limit := queue.StateReady, 10
+	if len(args) > 1 {
+		var ok bool
+		if state, ok = tengo.ToString(args[1]); !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: "state", Expected: "string", Found: args[1].TypeName()}
+		}
+	}
+	if len(args) > 2 {
+		if limit, err = toInt("limit", args[2]); err != nil {
+			return nil, err
+		}
+	}
+	s, err := storeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	jobs, err := s.Peek(name, state, limit)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	arr := &tengo.Array{Value: make([]tengo.Object, 0, len(jobs))}
+	for _, j := range jobs {
+		arr.Value = append(arr.Value, &tengo.ImmutableMap{Value: jobFields(j)})
+	}
+	return arr, nil
+}
+
+// requeueDead 把死信任务放回队列，参数(queue[,ids])，不指定ids时处理所有死信任务，返回处理的数量
+//
+//snippet:name=queue.requeue_dead;prefix=requeue_dead;body=requeue_dead(${1:queue});
+func requeueDead(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	name, err := queueName(args, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if len(args) == 2 {
+		arr, ok := args[1].(*tengo.Array)
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: "ids", Expected: "array", Found: args[1].TypeName()}
+		}
+		for _, v := range arr.Value {
+			id, ok := tengo.ToString(v)
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "id", Expected: "string", Found: v.TypeName()}
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			return &tengo.Int{}, nil
+		}
+	}
+	s, err := storeOf(app)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	n, err := s.RequeueDead(name, ids...)
+	if err != nil {
+		return util.Error(err), nil
+	}
+	return &tengo.Int{Value: int64(n)}, nil
+}
+
+var appModule = map[string]sandbox.UserFunction{
+	"enqueue":      enqueue,
+	"dequeue":      dequeue,
+	"worker":       worker,
+	"stats":        stats,
+	"peek":         peek,
+	"requeue_dead": requeueDead,
+}
+
+// Entry 持久化任务队列，数据保存在applet的badger数据库中，进程重启后未完成的任务会重新投递
+var Entry = sandbox.NewRegistry("queue", nil, appModule)
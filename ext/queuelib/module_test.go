@@ -0,0 +1,174 @@
+package queuelib
+
+import (
+	"github.com/d5/tengo/v2"
+	"lightbox/kvstore"
+	"lightbox/sandbox"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestApp(t *testing.T, script string) *sandbox.Applet {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "job.tengo"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := sandbox.NewWithDir("queue_test", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.Shutdown("test")
+		if s, err := storeOf(app); err == nil {
+			_ = s.Close()
+		}
+		_ = kvstore.Close("queue_test/queue")
+	})
+	return app
+}
+
+func str(s string) tengo.Object {
+	return &tengo.String{Value: s}
+}
+
+func statsOf(t *testing.T, app *sandbox.Applet, name string) map[string]int64 {
+	ret, err := stats(app, str(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]int64{}
+	for k, v := range ret.(*tengo.ImmutableMap).Value {
+		out[k] = v.(*tengo.Int).Value
+	}
+	return out
+}
+
+func TestWorkerScript(t *testing.T) {
+	app := newTestApp(t, `
+probe := import("probe")
+probe.record(job.id, payload.n, job.attempts)
+if payload.n == 2 { x := 1 / 0 }
+`)
+	var (
+		mu   sync.Mutex
+		seen = map[string]int{}
+	)
+	m := tengo.NewModuleMap()
+	m.AddBuiltinModule("probe", map[string]tengo.Object{
+		"record": &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+			id, _ := tengo.ToString(args[0])
+			mu.Lock()
+			seen[id]++
+			mu.Unlock()
+			return nil, nil
+		}},
+	})
+	app.WithModule(m)
+
+	ids := map[int]string{}
+	for _, n := range []int{1, 2} {
+		payload := &tengo.Map{Value: map[string]tengo.Object{"n": &tengo.Int{Value: int64(n)}}}
+		ret, err := enqueue(app, str("jobs"), payload, &tengo.Map{Value: map[string]tengo.Object{"max_attempts": &tengo.Int{Value: 2}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[n], _ = tengo.ToString(ret)
+	}
+	opts := &tengo.Map{Value: map[string]tengo.Object{
+		"concurrency":   &tengo.Int{Value: 2},
+		"backoff":       str("5ms"),
+		"poll_interval": str("10ms"),
+	}}
+	if _, err := worker(app, str("jobs"), str("job.tengo"), opts); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for statsOf(t, app, "jobs")["dead"] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout, stats %v", statsOf(t, app, "jobs"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	if seen[ids[1]] != 1 || seen[ids[2]] != 2 {
+		t.Fatalf("unexpected executions %v", seen)
+	}
+	mu.Unlock()
+
+	ret, _ := peek(app, str("jobs"), str("dead"))
+	dead := ret.(*tengo.Array).Value
+	if len(dead) != 1 {
+		t.Fatalf("expect 1 dead job, got %v", dead)
+	}
+	if id, _ := tengo.ToString(dead[0].(*tengo.ImmutableMap).Value["id"]); id != ids[2] {
+		t.Fatalf("unexpected dead job %s", id)
+	}
+	if ret, _ = requeueDead(app, str("jobs")); ret.(*tengo.Int).Value != 1 {
+		t.Fatalf("requeue dead returns %v", ret)
+	}
+	for statsOf(t, app, "jobs")["dead"] != 1 || seen[ids[2]] != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("requeued job should run again, stats %v", statsOf(t, app, "jobs"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDequeueAck(t *testing.T) {
+	app := newTestApp(t, "")
+	if _, err := enqueue(app, str("manual"), str("hello")); err != nil {
+		t.Fatal(err)
+	}
+	job, err := dequeue(app, str("manual"), &tengo.Map{Value: map[string]tengo.Object{"visibility": &tengo.Int{Value: 30}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := job.IndexGet(str("payload"))
+	if s, _ := tengo.ToString(payload); s != "hello" {
+		t.Fatalf("unexpected payload %v", payload)
+	}
+	if st := statsOf(t, app, "manual"); st["inflight"] != 1 {
+		t.Fatalf("unexpected stats %v", st)
+	}
+	ack, _ := job.IndexGet(str("ack"))
+	if ret, _ := ack.Call(); ret != tengo.TrueValue {
+		t.Fatalf("ack returns %v", ret)
+	}
+	if ret, _ := ack.Call(); ret.TypeName() != "error" {
+		t.Fatalf("second ack should be stale, got %v", ret)
+	}
+	if ret, _ := dequeue(app, str("manual")); ret != tengo.UndefinedValue {
+		t.Fatalf("queue should be empty, got %v", ret)
+	}
+}
+
+func TestShutdownStopsWorkersAndStore(t *testing.T) {
+	app := newTestApp(t, "")
+	opts := &tengo.Map{Value: map[string]tengo.Object{"poll_interval": str("10ms")}}
+	stopped, err := worker(app, str("a"), str("job.tengo"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := worker(app, str("b"), str("job.tengo"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, _ := stopped.IndexGet(str("stop"))
+	if _, err = stop.Call(); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := storeOf(app)
+	app.Shutdown("test")
+	if !running.(*workerObject).Stopped() {
+		t.Error("worker should stop with the applet")
+	}
+	if v, _ := app.Context.Get(storeContextKey); v != nil {
+		t.Errorf("store should be closed with the applet, got %v", v)
+	}
+	if s2, err := storeOf(app); err != nil || s2 == s {
+		t.Errorf("closed store should not be reused: %v", err)
+	}
+}
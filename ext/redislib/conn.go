@@ -84,6 +84,7 @@ func NewClient(url string) (*util.Proxy[*redis.Client], error) {
 		return nil, err
 	}
 	client := redis.NewClient(opt)
+	client.AddHook(tracingHook{addr: opt.Addr})
 
 	return util.NewProxy(client).WithConstructor(newClientConstructor()), nil
 }
@@ -1,21 +1,47 @@
 package redislib
 
 import (
+	"context"
+	"fmt"
 	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
 	"github.com/go-redis/redis/v8"
 	"lightbox/ext/util"
 	"lightbox/sandbox"
+	"sync/atomic"
 )
 
 var module = map[string]tengo.Object{
 	"keep_ttl": &tengo.Int{Value: redis.KeepTTL},
-	"dial": util.NewUserFunc(func(args ...tengo.Object) (tengo.Object, error) {
-		if len(args) != 1 {
-			return nil, tengo.ErrWrongNumArguments
-		}
-		url, _ := tengo.ToString(args[0])
-		return NewClient(url)
-	}),
 }
 
-var Entry = sandbox.NewRegistry("redis", module, nil)
+var appModule = map[string]sandbox.UserFunction{
+	"dial": dial,
+}
+
+// clientSeq 区分连接同一地址的多个客户端的检查名称
+var clientSeq uint64
+
+// dial 连接redis,并注册就绪检查(PING),关闭连接时删除检查
+func dial(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	url, _ := tengo.ToString(args[0])
+	client, err := NewClient(url)
+	if err != nil {
+		return nil, err
+	}
+	checkName := fmt.Sprintf("redis:%s#%d", client.Value.Options().Addr, atomic.AddUint64(&clientSeq, 1))
+	app.RegisterHealthCheck(checkName, sandbox.HealthReady, func(ctx context.Context) error {
+		return client.Value.Ping(ctx).Err()
+	})
+	client.Init()
+	client.Props["close"] = util.NewUserFunc(stdlib.FuncARE(func() error {
+		app.UnregisterHealthCheck(checkName)
+		return client.Value.Close()
+	}))
+	return client, nil
+}
+
+var Entry = sandbox.NewRegistry("redis", module, appModule)
@@ -0,0 +1,68 @@
+package redislib
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"lightbox/tracing"
+	"strings"
+)
+
+type spanKey struct{}
+
+// tracingHook 为redis命令创建span
+type tracingHook struct {
+	addr string
+}
+
+func (h tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "ping" {
+		//就绪检查
+		return ctx, nil
+	}
+	span := tracing.Start(ctx, "redis "+cmd.Name(), tracing.KindClient)
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("net.peer.name", h.addr)
+	span.SetAttr("db.operation", cmd.Name())
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+func (h tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if span, ok := ctx.Value(spanKey{}).(*tracing.Span); ok {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+	return nil
+}
+
+func (h tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	span := tracing.Start(ctx, "redis pipeline", tracing.KindClient)
+	if span == nil {
+		return ctx, nil
+	}
+	names := make([]string, 0, len(cmds))
+	for _, c := range cmds {
+		names = append(names, c.Name())
+	}
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("net.peer.name", h.addr)
+	span.SetAttr("db.operation", strings.Join(names, ","))
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+func (h tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if span, ok := ctx.Value(spanKey{}).(*tracing.Span); ok {
+		for _, c := range cmds {
+			if err := c.Err(); err != nil && err != redis.Nil {
+				span.SetError(err)
+				break
+			}
+		}
+		span.End()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package tracelib
+
+import (
+	"context"
+	"errors"
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/sandbox"
+	"lightbox/tracing"
+)
+
+// spanObject 脚本中的span,未启用追踪时所有操作都不生效
+func spanObject(span *tracing.Span) tengo.Object {
+	sc := span.Context()
+	traceID, spanID := "", ""
+	if sc.IsValid() {
+		traceID, spanID = sc.TraceID.String(), sc.SpanID.String()
+	}
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"trace_id":    &tengo.String{Value: traceID},
+		"span_id":     &tengo.String{Value: spanID},
+		"traceparent": &tengo.String{Value: sc.Traceparent()},
+		"set": &tengo.UserFunction{Name: "set", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 2 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			key, ok := tengo.ToString(args[0])
+			if !ok {
+				return nil, tengo.ErrInvalidArgumentType{Name: "key", Expected: "string", Found: args[0].TypeName()}
+			}
+			span.SetAttr(key, tengo.ToInterface(args[1]))
+			return nil, nil
+		}},
+		"error": &tengo.UserFunction{Name: "error", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			if len(args) != 1 {
+				return nil, tengo.ErrWrongNumArguments
+			}
+			msg, _ := tengo.ToString(args[0])
+			span.SetError(errors.New(msg))
+			return nil, nil
+		}},
+		"end": &tengo.UserFunction{Name: "end", Value: func(args ...tengo.Object) (tengo.Object, error) {
+			span.End()
+			return nil, nil
+		}},
+	}}
+}
+
+// execContext 第一个参数为脚本变量ctx时返回它携带的context(http请求、消息消费等的span)
+func execContext(args []tengo.Object) (*sandbox.Exec, context.Context, []tengo.Object) {
+	exec, args := sandbox.ExecArg(args)
+	if exec == nil {
+		return nil, context.Background(), args
+	}
+	return exec, exec.Context(), args
+}
+
+// start 创建span,传入ctx时父span为本次执行的span(http请求、消息消费等),脚本结束时未end的span自动结束
+//
+//snippet:name=trace.start;prefix=start;body=span := trace.start(ctx,${1:name},{$2})\nspan.end();desc=创建span,结束时调用end();
+func start(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	exec, ctx, args := execContext(args)
+	if len(args) < 1 || len(args) > 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	name, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{Name: "name", Expected: "string", Found: args[0].TypeName()}
+	}
+	span := tracing.Start(ctx, name, tracing.KindInternal)
+	if span != nil && exec != nil {
+		exec.OnEnd(span.Abandon)
+	}
+	span.SetAttr("sandbox", app.Name)
+	if len(args) == 2 {
+		switch m := args[1].(type) {
+		case *tengo.Map:
+			for k, v := range util.ToMap[interface{}](m.Value) {
+				span.SetAttr(k, v)
+			}
+		case *tengo.ImmutableMap:
+			for k, v := range util.ToMap[interface{}](m.Value) {
+				span.SetAttr(k, v)
+			}
+		}
+	}
+	return spanObject(span), nil
+}
+
+// current 本次执行的span(http请求、消息消费等)
+//
+//snippet:name=trace.current;prefix=current;body=current(ctx);
+func current(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	_, ctx, _ := execContext(args)
+	return spanObject(tracing.FromContext(ctx)), nil
+}
+
+// traceparent 本次执行的span的W3C traceparent,用于手动传递到下游
+//
+//snippet:name=trace.traceparent;prefix=traceparent;body=traceparent(ctx);
+func traceparent(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	_, ctx, _ := execContext(args)
+	return &tengo.String{Value: tracing.FromContext(ctx).Context().Traceparent()}, nil
+}
+
+func enabled(app *sandbox.Applet, args ...tengo.Object) (tengo.Object, error) {
+	if tracing.Enabled() {
+		return tengo.TrueValue, nil
+	}
+	return tengo.FalseValue, nil
+}
+
+var Entry = sandbox.NewRegistry("trace", nil, map[string]sandbox.UserFunction{
+	"start":       start,
+	"current":     current,
+	"traceparent": traceparent,
+	"enabled":     enabled,
+})
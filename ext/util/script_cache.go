@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -122,6 +123,29 @@ type ScriptCache struct {
 	compileFunc   CompileFunc
 	fileSystem    fs.FS
 	checkDuration time.Duration
+	hits          uint64
+	misses        uint64
+	recompiles    uint64
+}
+
+// CacheStats 脚本缓存命中统计
+type CacheStats struct {
+	Hits       uint64 //使用缓存的编译结果
+	Misses     uint64 //首次编译
+	Recompiles uint64 //文件修改后重新编译
+}
+
+func (c *ScriptCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:       atomic.LoadUint64(&c.hits),
+		Misses:     atomic.LoadUint64(&c.misses),
+		Recompiles: atomic.LoadUint64(&c.recompiles),
+	}
+}
+
+type cacheResult struct {
+	item    *CachedItem
+	counter *uint64
 }
 
 func (c *ScriptCache) Clean() {
@@ -143,6 +167,7 @@ func (c *ScriptCache) GetCompiled(srcFile string, placeHolder PlaceHolders) (*te
 	key := srcFile + "[" + strings.Join(placeHolder.Names(), ",") + "]"
 	result, _, _ := c.g.Do(key, func() (interface{}, error) {
 		var cachedItem *CachedItem
+		counter := &c.hits
 		itm, ok := c.m.Load(key)
 		if !ok {
 			//缓存中不存在
@@ -158,6 +183,7 @@ func (c *ScriptCache) GetCompiled(srcFile string, placeHolder PlaceHolders) (*te
 				}
 			}
 			c.m.Store(key, cachedItem)
+			counter = &c.misses
 		} else {
 			//缓存中存在
 			cachedItem = itm.(*CachedItem)
@@ -167,6 +193,8 @@ func (c *ScriptCache) GetCompiled(srcFile string, placeHolder PlaceHolders) (*te
 				if err == nil {
 					if lastModify.After(cachedItem.LastModify) {
 						//文件是否过期
+						counter = &c.recompiles
+						cachedItem.LastModify = lastModify
 						if src, err := fs.ReadFile(c.fileSystem, srcFile); err == nil {
 							cachedItem.Compiled, cachedItem.Error = c.compileFunc(src, placeHolder, srcFile)
 						} else {
@@ -177,11 +205,14 @@ func (c *ScriptCache) GetCompiled(srcFile string, placeHolder PlaceHolders) (*te
 					cachedItem.Error = err
 				}
 			}
-			return cachedItem, nil
+			return cacheResult{item: cachedItem, counter: counter}, nil
 		}
-		return cachedItem, nil
+		return cacheResult{item: cachedItem, counter: counter}, nil
 	})
-	item := result.(*CachedItem)
+	//同时等待编译结果的调用各自计数
+	cr := result.(cacheResult)
+	atomic.AddUint64(cr.counter, 1)
+	item := cr.item
 	if item.Error != nil {
 		return nil, item.Error
 	}
@@ -0,0 +1,263 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ManifestFile 备份目录中记录备份链的清单文件
+const ManifestFile = "manifest.json"
+
+// BackupFile 一次备份的记录，Full为true时开始一条新的备份链，增量备份依赖链上之前的所有备份
+type BackupFile struct {
+	File    string    `json:"file"`
+	Full    bool      `json:"full"`
+	Since   uint64    `json:"since"`
+	Version uint64    `json:"version"`
+	Size    int64     `json:"size"`
+	Time    time.Time `json:"time"`
+}
+
+// BackupOptions 备份选项，Keep/MaxAge按备份链计算，最新的一条链总是保留
+type BackupOptions struct {
+	//Incremental 基于上次备份的版本做增量备份(没有历史备份时为全量)
+	Incremental bool
+	//Keep 保留最近N条备份链，0不限制
+	Keep int
+	//MaxAge 删除最后一次备份早于MaxAge的备份链，0不限制
+	MaxAge time.Duration
+}
+
+// backupLocks 同一备份目录的备份/清理串行执行
+var backupLocks = &sync.Map{}
+
+func lockDir(dir string) func() {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	l, _ := backupLocks.LoadOrStore(abs, &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	return l.(*sync.Mutex).Unlock
+}
+
+// ReadManifest 读取备份目录的清单，目录不存在或没有备份时返回空
+func ReadManifest(dir string) ([]BackupFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []BackupFile
+	err = json.Unmarshal(data, &files)
+	return files, err
+}
+
+func writeManifest(dir string, files []BackupFile) error {
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestFile+".tmp")
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestFile))
+}
+
+// Backup 备份到dir，并按选项清理过期的备份链
+func Backup(db *badger.DB, dir string, opt BackupOptions) (*BackupFile, error) {
+	defer lockDir(dir)()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	bf := BackupFile{Full: true, Time: now}
+	if opt.Incremental && len(files) > 0 {
+		last := files[len(files)-1]
+		bf.Full = false
+		// badger v3的Stream只读取version>SinceTs的数据，since不需要+1
+		bf.Since = last.Version
+	}
+	kind := "full"
+	if !bf.Full {
+		kind = "inc"
+	}
+	bf.File = fmt.Sprintf("%s-%s.bak", now.Format("20060102T150405.000"), kind)
+	tmp := filepath.Join(dir, bf.File+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	version, err := db.Backup(f, bf.Since)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(dir, bf.File))
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	// 没有新数据时Backup返回0，沿用上次的版本
+	bf.Version = version
+	if version < bf.Since {
+		bf.Version = bf.Since
+	}
+	if fi, err := os.Stat(filepath.Join(dir, bf.File)); err == nil {
+		bf.Size = fi.Size()
+	}
+	files = append(files, bf)
+	files, removed := retain(files, opt, now)
+	if err = writeManifest(dir, files); err != nil {
+		return nil, err
+	}
+	for _, r := range removed {
+		if err := os.Remove(filepath.Join(dir, r.File)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("remove expired backup %s error:%s", r.File, err)
+		}
+	}
+	return &bf, nil
+}
+
+// chains 按全量备份把清单切分为备份链
+func chains(files []BackupFile) [][]BackupFile {
+	var result [][]BackupFile
+	for _, f := range files {
+		if f.Full || len(result) == 0 {
+			result = append(result, nil)
+		}
+		result[len(result)-1] = append(result[len(result)-1], f)
+	}
+	return result
+}
+
+// retain 返回保留的备份和需要删除的备份
+func retain(files []BackupFile, opt BackupOptions, now time.Time) (kept, removed []BackupFile) {
+	cs := chains(files)
+	for i, c := range cs {
+		newest := i == len(cs)-1
+		expired := opt.Keep > 0 && len(cs)-i > opt.Keep
+		if opt.MaxAge > 0 && now.Sub(c[len(c)-1].Time) > opt.MaxAge {
+			expired = true
+		}
+		if expired && !newest {
+			removed = append(removed, c...)
+		} else {
+			kept = append(kept, c...)
+		}
+	}
+	return
+}
+
+// Restore 把备份加载到db，path为备份目录时加载最新的备份链，为文件时只加载该文件
+func Restore(db *badger.DB, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	if fi.IsDir() {
+		files, err := ReadManifest(path)
+		if err != nil {
+			return err
+		}
+		cs := chains(files)
+		if len(cs) == 0 {
+			return fmt.Errorf("no backup found in %s", path)
+		}
+		for _, f := range cs[len(cs)-1] {
+			paths = append(paths, filepath.Join(path, f.File))
+		}
+	} else {
+		paths = []string{path}
+	}
+	for _, p := range paths {
+		if err = loadBackup(db, p); err != nil {
+			return fmt.Errorf("restore %s error:%w", p, err)
+		}
+	}
+	return nil
+}
+
+func loadBackup(db *badger.DB, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return db.Load(f, 256)
+}
+
+// RestoreTo 在一个新目录中打开名为name的数据库并恢复备份，目录必须不存在或为空
+func RestoreTo(name, dir, path string) (*badger.DB, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("restore target %s is not empty", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := Open(name, DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+	if err = Restore(db, path); err != nil {
+		_ = Close(name)
+		return nil, err
+	}
+	return db, nil
+}
+
+// BackupPlan 可序列化的备份计划，用于cron任务
+type BackupPlan struct {
+	//DB 已打开的kvstore名称
+	DB          string `json:"db"`
+	Dir         string `json:"dir"`
+	Incremental bool   `json:"incremental"`
+	Keep        int    `json:"keep"`
+	//MaxAge 如"720h"
+	MaxAge string `json:"max_age"`
+}
+
+func (p *BackupPlan) Options() (BackupOptions, error) {
+	opt := BackupOptions{Incremental: p.Incremental, Keep: p.Keep}
+	if p.MaxAge != "" {
+		d, err := time.ParseDuration(p.MaxAge)
+		if err != nil {
+			return opt, err
+		}
+		opt.MaxAge = d
+	}
+	return opt, nil
+}
+
+func (p *BackupPlan) Run() (*BackupFile, error) {
+	if p.DB == "" || p.Dir == "" {
+		return nil, errors.New("backup plan requires db and dir")
+	}
+	opt, err := p.Options()
+	if err != nil {
+		return nil, err
+	}
+	db, err := Get(p.DB)
+	if err != nil {
+		return nil, err
+	}
+	return Backup(db, p.Dir, opt)
+}
@@ -0,0 +1,134 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"testing"
+	"time"
+)
+
+func memDB(t *testing.T) *badger.DB {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func setKV(t *testing.T, db *badger.DB, kvs ...string) {
+	err := db.Update(func(txn *badger.Txn) error {
+		for i := 0; i < len(kvs); i += 2 {
+			if err := txn.Set([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getKV(t *testing.T, db *badger.DB, key string) string {
+	var v []byte
+	err := db.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get([]byte(key))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err = itm.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(v)
+}
+
+func TestIncrementalBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	db := memDB(t)
+	setKV(t, db, "a", "1")
+	full, err := Backup(db, dir, BackupOptions{Incremental: true})
+	if err != nil || !full.Full {
+		t.Fatal(full, err)
+	}
+	setKV(t, db, "b", "2", "a", "3")
+	inc, err := Backup(db, dir, BackupOptions{Incremental: true})
+	if err != nil || inc.Full || inc.Since != full.Version {
+		t.Fatal(inc, err)
+	}
+	// 没有变化的增量备份不应该回退版本
+	empty, err := Backup(db, dir, BackupOptions{Incremental: true})
+	if err != nil || empty.Version != inc.Version {
+		t.Fatal(empty, err)
+	}
+	target := memDB(t)
+	if err = Restore(target, dir); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := getKV(t, target, "a"), getKV(t, target, "b"); a != "3" || b != "2" {
+		t.Fatalf("unexpected a=%s b=%s", a, b)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	now := time.Now()
+	var files []BackupFile
+	for i := 0; i < 6; i++ {
+		files = append(files, BackupFile{File: fmt.Sprint(i), Full: i%2 == 0, Time: now.Add(time.Duration(i-6) * time.Hour)})
+	}
+	kept, removed := retain(files, BackupOptions{Keep: 2}, now)
+	if len(kept) != 4 || len(removed) != 2 || kept[0].File != "2" {
+		t.Fatal(kept, removed)
+	}
+	kept, removed = retain(files, BackupOptions{MaxAge: 2 * time.Hour}, now)
+	if len(kept) != 2 || kept[0].File != "4" {
+		t.Fatal(kept, removed)
+	}
+	// 最新的链总是保留
+	kept, _ = retain(files, BackupOptions{MaxAge: time.Minute}, now)
+	if len(kept) != 2 {
+		t.Fatal(kept)
+	}
+}
+
+func TestDumpLoad(t *testing.T) {
+	db := memDB(t)
+	setKV(t, db, "k1", "v1", "k2", "\xff\x00")
+	err := db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte("k3"), []byte("ttl")).WithTTL(time.Hour).WithMeta(1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if n, err := Dump(db, buf, nil); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	target := memDB(t)
+	if n, err := LoadDump(target, bytes.NewReader(buf.Bytes())); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	if getKV(t, target, "k2") != "\xff\x00" {
+		t.Fatal("binary value mismatch")
+	}
+	err = target.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get([]byte("k3"))
+		if err != nil {
+			return err
+		}
+		if itm.ExpiresAt() == 0 || itm.UserMeta() != 1 {
+			t.Errorf("expires/meta lost: %d %d", itm.ExpiresAt(), itm.UserMeta())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,373 @@
+package docstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	uuid "github.com/satori/go.uuid"
+	"lightbox/kvstore"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IDField 文档主键字段
+const IDField = "_id"
+
+var (
+	ErrNotFound = errors.New("document not found")
+	ErrExists   = errors.New("document already exists")
+	ErrNoIndex  = errors.New("field is not indexed")
+	// MaxRetry 事务冲突时的最大重试次数
+	MaxRetry = 32
+)
+
+// Doc JSON文档
+type Doc = map[string]interface{}
+
+// Collection 基于badger的文档集合，key布局:
+//
+//	doc\x00<collection>\x00m                          -> 索引字段列表(JSON)
+//	doc\x00<collection>\x00d\x00<id>                  -> 文档(JSON)
+//	doc\x00<collection>\x00i\x00<field>\x00<value>\x00<id> -> id
+type Collection struct {
+	db      *badger.DB
+	name    string
+	lock    sync.RWMutex
+	indexes map[string]bool
+}
+
+type collectionKey struct {
+	db   *badger.DB
+	name string
+}
+
+var (
+	// collections 同一个db上的同名集合共享索引定义
+	collections   = map[collectionKey]*Collection{}
+	collectionsMu sync.Mutex
+)
+
+func init() {
+	kvstore.OnClose(forget)
+}
+
+// forget 数据库关闭后移除其上的集合
+func forget(db *badger.DB) {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+	for k := range collections {
+		if k.db == db {
+			delete(collections, k)
+		}
+	}
+}
+
+// Open 打开集合，并确保fields上的索引存在(新增的索引会为已有文档补建)
+func Open(db *badger.DB, name string, fields ...string) (*Collection, error) {
+	if name == "" {
+		return nil, errors.New("collection name is empty")
+	}
+	collectionsMu.Lock()
+	c, ok := collections[collectionKey{db, name}]
+	if !ok {
+		c = &Collection{db: db, name: name}
+		if err := c.loadMeta(); err != nil {
+			collectionsMu.Unlock()
+			return nil, err
+		}
+		collections[collectionKey{db, name}] = c
+	}
+	collectionsMu.Unlock()
+	for _, f := range fields {
+		if err := c.EnsureIndex(f); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Collection) Name() string {
+	return c.name
+}
+
+func (c *Collection) prefix() []byte {
+	return []byte("doc\x00" + c.name + "\x00")
+}
+
+func (c *Collection) metaKey() []byte {
+	return append(c.prefix(), 'm')
+}
+
+func (c *Collection) docPrefix() []byte {
+	return append(c.prefix(), 'd', 0)
+}
+
+func (c *Collection) docKey(id string) []byte {
+	return append(c.docPrefix(), id...)
+}
+
+func (c *Collection) indexPrefix(field string) []byte {
+	return append(append(append(c.prefix(), 'i', 0), field...), 0)
+}
+
+func (c *Collection) indexKey(field string, value []byte, id string) []byte {
+	key := append(c.indexPrefix(field), value...)
+	key = append(key, 0)
+	return append(key, id...)
+}
+
+func (c *Collection) loadMeta() error {
+	c.indexes = map[string]bool{}
+	return c.db.View(func(txn *badger.Txn) error {
+		itm, err := txn.Get(c.metaKey())
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return itm.Value(func(val []byte) error {
+			var fields []string
+			if err := json.Unmarshal(val, &fields); err != nil {
+				return err
+			}
+			for _, f := range fields {
+				c.indexes[f] = true
+			}
+			return nil
+		})
+	})
+}
+
+// Indexes 已声明的索引字段
+func (c *Collection) Indexes() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	fields := make([]string, 0, len(c.indexes))
+	for f := range c.indexes {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (c *Collection) hasIndex(field string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.indexes[field]
+}
+
+// EnsureIndex 声明索引，字段支持a.b形式的嵌套路径
+func (c *Collection) EnsureIndex(field string) error {
+	if field == "" || strings.ContainsRune(field, 0) {
+		return fmt.Errorf("invalid index field %q", field)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.indexes[field] {
+		return nil
+	}
+	// 持有写锁补建索引，期间的写入会等待，保证索引完整
+	wb := c.db.NewWriteBatch()
+	defer wb.Cancel()
+	err := c.db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = c.docPrefix()
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var doc Doc
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &doc)
+			}); err != nil {
+				return err
+			}
+			id := string(it.Item().Key()[len(opt.Prefix):])
+			if v, ok := encodeField(doc, field); ok {
+				if err := wb.Set(c.indexKey(field, v, id), []byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = wb.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	c.indexes[field] = true
+	return c.db.Update(c.saveMeta)
+}
+
+// DropIndex 删除索引及其所有条目
+func (c *Collection) DropIndex(field string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.indexes[field] {
+		return nil
+	}
+	delete(c.indexes, field)
+	if err := c.db.Update(c.saveMeta); err != nil {
+		return err
+	}
+	return c.db.DropPrefix(c.indexPrefix(field))
+}
+
+// saveMeta 保存索引定义，调用方需持有写锁
+func (c *Collection) saveMeta(txn *badger.Txn) error {
+	fields := make([]string, 0, len(c.indexes))
+	for f := range c.indexes {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return txn.Set(c.metaKey(), data)
+}
+
+// update 读写事务，冲突时自动重试；持有读锁保证索引定义在事务期间不变
+func (c *Collection) update(fn func(txn *badger.Txn) error) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var err error
+	for i := 0; i <= MaxRetry; i++ {
+		if err = c.db.Update(fn); err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Collection) get(txn *badger.Txn, id string) (Doc, error) {
+	itm, err := txn.Get(c.docKey(id))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc Doc
+	err = itm.Value(func(val []byte) error {
+		return json.Unmarshal(val, &doc)
+	})
+	return doc, err
+}
+
+// put 写入文档并维护索引，old为nil表示新文档
+func (c *Collection) put(txn *badger.Txn, id string, old, doc Doc) error {
+	for field := range c.indexes {
+		ov, oldOk := encodeField(old, field)
+		nv, newOk := encodeField(doc, field)
+		if oldOk && newOk && string(ov) == string(nv) {
+			continue
+		}
+		if oldOk {
+			if err := txn.Delete(c.indexKey(field, ov, id)); err != nil {
+				return err
+			}
+		}
+		if newOk {
+			if err := txn.Set(c.indexKey(field, nv, id), []byte(id)); err != nil {
+				return err
+			}
+		}
+	}
+	if doc == nil {
+		return txn.Delete(c.docKey(id))
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return txn.Set(c.docKey(id), data)
+}
+
+// normalize 通过JSON往返统一数值等类型，保证写入和索引使用同样的值
+func normalize(doc Doc) (Doc, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var out Doc
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+// Insert 插入文档，_id为空时自动生成
+func (c *Collection) Insert(doc Doc) (string, error) {
+	doc, err := normalize(doc)
+	if err != nil {
+		return "", err
+	}
+	if doc == nil {
+		doc = Doc{}
+	}
+	id, _ := doc[IDField].(string)
+	if id == "" {
+		id = uuid.NewV1().String()
+		doc[IDField] = id
+	}
+	err = c.update(func(txn *badger.Txn) error {
+		if _, err := c.get(txn, id); err == nil {
+			return ErrExists
+		} else if err != ErrNotFound {
+			return err
+		}
+		return c.put(txn, id, nil, doc)
+	})
+	return id, err
+}
+
+// Update 把fields合并到已有文档(只合并第一层字段)，值为nil的字段会被删除
+func (c *Collection) Update(id string, fields Doc) error {
+	fields, err := normalize(fields)
+	if err != nil {
+		return err
+	}
+	return c.update(func(txn *badger.Txn) error {
+		old, err := c.get(txn, id)
+		if err != nil {
+			return err
+		}
+		doc := make(Doc, len(old)+len(fields))
+		for k, v := range old {
+			doc[k] = v
+		}
+		for k, v := range fields {
+			if v == nil {
+				delete(doc, k)
+			} else {
+				doc[k] = v
+			}
+		}
+		doc[IDField] = id
+		return c.put(txn, id, old, doc)
+	})
+}
+
+// Delete 删除文档，不存在时返回ErrNotFound
+func (c *Collection) Delete(id string) error {
+	return c.update(func(txn *badger.Txn) error {
+		old, err := c.get(txn, id)
+		if err != nil {
+			return err
+		}
+		return c.put(txn, id, old, nil)
+	})
+}
+
+// Get 读取文档，不存在时返回ErrNotFound
+func (c *Collection) Get(id string) (Doc, error) {
+	var doc Doc
+	err := c.db.View(func(txn *badger.Txn) (err error) {
+		doc, err = c.get(txn, id)
+		return
+	})
+	return doc, err
+}
@@ -0,0 +1,197 @@
+package docstore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"lightbox/kvstore"
+	"strings"
+	"testing"
+)
+
+func openTest(t *testing.T, fields ...string) *Collection {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	c, err := Open(db, "users", fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func ids(docs []Doc) string {
+	var s []string
+	for _, d := range docs {
+		s = append(s, fmt.Sprint(d[IDField]))
+	}
+	return strings.Join(s, " ")
+}
+
+func strp(s string) *string { return &s }
+
+func TestCRUD(t *testing.T) {
+	c := openTest(t, "age")
+	id, err := c.Insert(Doc{"name": "tom", "age": 18})
+	if err != nil || id == "" {
+		t.Fatal(id, err)
+	}
+	if _, err = c.Insert(Doc{IDField: id}); err != ErrExists {
+		t.Fatalf("expect ErrExists, got %v", err)
+	}
+	if err = c.Update(id, Doc{"age": 20, "name": nil}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := c.Get(id)
+	if err != nil || doc["age"] != float64(20) || doc["name"] != nil {
+		t.Fatal(doc, err)
+	}
+	// 旧的索引值已删除
+	if n, _ := c.Count(Query{Field: "age", Eq: 18}); n != 0 {
+		t.Fatalf("stale index entry, count=%d", n)
+	}
+	if n, _ := c.Count(Query{Field: "age", Eq: 20}); n != 1 {
+		t.Fatalf("expect 1, got %d", n)
+	}
+	if err = c.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get(id); err != ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if n, _ := c.Count(Query{Field: "age"}); n != 0 {
+		t.Fatalf("index not cleaned, count=%d", n)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	c := openTest(t, "age", "name", "addr.city")
+	users := []Doc{
+		{IDField: "a", "name": "alice", "age": 30, "addr": Doc{"city": "bj"}},
+		{IDField: "b", "name": "bob", "age": -5, "addr": Doc{"city": "sh"}},
+		{IDField: "c", "name": "carol", "age": 2.5, "addr": Doc{"city": "bj"}},
+		{IDField: "d", "name": "alan", "age": 100},
+		{IDField: "e", "name": "eve", "age": 30},
+	}
+	for _, u := range users {
+		if _, err := c.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		q    Query
+		want string
+	}{
+		{Query{}, "a b c d e"},
+		{Query{Desc: true, Limit: 2}, "e d"},
+		{Query{Field: "age"}, "b c a e d"},
+		{Query{Field: "age", Desc: true}, "d e a c b"},
+		{Query{Field: "age", Eq: 30}, "a e"},
+		{Query{Field: "age", Gte: 2.5, Lt: 100}, "c a e"},
+		{Query{Field: "age", Gt: 2.5, Lte: 100, Desc: true}, "d e a"},
+		{Query{Field: "age", Lt: 30, Desc: true}, "c b"},
+		{Query{Field: "age", Offset: 1, Limit: 2}, "c a"},
+		{Query{Field: "name", Prefix: strp("al")}, "d a"},
+		{Query{Field: "name", Prefix: strp("al"), Desc: true}, "a d"},
+		{Query{Field: "addr.city", Eq: "bj"}, "a c"},
+	}
+	for _, cs := range cases {
+		docs, err := c.Find(cs.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(docs); got != cs.want {
+			t.Errorf("%+v: want %s, got %s", cs.q, cs.want, got)
+		}
+	}
+	if _, err := c.Find(Query{Field: "email", Eq: "x"}); !errors.Is(err, ErrNoIndex) {
+		t.Fatalf("expect ErrNoIndex, got %v", err)
+	}
+}
+
+func TestRangeOfType(t *testing.T) {
+	c := openTest(t, "age")
+	for _, u := range []Doc{
+		{IDField: "a", "age": nil},
+		{IDField: "b", "age": false},
+		{IDField: "c", "age": 1},
+		{IDField: "d", "age": 20},
+		{IDField: "e", "age": "10"},
+	} {
+		if _, err := c.Insert(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		q    Query
+		want string
+	}{
+		{Query{Field: "age", Gt: 1}, "d"},
+		{Query{Field: "age", Lte: 20}, "c d"},
+		{Query{Field: "age", Gte: ""}, "e"},
+		{Query{Field: "age", Lt: "2"}, "e"},
+	}
+	for _, cs := range cases {
+		docs, err := c.Find(cs.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(docs); got != cs.want {
+			t.Errorf("%+v: want %s, got %s", cs.q, cs.want, got)
+		}
+	}
+	if _, err := c.Find(Query{Field: "age", Gte: 1, Lt: "2"}); err == nil {
+		t.Error("range of different types should fail")
+	}
+}
+
+func TestForgetClosedDB(t *testing.T) {
+	db, err := kvstore.Open(":docstore_test", badger.DefaultOptions("").WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(db, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kvstore.Close(":docstore_test"); err != nil {
+		t.Fatal(err)
+	}
+	collectionsMu.Lock()
+	_, ok := collections[collectionKey{c.db, c.name}]
+	collectionsMu.Unlock()
+	if ok {
+		t.Error("collections of a closed db should be removed")
+	}
+}
+
+func TestEnsureIndexBackfill(t *testing.T) {
+	c := openTest(t)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Insert(Doc{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.EnsureIndex("n"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Count(Query{Field: "n", Gte: 5}); n != 5 {
+		t.Fatalf("expect 5, got %d", n)
+	}
+	// 重新打开时从meta加载索引定义
+	collectionsMu.Lock()
+	delete(collections, collectionKey{c.db, c.name})
+	collectionsMu.Unlock()
+	c2, err := Open(c.db, c.name)
+	if err != nil || len(c2.Indexes()) != 1 {
+		t.Fatal(c2.Indexes(), err)
+	}
+	if err = c2.DropIndex("n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c2.Find(Query{Field: "n"}); !errors.Is(err, ErrNoIndex) {
+		t.Fatalf("expect ErrNoIndex, got %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package docstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"math"
+	"strings"
+)
+
+// DefaultLimit 查询未指定Limit时最多返回的文档数
+var DefaultLimit = 100
+
+// Query 查询条件，Field为空时按_id顺序遍历全部文档；
+// Eq/Prefix/范围条件只能用于已索引的字段，结果按字段值(相同时按_id)排序
+type Query struct {
+	Field  string
+	Eq     interface{}
+	Gt     interface{}
+	Gte    interface{}
+	Lt     interface{}
+	Lte    interface{}
+	Prefix *string
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+// 索引值编码: 类型标记 + 保序的字节序列
+const (
+	tagNull byte = iota + 1
+	tagFalse
+	tagTrue
+	tagNumber
+	tagString
+)
+
+// encodeValue 把标量编码为保序字节，数组/对象不能被索引
+func encodeValue(v interface{}) ([]byte, bool) {
+	var f float64
+	switch vv := v.(type) {
+	case nil:
+		return []byte{tagNull}, true
+	case bool:
+		if vv {
+			return []byte{tagTrue}, true
+		}
+		return []byte{tagFalse}, true
+	case string:
+		return append([]byte{tagString}, vv...), true
+	case float64:
+		f = vv
+	case float32:
+		f = float64(vv)
+	case int:
+		f = float64(vv)
+	case int64:
+		f = float64(vv)
+	case int32:
+		f = float64(vv)
+	case uint64:
+		f = float64(vv)
+	case json.Number:
+		n, err := vv.Float64()
+		if err != nil {
+			return nil, false
+		}
+		f = n
+	default:
+		return nil, false
+	}
+	bits := math.Float64bits(f)
+	if f >= 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, 9)
+	buf[0] = tagNumber
+	binary.BigEndian.PutUint64(buf[1:], bits)
+	return buf, true
+}
+
+// lookup 按a.b形式的路径取字段值
+func lookup(doc Doc, field string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func encodeField(doc Doc, field string) ([]byte, bool) {
+	if doc == nil {
+		return nil, false
+	}
+	v, ok := lookup(doc, field)
+	if !ok {
+		return nil, false
+	}
+	return encodeValue(v)
+}
+
+// prefixEnd 返回大于所有以p为前缀的key的最小key
+func prefixEnd(p []byte) []byte {
+	end := append([]byte{}, p...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// bounds 计算查询在索引上的key区间[lo,hi)
+func (c *Collection) bounds(q Query) (prefix, lo, hi []byte, err error) {
+	prefix = c.indexPrefix(q.Field)
+	lo, hi = prefix, prefixEnd(prefix)
+	bound := func(name string, v interface{}, sep byte) ([]byte, error) {
+		enc, ok := encodeValue(v)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported value type %T", name, v)
+		}
+		return append(append(append([]byte{}, prefix...), enc...), sep), nil
+	}
+	// 索引key为 prefix+value+\x00+id，value相同的key都落在[value\x00, value\x01)之间
+	if q.Eq != nil {
+		if lo, err = bound("eq", q.Eq, 0); err != nil {
+			return
+		}
+		hi, err = bound("eq", q.Eq, 1)
+		return
+	}
+	if q.Prefix != nil {
+		lo = append(append(append([]byte{}, prefix...), tagString), *q.Prefix...)
+		hi = prefixEnd(lo)
+		return
+	}
+	switch {
+	case q.Gte != nil:
+		lo, err = bound("gte", q.Gte, 0)
+	case q.Gt != nil:
+		lo, err = bound("gt", q.Gt, 1)
+	}
+	if err != nil {
+		return
+	}
+	switch {
+	case q.Lte != nil:
+		hi, err = bound("lte", q.Lte, 1)
+	case q.Lt != nil:
+		hi, err = bound("lt", q.Lt, 0)
+	}
+	if err != nil {
+		return
+	}
+	// 范围只比较同类型的值，单边的范围限制在比较值的类型标记内
+	loTag, hiTag := len(lo) > len(prefix), len(hi) > len(prefix)
+	switch {
+	case loTag && hiTag && lo[len(prefix)] != hi[len(prefix)]:
+		err = errors.New("range bounds are of different types")
+	case loTag && !hiTag:
+		hi = prefixEnd(append(append([]byte{}, prefix...), lo[len(prefix)]))
+	case hiTag && !loTag:
+		lo = append(append([]byte{}, prefix...), hi[len(prefix)])
+	}
+	return
+}
+
+// scan 按查询条件遍历匹配的文档id
+func (c *Collection) scan(txn *badger.Txn, q Query, fn func(id string) bool) error {
+	var prefix, lo, hi []byte
+	if q.Field == "" || q.Field == IDField {
+		prefix = c.docPrefix()
+		lo, hi = prefix, prefixEnd(prefix)
+	} else {
+		if !c.hasIndex(q.Field) {
+			return fmt.Errorf("%w: %s", ErrNoIndex, q.Field)
+		}
+		var err error
+		if prefix, lo, hi, err = c.bounds(q); err != nil {
+			return err
+		}
+	}
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = prefix
+	opt.Reverse = q.Desc
+	it := txn.NewIterator(opt)
+	defer it.Close()
+	if q.Desc {
+		it.Seek(hi)
+	} else {
+		it.Seek(lo)
+	}
+	for ; it.ValidForPrefix(prefix); it.Next() {
+		key := it.Item().Key()
+		if bytes.Compare(key, hi) >= 0 {
+			if q.Desc {
+				continue
+			}
+			break
+		}
+		if bytes.Compare(key, lo) < 0 {
+			if q.Desc {
+				break
+			}
+			continue
+		}
+		var id string
+		if q.Field == "" || q.Field == IDField {
+			id = string(key[len(prefix):])
+		} else {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			id = string(v)
+		}
+		if !fn(id) {
+			break
+		}
+	}
+	return nil
+}
+
+// Find 查询文档
+func (c *Collection) Find(q Query) ([]Doc, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	docs := []Doc{}
+	err := c.db.View(func(txn *badger.Txn) error {
+		var (
+			skipped int
+			err     error
+		)
+		scanErr := c.scan(txn, q, func(id string) bool {
+			if skipped < q.Offset {
+				skipped++
+				return true
+			}
+			var doc Doc
+			if doc, err = c.get(txn, id); err != nil {
+				return false
+			}
+			docs = append(docs, doc)
+			return len(docs) < limit
+		})
+		if scanErr != nil {
+			return scanErr
+		}
+		return err
+	})
+	return docs, err
+}
+
+// Count 统计匹配的文档数(忽略Offset/Limit)
+func (c *Collection) Count(q Query) (int, error) {
+	n := 0
+	err := c.db.View(func(txn *badger.Txn) error {
+		return c.scan(txn, q, func(string) bool {
+			n++
+			return true
+		})
+	})
+	return n, err
+}
@@ -0,0 +1,101 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/dgraph-io/badger/v3"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// DumpEntry JSON lines导出的一行，key/value为合法UTF-8时使用字符串，否则使用base64字段
+type DumpEntry struct {
+	Key         string `json:"key,omitempty"`
+	KeyBase64   []byte `json:"key_base64,omitempty"`
+	Value       string `json:"value,omitempty"`
+	ValueBase64 []byte `json:"value_base64,omitempty"`
+	ExpiresAt   uint64 `json:"expires_at,omitempty"`
+	UserMeta    byte   `json:"user_meta,omitempty"`
+}
+
+func (e *DumpEntry) key() []byte {
+	if e.KeyBase64 != nil {
+		return e.KeyBase64
+	}
+	return []byte(e.Key)
+}
+
+func (e *DumpEntry) value() []byte {
+	if e.ValueBase64 != nil {
+		return e.ValueBase64
+	}
+	return []byte(e.Value)
+}
+
+// Dump 把prefix下的所有key以JSON lines导出，返回导出的条数
+func Dump(db *badger.DB, w io.Writer, prefix []byte) (int, error) {
+	n := 0
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = prefix
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			itm := it.Item()
+			e := DumpEntry{ExpiresAt: itm.ExpiresAt(), UserMeta: itm.UserMeta()}
+			if k := itm.KeyCopy(nil); utf8.Valid(k) {
+				e.Key = string(k)
+			} else {
+				e.KeyBase64 = k
+			}
+			v, err := itm.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if utf8.Valid(v) {
+				e.Value = string(v)
+			} else {
+				e.ValueBase64 = v
+			}
+			if err = enc.Encode(&e); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// LoadDump 导入Dump生成的JSON lines，已过期的条目会被跳过，返回导入的条数
+func LoadDump(db *badger.DB, r io.Reader) (int, error) {
+	n := 0
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	dec := json.NewDecoder(r)
+	now := uint64(time.Now().Unix())
+	for {
+		var e DumpEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		if e.ExpiresAt > 0 && e.ExpiresAt <= now {
+			continue
+		}
+		entry := badger.NewEntry(e.key(), e.value()).WithMeta(e.UserMeta)
+		entry.ExpiresAt = e.ExpiresAt
+		if err := wb.SetEntry(entry); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, wb.Flush()
+}
@@ -12,7 +12,29 @@ import (
 
 var databases = &sync.Map{}
 
+var (
+	closeHooks   []func(db *badger.DB)
+	closeHooksMu sync.Mutex
+)
+
+// OnClose 注册数据库关闭时的回调，用于清理以*badger.DB为key的缓存
+func OnClose(fn func(db *badger.DB)) {
+	closeHooksMu.Lock()
+	defer closeHooksMu.Unlock()
+	closeHooks = append(closeHooks, fn)
+}
+
+func closed(db *badger.DB) {
+	closeHooksMu.Lock()
+	hooks := closeHooks
+	closeHooksMu.Unlock()
+	for _, fn := range hooks {
+		fn(db)
+	}
+}
+
 func Shutdown() {
+	stopAllMaintenance()
 	databases.Range(func(key, value any) bool {
 		if value != nil {
 			if db, ok := value.(*badger.DB); ok && db != nil {
@@ -20,6 +42,7 @@ func Shutdown() {
 				if !db.IsClosed() {
 					db.Close()
 				}
+				closed(db)
 			}
 		}
 		return true
@@ -69,7 +92,7 @@ func OpenWith(name, dir string) (*badger.DB, error) {
 func Open(name string, options badger.Options) (*badger.DB, error) {
 	if strings.HasPrefix(name, ":") {
 		//如果前缀带有`:`,则不落盘
-		options.Dir = ""
+		options.Dir, options.ValueDir = "", ""
 	}
 	if options.Dir == "" {
 		options.InMemory = true
@@ -81,6 +104,7 @@ func Open(name string, options badger.Options) (*badger.DB, error) {
 			db, err := badger.Open(options)
 			if err == nil {
 				databases.Store(name, db)
+				startMaintenance(name, db, DefaultMaintenance())
 			}
 			return db, err
 		} else {
@@ -108,9 +132,12 @@ func Get(name string) (*badger.DB, error) {
 	return nil, e
 }
 func Close(name string) error {
+	stopMaintenance(name)
 	if d, ok := databases.Load(name); ok && d != nil {
 		databases.Delete(name)
-		err := d.(*badger.DB).Close()
+		db := d.(*badger.DB)
+		err := db.Close()
+		closed(db)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,280 @@
+package kvstore
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaintenanceOptions 后台维护选项，间隔<=0表示不执行
+type MaintenanceOptions struct {
+	//GCInterval value log GC间隔
+	GCInterval time.Duration `json:"gc_interval" yaml:"gc_interval"`
+	//DiscardRatio value log文件中可回收数据超过该比例时才重写(0~1)
+	DiscardRatio float64 `json:"discard_ratio" yaml:"discard_ratio"`
+	//FlattenInterval 定期把LSM压缩到一层
+	FlattenInterval time.Duration `json:"flatten_interval" yaml:"flatten_interval"`
+	//FlattenWorkers 压缩并发数
+	FlattenWorkers int `json:"flatten_workers" yaml:"flatten_workers"`
+}
+
+// DBStats 数据库大小及维护统计
+type DBStats struct {
+	Name        string    `json:"name"`
+	Dir         string    `json:"dir"`
+	LSMSize     int64     `json:"lsm_size"`
+	VLogSize    int64     `json:"vlog_size"`
+	Tables      int       `json:"tables"`
+	GCRuns      int64     `json:"gc_runs"`
+	GCRewrites  int64     `json:"gc_rewrites"`
+	LastGC      time.Time `json:"last_gc"`
+	Flattens    int64     `json:"flattens"`
+	LastFlatten time.Time `json:"last_flatten"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+var (
+	defaultMaintenance = MaintenanceOptions{GCInterval: 10 * time.Minute, DiscardRatio: 0.5, FlattenWorkers: 1}
+	maintenanceLock    sync.Mutex
+	maintainers        = map[string]*maintainer{}
+)
+
+// SetDefaultMaintenance 设置之后打开的数据库使用的维护选项
+func SetDefaultMaintenance(opt MaintenanceOptions) {
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	defaultMaintenance = opt.withDefaults()
+}
+
+func DefaultMaintenance() MaintenanceOptions {
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	return defaultMaintenance
+}
+
+func (o MaintenanceOptions) withDefaults() MaintenanceOptions {
+	if o.DiscardRatio <= 0 || o.DiscardRatio >= 1 {
+		o.DiscardRatio = 0.5
+	}
+	if o.FlattenWorkers <= 0 {
+		o.FlattenWorkers = 1
+	}
+	return o
+}
+
+type maintainer struct {
+	name string
+	db   *badger.DB
+	opt  MaintenanceOptions
+	stop chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex
+	stats DBStats
+}
+
+// startMaintenance 为数据库启动维护协程，已存在时按新的选项重启
+func startMaintenance(name string, db *badger.DB, opt MaintenanceOptions) {
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	var stats DBStats
+	if m, ok := maintainers[name]; ok {
+		m.shutdown()
+		stats = m.snapshot()
+	}
+	m := &maintainer{name: name, db: db, opt: opt.withDefaults(), stop: make(chan struct{}), done: make(chan struct{}), stats: stats}
+	m.stats.Name = name
+	m.stats.Dir = db.Opts().Dir
+	maintainers[name] = m
+	go m.loop()
+}
+
+func stopMaintenance(name string) {
+	maintenanceLock.Lock()
+	m, ok := maintainers[name]
+	delete(maintainers, name)
+	maintenanceLock.Unlock()
+	if ok {
+		m.shutdown()
+	}
+}
+
+func stopAllMaintenance() {
+	maintenanceLock.Lock()
+	all := maintainers
+	maintainers = map[string]*maintainer{}
+	maintenanceLock.Unlock()
+	for _, m := range all {
+		m.shutdown()
+	}
+}
+
+func getMaintainer(name string) (*maintainer, error) {
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	if m, ok := maintainers[name]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s not exists", name)
+}
+
+func (m *maintainer) shutdown() {
+	close(m.stop)
+	<-m.done
+}
+
+func ticker(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+func (m *maintainer) loop() {
+	defer close(m.done)
+	gc, stopGC := ticker(m.opt.GCInterval)
+	defer stopGC()
+	flatten, stopFlatten := ticker(m.opt.FlattenInterval)
+	defer stopFlatten()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-gc:
+			if _, err := m.runGC(); err != nil {
+				log.Warnf("badger %s value log gc error:%s", m.name, err)
+			}
+		case <-flatten:
+			if err := m.flatten(m.opt.FlattenWorkers); err != nil {
+				log.Warnf("badger %s flatten error:%s", m.name, err)
+			}
+		}
+	}
+}
+
+// runGC 反复执行value log GC直到没有可重写的文件，返回重写的文件数
+func (m *maintainer) runGC() (int, error) {
+	if m.db.IsClosed() || m.db.Opts().InMemory {
+		return 0, nil
+	}
+	rewrites := 0
+	var err error
+	for {
+		select {
+		case <-m.stop:
+			return rewrites, nil
+		default:
+		}
+		if err = m.db.RunValueLogGC(m.opt.DiscardRatio); err != nil {
+			break
+		}
+		rewrites++
+	}
+	if err == badger.ErrNoRewrite || err == badger.ErrRejected {
+		err = nil
+	}
+	m.mu.Lock()
+	m.stats.GCRuns++
+	m.stats.GCRewrites += int64(rewrites)
+	m.stats.LastGC = time.Now()
+	m.setError(err)
+	m.mu.Unlock()
+	if rewrites > 0 {
+		log.Infof("badger %s value log gc rewrote %d files", m.name, rewrites)
+	}
+	return rewrites, err
+}
+
+func (m *maintainer) flatten(workers int) error {
+	if m.db.IsClosed() {
+		return nil
+	}
+	if workers <= 0 {
+		workers = m.opt.FlattenWorkers
+	}
+	err := m.db.Flatten(workers)
+	m.mu.Lock()
+	m.stats.Flattens++
+	m.stats.LastFlatten = time.Now()
+	m.setError(err)
+	m.mu.Unlock()
+	return err
+}
+
+func (m *maintainer) setError(err error) {
+	if err != nil {
+		m.stats.LastError = err.Error()
+	} else {
+		m.stats.LastError = ""
+	}
+}
+
+func (m *maintainer) snapshot() DBStats {
+	m.mu.Lock()
+	s := m.stats
+	m.mu.Unlock()
+	if !m.db.IsClosed() {
+		s.LSMSize, s.VLogSize = m.db.Size()
+		s.Tables = len(m.db.Tables())
+	}
+	return s
+}
+
+// SetMaintenance 修改已打开数据库的维护选项
+func SetMaintenance(name string, opt MaintenanceOptions) error {
+	m, err := getMaintainer(name)
+	if err != nil {
+		return err
+	}
+	startMaintenance(name, m.db, opt)
+	return nil
+}
+
+// RunGC 立即执行value log GC，返回重写的文件数
+func RunGC(name string) (int, error) {
+	m, err := getMaintainer(name)
+	if err != nil {
+		return 0, err
+	}
+	return m.runGC()
+}
+
+// Flatten 立即把LSM压缩到一层，workers<=0时使用维护选项中的并发数
+func Flatten(name string, workers int) error {
+	m, err := getMaintainer(name)
+	if err != nil {
+		return err
+	}
+	return m.flatten(workers)
+}
+
+// Stats 返回数据库的大小及维护统计
+func Stats(name string) (DBStats, error) {
+	m, err := getMaintainer(name)
+	if err != nil {
+		return DBStats{}, err
+	}
+	return m.snapshot(), nil
+}
+
+// AllStats 所有已打开数据库的统计，按名称排序
+func AllStats() []DBStats {
+	maintenanceLock.Lock()
+	all := make([]*maintainer, 0, len(maintainers))
+	for _, m := range maintainers {
+		all = append(all, m)
+	}
+	maintenanceLock.Unlock()
+	stats := make([]DBStats, 0, len(all))
+	for _, m := range all {
+		stats = append(stats, m.snapshot())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
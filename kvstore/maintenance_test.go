@@ -0,0 +1,57 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	SetDefaultMaintenance(MaintenanceOptions{GCInterval: 50 * time.Millisecond, DiscardRatio: 0.1})
+	defer SetDefaultMaintenance(MaintenanceOptions{GCInterval: 10 * time.Minute})
+	opt := DefaultOptions(t.TempDir()).WithValueLogFileSize(1 << 20).WithValueThreshold(1 << 10).WithLogger(nil)
+	db, err := Open("maintenance_test", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("x"), 16<<10)
+	for i := 0; i < 200; i++ {
+		if err = db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(fmt.Sprint("k", i)), value)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = db.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RunGC("maintenance_test"); err != nil {
+		t.Fatal(err)
+	}
+	if err = Flatten("maintenance_test", 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	st, err := Stats("maintenance_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 手动1次+定时至少1次
+	if st.GCRuns < 2 || st.Flattens != 1 || st.LastError != "" {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if err = SetMaintenance("maintenance_test", MaintenanceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if st2, _ := Stats("maintenance_test"); st2.GCRuns < st.GCRuns {
+		t.Fatalf("stats lost after restart %+v", st2)
+	}
+	if err = Close("maintenance_test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Stats("maintenance_test"); err == nil {
+		t.Fatal("maintainer should be stopped after close")
+	}
+}
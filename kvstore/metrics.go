@@ -0,0 +1,41 @@
+package kvstore
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"lightbox/metrics"
+	"sort"
+)
+
+func init() {
+	metrics.Register("kvstore", collectSize)
+}
+
+// collectSize 已打开的badger数据库的LSM及value log大小
+func collectSize() []metrics.Family {
+	lsm := metrics.Family{Name: "lightbox_badger_lsm_size_bytes", Help: "Size of the badger LSM tree in bytes.",
+		Type: metrics.TypeGauge, Labels: []string{"db"}}
+	vlog := metrics.Family{Name: "lightbox_badger_vlog_size_bytes", Help: "Size of the badger value log in bytes.",
+		Type: metrics.TypeGauge, Labels: []string{"db"}}
+	var names []string
+	dbs := map[string]*badger.DB{}
+	databases.Range(func(key, value any) bool {
+		if db, ok := value.(*badger.DB); ok && db != nil && !db.IsClosed() {
+			name := key.(string)
+			names = append(names, name)
+			dbs[name] = db
+		}
+		return true
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		l, v := dbs[name].Size()
+		lsm.Samples = append(lsm.Samples, metrics.Sample{Values: []string{name}, Value: float64(l)})
+		vlog.Samples = append(vlog.Samples, metrics.Sample{Values: []string{name}, Value: float64(v)})
+	}
+	gc := metrics.Family{Name: "lightbox_badger_gc_rewrites_total", Help: "Total number of value log files rewritten by GC.",
+		Type: metrics.TypeCounter, Labels: []string{"db"}}
+	for _, st := range AllStats() {
+		gc.Samples = append(gc.Samples, metrics.Sample{Values: []string{st.Name}, Value: float64(st.GCRewrites)})
+	}
+	return []metrics.Family{lsm, vlog, gc}
+}
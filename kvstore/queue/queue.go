@@ -0,0 +1,581 @@
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"sync"
+	"time"
+)
+
+// 任务状态
+const (
+	StateReady    = "ready"
+	StateDelayed  = "delayed"
+	StateInflight = "inflight"
+	StateDead     = "dead"
+)
+
+const (
+	// DefaultMaxAttempts 未指定时任务最多执行的次数，超过后进入死信队列
+	DefaultMaxAttempts = 5
+	// maxPromote 每次出队时最多处理的到期任务数
+	maxPromote = 1000
+	// batchSize 到期任务、死信重新入队时每个事务处理的任务数
+	batchSize = 100
+)
+
+var (
+	ErrNotFound = errors.New("job not found")
+	// ErrStale 任务已超时被重新投递或已被确认
+	ErrStale = errors.New("job is not inflight with the attempt")
+)
+
+// Job 队列中的任务
+type Job struct {
+	ID          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int             `json:"priority"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	//RunAt delayed为下次执行的时间，inflight为可见性超时的时间
+	RunAt     time.Time `json:"run_at"`
+	CreatedAt time.Time `json:"created_at"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// EnqueueOptions 入队选项
+type EnqueueOptions struct {
+	Delay time.Duration
+	//Priority 数值大的先执行，相同优先级按入队顺序执行
+	Priority    int
+	MaxAttempts int
+}
+
+// RetryPolicy 失败后按 Backoff*2^(attempts-1) 延迟重试，不超过MaxBackoff
+type RetryPolicy struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	backoff, max := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	if max <= 0 {
+		max = time.Hour
+	}
+	d := backoff
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Stats 各状态的任务数
+type Stats struct {
+	Ready    int `json:"ready"`
+	Delayed  int `json:"delayed"`
+	Inflight int `json:"inflight"`
+	Dead     int `json:"dead"`
+}
+
+// Store 基于badger的持久化任务队列，同一个数据库中可以有多个队列
+//
+// key布局: queue\x00<name>\x00 + j<id>(任务) / r<priority><id>(就绪) / d<run_at><id>(延迟) / i<deadline><id>(执行中) / x<id>(死信)
+type Store struct {
+	db  *badger.DB
+	seq *badger.Sequence
+	//mu 同一Store的状态变更串行执行，避免事务冲突
+	mu     sync.Mutex
+	wakeMu sync.Mutex
+	wake   map[string]chan struct{}
+}
+
+var (
+	stores   = map[*badger.DB]*Store{}
+	storesMu sync.Mutex
+)
+
+var seqKey = []byte("queue\x00\x00seq")
+
+// Open 在数据库上打开任务队列，同一个数据库返回同一个Store
+func Open(db *badger.DB) (*Store, error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if s, ok := stores[db]; ok {
+		return s, nil
+	}
+	seq, err := db.GetSequence(seqKey, 100)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, seq: seq, wake: map[string]chan struct{}{}}
+	stores[db] = s
+	return s, nil
+}
+
+// Close 释放序列号并从缓存中移除，不关闭数据库
+func (s *Store) Close() error {
+	storesMu.Lock()
+	delete(stores, s.db)
+	storesMu.Unlock()
+	return s.seq.Release()
+}
+
+func prefix(queue string) []byte {
+	return []byte("queue\x00" + queue + "\x00")
+}
+
+func jobKey(queue, id string) []byte {
+	return append(append(prefix(queue), 'j'), id...)
+}
+
+func stateKey(queue string, kind byte) []byte {
+	return append(prefix(queue), kind)
+}
+
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+// encodePriority 优先级大的排在前面
+func encodePriority(p int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(-int64(p))^(1<<63))
+	return b
+}
+
+func indexKey(j *Job) []byte {
+	switch j.State {
+	case StateReady:
+		return append(append(stateKey(j.Queue, 'r'), encodePriority(j.Priority)...), j.ID...)
+	case StateDelayed:
+		return append(append(stateKey(j.Queue, 'd'), encodeTime(j.RunAt)...), j.ID...)
+	case StateInflight:
+		return append(append(stateKey(j.Queue, 'i'), encodeTime(j.RunAt)...), j.ID...)
+	default:
+		return append(stateKey(j.Queue, 'x'), j.ID...)
+	}
+}
+
+func stateKind(state string) (byte, error) {
+	switch state {
+	case StateReady:
+		return 'r', nil
+	case StateDelayed:
+		return 'd', nil
+	case StateInflight:
+		return 'i', nil
+	case StateDead:
+		return 'x', nil
+	}
+	return 0, fmt.Errorf("unknown job state %s", state)
+}
+
+func getJob(txn *badger.Txn, queue, id string) (*Job, error) {
+	item, err := txn.Get(jobKey(queue, id))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	j := &Job{}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, j)
+	})
+	return j, err
+}
+
+func putJob(txn *badger.Txn, j *Job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err = txn.Set(jobKey(j.Queue, j.ID), data); err != nil {
+		return err
+	}
+	return txn.Set(indexKey(j), []byte(j.ID))
+}
+
+// move 修改任务状态并更新索引
+func move(txn *badger.Txn, j *Job, state string, runAt time.Time) error {
+	if err := txn.Delete(indexKey(j)); err != nil {
+		return err
+	}
+	j.State, j.RunAt = state, runAt
+	return putJob(txn, j)
+}
+
+// due 按索引顺序读取到期(key中的时间<=now)的任务
+func due(txn *badger.Txn, queue string, kind byte, now time.Time, limit int) ([]*Job, error) {
+	p := stateKey(queue, kind)
+	opt := badger.DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.Prefix = p
+	it := txn.NewIterator(opt)
+	var ids []string
+	for it.Seek(p); it.ValidForPrefix(p) && len(ids) < limit; it.Next() {
+		k := it.Item().Key()
+		if len(k) < len(p)+8 || int64(binary.BigEndian.Uint64(k[len(p):])) > now.UnixNano() {
+			break
+		}
+		ids = append(ids, string(k[len(p)+8:]))
+	}
+	it.Close()
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		j, err := getJob(txn, queue, id)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// fail 记录失败，未超过最大次数时延迟重试，否则进入死信队列
+func fail(txn *badger.Txn, j *Job, reason string, policy RetryPolicy, now time.Time) error {
+	j.LastError = reason
+	if j.Attempts >= j.MaxAttempts {
+		return move(txn, j, StateDead, now)
+	}
+	return move(txn, j, StateDelayed, now.Add(policy.delay(j.Attempts)))
+}
+
+func (s *Store) waker(queue string) chan struct{} {
+	s.wakeMu.Lock()
+	defer s.wakeMu.Unlock()
+	ch, ok := s.wake[queue]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		s.wake[queue] = ch
+	}
+	return ch
+}
+
+func (s *Store) notify(queue string) {
+	select {
+	case s.waker(queue) <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue 任务入队，payload为JSON
+func (s *Store) Enqueue(queue string, payload json.RawMessage, opt EnqueueOptions) (*Job, error) {
+	if queue == "" {
+		return nil, errors.New("queue name is empty")
+	}
+	if payload == nil {
+		payload = json.RawMessage("null")
+	} else if !json.Valid(payload) {
+		return nil, errors.New("payload is not valid json")
+	}
+	n, err := s.seq.Next()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	j := &Job{
+		ID:          fmt.Sprintf("%016x", n+1),
+		Queue:       queue,
+		Payload:     payload,
+		Priority:    opt.Priority,
+		State:       StateReady,
+		MaxAttempts: opt.MaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+	}
+	if j.MaxAttempts <= 0 {
+		j.MaxAttempts = DefaultMaxAttempts
+	}
+	if opt.Delay > 0 {
+		j.State, j.RunAt = StateDelayed, now.Add(opt.Delay)
+	}
+	s.mu.Lock()
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return putJob(txn, j)
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if j.State == StateReady {
+		s.notify(queue)
+	}
+	return j, nil
+}
+
+// Dequeue 取出优先级最高的就绪任务，任务在visibility内未确认时视为失败并重试，没有任务时返回nil
+func (s *Store) Dequeue(queue string, visibility time.Duration, policy RetryPolicy) (*Job, error) {
+	if visibility <= 0 {
+		return nil, errors.New("visibility timeout must be positive")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	//到期的延迟任务和超时的任务分批在各自的事务中处理,不和出队放在同一个事务中
+	_, err := s.batched(maxPromote, func(txn *badger.Txn, _, limit int) (int, int, error) {
+		delayed, err := due(txn, queue, 'd', now, limit)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, j := range delayed {
+			if err = move(txn, j, StateReady, now); err != nil {
+				return 0, 0, err
+			}
+		}
+		return len(delayed), len(delayed), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.batched(maxPromote, func(txn *badger.Txn, _, limit int) (int, int, error) {
+		expired, err := due(txn, queue, 'i', now, limit)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, j := range expired {
+			if err = fail(txn, j, "visibility timeout", policy, now); err != nil {
+				return 0, 0, err
+			}
+		}
+		return len(expired), len(expired), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var job *Job
+	err = s.db.Update(func(txn *badger.Txn) error {
+		var err error
+		p := stateKey(queue, 'r')
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = p
+		it := txn.NewIterator(opt)
+		var id []byte
+		if it.Seek(p); it.ValidForPrefix(p) {
+			id, err = it.Item().ValueCopy(nil)
+		}
+		it.Close()
+		if id == nil || err != nil {
+			return err
+		}
+		if job, err = getJob(txn, queue, string(id)); err != nil {
+			return err
+		}
+		job.Attempts++
+		return move(txn, job, StateInflight, now.Add(visibility))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// inflight 读取指定次数的执行中任务
+func inflight(txn *badger.Txn, queue, id string, attempt int) (*Job, error) {
+	j, err := getJob(txn, queue, id)
+	if err != nil {
+		return nil, err
+	}
+	if j.State != StateInflight || j.Attempts != attempt {
+		return nil, ErrStale
+	}
+	return j, nil
+}
+
+// Ack 确认任务完成并删除，attempt为出队时的Attempts
+func (s *Store) Ack(queue, id string, attempt int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Update(func(txn *badger.Txn) error {
+		j, err := inflight(txn, queue, id, attempt)
+		if err != nil {
+			return err
+		}
+		if err = txn.Delete(indexKey(j)); err != nil {
+			return err
+		}
+		return txn.Delete(jobKey(queue, id))
+	})
+}
+
+// Fail 任务执行失败，返回任务的新状态(delayed/dead)
+func (s *Store) Fail(queue, id string, attempt int, reason string, policy RetryPolicy) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var state string
+	err := s.db.Update(func(txn *badger.Txn) error {
+		j, err := inflight(txn, queue, id, attempt)
+		if err != nil {
+			return err
+		}
+		err = fail(txn, j, reason, policy, time.Now())
+		state = j.State
+		return err
+	})
+	return state, err
+}
+
+// Get 读取任务
+func (s *Store) Get(queue, id string) (*Job, error) {
+	var j *Job
+	err := s.db.View(func(txn *badger.Txn) (err error) {
+		j, err = getJob(txn, queue, id)
+		return
+	})
+	return j, err
+}
+
+// Stats 统计各状态的任务数
+func (s *Store) Stats(queue string) (Stats, error) {
+	var st Stats
+	err := s.db.View(func(txn *badger.Txn) error {
+		for kind, n := range map[byte]*int{'r': &st.Ready, 'd': &st.Delayed, 'i': &st.Inflight, 'x': &st.Dead} {
+			p := stateKey(queue, kind)
+			opt := badger.DefaultIteratorOptions
+			opt.PrefetchValues = false
+			opt.Prefix = p
+			it := txn.NewIterator(opt)
+			for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+				*n++
+			}
+			it.Close()
+		}
+		return nil
+	})
+	return st, err
+}
+
+// Peek 按执行顺序查看指定状态的任务，不改变任务状态
+func (s *Store) Peek(queue, state string, limit int) ([]*Job, error) {
+	kind, err := stateKind(state)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	var jobs []*Job
+	err = s.db.View(func(txn *badger.Txn) error {
+		p := stateKey(queue, kind)
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = p
+		it := txn.NewIterator(opt)
+		var ids []string
+		for it.Seek(p); it.ValidForPrefix(p) && len(ids) < limit; it.Next() {
+			id, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				it.Close()
+				return err
+			}
+			ids = append(ids, string(id))
+		}
+		it.Close()
+		for _, id := range ids {
+			j, err := getJob(txn, queue, id)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, j)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// batched 分批在各自的事务中执行fn,fn从第offset个开始处理最多limit个任务,返回处理的数量和实际修改的数量;
+// 处理的数量少于limit或累计达到total(<=0时不限)时结束,事务过大(badger.ErrTxnTooBig)时减少每批的数量后重试
+func (s *Store) batched(total int, fn func(txn *badger.Txn, offset, limit int) (n, affected int, err error)) (int, error) {
+	size, affected := batchSize, 0
+	for done := 0; total <= 0 || done < total; {
+		limit := size
+		if total > 0 && total-done < limit {
+			limit = total - done
+		}
+		var n, a int
+		err := s.db.Update(func(txn *badger.Txn) (err error) {
+			n, a, err = fn(txn, done, limit)
+			return err
+		})
+		if err == badger.ErrTxnTooBig && size > 1 {
+			size /= 2
+			continue
+		}
+		if err != nil {
+			return affected, err
+		}
+		done, affected = done+n, affected+a
+		if n < limit {
+			break
+		}
+	}
+	return affected, nil
+}
+
+// requeue 把死信任务重新放回就绪队列并重置执行次数,返回处理的数量
+func requeue(txn *badger.Txn, queue string, ids []string, now time.Time) (int, error) {
+	count := 0
+	for _, id := range ids {
+		j, err := getJob(txn, queue, id)
+		if err != nil {
+			return 0, err
+		}
+		if j.State != StateDead {
+			continue
+		}
+		j.Attempts = 0
+		if err = move(txn, j, StateReady, now); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RequeueDead 把死信任务重新放回就绪队列并重置执行次数，ids为空时处理所有死信任务
+func (s *Store) RequeueDead(queue string, ids ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var (
+		count int
+		err   error
+	)
+	if len(ids) == 0 {
+		p := stateKey(queue, 'x')
+		count, err = s.batched(0, func(txn *badger.Txn, _, limit int) (int, int, error) {
+			opt := badger.DefaultIteratorOptions
+			opt.PrefetchValues = false
+			opt.Prefix = p
+			it := txn.NewIterator(opt)
+			var dead []string
+			for it.Seek(p); it.ValidForPrefix(p) && len(dead) < limit; it.Next() {
+				dead = append(dead, string(it.Item().Key()[len(p):]))
+			}
+			it.Close()
+			n, err := requeue(txn, queue, dead, now)
+			return len(dead), n, err
+		})
+	} else {
+		count, err = s.batched(len(ids), func(txn *badger.Txn, offset, limit int) (int, int, error) {
+			n, err := requeue(txn, queue, ids[offset:offset+limit], now)
+			return limit, n, err
+		})
+	}
+	if count > 0 {
+		s.notify(queue)
+	}
+	return count, err
+}
@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T, dir string) (*badger.DB, *Store) {
+	opt := badger.DefaultOptions(dir).WithLogger(nil)
+	if dir == "" {
+		opt = opt.WithInMemory(true)
+	}
+	db, err := badger.Open(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+		_ = db.Close()
+	})
+	return db, s
+}
+
+func enqueue(t *testing.T, s *Store, payload string, opt EnqueueOptions) *Job {
+	j, err := s.Enqueue("q", json.RawMessage(payload), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestPriorityAndDelay(t *testing.T) {
+	_, s := openTest(t, "")
+	enqueue(t, s, `"low"`, EnqueueOptions{})
+	enqueue(t, s, `"later"`, EnqueueOptions{Delay: 100 * time.Millisecond, Priority: 9})
+	enqueue(t, s, `"high"`, EnqueueOptions{Priority: 5})
+	enqueue(t, s, `"low2"`, EnqueueOptions{})
+
+	var got []string
+	for {
+		j, err := s.Dequeue("q", time.Minute, RetryPolicy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil {
+			break
+		}
+		got = append(got, string(j.Payload))
+	}
+	if want := `"high" "low" "low2"`; strings.Join(got, " ") != want {
+		t.Fatalf("want %s, got %s", want, strings.Join(got, " "))
+	}
+	st, _ := s.Stats("q")
+	if st != (Stats{Delayed: 1, Inflight: 3}) {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if j, _ := s.Dequeue("q", time.Minute, RetryPolicy{}); j == nil || string(j.Payload) != `"later"` {
+		t.Fatalf("delayed job should be ready:%+v", j)
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	_, s := openTest(t, "")
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond}
+	job := enqueue(t, s, `{"n":1}`, EnqueueOptions{MaxAttempts: 2})
+
+	j, _ := s.Dequeue("q", time.Minute, policy)
+	if state, err := s.Fail("q", j.ID, j.Attempts, "boom", policy); err != nil || state != StateDelayed {
+		t.Fatal(state, err)
+	}
+	if err := s.Ack("q", j.ID, j.Attempts); !errors.Is(err, ErrStale) {
+		t.Fatalf("ack after fail should be stale:%v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	//可见性超时视为失败
+	j, _ = s.Dequeue("q", 10*time.Millisecond, policy)
+	if j == nil || j.Attempts != 2 {
+		t.Fatalf("expect second attempt:%+v", j)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if j, _ = s.Dequeue("q", time.Minute, policy); j != nil {
+		t.Fatalf("dead job should not be delivered:%+v", j)
+	}
+	dead, _ := s.Peek("q", StateDead, 0)
+	if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "visibility timeout" {
+		t.Fatalf("unexpected dead jobs %+v", dead)
+	}
+	if n, err := s.RequeueDead("q"); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+	j, _ = s.Dequeue("q", time.Minute, policy)
+	if j == nil || j.Attempts != 1 {
+		t.Fatalf("requeued job should restart attempts:%+v", j)
+	}
+	if err := s.Ack("q", j.ID, j.Attempts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("q", j.ID); err != ErrNotFound {
+		t.Fatalf("acked job should be removed:%v", err)
+	}
+}
+
+func TestPromoteInBatches(t *testing.T) {
+	//较小的memtable使单个事务只能容纳几百个任务的修改
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithMemTableSize(1 << 20).WithValueThreshold(1 << 10).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+		_ = db.Close()
+	})
+	const total = 1000
+	for i := 0; i < total; i++ {
+		enqueue(t, s, `{}`, EnqueueOptions{Delay: time.Millisecond, MaxAttempts: 1})
+	}
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < total; i++ {
+		if j, err := s.Dequeue("q", time.Millisecond, RetryPolicy{}); err != nil || j == nil {
+			t.Fatalf("dequeue %d: %+v %v", i, j, err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	//全部超时进入死信队列
+	if j, err := s.Dequeue("q", time.Minute, RetryPolicy{}); err != nil || j != nil {
+		t.Fatalf("expect no job: %+v %v", j, err)
+	}
+	if n, err := s.RequeueDead("q"); n != total || err != nil {
+		t.Fatal(n, err)
+	}
+	stats, err := s.Stats("q")
+	if err != nil || stats.Ready != total || stats.Dead != 0 {
+		t.Fatalf("unexpected stats %+v %v", stats, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if d := p.delay(attempts); d != want {
+			t.Errorf("attempts %d: want %s, got %s", attempts, want, d)
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	db, s := openTest(t, dir)
+	first := enqueue(t, s, `1`, EnqueueOptions{})
+	if j, _ := s.Dequeue("q", 50*time.Millisecond, RetryPolicy{Backoff: time.Millisecond}); j == nil {
+		t.Fatal("expect job")
+	}
+	_ = s.Close()
+	_ = db.Close()
+
+	time.Sleep(60 * time.Millisecond)
+	_, s = openTest(t, dir)
+	second := enqueue(t, s, `2`, EnqueueOptions{})
+	if second.ID <= first.ID {
+		t.Fatalf("job id should increase after reopen:%s %s", first.ID, second.ID)
+	}
+	policy := RetryPolicy{Backoff: time.Millisecond}
+	if j, _ := s.Dequeue("q", time.Minute, policy); j == nil || j.ID != second.ID {
+		t.Fatalf("expect new job while expired one backs off:%+v", j)
+	}
+	time.Sleep(5 * time.Millisecond)
+	j, _ := s.Dequeue("q", time.Minute, policy)
+	if j == nil || j.ID != first.ID || j.Attempts != 2 || j.LastError != "visibility timeout" {
+		t.Fatalf("inflight job should be redelivered after restart:%+v", j)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	_, s := openTest(t, "")
+	var (
+		mu   sync.Mutex
+		done = map[string]int{}
+	)
+	w := s.Work("q", WorkerOptions{Concurrency: 4, PollInterval: 10 * time.Millisecond, RetryPolicy: RetryPolicy{Backoff: time.Millisecond}}, func(job *Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		done[string(job.Payload)]++
+		if string(job.Payload) == `"flaky"` && job.Attempts < 2 {
+			return errors.New("try again")
+		}
+		if string(job.Payload) == `"panic"` {
+			panic("bad job")
+		}
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		enqueue(t, s, `"ok"`, EnqueueOptions{})
+	}
+	enqueue(t, s, `"flaky"`, EnqueueOptions{})
+	enqueue(t, s, `"panic"`, EnqueueOptions{MaxAttempts: 1})
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		st, _ := s.Stats("q")
+		if st == (Stats{Dead: 1}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout, stats %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if done[`"ok"`] != 20 || done[`"flaky"`] != 2 || done[`"panic"`] != 1 {
+		t.Fatalf("unexpected executions %v", done)
+	}
+}
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"lightbox/metrics"
+	"sync"
+	"time"
+)
+
+var queueJobs = metrics.NewCounter("lightbox_queue_jobs_total", "Total number of processed queue jobs.", "queue", "status")
+
+// Handler 处理任务，返回错误时按重试策略重试
+type Handler func(job *Job) error
+
+// WorkerOptions 工作协程选项
+type WorkerOptions struct {
+	//Concurrency 并发处理的任务数
+	Concurrency int
+	//Visibility 任务在该时间内未确认时重新投递
+	Visibility time.Duration
+	//PollInterval 队列为空时检查延迟任务的间隔
+	PollInterval time.Duration
+	RetryPolicy
+}
+
+func (o WorkerOptions) withDefaults() WorkerOptions {
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	if o.Visibility <= 0 {
+		o.Visibility = 5 * time.Minute
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	return o
+}
+
+// Worker 从队列中取出任务并执行
+type Worker struct {
+	store   *Store
+	queue   string
+	opt     WorkerOptions
+	handler Handler
+	stop    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// Work 启动工作协程
+func (s *Store) Work(queue string, opt WorkerOptions, handler Handler) *Worker {
+	w := &Worker{store: s, queue: queue, opt: opt.withDefaults(), handler: handler, stop: make(chan struct{})}
+	for i := 0; i < w.opt.Concurrency; i++ {
+		w.wg.Add(1)
+		go w.loop()
+	}
+	return w
+}
+
+// Stop 停止取任务并等待正在执行的任务完成
+func (w *Worker) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	w.wg.Wait()
+}
+
+func (w *Worker) Stopped() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *Worker) loop() {
+	defer w.wg.Done()
+	wake := w.store.waker(w.queue)
+	for !w.Stopped() {
+		job, err := w.store.Dequeue(w.queue, w.opt.Visibility, w.opt.RetryPolicy)
+		if err != nil {
+			log.Errorf("dequeue %s error:%s", w.queue, err)
+		}
+		if job == nil {
+			select {
+			case <-w.stop:
+			case <-wake:
+			case <-time.After(w.opt.PollInterval):
+			}
+			continue
+		}
+		w.process(job)
+	}
+}
+
+func (w *Worker) process(job *Job) {
+	err := w.handle(job)
+	if err == nil {
+		if err = w.store.Ack(w.queue, job.ID, job.Attempts); err != nil && err != ErrStale {
+			log.Errorf("ack job %s/%s error:%s", w.queue, job.ID, err)
+		}
+		queueJobs.With(w.queue, "ok").Inc()
+		return
+	}
+	state, ferr := w.store.Fail(w.queue, job.ID, job.Attempts, err.Error(), w.opt.RetryPolicy)
+	if ferr != nil {
+		if ferr != ErrStale {
+			log.Errorf("fail job %s/%s error:%s", w.queue, job.ID, ferr)
+		}
+		return
+	}
+	if state == StateDead {
+		log.Errorf("job %s/%s dead after %d attempts:%s", w.queue, job.ID, job.Attempts, err)
+		queueJobs.With(w.queue, "dead").Inc()
+	} else {
+		log.Warnf("job %s/%s attempt %d failed:%s", w.queue, job.ID, job.Attempts, err)
+		queueJobs.With(w.queue, "retry").Inc()
+	}
+}
+
+func (w *Worker) handle(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return w.handler(job)
+}
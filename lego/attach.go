@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"lightbox/vm"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// attach 连接到运行中applet的远程REPL
+// lego attach <addr> <sandbox>
+// addr: unix:/path/to/repl.sock 或 [ws|wss|http|https]://host:port[/prefix/],websocket地址为{prefix}/{sandbox}/repl
+func attach(args []string) int {
+	if len(args) < 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: lego [-token token] attach <addr> <sandbox>")
+		return 2
+	}
+	addr := args[0]
+	if strings.HasPrefix(addr, "unix:") {
+		return attachSocket(strings.TrimPrefix(addr, "unix:"))
+	}
+	if len(args) < 2 {
+		_, _ = fmt.Fprintln(os.Stderr, "require sandbox name")
+		return 2
+	}
+	return attachWebsocket(addr, args[1])
+}
+
+func attachSocket(socket string) int {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "connect repl error:", err)
+		return 1
+	}
+	defer conn.Close()
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+		if c, ok := conn.(*net.UnixConn); ok {
+			_ = c.CloseWrite()
+		}
+	}()
+	_, _ = io.Copy(os.Stdout, conn)
+	fmt.Println()
+	return 0
+}
+
+func replURL(addr, sandbox string) (string, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "ws://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + url.PathEscape(sandbox) + "/repl"
+	return u.String(), nil
+}
+
+func attachWebsocket(addr, sandbox string) int {
+	target, err := replURL(addr, sandbox)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "invalid address:", err)
+		return 2
+	}
+	header := http.Header{}
+	if accessToken == "" {
+		accessToken = os.Getenv("LEGO_TOKEN")
+	}
+	if accessToken != "" {
+		header.Set("Authorization", "Bearer "+accessToken)
+	}
+	ws, resp, err := websocket.DefaultDialer.Dial(target, header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%s(%s)", err, resp.Status)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, "connect repl error:", err)
+		return 1
+	}
+	defer ws.Close()
+	fmt.Printf("attached to %s (%s)\n", sandbox, target)
+	stdin := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print(replPrompt)
+		if !stdin.Scan() {
+			fmt.Println()
+			return 0
+		}
+		if strings.TrimSpace(stdin.Text()) == "" {
+			continue
+		}
+		if err = ws.WriteMessage(websocket.TextMessage, stdin.Bytes()); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "send error:", err)
+			return 1
+		}
+		result := &vm.REPLResult{}
+		if err = ws.ReadJSON(result); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "receive error:", err)
+			return 1
+		}
+		fmt.Print(result.Output)
+		if result.Error != "" {
+			fmt.Println(result.Error)
+		}
+	}
+}
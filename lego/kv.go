@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"io"
+	"lightbox/kvstore"
+	"os"
+)
+
+const kvUsage = `usage:
+	lego kv backup  -db <dir> -out <backup dir> [-incremental] [-keep N] [-max_age 720h]
+	lego kv restore -db <new dir> <backup dir|backup file>
+	lego kv dump    -db <dir> [-prefix prefix] [-o file]
+	lego kv load    -db <dir> [file]`
+
+// kv 离线维护badger存储(备份/恢复/JSON lines导入导出)，数据库不能被运行中的lego占用
+func kv(args []string) int {
+	if len(args) < 1 {
+		_, _ = fmt.Fprintln(os.Stderr, kvUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("kv "+args[0], flag.ContinueOnError)
+	dir := fs.String("db", "", "badger directory")
+	out := fs.String("out", "", "backup directory")
+	incremental := fs.Bool("incremental", false, "incremental backup since last backup in -out")
+	keep := fs.Int("keep", 0, "keep latest N backup chains(0: unlimited)")
+	maxAge := fs.Duration("max_age", 0, "remove backup chains older than max_age(0: unlimited)")
+	prefix := fs.String("prefix", "", "dump keys with prefix")
+	output := fs.String("o", "", "dump output file(default stdout)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *dir == "" {
+		_, _ = fmt.Fprintln(os.Stderr, "require -db")
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "backup":
+		if *out == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "require -out")
+			return 2
+		}
+		err = withKV(*dir, func(db *badger.DB) error {
+			bf, err := kvstore.Backup(db, *out, kvstore.BackupOptions{Incremental: *incremental, Keep: *keep, MaxAge: *maxAge})
+			if err == nil {
+				fmt.Printf("%s full=%v version=%d size=%d\n", bf.File, bf.Full, bf.Version, bf.Size)
+			}
+			return err
+		})
+	case "restore":
+		if fs.NArg() != 1 {
+			_, _ = fmt.Fprintln(os.Stderr, kvUsage)
+			return 2
+		}
+		var db *badger.DB
+		if db, err = kvstore.RestoreTo("kv", *dir, fs.Arg(0)); err == nil {
+			err = db.Close()
+		}
+	case "dump":
+		err = withKV(*dir, func(db *badger.DB) error {
+			var w io.Writer = os.Stdout
+			if *output != "" {
+				f, err := os.Create(*output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			n, err := kvstore.Dump(db, w, []byte(*prefix))
+			_, _ = fmt.Fprintf(os.Stderr, "%d keys dumped\n", n)
+			return err
+		})
+	case "load":
+		err = withKV(*dir, func(db *badger.DB) error {
+			var r io.Reader = os.Stdin
+			if fs.NArg() > 0 && fs.Arg(0) != "-" {
+				f, err := os.Open(fs.Arg(0))
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			n, err := kvstore.LoadDump(db, r)
+			_, _ = fmt.Fprintf(os.Stderr, "%d keys loaded\n", n)
+			return err
+		})
+	default:
+		_, _ = fmt.Fprintln(os.Stderr, kvUsage)
+		return 2
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func withKV(dir string, fn func(db *badger.DB) error) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	db, err := badger.Open(kvstore.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
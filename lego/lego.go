@@ -3,6 +3,8 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/d5/tengo/v2"
@@ -18,7 +20,10 @@ import (
 	"lightbox/ext/transpile"
 	"lightbox/ext/vfs"
 	"lightbox/kvstore"
+	"lightbox/loghub"
 	"lightbox/sandbox"
+	"lightbox/tracing"
+	"lightbox/vm"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -73,6 +78,9 @@ var (
 	envs = environMap{}
 	app  *sandbox.Applet
 	eval bool
+	//子进程模式(由vm.VirtualHost以进程隔离方式启动)
+	childSocket string
+	childName   string
 )
 
 func init() {
@@ -99,6 +107,8 @@ func init() {
 	flag.StringVar(&logFormat, "log_format", "text", "log format,default text")
 	flag.BoolVar(&trans, "t", false, "transpile source file")
 	flag.BoolVar(&eval, "e", false, "eval input string")
+	flag.StringVar(&childSocket, "child_socket", "", "run as child applet and serve on the unix socket")
+	flag.StringVar(&childName, "child_name", "DEFAULT", "child applet name")
 }
 
 func cleanup() {
@@ -106,6 +116,11 @@ func cleanup() {
 	if app != nil {
 		app.Shutdown("sys exit")
 	}
+	if store := loghub.DefaultStore(); store != nil {
+		_ = store.Close()
+	}
+	_ = loghub.CloseSinks()
+	_ = tracing.Shutdown()
 	kvstore.Shutdown()
 }
 func startup() {
@@ -146,7 +161,15 @@ func startup() {
 		logFormatter = &log.TextFormatter{}
 	}
 	log.SetFormatter(logFormatter)
+	enableAuth()
 	enableLogger()
+	enableLogStore()
+	enableLogSinks()
+	enableTracing()
+	enableHealth()
+	enableMetrics()
+	enableConsole()
+	enableREPL()
 	//end
 	if profileName != "" {
 		env.Set(env.Profile, profileName)
@@ -200,6 +223,18 @@ private library : %s
 }
 
 func main() {
+	flag.Parse()
+	//同名的脚本文件优先于子命令
+	if _, err := os.Stat(flag.Arg(0)); err != nil {
+		switch flag.Arg(0) {
+		case "attach":
+			os.Exit(attach(flag.Args()[1:]))
+		case "logs":
+			os.Exit(logs(flag.Args()[1:]))
+		case "kv":
+			os.Exit(kv(flag.Args()[1:]))
+		}
+	}
 	startup()
 	defer cleanup()
 	if showHelp {
@@ -231,7 +266,7 @@ func main() {
 	}
 
 	d, _ := filepath.Abs(".")
-	app, err = sandbox.NewWithDir("DEFAULT", d)
+	app, err = sandbox.NewWithDir(childName, d)
 	//继承自系统的环境变量
 	for k, v := range env.All() {
 		app.Context.Set(k, v)
@@ -242,15 +277,24 @@ func main() {
 	}
 	modules = getAllModules(app)
 	log.Info("module initialized")
+	if childSocket != "" {
+		if err = vm.ServeChild(context.Background(), app, childSocket); err != nil {
+			log.Error("serve child applet error:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if showMod {
 		showModule(app, modules)
 		os.Exit(0)
 	}
 	enableProf()
+	attachConsole(app)
+	serveREPLSocket(app)
 
 	if inputFile == "" {
 		// REPL
-		RunREPL(app, modules, os.Stdin, os.Stdout)
+		RunREPL(app, os.Stdin, os.Stdout)
 		return
 	}
 	//transpile file from source files
@@ -311,6 +355,8 @@ func main() {
 	if filepath.Ext(inputFile) == sourceFileExt {
 		//运行脚本
 		err := CompileAndRun(app, modules, inputData, inputFile)
+		//os.Exit不会执行defer,脚本结束后停止applet(写入指标快照、导出span等)
+		cleanup()
 		if err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
@@ -414,35 +460,11 @@ func RunCompiled(modules tengo.ModuleGetter, data []byte) (err error) {
 	return
 }
 
-// RunREPL starts REPL.
-func RunREPL(app *sandbox.Applet, modules tengo.ModuleGetter, in io.Reader, out io.Writer) {
+// RunREPL starts REPL with the modules of the applet.
+func RunREPL(app *sandbox.Applet, in io.Reader, out io.Writer) {
 	log.Info("run REPL mode")
-	app.Initialize()
 	stdin := bufio.NewScanner(in)
-	fileSet := parser.NewFileSet()
-	symbolTable, globals := preCompile()
-
-	// embed println function
-	symbol := symbolTable.Define("__repl_println__")
-	globals[symbol.Index] = &tengo.UserFunction{
-		Name: "println",
-		Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
-			var printArgs []interface{}
-			for _, arg := range args {
-				if _, isUndefined := arg.(*tengo.Undefined); isUndefined {
-					printArgs = append(printArgs, "<undefined>")
-				} else {
-					s, _ := tengo.ToString(arg)
-					printArgs = append(printArgs, s)
-				}
-			}
-			printArgs = append(printArgs, "\n")
-			_, _ = fmt.Print(printArgs...)
-			return
-		},
-	}
-
-	var constants []tengo.Object
+	repl := sandbox.NewREPL(app, out)
 	for {
 		if !eval {
 			_, _ = fmt.Fprint(out, replPrompt)
@@ -451,41 +473,14 @@ func RunREPL(app *sandbox.Applet, modules tengo.ModuleGetter, in io.Reader, out
 		if !scanned {
 			return
 		}
-
-		line := stdin.Text()
-
-		tcode, err := app.Transpile([]byte(line))
-		if bytes.Compare(tcode, []byte(line)) != 0 {
-			log.Info("transpile:", string(tcode))
-		}
-		if err != nil {
-			_, _ = fmt.Fprintln(out, err.Error())
-			continue
-		}
-		srcFile := fileSet.AddFile("repl", -1, len(tcode))
-		p := parser.NewParser(srcFile, tcode, nil)
-		file, err := p.ParseFile()
-		if err != nil {
-			_, _ = fmt.Fprintln(out, err.Error())
-			continue
-		}
-
-		file = addPrints(file)
-		c := tengo.NewCompiler(srcFile, symbolTable, constants, modules, nil)
-		if err := c.Compile(file); err != nil {
-			_, _ = fmt.Fprintln(out, err.Error())
-			continue
-		}
-
-		bytecode := c.Bytecode()
-		machine := tengo.NewVM(bytecode, globals, -1)
-		if err := machine.Run(); err != nil {
-			if !eval {
-				_, _ = fmt.Fprintln(out, err.Error())
+		if err := repl.Eval(context.Background(), stdin.Bytes()); err != nil {
+			//-e模式不输出执行时的错误
+			var runtimeErr *sandbox.RuntimeError
+			if eval && errors.As(err, &runtimeErr) {
+				continue
 			}
-			continue
+			_, _ = fmt.Fprintln(out, err.Error())
 		}
-		constants = bytecode.Constants
 	}
 }
 
@@ -535,39 +530,15 @@ Examples:
 	lego -o myapp myapp.tengo
 		Compile source file (myapp.tengo) into bytecode file (myapp)
 	lego myapp
-		RunFile bytecode file (myapp)`)
-}
-
-func addPrints(file *parser.File) *parser.File {
-	var stmts []parser.Stmt
-	for _, s := range file.Stmts {
-		switch s := s.(type) {
-		case *parser.ExprStmt:
-			stmts = append(stmts, &parser.ExprStmt{
-				Expr: &parser.CallExpr{
-					Func: &parser.Ident{Name: "__repl_println__"},
-					Args: []parser.Expr{s.Expr},
-				},
-			})
-		case *parser.AssignStmt:
-			stmts = append(stmts, s)
-
-			stmts = append(stmts, &parser.ExprStmt{
-				Expr: &parser.CallExpr{
-					Func: &parser.Ident{
-						Name: "__repl_println__",
-					},
-					Args: s.LHS,
-				},
-			})
-		default:
-			stmts = append(stmts, s)
-		}
-	}
-	return &parser.File{
-		InputFile: file.InputFile,
-		Stmts:     stmts,
-	}
+		RunFile bytecode file (myapp)
+	lego -token {token} attach localhost:8018 DEFAULT
+		Attach to remote REPL of a running applet (lego -repl -auth_config auth.yml)
+	lego attach unix:/tmp/lego.sock
+		Attach to local REPL (lego -repl_socket /tmp/lego.sock)
+	lego logs -f -sandbox DEFAULT -level warn
+		Follow logs of a running lego (lego -log_tail), without -f query stored logs (lego -log_store)
+	lego kv backup -db cron_storage -out backup/cron -incremental -keep 7
+		Backup a badger store(also: restore/dump/load), the store must not be opened by a running lego`)
 }
 
 func basename(s string) string {
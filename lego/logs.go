@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// logs 查询或跟踪运行中lego的日志
+// lego logs [-f] [-addr localhost:8018] [-sandbox DEFAULT] [-level warn] [-filter expr] [-since 1h]
+// 不带-f时查询已存储的日志(-log_store),带-f时通过NDJSON流持续输出新日志(-log_tail)
+func logs(args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := fs.Bool("f", false, "follow new logs")
+	addr := fs.String("addr", "localhost"+profAddr, "lego http server address")
+	sandboxName := fs.String("sandbox", "DEFAULT", "sandbox name")
+	level := fs.String("level", "", "minimum log level(trace/debug/info/warn/error)")
+	filter := fs.String("filter", "", `filter expression, e.g. log.user=="bob"`)
+	since := fs.String("since", "", "show stored logs since(duration or RFC3339,default 1h without -f)")
+	limit := fs.Int("limit", 100, "maximum number of stored logs")
+	token := fs.String("token", accessToken, "access token(default: $LEGO_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *token == "" {
+		*token = os.Getenv("LEGO_TOKEN")
+	}
+	values := url.Values{}
+	if *level != "" {
+		levels, err := levelsFrom(*level)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		values.Set("level", strings.Join(levels, ","))
+	}
+	if *filter != "" {
+		values.Set("filter", *filter)
+	}
+	base := *addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	base = strings.TrimSuffix(base, "/") + "/" + url.PathEscape(*sandboxName)
+	if !*follow || *since != "" {
+		query := url.Values{}
+		for k, v := range values {
+			query[k] = v
+		}
+		query.Set("since", *since)
+		if *since == "" {
+			query.Set("since", "1h")
+		}
+		query.Set("limit", fmt.Sprint(*limit))
+		if err := printStoredLogs(base+"/logs?"+query.Encode(), *token); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			if !*follow {
+				return 1
+			}
+		}
+	}
+	if !*follow {
+		return 0
+	}
+	if err := followLogs(base+"/log/stream?"+values.Encode(), *token); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// levelsFrom 返回不低于指定级别的所有级别
+func levelsFrom(name string) ([]string, error) {
+	threshold, err := logrus.ParseLevel(name)
+	if err != nil {
+		return nil, err
+	}
+	var levels []string
+	for _, lv := range logrus.AllLevels {
+		if lv <= threshold {
+			levels = append(levels, lv.String())
+		}
+	}
+	return levels, nil
+}
+
+func getLogs(target, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func printStoredLogs(target, token string) error {
+	resp, err := getLogs(target, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var entries []struct {
+		Level   string                 `json:"level"`
+		Time    string                 `json:"time"`
+		Message string                 `json:"message"`
+		Fields  map[string]interface{} `json:"fields"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		m := map[string]interface{}{"level": e.Level, "time": e.Time, "message": e.Message}
+		for k, v := range e.Fields {
+			m[k] = v
+		}
+		printLog(m)
+	}
+	return nil
+}
+
+func followLogs(target, token string) error {
+	resp, err := getLogs(target, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			//keep alive
+			continue
+		}
+		m := map[string]interface{}{}
+		if err = json.Unmarshal(line, &m); err != nil {
+			continue
+		}
+		if e, ok := m["error"]; ok && m["message"] == nil {
+			_, _ = fmt.Fprintln(os.Stderr, "filter error:", e)
+			continue
+		}
+		printLog(m)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// printLog 输出: 时间 级别 消息 字段(按名称排序)
+func printLog(m map[string]interface{}) {
+	var fields []string
+	for k, v := range m {
+		switch k {
+		case "time", "level", "message", "sandbox":
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(fields)
+	fmt.Printf("%v %-7s %v", m["time"], strings.ToUpper(fmt.Sprint(m["level"])), m["message"])
+	if len(fields) > 0 {
+		fmt.Print(" ", strings.Join(fields, " "))
+	}
+	fmt.Println()
+}
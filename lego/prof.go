@@ -1,59 +1,292 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pingcap/log"
 	"github.com/sirupsen/logrus"
+	"lightbox/auth"
 	"lightbox/loghub"
+	"lightbox/metrics"
+	"lightbox/sandbox"
+	"lightbox/tracing"
+	"lightbox/vm"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"os"
+	"time"
 )
 
 var (
-	prof         = false
-	profAddr     = ":8018"
-	logSubscribe = false
-	enableHttp   = false
+	prof          = false
+	profAddr      = ":8018"
+	logSubscribe  = false
+	healthCheck   = false
+	exportMetrics = false
+	console       = false
+	replEnabled   = false
+	replSocket    = ""
+	accessToken   = ""
+	logStore      = ""
+	logRetention  = 7 * 24 * time.Hour
+	logStoreSize  = 1024
+	logSinks      = ""
+	enableHttp    = false
+	authConfig    = ""
+	httpCert      = ""
+	httpKey       = ""
+	traceFile     = ""
+	traceEndpoint = ""
+	traceService  = "lego"
 
-	router *mux.Router = mux.NewRouter()
+	router    *mux.Router = mux.NewRouter()
+	guard     *auth.Guard
+	tlsConfig *tls.Config
+	//host web控制台及管理API使用的VirtualHost
+	host *vm.VirtualHost
 )
 
 func init() {
 	flag.BoolVar(&prof, "prof", false, "enable prof trace")
 	flag.StringVar(&profAddr, "http_addr", ":8018", "http server(log/profile).... port")
 	flag.BoolVar(&logSubscribe, "log_tail", false, "enable log subscribe")
+	flag.StringVar(&logStore, "log_store", "", "persistent log store directory(enable log query api /logs,/{sandbox}/logs)")
+	flag.DurationVar(&logRetention, "log_retention", 7*24*time.Hour, "maximum age of stored logs(0: not remove old logs)")
+	flag.IntVar(&logStoreSize, "log_store_size", 1024, "maximum size of log store(MB,0: unlimited)")
+	flag.StringVar(&logSinks, "log_sinks", "", "log shipping sinks config file(yml, syslog/http/file), status api /log/sinks")
+	flag.BoolVar(&healthCheck, "health", false, "enable health check(/health/live,/health/ready)")
+	flag.BoolVar(&exportMetrics, "metrics", false, "enable prometheus metrics(/metrics)")
+	flag.BoolVar(&console, "console", false, "enable web console(/ui/) and admin api, require -auth_config")
+	flag.BoolVar(&replEnabled, "repl", false, "enable remote repl over websocket(/{sandbox}/repl), require -auth_config")
+	flag.StringVar(&replSocket, "repl_socket", "", "serve repl on the unix socket(only current user can access)")
+	flag.StringVar(&accessToken, "token", "", "access token for attach(default: $LEGO_TOKEN)")
+	flag.StringVar(&authConfig, "auth_config", "", "http server authentication config file(yml), without it pprof/log/metrics api only accept local requests")
+	flag.StringVar(&httpCert, "http_cert", "", "http server certificate file(enable https)")
+	flag.StringVar(&httpKey, "http_key", "", "http server private key file")
+	flag.StringVar(&traceFile, "trace_file", "", "export trace spans to file(OTLP/JSON, one request per line)")
+	flag.StringVar(&traceEndpoint, "trace_endpoint", "", "export trace spans to OTLP/HTTP collector(e.g. http://localhost:4318)")
+	flag.StringVar(&traceService, "trace_service", "lego", "trace service name")
+}
+
+// enableAuth 加载认证配置,未配置时http服务不做认证
+func enableAuth() {
+	if authConfig == "" {
+		return
+	}
+	cfg, err := auth.LoadConfig(authConfig)
+	if err == nil {
+		guard, err = cfg.Guard()
+	}
+	if err == nil {
+		tlsConfig, err = cfg.TLSConfig()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "load auth config error:", err)
+		os.Exit(1)
+	}
+	loghub.SetAllowedOrigins(cfg.AllowedOrigins...)
+}
+
+// protect 启用认证时，要求调用者拥有指定角色；未启用认证时只允许本机访问
+func protect(role auth.Role, action string, h http.Handler) http.Handler {
+	if guard == nil {
+		return loopbackOnly(h)
+	}
+	return guard.Protect(role, action, h)
+}
+
+// loopbackOnly 拒绝非本机的请求
+func loopbackOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "remote access require -auth_config", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
 }
 
 func enableProf() {
 	if prof {
 		log.Debug("profile enabled")
 		enableHttp = true
-		router.HandleFunc("/debug/pprof/", pprof.Index)
-		router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-		router.HandleFunc("/debug/pprof/profile", pprof.Profile)
-		router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-		router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		router.Handle("/debug/pprof/", protect(auth.RoleAdmin, "pprof", http.HandlerFunc(pprof.Index)))
+		router.Handle("/debug/pprof/cmdline", protect(auth.RoleAdmin, "pprof", http.HandlerFunc(pprof.Cmdline)))
+		router.Handle("/debug/pprof/profile", protect(auth.RoleAdmin, "pprof", http.HandlerFunc(pprof.Profile)))
+		router.Handle("/debug/pprof/symbol", protect(auth.RoleAdmin, "pprof", http.HandlerFunc(pprof.Symbol)))
+		router.Handle("/debug/pprof/trace", protect(auth.RoleAdmin, "pprof", http.HandlerFunc(pprof.Trace)))
 	}
 }
 func enableLogger() {
 	if logSubscribe {
 		enableHttp = true
 		subscriber := loghub.NewLogSubscriber()
-		router.HandleFunc("/{sandbox}/log", loghub.NewWebsocketSubscribeHandler(subscriber))
+		router.Handle("/{sandbox}/log", protect(auth.RoleViewer, "log:tail", loghub.NewWebsocketSubscribeHandler(subscriber)))
+		router.Handle("/{sandbox}/log/sse", protect(auth.RoleViewer, "log:tail", loghub.NewSSESubscribeHandler(subscriber)))
+		router.Handle("/{sandbox}/log/stream", protect(auth.RoleViewer, "log:tail", loghub.NewNDJSONSubscribeHandler(subscriber)))
+		router.Handle("/log/subscribers", protect(auth.RoleViewer, "log:tail", loghub.NewSubscriberStatsHandler(subscriber)))
+	}
+
+}
+
+// enableLogStore 日志持久化存储及查询接口
+func enableLogStore() {
+	if logStore == "" {
+		return
+	}
+	store, err := loghub.OpenStore(logStore, loghub.StoreOption{
+		MaxAge:  logRetention,
+		MaxSize: int64(logStoreSize) << 20,
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "open log store error:", err)
+		os.Exit(1)
+	}
+	logrus.AddHook(store)
+	loghub.SetDefaultStore(store)
+	enableHttp = true
+	handler := loghub.NewQueryHandler(loghub.DefaultStore)
+	router.Handle("/logs", protect(auth.RoleViewer, "log:query", handler))
+	router.Handle("/{sandbox}/logs", protect(auth.RoleViewer, "log:query", handler))
+}
+
+// enableLogSinks 日志转发(syslog/http/file)
+func enableLogSinks() {
+	if logSinks == "" {
+		return
+	}
+	if _, err := loghub.LoadSinks(logrus.StandardLogger(), logSinks); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "load log sinks error:", err)
+		os.Exit(1)
 	}
+	enableHttp = true
+	router.Handle("/log/sinks", protect(auth.RoleViewer, "log:sinks", loghub.NewSinkStatusHandler()))
+}
 
+// enableTracing 启用追踪(http请求、sql、redis、amqp)
+func enableTracing() {
+	if traceFile == "" && traceEndpoint == "" {
+		return
+	}
+	if err := tracing.Setup(tracing.Config{Service: traceService, File: traceFile, Endpoint: traceEndpoint}); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "enable tracing error:", err)
+		os.Exit(1)
+	}
 }
+
+// enableHealth 健康检查接口(无需认证),/health/* 为所有applet的汇总(lego只运行一个applet)
+func enableHealth() {
+	if !healthCheck {
+		return
+	}
+	enableHttp = true
+	for path, kind := range map[string]sandbox.HealthKind{"/live": sandbox.HealthLive, "/ready": sandbox.HealthReady} {
+		kind := kind
+		handler := vm.HealthHandler(func(r *http.Request) (sandbox.HealthReport, bool) {
+			if app == nil {
+				return sandbox.HealthReport{Name: childName, Status: sandbox.HealthDown}, true
+			}
+			if name, ok := mux.Vars(r)["sandbox"]; ok && name != app.Name {
+				return sandbox.HealthReport{}, false
+			}
+			return app.CheckHealth(r.Context(), kind), true
+		})
+		router.Handle("/health"+path, handler)
+		router.Handle("/{sandbox}/health"+path, handler)
+	}
+}
+
+// enableMetrics Prometheus指标(脚本执行、http请求、数据库连接池、定时任务、badger)
+func enableMetrics() {
+	if !exportMetrics {
+		return
+	}
+	enableHttp = true
+	router.Handle("/metrics", protect(auth.RoleViewer, "metrics", metrics.Handler()))
+}
+
+// enableConsole web控制台及管理API(必须启用认证)
+func enableConsole() {
+	if !console {
+		return
+	}
+	if guard == nil {
+		_, _ = fmt.Fprintln(os.Stderr, "web console require -auth_config")
+		os.Exit(1)
+	}
+	enableHttp = true
+	host = new(vm.VirtualHost)
+	vm.SetVirtualHost(host)
+	vm.SetSubscriber(loghub.NewLogSubscriber())
+	if err := vm.RegisterAPI(router, guard); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	vm.RegisterConsole(router, "/ui")
+}
+
+// enableREPL 远程REPL(websocket需要认证,本地socket由文件权限控制)
+func enableREPL() {
+	if !replEnabled {
+		return
+	}
+	if guard == nil {
+		_, _ = fmt.Fprintln(os.Stderr, "remote repl require -auth_config")
+		os.Exit(1)
+	}
+	enableHttp = true
+	router.Handle("/{sandbox}/repl", guard.Protect(auth.RoleAdmin, "repl", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app == nil || mux.Vars(r)["sandbox"] != app.Name {
+			http.NotFound(w, r)
+			return
+		}
+		vm.NewREPLHandler(app).ServeHTTP(w, r)
+	})))
+}
+
+// serveREPLSocket 在本地socket上提供REPL
+func serveREPLSocket(app *sandbox.Applet) {
+	if replSocket == "" {
+		return
+	}
+	go func() {
+		if err := vm.ServeREPL(context.Background(), app, replSocket); err != nil {
+			logrus.Error("serve repl socket error:", err)
+		}
+	}()
+}
+
+// attachConsole 将当前applet注册到控制台
+func attachConsole(app *sandbox.Applet) {
+	if host == nil {
+		return
+	}
+	if _, err := host.Attach(app); err != nil {
+		logrus.Error("attach applet to console error:", err)
+	}
+}
+
 func startHttpServer() {
 	if !enableHttp {
 		return
 	}
 	go func() {
 		server := &http.Server{
-			Addr:    profAddr,
-			Handler: router,
+			Addr:      profAddr,
+			Handler:   router,
+			TLSConfig: tlsConfig,
+		}
+		var err error
+		if httpCert != "" && httpKey != "" {
+			err = server.ListenAndServeTLS(httpCert, httpKey)
+		} else {
+			err = server.ListenAndServe()
 		}
-		err := server.ListenAndServe()
 		if err != nil {
 			logrus.Error("http server error", err)
 		}
@@ -0,0 +1,287 @@
+package loghub
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertWindow = time.Minute
+	// maxAlertGroups 每个规则最多统计的分组数,超过时移除最久未活动的分组
+	maxAlertGroups = 1000
+)
+
+// AlertRule 日志告警规则: 窗口期内匹配的日志数量超过阈值时触发
+type AlertRule struct {
+	Name string `json:"name" yaml:"name"`
+	//Filter 与日志订阅相同的tengo过滤表达式,如: log.level=="error" && log.sandbox=="billing"
+	Filter    string        `json:"filter" yaml:"filter"`
+	Levels    []string      `json:"levels" yaml:"levels"`
+	Sandboxes []string      `json:"sandboxes" yaml:"sandboxes"` //为空或包含*时匹配所有applet
+	Window    time.Duration `json:"window" yaml:"window"`       //统计窗口,默认1分钟
+	Threshold int           `json:"threshold" yaml:"threshold"` //窗口内数量超过阈值时触发,默认0(匹配即触发)
+	Cooldown  time.Duration `json:"cooldown" yaml:"cooldown"`   //触发后的静默时间,默认与窗口相同
+	//GroupBy 按字段分组统计及去重,如[sandbox],为空时整个规则一组
+	GroupBy []string      `json:"groupBy" yaml:"groupBy"`
+	Actions []AlertAction `json:"actions" yaml:"actions"`
+}
+
+// AlertAction 告警触发后的动作,Type为script/mail/webhook,具体参数由执行方解释
+type AlertAction struct {
+	Type   string                 `json:"type" yaml:"type"`
+	Params map[string]interface{} `json:"params" yaml:",inline"`
+}
+
+// Alert 触发的告警
+type Alert struct {
+	Rule    string                 `json:"rule"`
+	Group   string                 `json:"group,omitempty"`
+	Count   int                    `json:"count"`
+	Window  string                 `json:"window"`
+	FiredAt time.Time              `json:"firedAt"`
+	Sample  map[string]interface{} `json:"sample"` //最后一条匹配的日志
+}
+
+// AlertRuleStatus 规则的运行状态
+type AlertRuleStatus struct {
+	Name       string     `json:"name"`
+	Matched    uint64     `json:"matched"`
+	Fired      uint64     `json:"fired"`
+	Suppressed uint64     `json:"suppressed"`
+	LastFired  *time.Time `json:"lastFired,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+}
+
+type alertGroup struct {
+	hits      []time.Time
+	lastFired time.Time
+	active    time.Time //最后一次匹配的时间
+}
+
+type alertRule struct {
+	AlertRule
+	setting   *SubscriberSetting
+	sandboxes map[string]bool
+	groups    map[string]*alertGroup
+	status    AlertRuleStatus
+}
+
+// AlertEngine 订阅日志并按规则触发告警,告警在独立的协程中通知,不阻塞日志的处理
+type AlertEngine struct {
+	rules      []*alertRule
+	notify     func(AlertRule, Alert) error
+	subscriber LogSubscriber
+	sub        *Subscription
+	mx         sync.Mutex
+	done       chan struct{}
+	now        func() time.Time
+}
+
+// NewAlertEngine 校验并编译规则,notify执行规则的动作
+func NewAlertEngine(rules []AlertRule, notify func(AlertRule, Alert) error) (*AlertEngine, error) {
+	e := &AlertEngine{notify: notify, now: time.Now}
+	names := map[string]bool{}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %s", r.Name)
+		}
+		names[r.Name] = true
+		if r.Window <= 0 {
+			r.Window = defaultAlertWindow
+		}
+		if r.Cooldown <= 0 {
+			r.Cooldown = r.Window
+		}
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("alert rule %s: require actions", r.Name)
+		}
+		setting := &SubscriberSetting{Filter: r.Filter}
+		for _, l := range r.Levels {
+			lv, err := logrus.ParseLevel(l)
+			if err != nil {
+				return nil, fmt.Errorf("alert rule %s: %s", r.Name, err)
+			}
+			setting.Levels = append(setting.Levels, lv)
+		}
+		if err := setting.update(); err != nil {
+			return nil, fmt.Errorf("alert rule %s: invalid filter:%s", r.Name, err)
+		}
+		rule := &alertRule{AlertRule: r, setting: setting, groups: map[string]*alertGroup{}}
+		rule.status.Name = r.Name
+		if len(r.Sandboxes) > 0 {
+			rule.sandboxes = map[string]bool{}
+			for _, s := range r.Sandboxes {
+				rule.sandboxes[s] = true
+			}
+			if rule.sandboxes["*"] {
+				rule.sandboxes = nil
+			}
+		}
+		e.rules = append(e.rules, rule)
+	}
+	return e, nil
+}
+
+// Start 订阅日志并开始处理
+func (e *AlertEngine) Start(subscriber LogSubscriber) error {
+	if subscriber == nil {
+		return errors.New("require log subscriber")
+	}
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	if e.sub != nil {
+		return errors.New("alert engine already started")
+	}
+	e.subscriber = subscriber
+	e.sub = subscriber.Subscribe("alert", SubscribeOption{Capacity: 4096})
+	e.done = make(chan struct{})
+	go func(sub *Subscription, done chan struct{}) {
+		defer close(done)
+		for entry := range sub.C {
+			e.Process(entry)
+		}
+	}(e.sub, e.done)
+	return nil
+}
+
+// Stop 取消订阅
+func (e *AlertEngine) Stop() {
+	e.mx.Lock()
+	sub, done := e.sub, e.done
+	e.sub = nil
+	e.mx.Unlock()
+	if sub != nil {
+		e.subscriber.Unsubscribe(sub)
+		<-done
+	}
+}
+
+// Process 处理一条日志
+func (e *AlertEngine) Process(entry *logrus.Entry) {
+	var m map[string]interface{}
+	for _, r := range e.rules {
+		if r.sandboxes != nil {
+			name, _ := entry.Data[sandboxName].(string)
+			if !r.sandboxes[name] {
+				continue
+			}
+		}
+		if !r.setting.matchLevel(entry.Level) {
+			continue
+		}
+		if m == nil {
+			m = EntryToMap(entry)
+		}
+		match, err := r.setting.Exec(m)
+		if err != nil {
+			e.mx.Lock()
+			r.status.LastError = err.Error()
+			e.mx.Unlock()
+			continue
+		}
+		if match {
+			e.hit(r, m)
+		}
+	}
+}
+
+func (r *alertRule) groupKey(m map[string]interface{}) string {
+	if len(r.GroupBy) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(r.GroupBy))
+	for _, f := range r.GroupBy {
+		parts = append(parts, fmt.Sprintf("%s=%v", f, m[f]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (e *AlertEngine) hit(r *alertRule, m map[string]interface{}) {
+	now := e.now()
+	key := r.groupKey(m)
+	e.mx.Lock()
+	r.status.Matched++
+	g, ok := r.groups[key]
+	if !ok {
+		if len(r.groups) >= maxAlertGroups {
+			r.prune(now)
+		}
+		g = &alertGroup{}
+		r.groups[key] = g
+	}
+	g.active = now
+	g.hits = append(g.hits, now)
+	//移除窗口之外的记录
+	start := 0
+	for start < len(g.hits) && now.Sub(g.hits[start]) > r.Window {
+		start++
+	}
+	g.hits = g.hits[start:]
+	count := len(g.hits)
+	if count <= r.Threshold {
+		e.mx.Unlock()
+		return
+	}
+	if !g.lastFired.IsZero() && now.Sub(g.lastFired) < r.Cooldown {
+		r.status.Suppressed++
+		e.mx.Unlock()
+		return
+	}
+	g.lastFired = now
+	g.hits = nil
+	r.status.Fired++
+	fired := now
+	r.status.LastFired = &fired
+	e.mx.Unlock()
+
+	alert := Alert{Rule: r.Name, Group: key, Count: count, Window: r.Window.String(), FiredAt: now, Sample: m}
+	go func() {
+		if err := e.notify(r.AlertRule, alert); err != nil {
+			e.mx.Lock()
+			r.status.LastError = err.Error()
+			e.mx.Unlock()
+		}
+	}()
+}
+
+// prune 移除窗口内没有记录且不在静默期的分组,仍然超过上限时移除最久未活动的分组
+func (r *alertRule) prune(now time.Time) {
+	var (
+		oldest string
+		found  bool
+	)
+	for key, g := range r.groups {
+		if now.Sub(g.active) > r.Window && now.Sub(g.lastFired) >= r.Cooldown {
+			delete(r.groups, key)
+			continue
+		}
+		if !found || g.active.Before(r.groups[oldest].active) {
+			oldest, found = key, true
+		}
+	}
+	if found && len(r.groups) >= maxAlertGroups {
+		delete(r.groups, oldest)
+	}
+}
+
+// Status 所有规则的状态
+func (e *AlertEngine) Status() []AlertRuleStatus {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	result := make([]AlertRuleStatus, 0, len(e.rules))
+	for _, r := range e.rules {
+		result = append(result, r.status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
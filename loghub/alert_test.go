@@ -0,0 +1,173 @@
+package loghub
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAlertEngine(t *testing.T) {
+	fired := make(chan Alert, 10)
+	engine, err := NewAlertEngine([]AlertRule{{
+		Name:      "payment",
+		Filter:    `log.message=="payment failed"`,
+		Levels:    []string{"error"},
+		Sandboxes: []string{"billing"},
+		Window:    time.Minute,
+		Threshold: 2,
+		Cooldown:  5 * time.Minute,
+		GroupBy:   []string{"region"},
+		Actions:   []AlertAction{{Type: "webhook"}},
+	}}, func(rule AlertRule, alert Alert) error {
+		fired <- alert
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	engine.now = func() time.Time {
+		return now
+	}
+	emit := func(sandbox, region string, level logrus.Level, msg string) {
+		engine.Process(&logrus.Entry{
+			Time:    now,
+			Level:   level,
+			Message: msg,
+			Data:    logrus.Fields{sandboxName: sandbox, "region": region},
+		})
+	}
+	expectFired := func(count int, group string) {
+		t.Helper()
+		select {
+		case a := <-fired:
+			if a.Count != count || a.Group != group || a.Rule != "payment" {
+				t.Fatalf("unexpected alert %+v", a)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("alert not fired")
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case a := <-fired:
+			t.Fatalf("unexpected alert %+v", a)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	emit("billing", "eu", logrus.WarnLevel, "payment failed")
+	emit("shop", "eu", logrus.ErrorLevel, "payment failed")
+	emit("billing", "eu", logrus.ErrorLevel, "other")
+	expectNone()
+
+	//窗口过期的记录不计数
+	now = now.Add(2 * time.Minute)
+	emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	expectNone()
+	emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	expectFired(3, "region=eu")
+
+	//分组独立计数
+	for i := 0; i < 3; i++ {
+		emit("billing", "us", logrus.ErrorLevel, "payment failed")
+	}
+	expectFired(3, "region=us")
+
+	//静默期内不重复触发
+	for i := 0; i < 3; i++ {
+		emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	}
+	expectNone()
+
+	now = now.Add(5 * time.Minute)
+	for i := 0; i < 3; i++ {
+		emit("billing", "eu", logrus.ErrorLevel, "payment failed")
+	}
+	expectFired(3, "region=eu")
+
+	st := engine.Status()
+	if len(st) != 1 || st[0].Fired != 3 || st[0].Suppressed != 1 || st[0].Matched != 14 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestAlertEngineSubscribe(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	hub := NewWithLevels(logger, logrus.AllLevels...)
+	fired := make(chan Alert, 1)
+	engine, err := NewAlertEngine([]AlertRule{{
+		Filter:  `log.code==500`,
+		Actions: []AlertAction{{Type: "script"}},
+	}}, func(rule AlertRule, alert Alert) error {
+		fired <- alert
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.Start(hub); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Stop()
+	logger.WithField("code", 404).Info("not found")
+	logger.WithField("code", 500).Error("server error")
+	select {
+	case a := <-fired:
+		if a.Rule != "rule1" || a.Sample["message"] != "server error" {
+			t.Fatalf("unexpected alert %+v", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("alert not fired")
+	}
+}
+
+func TestAlertRuleInvalid(t *testing.T) {
+	if _, err := NewAlertEngine([]AlertRule{{Name: "a", Filter: "log.level ==", Actions: []AlertAction{{Type: "script"}}}}, nil); err == nil {
+		t.Fatal("expect filter error")
+	}
+	if _, err := NewAlertEngine([]AlertRule{{Name: "a"}}, nil); err == nil {
+		t.Fatal("expect actions error")
+	}
+}
+
+func TestAlertGroupsBounded(t *testing.T) {
+	engine, err := NewAlertEngine([]AlertRule{{
+		Sandboxes: []string{"*"},
+		Threshold: 10,
+		GroupBy:   []string{"user"},
+		Actions:   []AlertAction{{Type: "script"}},
+	}}, func(rule AlertRule, alert Alert) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	engine.now = func() time.Time {
+		return now
+	}
+	emit := func(user int) {
+		engine.Process(&logrus.Entry{Time: now, Level: logrus.ErrorLevel, Data: logrus.Fields{sandboxName: "any", "user": user}})
+	}
+	r := engine.rules[0]
+	for i := 0; i <= maxAlertGroups; i++ {
+		now = now.Add(time.Microsecond)
+		emit(i)
+	}
+	if len(r.groups) != maxAlertGroups || r.groups["user=0"] != nil {
+		t.Fatalf("expect %d groups without the oldest, got %d", maxAlertGroups, len(r.groups))
+	}
+	//窗口之外的分组被移除
+	now = now.Add(2 * defaultAlertWindow)
+	emit(-1)
+	if len(r.groups) != 1 {
+		t.Fatalf("expect expired groups pruned, got %d", len(r.groups))
+	}
+}
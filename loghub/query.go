@@ -0,0 +1,239 @@
+package loghub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogQuery 日志查询条件,零值表示不限制
+type LogQuery struct {
+	Sandbox string            `json:"sandbox"`
+	Levels  []string          `json:"levels"`
+	Since   time.Time         `json:"since"`
+	Until   time.Time         `json:"until"`
+	Fields  map[string]string `json:"fields"`
+	//Filter 与日志订阅相同的tengo过滤表达式,如: log.level=="error" && log.user=="bob"
+	Filter string `json:"filter"`
+	Limit  int    `json:"limit"`
+	//Desc 从最新的日志开始返回
+	Desc bool `json:"desc"`
+}
+
+func (q *LogQuery) matchLevel(level string) bool {
+	if len(q.Levels) == 0 {
+		return true
+	}
+	for _, l := range q.Levels {
+		if strings.EqualFold(l, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *LogQuery) matchFields(e *StoredEntry) bool {
+	for k, v := range q.Fields {
+		fv, ok := e.Fields[k]
+		if !ok || fmt.Sprint(fv) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func timeKey(t time.Time, fill byte) []byte {
+	key := make([]byte, 13)
+	key[0] = storeKeyPrefix
+	binary.BigEndian.PutUint64(key[1:], uint64(t.UnixNano()))
+	for i := 9; i < len(key); i++ {
+		key[i] = fill
+	}
+	return key
+}
+
+// Query 按条件查询日志,默认按时间顺序返回
+func (s *LogStore) Query(q LogQuery) ([]*StoredEntry, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		q.Limit = MaxQueryLimit
+	}
+	setting := &SubscriberSetting{Filter: q.Filter}
+	if err := setting.update(); err != nil {
+		return nil, fmt.Errorf("invalid filter:%s", err)
+	}
+	result := make([]*StoredEntry, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{
+			Prefix:         []byte{storeKeyPrefix},
+			PrefetchValues: true,
+			PrefetchSize:   q.Limit,
+			Reverse:        q.Desc,
+		})
+		defer it.Close()
+		var start, end []byte
+		if !q.Since.IsZero() {
+			start = timeKey(q.Since, 0)
+		}
+		if !q.Until.IsZero() {
+			end = timeKey(q.Until, 0xff)
+		}
+		switch {
+		case q.Desc && end != nil:
+			it.Seek(end)
+		case q.Desc:
+			//逆序时Rewind会定位到前缀本身,需要从最大的key开始
+			it.Seek(timeKey(time.Unix(0, -1), 0xff))
+		case start != nil:
+			it.Seek(start)
+		default:
+			it.Rewind()
+		}
+		for ; it.Valid() && len(result) < q.Limit; it.Next() {
+			key := it.Item().Key()
+			if q.Desc && start != nil && string(key) < string(start) {
+				break
+			}
+			if !q.Desc && end != nil && string(key) > string(end) {
+				break
+			}
+			e := &StoredEntry{}
+			if err := it.Item().Value(func(val []byte) error {
+				return decodeEntry(val, e)
+			}); err != nil {
+				return err
+			}
+			if q.Sandbox != "" && e.Sandbox != q.Sandbox {
+				continue
+			}
+			if !q.matchLevel(e.Level) || !q.matchFields(e) {
+				continue
+			}
+			if match, err := setting.Exec(e.ToMap()); err != nil {
+				return fmt.Errorf("filter error:%s", err)
+			} else if !match {
+				continue
+			}
+			result = append(result, e)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// decodeEntry 解码日志条目,整数字段保持为整数(与实时订阅时过滤表达式的行为一致)
+func decodeEntry(data []byte, e *StoredEntry) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(e); err != nil {
+		return err
+	}
+	for k, v := range e.Fields {
+		e.Fields[k] = normalizeNumber(v)
+	}
+	return nil
+}
+
+func normalizeNumber(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case json.Number:
+		if i, err := vv.Int64(); err == nil {
+			return i
+		}
+		f, _ := vv.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range vv {
+			vv[k] = normalizeNumber(item)
+		}
+	case []interface{}:
+		for i, item := range vv {
+			vv[i] = normalizeNumber(item)
+		}
+	}
+	return v
+}
+
+// parseTime 解析RFC3339时间或相对当前的时长(如: 30m表示30分钟前)
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ParseQuery 从url参数解析查询条件
+// sandbox,level(多个以逗号分隔),since/until(RFC3339或时长),field(name:value,可多个),filter,limit,order(asc/desc)
+func ParseQuery(values url.Values) (q LogQuery, err error) {
+	q.Sandbox = values.Get(sandboxName)
+	for _, l := range values["level"] {
+		for _, level := range strings.Split(l, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				q.Levels = append(q.Levels, level)
+			}
+		}
+	}
+	if q.Since, err = parseTime(values.Get("since")); err != nil {
+		return q, fmt.Errorf("invalid since:%s", err)
+	}
+	if q.Until, err = parseTime(values.Get("until")); err != nil {
+		return q, fmt.Errorf("invalid until:%s", err)
+	}
+	for _, f := range values["field"] {
+		kv := strings.SplitN(f, ":", 2)
+		if len(kv) != 2 {
+			return q, fmt.Errorf("invalid field %s, require name:value", f)
+		}
+		if q.Fields == nil {
+			q.Fields = map[string]string{}
+		}
+		q.Fields[kv[0]] = kv[1]
+	}
+	q.Filter = values.Get("filter")
+	if limit := values.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, fmt.Errorf("invalid limit:%s", err)
+		}
+	}
+	q.Desc = strings.EqualFold(values.Get("order"), "desc")
+	return q, nil
+}
+
+// NewQueryHandler 日志查询接口,路由中有{sandbox}时只查询该sandbox的日志
+func NewQueryHandler(store func() *LogStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := store()
+		if s == nil {
+			http.Error(w, "log store not enabled", http.StatusNotFound)
+			return
+		}
+		q, err := ParseQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name, ok := mux.Vars(r)[sandboxName]; ok {
+			q.Sandbox = name
+		}
+		entries, err := s.Query(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(entries)
+	}
+}
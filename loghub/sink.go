@@ -0,0 +1,412 @@
+package loghub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SinkSyslog = "syslog"
+	SinkHTTP   = "http"
+	SinkFile   = "file"
+
+	PolicyDrop  = "drop"
+	PolicyBlock = "block"
+
+	defaultSinkBuffer   = 1024
+	defaultSinkBatch    = 100
+	defaultSinkInterval = time.Second
+)
+
+// SinkConfig 日志转发设置
+type SinkConfig struct {
+	Name      string   `json:"name" yaml:"name"`
+	Type      string   `json:"type" yaml:"type"`           //syslog/http/file
+	Levels    []string `json:"levels" yaml:"levels"`       //转发的日志级别,默认全部
+	Sandboxes []string `json:"sandboxes" yaml:"sandboxes"` //转发的sandbox,默认全部
+	//BufferSize 缓冲区大小,Policy为缓冲区满时的处理方式:drop(默认,丢弃)/block(阻塞写日志的调用方)
+	BufferSize    int           `json:"bufferSize" yaml:"bufferSize"`
+	Policy        string        `json:"policy" yaml:"policy"`
+	BatchSize     int           `json:"batchSize" yaml:"batchSize"`
+	FlushInterval time.Duration `json:"flushInterval" yaml:"flushInterval"`
+	//syslog: Network为udp/tcp/unix/unixgram,Address为空时使用本机的syslog
+	Network  string `json:"network" yaml:"network"`
+	Address  string `json:"address" yaml:"address"`
+	Facility int    `json:"facility" yaml:"facility"` //默认1(user)
+	AppName  string `json:"appName" yaml:"appName"`   //默认lego
+	//http: 以NDJSON格式POST到URL,失败时按退避时间重试
+	URL        string            `json:"url" yaml:"url"`
+	Headers    map[string]string `json:"headers" yaml:"headers"`
+	Timeout    time.Duration     `json:"timeout" yaml:"timeout"`
+	MaxRetries int               `json:"maxRetries" yaml:"maxRetries"`
+	MinBackoff time.Duration     `json:"minBackoff" yaml:"minBackoff"`
+	MaxBackoff time.Duration     `json:"maxBackoff" yaml:"maxBackoff"`
+	//file: NDJSON文件,按大小滚动
+	Path       string `json:"path" yaml:"path"`
+	MaxSize    int    `json:"maxSize" yaml:"maxSize"`
+	MaxAge     int    `json:"maxAge" yaml:"maxAge"`
+	MaxBackups int    `json:"maxBackups" yaml:"maxBackups"`
+	Compress   bool   `json:"compress" yaml:"compress"`
+}
+
+// SinkStatus 日志转发的运行状态
+type SinkStatus struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Healthy     bool       `json:"healthy"`
+	Buffered    int        `json:"buffered"`
+	Sent        uint64     `json:"sent"`
+	Dropped     uint64     `json:"dropped"`
+	Failed      uint64     `json:"failed"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	LastSentAt  *time.Time `json:"lastSentAt,omitempty"`
+}
+
+// sinkWriter 批量写入日志
+type sinkWriter interface {
+	write(entries []*logrus.Entry) error
+	close() error
+}
+
+// Sink 以logrus hook的方式批量转发日志
+type Sink struct {
+	cfg       SinkConfig
+	writer    sinkWriter
+	levels    []logrus.Level
+	sandboxes map[string]bool
+	ch        chan *logrus.Entry
+	closed    chan struct{}
+	done      chan struct{}
+	once      sync.Once
+	sent      uint64
+	dropped   uint64
+	failed    uint64
+	mx        sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
+	lastSent  time.Time
+}
+
+// NewSink 创建日志转发
+func NewSink(cfg SinkConfig) (*Sink, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultSinkBuffer
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultSinkBatch
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultSinkInterval
+	}
+	switch cfg.Policy {
+	case "":
+		cfg.Policy = PolicyDrop
+	case PolicyDrop, PolicyBlock:
+	default:
+		return nil, fmt.Errorf("sink %s: unknown policy %s", cfg.Name, cfg.Policy)
+	}
+	s := &Sink{
+		cfg:    cfg,
+		ch:     make(chan *logrus.Entry, cfg.BufferSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+		levels: logrus.AllLevels,
+	}
+	if len(cfg.Levels) > 0 {
+		s.levels = nil
+		for _, l := range cfg.Levels {
+			lv, err := logrus.ParseLevel(l)
+			if err != nil {
+				return nil, fmt.Errorf("sink %s: %s", cfg.Name, err)
+			}
+			s.levels = append(s.levels, lv)
+		}
+	}
+	if len(cfg.Sandboxes) > 0 {
+		s.sandboxes = make(map[string]bool, len(cfg.Sandboxes))
+		for _, name := range cfg.Sandboxes {
+			s.sandboxes[name] = true
+		}
+	}
+	var err error
+	switch cfg.Type {
+	case SinkSyslog:
+		s.writer, err = newSyslogWriter(cfg)
+	case SinkHTTP:
+		s.writer, err = newHTTPWriter(cfg)
+	case SinkFile:
+		s.writer, err = newFileWriter(cfg)
+	default:
+		err = fmt.Errorf("unknown sink type %s", cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %s", cfg.Name, err)
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Sink) Name() string {
+	return s.cfg.Name
+}
+
+func (s *Sink) Levels() []logrus.Level {
+	return s.levels
+}
+
+// Fire 复制日志条目并放入缓冲区
+func (s *Sink) Fire(entry *logrus.Entry) error {
+	if s.sandboxes != nil {
+		name, _ := entry.Data[sandboxName].(string)
+		if !s.sandboxes[name] {
+			return nil
+		}
+	}
+	e := &logrus.Entry{
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Message: entry.Message,
+		Data:    make(logrus.Fields, len(entry.Data)),
+	}
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		e.Data[k] = v
+	}
+	if s.cfg.Policy == PolicyBlock {
+		select {
+		case s.ch <- e:
+		case <-s.closed:
+		}
+		return nil
+	}
+	select {
+	case s.ch <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*logrus.Entry, 0, s.cfg.BatchSize)
+	for {
+		select {
+		case e := <-s.ch:
+			batch = append(batch, e)
+			if len(batch) < s.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-s.closed:
+			s.flushRemaining(batch)
+			return
+		}
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flushRemaining 关闭时按批次发送缓冲区中剩余的日志
+func (s *Sink) flushRemaining(batch []*logrus.Entry) {
+	for {
+		select {
+		case e := <-s.ch:
+			batch = append(batch, e)
+			if len(batch) < s.cfg.BatchSize {
+				continue
+			}
+		default:
+			s.flush(batch)
+			return
+		}
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (s *Sink) flush(batch []*logrus.Entry) {
+	if len(batch) == 0 {
+		return
+	}
+	err := s.writer.write(batch)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err != nil {
+		atomic.AddUint64(&s.failed, uint64(len(batch)))
+		s.lastErr = err
+		s.lastErrAt = time.Now()
+		return
+	}
+	atomic.AddUint64(&s.sent, uint64(len(batch)))
+	s.lastErr = nil
+	s.lastSent = time.Now()
+}
+
+// Status 转发状态,最近一次写入失败时不健康
+func (s *Sink) Status() SinkStatus {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	st := SinkStatus{
+		Name:     s.cfg.Name,
+		Type:     s.cfg.Type,
+		Healthy:  s.lastErr == nil,
+		Buffered: len(s.ch),
+		Sent:     atomic.LoadUint64(&s.sent),
+		Dropped:  atomic.LoadUint64(&s.dropped),
+		Failed:   atomic.LoadUint64(&s.failed),
+	}
+	if s.lastErr != nil {
+		st.LastError = s.lastErr.Error()
+	}
+	if !s.lastErrAt.IsZero() {
+		t := s.lastErrAt
+		st.LastErrorAt = &t
+	}
+	if !s.lastSent.IsZero() {
+		t := s.lastSent
+		st.LastSentAt = &t
+	}
+	return st
+}
+
+// Close 发送缓冲区中的日志后关闭
+func (s *Sink) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	<-s.done
+	return s.writer.close()
+}
+
+var (
+	sinks  = map[string]*Sink{}
+	sinkMx sync.RWMutex
+)
+
+// LoadSinks 从yml文件加载日志转发设置(sinks节点),创建并注册到logger
+func LoadSinks(logger *logrus.Logger, path string) ([]*Sink, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := struct {
+		Sinks []SinkConfig `yaml:"sinks"`
+	}{}
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	var created []*Sink
+	for _, c := range cfg.Sinks {
+		s, err := NewSink(c)
+		if err == nil {
+			if err = RegisterSink(logger, s); err != nil {
+				_ = s.Close()
+			}
+		}
+		if err != nil {
+			//已创建的转发先注销再关闭,修正配置后可以重新加载
+			for _, s := range created {
+				UnregisterSink(logger, s)
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		created = append(created, s)
+	}
+	return created, nil
+}
+
+// RegisterSink 注册日志转发(名称唯一)并添加到logger的hook
+func RegisterSink(logger *logrus.Logger, s *Sink) error {
+	sinkMx.Lock()
+	defer sinkMx.Unlock()
+	if _, ok := sinks[s.Name()]; ok {
+		return fmt.Errorf("sink %s exists", s.Name())
+	}
+	sinks[s.Name()] = s
+	logger.AddHook(s)
+	return nil
+}
+
+// UnregisterSink 从注册表及logger的hook中移除日志转发(不关闭)
+func UnregisterSink(logger *logrus.Logger, s *Sink) {
+	sinkMx.Lock()
+	if sinks[s.Name()] == s {
+		delete(sinks, s.Name())
+	}
+	sinkMx.Unlock()
+	hooks := make(logrus.LevelHooks)
+	for level, hs := range logger.ReplaceHooks(make(logrus.LevelHooks)) {
+		for _, h := range hs {
+			if h != s {
+				hooks[level] = append(hooks[level], h)
+			}
+		}
+	}
+	logger.ReplaceHooks(hooks)
+}
+
+// SinkStatuses 所有日志转发的状态
+func SinkStatuses() []SinkStatus {
+	sinkMx.RLock()
+	defer sinkMx.RUnlock()
+	var result []SinkStatus
+	for _, s := range sinks {
+		result = append(result, s.Status())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// CloseSinks 关闭所有日志转发(logger的hook需要由调用方移除,关闭后的转发不再接收日志)
+func CloseSinks() error {
+	sinkMx.Lock()
+	defer sinkMx.Unlock()
+	var errs []error
+	for name, s := range sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%s", name, err))
+		}
+		delete(sinks, name)
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprint(errs))
+	}
+	return nil
+}
+
+// NewSinkStatusHandler 日志转发状态接口,存在不健康的转发时返回503
+func NewSinkStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := SinkStatuses()
+		code := http.StatusOK
+		for _, st := range statuses {
+			if !st.Healthy {
+				code = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(statuses)
+	}
+}
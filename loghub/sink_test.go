@@ -0,0 +1,184 @@
+package loghub
+
+import (
+	"bufio"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLogger(sinks ...*Sink) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	for _, s := range sinks {
+		logger.AddHook(s)
+	}
+	return logger
+}
+
+func TestHTTPSink(t *testing.T) {
+	var calls int32
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-Token") != "t1" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			received = append(received, scanner.Text())
+		}
+	}))
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{
+		Name:       "central",
+		Type:       SinkHTTP,
+		URL:        server.URL,
+		Headers:    map[string]string{"X-Token": "t1"},
+		Levels:     []string{"warn", "error"},
+		Sandboxes:  []string{"a"},
+		MinBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := newTestLogger(sink)
+	logger.WithField(sandboxName, "a").Warn("one")
+	logger.WithField(sandboxName, "a").Info("skipped level")
+	logger.WithField(sandboxName, "b").Warn("skipped sandbox")
+	logger.WithField(sandboxName, "a").Error("two")
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || !strings.Contains(received[0], `"message":"one"`) || !strings.Contains(received[1], `"message":"two"`) {
+		t.Errorf("unexpected received %v", received)
+	}
+	st := sink.Status()
+	if !st.Healthy || st.Sent != 2 || st.Failed != 0 || calls != 2 {
+		t.Errorf("unexpected status %+v, calls %d", st, calls)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ship.log")
+	sink, err := NewSink(SinkConfig{Type: SinkFile, Path: path, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := newTestLogger(sink)
+	for i := 0; i < 10; i++ {
+		logger.WithField("n", i).Info("entry")
+	}
+	//关闭时写入缓冲区中剩余的日志
+	_ = sink.Close()
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != 10 || sink.Status().Sent != 10 {
+		t.Errorf("expect 10 lines, got %d", n)
+	}
+}
+
+func TestSinkDropPolicy(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	sink, err := NewSink(SinkConfig{Type: SinkHTTP, URL: server.URL, BufferSize: 2, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := newTestLogger(sink)
+	for i := 0; i < 10; i++ {
+		logger.Info("entry")
+	}
+	//最多1条正在发送,2条在缓冲区
+	if st := sink.Status(); st.Dropped < 7 {
+		t.Errorf("expect dropped, got %+v", st)
+	}
+	close(release)
+	_ = sink.Close()
+	if st := sink.Status(); st.Sent+st.Dropped != 10 {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	sink, err := NewSink(SinkConfig{Type: SinkSyslog, Network: "udp", Address: pc.LocalAddr().String(), AppName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := newTestLogger(sink)
+	logger.WithField(sandboxName, "a").WithField("q", `say "hi"]`).Error("failed")
+	_ = sink.Close()
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	//facility 1(user)*8 + severity 3(error)
+	if !strings.HasPrefix(msg, "<11>1 ") || !strings.Contains(msg, ` test `) ||
+		!strings.Contains(msg, `[lego@32473 q="say \"hi\"\]" sandbox="a"] failed`) {
+		t.Errorf("unexpected syslog message %s", msg)
+	}
+}
+
+func TestNewSinkError(t *testing.T) {
+	for _, cfg := range []SinkConfig{
+		{Type: "kafka"},
+		{Type: SinkHTTP},
+		{Type: SinkFile, Path: "x.log", Policy: "wait"},
+		{Type: SinkSyslog, Network: "sctp"},
+		{Type: SinkFile, Path: "x.log", Levels: []string{"loud"}},
+	} {
+		if _, err := NewSink(cfg); err == nil {
+			t.Errorf("expect error for %+v", cfg)
+		}
+	}
+}
+
+func TestLoadSinksPartialFailure(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "sinks.yml")
+	write := func(second string) {
+		data := "sinks:\n  - {name: ship, type: file, path: " + filepath.Join(dir, "ship.log") + "}\n  - " + second + "\n"
+		if err := os.WriteFile(cfg, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger := newTestLogger()
+	write("{name: broken, type: kafka}")
+	if _, err := LoadSinks(logger, cfg); err == nil {
+		t.Fatal("expect error for unknown sink type")
+	}
+	if len(logger.Hooks) != 0 || len(SinkStatuses()) != 0 {
+		t.Fatalf("failed sinks should be unregistered: hooks=%v statuses=%v", logger.Hooks, SinkStatuses())
+	}
+	write("{name: audit, type: file, path: " + filepath.Join(dir, "audit.log") + "}")
+	created, err := LoadSinks(logger, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseSinks()
+	if len(created) != 2 || len(logger.Hooks[logrus.InfoLevel]) != 2 {
+		t.Fatalf("expect 2 sinks registered, got %d", len(created))
+	}
+}
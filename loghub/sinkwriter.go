@@ -0,0 +1,273 @@
+package loghub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 日志转发的写入不能再使用logrus输出(block策略下会死锁)
+
+// encodeNDJSON 每条日志一行json
+func encodeNDJSON(entries []*logrus.Entry) []byte {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, e := range entries {
+		if err := enc.Encode(EntryToMap(e)); err != nil {
+			_ = enc.Encode(map[string]interface{}{"level": e.Level.String(), "message": e.Message, "time": e.Time})
+		}
+	}
+	return buf.Bytes()
+}
+
+type fileWriter struct {
+	out *lumberjack.Logger
+}
+
+func newFileWriter(cfg SinkConfig) (sinkWriter, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("require path")
+	}
+	return &fileWriter{out: &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSize,
+		MaxAge:     cfg.MaxAge,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+	}}, nil
+}
+
+func (f *fileWriter) write(entries []*logrus.Entry) error {
+	_, err := f.out.Write(encodeNDJSON(entries))
+	return err
+}
+
+func (f *fileWriter) close() error {
+	return f.out.Close()
+}
+
+type httpWriter struct {
+	cfg    SinkConfig
+	client *http.Client
+}
+
+func newHTTPWriter(cfg SinkConfig) (sinkWriter, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("require url")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	return &httpWriter{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+// write 以NDJSON发送,网络错误及5xx/429时按指数退避重试
+func (h *httpWriter) write(entries []*logrus.Entry) error {
+	body := encodeNDJSON(entries)
+	backoff := h.cfg.MinBackoff
+	var err error
+	for i := 0; i <= h.cfg.MaxRetries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > h.cfg.MaxBackoff {
+				backoff = h.cfg.MaxBackoff
+			}
+		}
+		var retry bool
+		if retry, err = h.post(body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func (h *httpWriter) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("post logs to %s: %s", h.cfg.URL, resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+func (h *httpWriter) close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// syslogWriter RFC5424格式的syslog,tcp使用octet counting分帧(RFC6587)
+type syslogWriter struct {
+	cfg      SinkConfig
+	hostname string
+	conn     net.Conn
+	stream   bool //tcp及unix stream需要分帧
+}
+
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+func newSyslogWriter(cfg SinkConfig) (sinkWriter, error) {
+	if cfg.Network == "" {
+		cfg.Network = "udp"
+	}
+	switch cfg.Network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %s", cfg.Network)
+	}
+	if cfg.Facility <= 0 || cfg.Facility > 23 {
+		cfg.Facility = 1
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "lego"
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &syslogWriter{cfg: cfg, hostname: hostname}, nil
+}
+
+// dial 连接syslog,返回连接及实际使用的网络类型
+func (s *syslogWriter) dial() (net.Conn, string, error) {
+	if s.cfg.Address != "" {
+		network := s.cfg.Network
+		if network == "unix" {
+			//syslog的本地socket通常是datagram
+			if conn, err := net.DialTimeout("unixgram", s.cfg.Address, 5*time.Second); err == nil {
+				return conn, "unixgram", nil
+			}
+		}
+		conn, err := net.DialTimeout(network, s.cfg.Address, 5*time.Second)
+		return conn, network, err
+	}
+	for _, p := range localSyslogPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.DialTimeout(network, p, 5*time.Second); err == nil {
+				return conn, network, nil
+			}
+		}
+	}
+	return nil, "", errors.New("local syslog not available")
+}
+
+// severity logrus级别对应的syslog严重性
+func severity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 1
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName 结构化数据的参数名只能是可打印ASCII,不能包含'=',' ',']','"'
+func sdName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	return string(b)
+}
+
+// format RFC5424: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [lego@32473 k="v"...] MSG
+func (s *syslogWriter) format(e *logrus.Entry) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d - ", s.cfg.Facility*8+severity(e.Level),
+		e.Time.UTC().Format(time.RFC3339Nano), s.hostname, s.cfg.AppName, os.Getpid())
+	if len(e.Data) == 0 {
+		buf.WriteString("-")
+	} else {
+		keys := make([]string, 0, len(e.Data))
+		for k := range e.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteString("[lego@32473")
+		for _, k := range keys {
+			fmt.Fprintf(buf, ` %s="%s"`, sdName(k), sdEscaper.Replace(fmt.Sprint(e.Data[k])))
+		}
+		buf.WriteString("]")
+	}
+	buf.WriteString(" ")
+	buf.WriteString(e.Message)
+	return buf.String()
+}
+
+func (s *syslogWriter) write(entries []*logrus.Entry) error {
+	if s.conn == nil {
+		conn, network, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		s.stream = network == "tcp" || network == "unix"
+	}
+	for _, e := range entries {
+		msg := s.format(e)
+		if s.stream {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		_ = s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			//下次写入时重新连接
+			_ = s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogWriter) close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package loghub
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/sirupsen/logrus"
+	"lightbox/kvstore"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	storeName       = "loghub"
+	storeKeyPrefix  = 'l'
+	storeBufferSize = 4096
+	storeBatchSize  = 256
+	storeFlushEvery = 200 * time.Millisecond
+	pruneInterval   = time.Minute
+
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 10000
+)
+
+// StoreOption 日志存储设置
+type StoreOption struct {
+	//MaxAge 日志保留时间,0表示不按时间清理
+	MaxAge time.Duration
+	//MaxSize 日志最大占用空间(字节),超出时删除最早的日志,0表示不限制
+	MaxSize int64
+	//Levels 需要存储的日志级别,默认全部
+	Levels []logrus.Level
+}
+
+// StoredEntry 存储的日志条目
+type StoredEntry struct {
+	Sandbox string                 `json:"sandbox"`
+	Level   string                 `json:"level"`
+	Time    time.Time              `json:"time"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ToMap 转换为与EntryToMap相同格式的map(过滤表达式中的log变量)
+func (e *StoredEntry) ToMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(e.Fields)+4)
+	for k, v := range e.Fields {
+		m[k] = v
+	}
+	if e.Sandbox != "" {
+		m[sandboxName] = e.Sandbox
+	}
+	m["level"] = e.Level
+	m["message"] = e.Message
+	m["time"] = e.Time
+	return m
+}
+
+// LogStore 基于badger的日志存储,作为logrus hook写入,支持按时间及大小清理
+type LogStore struct {
+	db      *badger.DB
+	opt     StoreOption
+	ch      chan *StoredEntry
+	seq     uint32
+	dropped uint64
+	closed  chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+var (
+	defaultStore *LogStore
+	storeMx      sync.RWMutex
+)
+
+// SetDefaultStore 设置默认的日志存储(日志查询API及脚本模块使用)
+func SetDefaultStore(s *LogStore) {
+	storeMx.Lock()
+	defer storeMx.Unlock()
+	defaultStore = s
+}
+
+// DefaultStore 默认的日志存储,未启用时返回nil
+func DefaultStore() *LogStore {
+	storeMx.RLock()
+	defer storeMx.RUnlock()
+	return defaultStore
+}
+
+// OpenStore 打开日志存储,dir为空时存储在内存中
+func OpenStore(dir string, opt StoreOption) (*LogStore, error) {
+	options := kvstore.DefaultOptions(dir).WithLoggingLevel(badger.WARNING)
+	db, err := kvstore.Open(storeName, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Levels) == 0 {
+		opt.Levels = logrus.AllLevels
+	}
+	s := &LogStore{
+		db:     db,
+		opt:    opt,
+		ch:     make(chan *StoredEntry, storeBufferSize),
+		closed: make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.writeLoop()
+	go s.pruneLoop()
+	return s, nil
+}
+
+func (s *LogStore) Levels() []logrus.Level {
+	return s.opt.Levels
+}
+
+// Fire 日志写入缓冲区,缓冲区满时丢弃(不阻塞日志调用方)
+func (s *LogStore) Fire(entry *logrus.Entry) error {
+	e := &StoredEntry{
+		Level:   entry.Level.String(),
+		Time:    entry.Time,
+		Message: entry.Message,
+	}
+	for k, v := range entry.Data {
+		if k == sandboxName {
+			e.Sandbox = fmt.Sprint(v)
+			continue
+		}
+		if e.Fields == nil {
+			e.Fields = make(map[string]interface{}, len(entry.Data))
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		e.Fields[k] = v
+	}
+	select {
+	case <-s.closed:
+	case s.ch <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped 因缓冲区满丢弃的日志数量
+func (s *LogStore) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close 写入缓冲区中的日志并关闭存储
+func (s *LogStore) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	s.wg.Wait()
+	return kvstore.Close(storeName)
+}
+
+func (s *LogStore) key(t time.Time) []byte {
+	key := make([]byte, 13)
+	key[0] = storeKeyPrefix
+	binary.BigEndian.PutUint64(key[1:], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(key[9:], atomic.AddUint32(&s.seq, 1))
+	return key
+}
+
+func (s *LogStore) writeLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(storeFlushEvery)
+	defer ticker.Stop()
+	var pending []*StoredEntry
+	for {
+		select {
+		case e := <-s.ch:
+			pending = append(pending, e)
+			if len(pending) < storeBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-s.closed:
+			s.write(append(pending, s.drain()...))
+			return
+		}
+		s.write(pending)
+		pending = pending[:0]
+	}
+}
+
+func (s *LogStore) drain() (entries []*StoredEntry) {
+	for {
+		select {
+		case e := <-s.ch:
+			entries = append(entries, e)
+		default:
+			return
+		}
+	}
+}
+
+func (s *LogStore) write(entries []*StoredEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			//字段不能序列化时只保存基本信息
+			data, _ = json.Marshal(&StoredEntry{Sandbox: e.Sandbox, Level: e.Level, Time: e.Time, Message: e.Message})
+		}
+		be := badger.NewEntry(s.key(e.Time), data)
+		if s.opt.MaxAge > 0 {
+			be = be.WithTTL(s.opt.MaxAge)
+		}
+		if err = wb.SetEntry(be); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "write log store error:", err)
+			return
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "write log store error:", err)
+	}
+}
+
+func (s *LogStore) pruneLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			if err := s.prune(); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "prune log store error:", err)
+			}
+		}
+	}
+}
+
+// prune 超出最大空间时从最早的日志开始删除,直到低于最大空间的90%
+func (s *LogStore) prune() error {
+	if s.opt.MaxSize > 0 {
+		var total int64
+		err := s.db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{storeKeyPrefix}})
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				total += it.Item().EstimatedSize()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if total > s.opt.MaxSize {
+			if err = s.deleteOldest(total - s.opt.MaxSize*9/10); err != nil {
+				return err
+			}
+		}
+	}
+	for s.db.RunValueLogGC(0.5) == nil {
+	}
+	return nil
+}
+
+func (s *LogStore) deleteOldest(size int64) error {
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{storeKeyPrefix}})
+		defer it.Close()
+		for it.Rewind(); it.Valid() && size > 0; it.Next() {
+			size -= it.Item().EstimatedSize()
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err = wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
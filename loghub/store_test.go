@@ -0,0 +1,69 @@
+package loghub
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLogStore_Query(t *testing.T) {
+	store, err := OpenStore("", StoreOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.TraceLevel)
+	logger.AddHook(store)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		logger.WithField(sandboxName, "a").WithField("seq", i).Info("hello ", i)
+	}
+	logger.WithField(sandboxName, "b").WithField("user", "bob").Error("timeout")
+	logger.WithField(sandboxName, "a").WithField("user", "alice").Warn("slow")
+	//等待写入
+	time.Sleep(3 * storeFlushEvery)
+
+	cases := []struct {
+		name  string
+		query LogQuery
+		count int
+		first string
+	}{
+		{"all", LogQuery{}, 12, "hello 0"},
+		{"sandbox", LogQuery{Sandbox: "b"}, 1, "timeout"},
+		{"level", LogQuery{Levels: []string{"warning", "error"}}, 2, "timeout"},
+		{"field", LogQuery{Fields: map[string]string{"seq": "3"}}, 1, "hello 3"},
+		{"filter", LogQuery{Sandbox: "a", Filter: `log.user == "alice"`}, 1, "slow"},
+		{"filter number", LogQuery{Filter: `log.seq == 5`}, 1, "hello 5"},
+		{"limit desc", LogQuery{Limit: 2, Desc: true}, 2, "slow"},
+		{"since", LogQuery{Since: time.Now()}, 0, ""},
+		{"until", LogQuery{Until: start.Add(-time.Second)}, 0, ""},
+	}
+	for _, c := range cases {
+		entries, err := store.Query(c.query)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if len(entries) != c.count {
+			t.Errorf("%s: expect %d entries, got %d", c.name, c.count, len(entries))
+			continue
+		}
+		if c.count > 0 && entries[0].Message != c.first {
+			t.Errorf("%s: expect first %q, got %q", c.name, c.first, entries[0].Message)
+		}
+	}
+	if _, err = store.Query(LogQuery{Filter: "log.("}); err == nil {
+		t.Error("expect filter error")
+	}
+
+	store.opt.MaxSize = 1
+	if err = store.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := store.Query(LogQuery{}); len(entries) != 0 {
+		t.Errorf("expect pruned, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,196 @@
+package loghub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+// ParseSetting 从url参数解析订阅设置: level(多个以逗号分隔),filter
+func ParseSetting(values url.Values) (*SubscriberSetting, error) {
+	setting := &SubscriberSetting{Filter: values.Get("filter")}
+	for _, l := range values["level"] {
+		for _, name := range strings.Split(l, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			level, err := logrus.ParseLevel(name)
+			if err != nil {
+				return nil, err
+			}
+			setting.Levels = append(setting.Levels, level)
+		}
+	}
+	if err := setting.update(); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+func (l *SubscriberSetting) matchLevel(level logrus.Level) bool {
+	if len(l.Levels) == 0 {
+		return true
+	}
+	for _, lv := range l.Levels {
+		if lv == level {
+			return true
+		}
+	}
+	return false
+}
+
+type streamEncoder interface {
+	// entry 输出一条日志
+	entry(m map[string]interface{}) error
+	// error 输出过滤表达式执行错误
+	error(err error) error
+	// keepAlive 保持连接
+	keepAlive() error
+}
+
+type ndjsonEncoder struct {
+	w http.ResponseWriter
+}
+
+func (e *ndjsonEncoder) entry(m map[string]interface{}) error {
+	return json.NewEncoder(e.w).Encode(m)
+}
+
+func (e *ndjsonEncoder) error(err error) error {
+	return json.NewEncoder(e.w).Encode(map[string]interface{}{"code": 500, "error": err.Error()})
+}
+
+func (e *ndjsonEncoder) keepAlive() error {
+	_, err := e.w.Write([]byte("\n"))
+	return err
+}
+
+type sseEncoder struct {
+	w http.ResponseWriter
+}
+
+func (e *sseEncoder) write(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if event != "" {
+		_, err = fmt.Fprintf(e.w, "event: %s\n", event)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(e.w, "data: %s\n\n", data)
+	}
+	return err
+}
+
+func (e *sseEncoder) entry(m map[string]interface{}) error {
+	return e.write("", m)
+}
+
+func (e *sseEncoder) error(err error) error {
+	return e.write("error", map[string]interface{}{"code": 500, "error": err.Error()})
+}
+
+func (e *sseEncoder) keepAlive() error {
+	_, err := e.w.Write([]byte(": ping\n\n"))
+	return err
+}
+
+// NewSSESubscribeHandler 以Server-Sent Events方式订阅日志
+// 参数与websocket订阅的设置相同: level(多个以逗号分隔),filter;timeout(可选,如30s)到期后结束,可用于长轮询
+func NewSSESubscribeHandler(subscriber LogSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		streamLogs(w, r, subscriber, &sseEncoder{w: w})
+	}
+}
+
+// NewNDJSONSubscribeHandler 以分块传输的NDJSON(每行一条日志)方式订阅日志,参数同NewSSESubscribeHandler
+func NewNDJSONSubscribeHandler(subscriber LogSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		streamLogs(w, r, subscriber, &ndjsonEncoder{w: w})
+	}
+}
+
+func streamLogs(w http.ResponseWriter, r *http.Request, subscriber LogSubscriber, enc streamEncoder) {
+	if subscriber == nil {
+		http.Error(w, "subscriber not initialize", http.StatusServiceUnavailable)
+		return
+	}
+	name, ok := mux.Vars(r)[sandboxName]
+	if !ok {
+		name = r.URL.Query().Get(sandboxName)
+	}
+	if name == "" {
+		http.Error(w, "require sandbox", http.StatusBadRequest)
+		return
+	}
+	setting, err := ParseSetting(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ctx := r.Context()
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			http.Error(w, "invalid timeout:"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	sub := subscriber.Subscribe("stream:"+name, SubscribeOption{})
+	defer subscriber.Unsubscribe(sub)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err = enc.keepAlive()
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if n, ok := msg.Data[sandboxName]; !(ok && n == name) || !setting.matchLevel(msg.Level) {
+				continue
+			}
+			m := EntryToMap(msg)
+			match, merr := setting.Exec(m)
+			if match {
+				err = enc.entry(m)
+			} else if merr != nil {
+				err = enc.error(merr)
+			} else {
+				continue
+			}
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
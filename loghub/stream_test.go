@@ -0,0 +1,65 @@
+package loghub
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNDJSONSubscribeHandler(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	subscriber := NewWithLevels(logger, logrus.AllLevels...)
+	router := mux.NewRouter()
+	router.Handle("/{sandbox}/log/stream", NewNDJSONSubscribeHandler(subscriber))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/a/log/stream?level=warn,error&filter=log.n%3E1&timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		for i := 0; i < 4; i++ {
+			logger.WithField(sandboxName, "a").WithField("n", i).Warn("warn")
+			logger.WithField(sandboxName, "a").WithField("n", i).Info("info")
+			logger.WithField(sandboxName, "b").WithField("n", i).Warn("other")
+		}
+	}()
+	var got []int
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		m := map[string]interface{}{}
+		if err = json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m["message"] != "warn" {
+			t.Errorf("unexpected entry %v", m)
+		}
+		got = append(got, int(m["n"].(float64)))
+	}
+	if len(got) != 2 {
+		t.Errorf("expect 2 entries, got %v", got)
+	}
+
+	resp2, err := server.Client().Get(server.URL + "/a/log/stream?level=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp2.Body)
+	resp2.Body.Close()
+	if resp2.StatusCode != 400 || !strings.Contains(string(body), "not a valid logrus Level") {
+		t.Errorf("expect bad request, got %d %s", resp2.StatusCode, body)
+	}
+}
@@ -1,27 +1,140 @@
 package loghub
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/d5/tengo/v2"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const sandboxName = "sandbox"
 
+// LogSubscriber 日志订阅,每个订阅者有独立的有序队列,队列满时按DropPolicy丢弃,不阻塞写日志的applet
 type LogSubscriber interface {
 	logrus.Hook
-	Subscribe(string, chan *logrus.Entry) LogSubscriber
-	UnSubscribe(string) LogSubscriber
+	// Subscribe 创建订阅,name仅用于统计,可以重复
+	Subscribe(name string, opt SubscribeOption) *Subscription
+	// Unsubscribe 取消订阅并关闭订阅的channel(可重复调用)
+	Unsubscribe(sub *Subscription)
+	// Stats 所有订阅者的统计
+	Stats() []SubscriptionStats
+}
+
+// DropPolicy 订阅队列满时的处理方式
+type DropPolicy int
+
+const (
+	// DropNewest 丢弃新的日志
+	DropNewest DropPolicy = iota
+	// DropOldest 丢弃队列中最早的日志
+	DropOldest
+)
+
+// DefaultSubscribeCapacity 订阅队列的默认容量
+const DefaultSubscribeCapacity = 256
+
+type SubscribeOption struct {
+	Capacity int
+	Policy   DropPolicy
+}
+
+// Subscription 一个订阅者,从C中按日志产生的顺序读取,取消订阅后C被关闭
+type Subscription struct {
+	Name      string
+	C         <-chan *logrus.Entry
+	ch        chan *logrus.Entry
+	policy    DropPolicy
+	delivered uint64
+	dropped   uint64
+}
+
+// Dropped 因队列满丢弃的日志数量
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// deliver 非阻塞投递
+func (s *Subscription) deliver(entry *logrus.Entry) {
+	select {
+	case s.ch <- entry:
+		atomic.AddUint64(&s.delivered, 1)
+		return
+	default:
+	}
+	if s.policy == DropOldest {
+		select {
+		case <-s.ch:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+		select {
+		case s.ch <- entry:
+			atomic.AddUint64(&s.delivered, 1)
+			return
+		default:
+		}
+	}
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+// SubscriptionStats 订阅者统计
+type SubscriptionStats struct {
+	Name      string `json:"name"`
+	Capacity  int    `json:"capacity"`
+	Queued    int    `json:"queued"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
 }
 
 const (
 	defaultFilter = `result=(%s)`
 )
 
+var (
+	allowedOrigins []string
+	originMx       sync.RWMutex
+)
+
+// SetAllowedOrigins 设置允许订阅日志的websocket来源,`*`表示不检查来源,未设置时只允许同源请求
+func SetAllowedOrigins(origins ...string) {
+	originMx.Lock()
+	defer originMx.Unlock()
+	allowedOrigins = origins
+}
+
+// CheckOrigin 检查websocket请求来源(与日志订阅使用相同的设置)
+func CheckOrigin(r *http.Request) bool {
+	return checkOrigin(r)
+}
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		//非浏览器客户端
+		return true
+	}
+	originMx.RLock()
+	defer originMx.RUnlock()
+	for _, o := range allowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
 type SubscriberSetting struct {
 	compiled *tengo.Compiled
 	Filter   string         `json:"filter"`
@@ -29,7 +142,8 @@ type SubscriberSetting struct {
 	sync.RWMutex
 }
 
-func entryToMap(entry *logrus.Entry) map[string]interface{} {
+// EntryToMap 转换日志条目为map(level/message/time及所有字段)
+func EntryToMap(entry *logrus.Entry) map[string]interface{} {
 	m := make(map[string]interface{}, len(entry.Data)+4)
 	for k, v := range entry.Data {
 		m[k] = v
@@ -84,47 +198,81 @@ func (l *SubscriberSetting) Exec(entry map[string]interface{}) (bool, error) {
 }
 
 type logHooker struct {
-	subscribe map[string]chan<- *logrus.Entry
-	levels    []logrus.Level
-	sync.Mutex
+	subscribers map[*Subscription]struct{}
+	levels      []logrus.Level
+	sync.RWMutex
 }
 
 func (f *logHooker) Levels() []logrus.Level {
 	return f.levels
 }
 
+// Fire 在写日志的goroutine中按顺序投递到每个订阅者的队列,队列满时丢弃
 func (f *logHooker) Fire(entry *logrus.Entry) error {
-	go func() {
-		defer func() {
-			err := recover()
-			if err != nil {
-				fmt.Println("log hook error:", err)
-			}
-		}()
-		for _, ch := range f.subscribe {
-			ch <- entry
-		}
-	}()
+	f.RLock()
+	defer f.RUnlock()
+	for sub := range f.subscribers {
+		sub.deliver(entry)
+	}
 	return nil
 }
 
-func (f *logHooker) UnSubscribe(name string) LogSubscriber {
+func (f *logHooker) Subscribe(name string, opt SubscribeOption) *Subscription {
+	if opt.Capacity <= 0 {
+		opt.Capacity = DefaultSubscribeCapacity
+	}
+	ch := make(chan *logrus.Entry, opt.Capacity)
+	sub := &Subscription{Name: name, C: ch, ch: ch, policy: opt.Policy}
 	f.Lock()
 	defer f.Unlock()
-	delete(f.subscribe, name)
-	return f
+	if f.subscribers == nil {
+		f.subscribers = make(map[*Subscription]struct{})
+	}
+	f.subscribers[sub] = struct{}{}
+	return sub
 }
-func (f *logHooker) Subscribe(name string, ch chan *logrus.Entry) LogSubscriber {
+
+func (f *logHooker) Unsubscribe(sub *Subscription) {
+	if sub == nil {
+		return
+	}
 	f.Lock()
 	defer f.Unlock()
-	if f.subscribe == nil {
-		f.subscribe = map[string]chan<- *logrus.Entry{
-			name: ch,
+	if _, ok := f.subscribers[sub]; ok {
+		delete(f.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+func (f *logHooker) Stats() []SubscriptionStats {
+	f.RLock()
+	defer f.RUnlock()
+	stats := make([]SubscriptionStats, 0, len(f.subscribers))
+	for sub := range f.subscribers {
+		stats = append(stats, SubscriptionStats{
+			Name:      sub.Name,
+			Capacity:  cap(sub.ch),
+			Queued:    len(sub.ch),
+			Delivered: atomic.LoadUint64(&sub.delivered),
+			Dropped:   sub.Dropped(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// NewSubscriberStatsHandler 订阅者统计接口
+func NewSubscriberStatsHandler(subscriber LogSubscriber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var stats []SubscriptionStats
+		if subscriber != nil {
+			stats = subscriber.Stats()
 		}
-	} else {
-		f.subscribe[name] = ch
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
 	}
-	return f
 }
 
 func NewLogSubscriber() LogSubscriber {
@@ -155,13 +303,11 @@ func NewWebsocketSubscribeHandler(subscriber LogSubscriber) http.HandlerFunc {
 			w.Write([]byte(err.Error()))
 			return
 		}
-		//mutex := &sync.Mutex{}
+		//websocket不支持并发写
+		mutex := &sync.Mutex{}
 		upgrader := websocket.Upgrader{
 			EnableCompression: true,
-			CheckOrigin: func(r *http.Request) bool {
-				//do not check origin
-				return true
-			},
+			CheckOrigin:       checkOrigin,
 		}
 		ws, err := upgrader.Upgrade(w, request, nil)
 		if err != nil {
@@ -170,32 +316,21 @@ func NewWebsocketSubscribeHandler(subscriber LogSubscriber) http.HandlerFunc {
 		defer func() {
 			ws.Close()
 		}()
-		ch := make(chan *logrus.Entry, 100)
-		subscriber.Subscribe(name, ch)
-		defer func() {
-			subscriber.UnSubscribe(name)
-		}()
+		sub := subscriber.Subscribe("websocket:"+name, SubscribeOption{})
+		defer subscriber.Unsubscribe(sub)
 		go func() {
-			for msg := range ch {
+			//取消订阅后channel关闭,协程退出
+			for msg := range sub.C {
 				if n, ok := msg.Data[sandboxName]; !(ok && n == name) {
 					continue
 				}
-				if filter.Levels != nil && len(filter.Levels) > 0 {
-					match := false
-					for _, l := range filter.Levels {
-						if l == msg.Level {
-							match = true
-							break
-						}
-					}
-					if !match {
-						continue
-					}
+				if !filter.matchLevel(msg.Level) {
+					continue
 				}
-				m := entryToMap(msg)
-				err = func() error {
-					//mutex.Lock()
-					//defer mutex.Unlock()
+				m := EntryToMap(msg)
+				err := func() error {
+					mutex.Lock()
+					defer mutex.Unlock()
 					if match, merr := filter.Exec(m); match {
 						return ws.WriteJSON(m)
 					} else if merr != nil {
@@ -207,18 +342,18 @@ func NewWebsocketSubscribeHandler(subscriber LogSubscriber) http.HandlerFunc {
 					return nil
 				}()
 				if err != nil {
-					logrus.Error(err)
+					return
 				}
 			}
 		}()
 		for {
 			err1 := func() error {
-				//mutex.Lock()
-				//defer mutex.Unlock()
 				err = ws.ReadJSON(filter)
 				if err != nil {
 					return err
 				}
+				mutex.Lock()
+				defer mutex.Unlock()
 				if err = filter.update(); err != nil {
 					return ws.WriteJSON(map[string]interface{}{
 						"code":  "500",
@@ -0,0 +1,95 @@
+package loghub
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newHookedLogger() (*logrus.Logger, LogSubscriber) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger, NewWithLevels(logger, logrus.AllLevels...)
+}
+
+func TestLogHooker_Ordered(t *testing.T) {
+	logger, hub := newHookedLogger()
+	sub := hub.Subscribe("ordered", SubscribeOption{Capacity: 1000})
+	for i := 0; i < 1000; i++ {
+		logger.WithField("n", i).Info("entry")
+	}
+	for i := 0; i < 1000; i++ {
+		e := <-sub.C
+		if e.Data["n"] != i {
+			t.Fatalf("expect %d, got %v", i, e.Data["n"])
+		}
+	}
+	hub.Unsubscribe(sub)
+	if _, ok := <-sub.C; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+	hub.Unsubscribe(sub)
+	logger.Info("after unsubscribe")
+}
+
+func TestLogHooker_DropPolicy(t *testing.T) {
+	logger, hub := newHookedLogger()
+	newest := hub.Subscribe("newest", SubscribeOption{Capacity: 3})
+	oldest := hub.Subscribe("oldest", SubscribeOption{Capacity: 3, Policy: DropOldest})
+	//订阅者不读取时写日志也不会阻塞
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			logger.WithField("n", i).Info("entry")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("logging blocked by slow subscriber")
+	}
+	expect := func(sub *Subscription, first int) {
+		for i := first; i < first+3; i++ {
+			if e := <-sub.C; e.Data["n"] != i {
+				t.Errorf("%s: expect %d, got %v", sub.Name, i, e.Data["n"])
+			}
+		}
+		if sub.Dropped() != 7 {
+			t.Errorf("%s: expect 7 dropped, got %d", sub.Name, sub.Dropped())
+		}
+	}
+	expect(newest, 0)
+	expect(oldest, 7)
+	stats := hub.Stats()
+	if len(stats) != 2 || stats[0].Name != "newest" || stats[0].Delivered != 3 || stats[0].Dropped != 7 || stats[0].Capacity != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLogHooker_Concurrent(t *testing.T) {
+	logger, hub := newHookedLogger()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				logger.Info("entry")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				sub := hub.Subscribe("c", SubscribeOption{Capacity: 4})
+				hub.Unsubscribe(sub)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(hub.Stats()) != 0 {
+		t.Error("all subscribers should be removed")
+	}
+}
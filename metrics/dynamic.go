@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrTooManySeries = errors.New("too many series")
+
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Opts 运行时定义的指标(如脚本自定义的指标)
+type Opts struct {
+	Name       string
+	Help       string
+	Type       Type
+	Labels     []string
+	Buckets    []float64 //histogram
+	Objectives []float64 //summary
+	MaxSeries  int       //标签组合的最大数量,超过时丢弃新的标签组合
+}
+
+// Metric 运行时定义的指标
+type Metric struct{ v *vec }
+
+func init() {
+	Register("metrics", collectDropped)
+}
+
+// ValidName 是否为合法的指标名
+func ValidName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+func validLabel(label string) bool {
+	return labelRe.MatchString(label) && !strings.HasPrefix(label, "__") && label != "le" && label != "quantile"
+}
+
+// GetOrCreate 获取指标,不存在时创建,已存在的指标类型及标签必须一致
+func GetOrCreate(opts Opts) (*Metric, error) {
+	if !ValidName(opts.Name) {
+		return nil, fmt.Errorf("invalid metric name %q", opts.Name)
+	}
+	switch opts.Type {
+	case TypeCounter, TypeGauge, TypeHistogram, TypeSummary:
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", opts.Type)
+	}
+	for _, l := range opts.Labels {
+		if !validLabel(l) {
+			return nil, fmt.Errorf("invalid label name %q", l)
+		}
+	}
+	r := defaultRegistry
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, v := range r.vecs {
+		if v.name != opts.Name {
+			continue
+		}
+		if v.typ != opts.Type || fmt.Sprint(v.labels) != fmt.Sprint(opts.Labels) {
+			return nil, fmt.Errorf("metric %s already defined as %s%v", v.name, v.typ, v.labels)
+		}
+		return &Metric{v: v}, nil
+	}
+	v := &vec{
+		name:      opts.Name,
+		help:      opts.Help,
+		typ:       opts.Type,
+		labels:    append([]string(nil), opts.Labels...),
+		maxSeries: opts.MaxSeries,
+		series:    map[string]*series{},
+	}
+	switch opts.Type {
+	case TypeHistogram:
+		v.buckets = sortedCopy(opts.Buckets, DefaultBuckets)
+	case TypeSummary:
+		v.objectives = sortedCopy(opts.Objectives, DefaultObjectives)
+		for _, q := range v.objectives {
+			if q <= 0 || q >= 1 {
+				return nil, fmt.Errorf("invalid quantile %v", q)
+			}
+		}
+	}
+	r.vecs = append(r.vecs, v)
+	return &Metric{v: v}, nil
+}
+
+// Remove 删除指标
+func Remove(name string) {
+	r := defaultRegistry
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for i, v := range r.vecs {
+		if v.name == name {
+			r.vecs = append(r.vecs[:i], r.vecs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Metric) Name() string {
+	return m.v.name
+}
+
+func (m *Metric) Type() Type {
+	return m.v.typ
+}
+
+func (m *Metric) Labels() []string {
+	return m.v.labels
+}
+
+func (m *Metric) series(values []string) (*series, error) {
+	if len(values) != len(m.v.labels) {
+		return nil, fmt.Errorf("metric %s require labels %v", m.v.name, m.v.labels)
+	}
+	s, ok := m.v.tryWith(values)
+	if !ok {
+		return nil, fmt.Errorf("metric %s: %w(max %d)", m.v.name, ErrTooManySeries, m.v.maxSeries)
+	}
+	return s, nil
+}
+
+// Add counter/gauge增加value(counter不能减少)
+func (m *Metric) Add(value float64, values ...string) error {
+	switch {
+	case m.v.typ == TypeCounter && value < 0:
+		return fmt.Errorf("counter %s cannot decrease", m.v.name)
+	case m.v.typ != TypeCounter && m.v.typ != TypeGauge:
+		return fmt.Errorf("%s %s not support add", m.v.typ, m.v.name)
+	}
+	s, err := m.series(values)
+	if err != nil {
+		return err
+	}
+	s.add(value)
+	return nil
+}
+
+// Set 设置gauge的值
+func (m *Metric) Set(value float64, values ...string) error {
+	if m.v.typ != TypeGauge {
+		return fmt.Errorf("%s %s not support set", m.v.typ, m.v.name)
+	}
+	s, err := m.series(values)
+	if err != nil {
+		return err
+	}
+	(&Gauge{s: s}).Set(value)
+	return nil
+}
+
+// Observe histogram/summary记录观测值
+func (m *Metric) Observe(value float64, values ...string) error {
+	if m.v.typ != TypeHistogram && m.v.typ != TypeSummary {
+		return fmt.Errorf("%s %s not support observe", m.v.typ, m.v.name)
+	}
+	s, err := m.series(values)
+	if err != nil {
+		return err
+	}
+	if m.v.typ == TypeSummary {
+		s.record(value)
+	} else {
+		(&Histogram{s: s, buckets: m.v.buckets}).Observe(value)
+	}
+	return nil
+}
+
+// collectDropped 超过标签组合数量限制而丢弃的次数
+func collectDropped() []Family {
+	f := Family{Name: "lightbox_metrics_dropped_series_total", Help: "Observations dropped because the metric reached its series limit.",
+		Type: TypeCounter, Labels: []string{"metric"}}
+	defaultRegistry.mx.RLock()
+	defer defaultRegistry.mx.RUnlock()
+	for _, v := range defaultRegistry.vecs {
+		if n := atomic.LoadUint64(&v.dropped); n > 0 {
+			f.Samples = append(f.Samples, Sample{Values: []string{v.name}, Value: float64(n)})
+		}
+	}
+	return []Family{f}
+}
+
+// WriteFile 把名称以prefix开头的指标写入文件,prefix为空时写入所有指标(先写临时文件再替换,可用于node_exporter的textfile collector)
+func WriteFile(file, prefix string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = writeText(tmp, prefix); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
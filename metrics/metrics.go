@@ -0,0 +1,298 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type 指标类型(与Prometheus一致)
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+	TypeSummary   Type = "summary"
+)
+
+// DefaultBuckets 默认的耗时分布(秒)
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultObjectives summary默认的分位数
+var DefaultObjectives = []float64{.5, .9, .99}
+
+// summaryWindow summary计算分位数使用最近的观测值数量
+const summaryWindow = 1024
+
+// Sample 采集器返回的一个序列
+type Sample struct {
+	Values []string //与Family.Labels一一对应
+	Value  float64
+}
+
+// Family 采集器返回的一组同名指标(只支持counter/gauge)
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Labels  []string
+	Samples []Sample
+}
+
+// Collector 在导出时采集指标(连接池状态、存储大小等)
+type Collector func() []Family
+
+type series struct {
+	values  []string
+	bits    uint64   //float64
+	buckets []uint64 //histogram,每个bucket的计数(不累加)
+	count   uint64
+	sumBits uint64
+	mx      sync.Mutex
+	window  []float64 //summary,最近的观测值
+	pos     int
+}
+
+func (s *series) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		if atomic.CompareAndSwapUint64(&s.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// observe 累加count/sum
+func (s *series) observe(v float64) {
+	atomic.AddUint64(&s.count, 1)
+	for {
+		old := atomic.LoadUint64(&s.sumBits)
+		if atomic.CompareAndSwapUint64(&s.sumBits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// record summary记录观测值
+func (s *series) record(v float64) {
+	s.mx.Lock()
+	if len(s.window) < summaryWindow {
+		s.window = append(s.window, v)
+	} else {
+		s.window[s.pos] = v
+		s.pos = (s.pos + 1) % summaryWindow
+	}
+	s.mx.Unlock()
+	s.observe(v)
+}
+
+// quantiles 根据最近的观测值计算分位数
+func (s *series) quantiles(objectives []float64) []float64 {
+	s.mx.Lock()
+	sorted := append([]float64(nil), s.window...)
+	s.mx.Unlock()
+	sort.Float64s(sorted)
+	result := make([]float64, len(objectives))
+	for i, q := range objectives {
+		if len(sorted) == 0 {
+			result[i] = math.NaN()
+			continue
+		}
+		idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		result[i] = sorted[idx]
+	}
+	return result
+}
+
+func (s *series) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+type vec struct {
+	name       string
+	help       string
+	typ        Type
+	labels     []string
+	buckets    []float64
+	objectives []float64
+	maxSeries  int //0:不限制
+	dropped    uint64
+	mx         sync.RWMutex
+	series     map[string]*series
+}
+
+func newVec(name, help string, typ Type, labels []string) *vec {
+	v := &vec{name: name, help: help, typ: typ, labels: labels, series: map[string]*series{}}
+	defaultRegistry.add(v)
+	return v
+}
+
+func (v *vec) with(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + " label values not match labels")
+	}
+	s, ok := v.tryWith(values)
+	if !ok {
+		panic("metrics: " + v.name + " too many series")
+	}
+	return s
+}
+
+// tryWith 序列数量超过maxSeries时返回false
+func (v *vec) tryWith(values []string) (*series, bool) {
+	key := strings.Join(values, "\xff")
+	v.mx.RLock()
+	s, ok := v.series[key]
+	v.mx.RUnlock()
+	if ok {
+		return s, true
+	}
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	if s, ok = v.series[key]; !ok {
+		if v.maxSeries > 0 && len(v.series) >= v.maxSeries {
+			atomic.AddUint64(&v.dropped, 1)
+			return nil, false
+		}
+		s = &series{values: append([]string(nil), values...)}
+		if v.typ == TypeHistogram {
+			s.buckets = make([]uint64, len(v.buckets)+1)
+		}
+		v.series[key] = s
+	}
+	return s, true
+}
+
+// snapshot 按标签值排序的序列
+func (v *vec) snapshot() []*series {
+	v.mx.RLock()
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	v.mx.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+	return all
+}
+
+// CounterVec 只增不减的计数
+type CounterVec struct{ v *vec }
+
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{v: newVec(name, help, TypeCounter, labels)}
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	return &Counter{s: c.v.with(values)}
+}
+
+type Counter struct{ s *series }
+
+func (c *Counter) Inc() {
+	c.s.add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.s.add(v)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.s.value()
+}
+
+// GaugeVec 可增减的数值
+type GaugeVec struct{ v *vec }
+
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{v: newVec(name, help, TypeGauge, labels)}
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{s: g.v.with(values)}
+}
+
+type Gauge struct{ s *series }
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.s.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	g.s.add(v)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.s.value()
+}
+
+// HistogramVec 数值分布(如耗时)
+type HistogramVec struct{ v *vec }
+
+// NewHistogram buckets为空时使用DefaultBuckets
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := newVec(name, help, TypeHistogram, labels)
+	v.buckets = sortedCopy(buckets, DefaultBuckets)
+	return &HistogramVec{v: v}
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{s: h.v.with(values), buckets: h.v.buckets}
+}
+
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.s.buckets[i], 1)
+	h.s.observe(v)
+}
+
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.s.count)
+}
+
+// SummaryVec 最近观测值的分位数
+type SummaryVec struct{ v *vec }
+
+// NewSummary objectives为空时使用DefaultObjectives
+func NewSummary(name, help string, objectives []float64, labels ...string) *SummaryVec {
+	v := newVec(name, help, TypeSummary, labels)
+	v.objectives = sortedCopy(objectives, DefaultObjectives)
+	return &SummaryVec{v: v}
+}
+
+func (sv *SummaryVec) With(values ...string) *Summary {
+	return &Summary{s: sv.v.with(values)}
+}
+
+type Summary struct{ s *series }
+
+func (s *Summary) Observe(v float64) {
+	s.s.record(v)
+}
+
+func sortedCopy(values, defaults []float64) []float64 {
+	if len(values) == 0 {
+		values = defaults
+	}
+	values = append([]float64(nil), values...)
+	sort.Float64s(values)
+	return values
+}
+
+func loadUint(p *uint64) uint64 {
+	return atomic.LoadUint64(p)
+}
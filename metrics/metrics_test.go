@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	requests := NewCounter("test_requests_total", "Total requests.", "route", "status")
+	duration := NewHistogram("test_duration_seconds", "", []float64{0.1, 1}, "route")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requests.With(`/a"b`, "200").Inc()
+		}()
+	}
+	wg.Wait()
+	duration.With("/a").Observe(0.05)
+	duration.With("/a").Observe(0.5)
+	duration.With("/a").Observe(5)
+	Register("test", func() []Family {
+		return []Family{{Name: "test_pool_open", Type: TypeGauge, Labels: []string{"db"}, Samples: []Sample{{Values: []string{"main"}, Value: 3}}}}
+	})
+	Register("test2", func() []Family {
+		return []Family{{Name: "test_pool_open", Type: TypeGauge, Labels: []string{"db"}, Samples: []Sample{{Values: []string{"log"}, Value: 1}}}}
+	})
+	defer Unregister("test")
+	defer Unregister("test2")
+
+	buf := &bytes.Buffer{}
+	if err := WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# HELP test_requests_total Total requests.",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/a\"b",status="200"} 10`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{route="/a",le="0.1"} 1`,
+		`test_duration_seconds_bucket{route="/a",le="1"} 2`,
+		`test_duration_seconds_bucket{route="/a",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="/a"} 5.55`,
+		`test_duration_seconds_count{route="/a"} 3`,
+		`test_pool_open{db="main"} 3`,
+		`test_pool_open{db="log"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	if strings.Count(out, "# TYPE test_pool_open gauge") != 1 {
+		t.Errorf("collector families should be merged:\n%s", out)
+	}
+}
+
+func TestGetOrCreate(t *testing.T) {
+	orders, err := GetOrCreate(Opts{Name: "test_orders_total", Type: TypeCounter, Labels: []string{"status"}, MaxSeries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove("test_orders_total")
+	again, err := GetOrCreate(Opts{Name: "test_orders_total", Type: TypeCounter, Labels: []string{"status"}})
+	if err != nil || again.v != orders.v {
+		t.Fatalf("expect same metric, got %v", err)
+	}
+	if _, err = GetOrCreate(Opts{Name: "test_orders_total", Type: TypeGauge, Labels: []string{"status"}}); err == nil {
+		t.Error("type conflict should fail")
+	}
+	for _, opts := range []Opts{
+		{Name: "1bad", Type: TypeCounter},
+		{Name: "test_bad_label", Type: TypeCounter, Labels: []string{"le"}},
+		{Name: "test_bad_quantile", Type: TypeSummary, Objectives: []float64{1.5}},
+	} {
+		if _, err = GetOrCreate(opts); err == nil {
+			t.Errorf("%v should be invalid", opts)
+		}
+	}
+	if err = orders.Add(-1, "ok"); err == nil {
+		t.Error("counter should not decrease")
+	}
+	if err = orders.Set(1, "ok"); err == nil {
+		t.Error("counter should not support set")
+	}
+	_ = orders.Add(1, "ok")
+	_ = orders.Add(1, "failed")
+	if err = orders.Add(1, "canceled"); !errors.Is(err, ErrTooManySeries) {
+		t.Errorf("expect too many series, got %v", err)
+	}
+
+	latency, err := GetOrCreate(Opts{Name: "test_latency", Type: TypeSummary, Objectives: []float64{0.5, 0.9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove("test_latency")
+	for i := 1; i <= 100; i++ {
+		_ = latency.Observe(float64(i))
+	}
+
+	file := filepath.Join(t.TempDir(), "app.prom")
+	if err = WriteFile(file, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`test_orders_total{status="ok"} 1`,
+		`lightbox_metrics_dropped_series_total{metric="test_orders_total"} 1`,
+		"# TYPE test_latency summary",
+		`test_latency{quantile="0.5"} 50`,
+		`test_latency{quantile="0.9"} 90`,
+		`test_latency_sum 5050`,
+		`test_latency_count 100`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, data)
+		}
+	}
+
+	if err = WriteFile(file, "test_orders"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `test_orders_total{status="ok"} 1`) || strings.Contains(string(data), "test_latency") ||
+		strings.Contains(string(data), "lightbox_metrics_dropped_series_total") {
+		t.Errorf("expect only metrics with the prefix:\n%s", data)
+	}
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type registry struct {
+	mx         sync.RWMutex
+	vecs       []*vec
+	collectors map[string]Collector
+}
+
+var defaultRegistry = &registry{collectors: map[string]Collector{}}
+
+func (r *registry) add(v *vec) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, exists := range r.vecs {
+		if exists.name == v.name {
+			panic("metrics: duplicate metric " + v.name)
+		}
+	}
+	r.vecs = append(r.vecs, v)
+}
+
+// Register 注册采集器,key相同时替换
+func Register(key string, c Collector) {
+	defaultRegistry.mx.Lock()
+	defer defaultRegistry.mx.Unlock()
+	defaultRegistry.collectors[key] = c
+}
+
+// Unregister 删除采集器
+func Unregister(key string) {
+	defaultRegistry.mx.Lock()
+	defer defaultRegistry.mx.Unlock()
+	delete(defaultRegistry.collectors, key)
+}
+
+// WriteText 以Prometheus文本格式(0.0.4)输出所有指标
+func WriteText(w io.Writer) error {
+	return writeText(w, "")
+}
+
+// writeText prefix不为空时只输出名称以prefix开头的运行时定义的指标,不包括采集器
+func writeText(w io.Writer, prefix string) error {
+	r := defaultRegistry
+	r.mx.RLock()
+	vecs := make([]*vec, 0, len(r.vecs))
+	for _, v := range r.vecs {
+		if strings.HasPrefix(v.name, prefix) {
+			vecs = append(vecs, v)
+		}
+	}
+	keys := make([]string, 0, len(r.collectors))
+	for k := range r.collectors {
+		if prefix == "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	collectors := make([]Collector, 0, len(keys))
+	for _, k := range keys {
+		collectors = append(collectors, r.collectors[k])
+	}
+	r.mx.RUnlock()
+
+	//采集器返回的同名指标合并输出
+	var (
+		families []*Family
+		byName   = map[string]*Family{}
+	)
+	for _, c := range collectors {
+		for _, f := range c() {
+			f := f
+			if exists, ok := byName[f.Name]; ok {
+				exists.Samples = append(exists.Samples, f.Samples...)
+				continue
+			}
+			byName[f.Name] = &f
+			families = append(families, &f)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	sort.Slice(vecs, func(i, j int) bool { return vecs[i].name < vecs[j].name })
+	for _, v := range vecs {
+		all := v.snapshot()
+		if len(all) == 0 {
+			continue
+		}
+		writeHeader(bw, v.name, v.help, v.typ)
+		for _, s := range all {
+			switch v.typ {
+			case TypeCounter, TypeGauge:
+				writeSample(bw, v.name, v.labels, s.values, "", "", s.value())
+				continue
+			case TypeSummary:
+				for i, q := range s.quantiles(v.objectives) {
+					writeSample(bw, v.name, v.labels, s.values, "quantile", formatFloat(v.objectives[i]), q)
+				}
+				writeSample(bw, v.name+"_sum", v.labels, s.values, "", "", math.Float64frombits(loadUint(&s.sumBits)))
+				writeSample(bw, v.name+"_count", v.labels, s.values, "", "", float64(loadUint(&s.count)))
+				continue
+			}
+			var cumulative uint64
+			for i, le := range v.buckets {
+				cumulative += loadUint(&s.buckets[i])
+				writeSample(bw, v.name+"_bucket", v.labels, s.values, "le", formatFloat(le), float64(cumulative))
+			}
+			cumulative += loadUint(&s.buckets[len(v.buckets)])
+			writeSample(bw, v.name+"_bucket", v.labels, s.values, "le", "+Inf", float64(cumulative))
+			writeSample(bw, v.name+"_sum", v.labels, s.values, "", "", math.Float64frombits(loadUint(&s.sumBits)))
+			writeSample(bw, v.name+"_count", v.labels, s.values, "", "", float64(loadUint(&s.count)))
+		}
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		writeHeader(bw, f.Name, f.Help, f.Type)
+		for _, s := range f.Samples {
+			writeSample(bw, f.Name, f.Labels, s.Values, "", "", s.Value)
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler /metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func writeHeader(w *bufio.Writer, name, help string, typ Type) {
+	if help != "" {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	}
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+var labelEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	_, _ = w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		_ = w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			v := ""
+			if i < len(values) {
+				v = values[i]
+			}
+			_, _ = fmt.Fprintf(w, `%s="%s"`, l, labelEscaper.Replace(v))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, `%s="%s"`, extraLabel, extraValue)
+		}
+		_ = w.WriteByte('}')
+	}
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(formatFloat(value))
+	_ = w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -7,14 +7,17 @@ import (
 	"github.com/d5/tengo/v2"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
+	"io"
 	"io/fs"
 	"lightbox/env"
 	"lightbox/ext/transpile"
 	"lightbox/ext/util"
 	"lightbox/ext/vfs"
+	"lightbox/metrics"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,7 +43,7 @@ func NewHook(signal Signal, fn func(applet *Applet) error) SignalHookFn {
 	return func(applet *Applet, sig Signal) {
 		if sig == signal {
 			if err := fn(applet); err != nil {
-				applet.Logger.Error("call hook error", err)
+				applet.Logger().Error("call hook error", err)
 			}
 		}
 	}
@@ -64,14 +67,28 @@ type Applet struct {
 	Option
 	modules             *moduleGroup     //module
 	Context             *env.Environment //应用执行环境(实例容器、变量等)
-	Logger              *log.Entry       //日志入口
+	logger              atomic.Value     //日志入口(*log.Entry),SetLogOption时替换
 	util.CompileService                  //编译服务
 	transpiler          transpile.Group  //转译服务
 	hooks               []SignalHookFn   //applet 生命周期的hooks
 	//pool                sync.Pool
-	config   map[string]interface{} //应用配置
-	mx       sync.Mutex
-	initOnce sync.Once
+	config       map[string]interface{} //应用配置
+	mx           sync.Mutex
+	initOnce     sync.Once
+	initialized  bool
+	stopped      bool
+	healthChecks sync.Map //健康检查(name->*healthCheck)
+	logCloser    io.Closer
+	baseDir      string //磁盘上的根目录(数据文件的相对路径基于该目录)
+}
+
+// Logger 日志入口,SetLogOption之后返回新的日志
+func (app *Applet) Logger() *log.Entry {
+	return app.logger.Load().(*log.Entry)
+}
+
+func (app *Applet) setLogger(entry *log.Entry) {
+	app.logger.Store(entry)
 }
 
 // WithModule 注册模块
@@ -126,10 +143,15 @@ func (app *Applet) Stat(name string) (fs.FileInfo, error) {
 }
 
 func (app *Applet) Shutdown(reason string) {
-	entry := log.WithField("sandbox", app.Name)
+	entry := app.Logger()
 	entry.WithField("reason", reason).Info("shutting down")
 	app.DoNotify(SigStop)
+	app.mx.Lock()
+	app.stopped = true
+	app.mx.Unlock()
 	entry.Info("stopped")
+	metrics.Unregister(app.metricsKey())
+	app.closeLogger()
 
 }
 
@@ -139,20 +161,27 @@ func New(opt Option) (*Applet, error) {
 	}
 	app := &Applet{
 		Option:  opt,
-		Logger:  log.WithField("sandbox", opt.Name),
 		modules: &moduleGroup{},
 	}
+	app.setLogger(log.WithField("sandbox", opt.Name))
 	app.CompileService = util.NewScriptCache(time.Second*10, app.fileSystem, app.Compile)
+	metrics.Register(app.metricsKey(), app.collectCache)
 	//注册全局的transpiler
 	app.WithTranspiler(transpile.G...)
 
+	if opt.Log != nil {
+		if err := app.SetLogOption(opt.Log); err != nil {
+			return nil, err
+		}
+	}
+
 	app.Context = new(env.Environment)
 	//继承自系统的所有环境变量(默认不继承，如果真需要继承，则由sandbox之外的控制器负责)
 	//初始化私有环境变量
 	for k, v := range opt.Environ {
 		app.Context.Set(k, v)
 	}
-	app.Logger.WithField("env", opt.Environ).Info("initialize environment")
+	app.Logger().WithField("env", opt.Environ).Info("initialize environment")
 	if v, ok := env.Get[string]("profile"); !ok || v == "" {
 		app.Context.Set("profile", "test")
 	}
@@ -163,7 +192,11 @@ func New(opt Option) (*Applet, error) {
 	return app, nil
 }
 func NewWithDir(name string, root string) (*Applet, error) {
-	return NewWithFS(name, os.DirFS(root))
+	app, err := NewWithFS(name, os.DirFS(root))
+	if err == nil {
+		app.SetBaseDir(root)
+	}
+	return app, err
 }
 func NewWithFS(name string, f fs.FS) (*Applet, error) {
 	return New(Option{
@@ -189,7 +222,19 @@ var configDir = []string{
 
 func (app *Applet) Initialize() {
 	app.initOnce.Do(func() {
+		if app.Option.Log == nil {
+			opt, err := app.logOptionFromConfig()
+			if err == nil && opt != nil {
+				err = app.SetLogOption(opt)
+			}
+			if err != nil {
+				app.Logger().Error("initialize applet logger error:", err)
+			}
+		}
 		app.DoNotify(SigInitialized)
+		app.mx.Lock()
+		app.initialized = true
+		app.mx.Unlock()
 	})
 }
 
@@ -198,7 +243,7 @@ func (app *Applet) Config() map[string]interface{} {
 	defer app.mx.Unlock()
 	if app.config == nil {
 		var allConfig []map[string]interface{}
-		logger := log.WithField("sandbox", app.Name)
+		logger := app.Logger()
 		//读取配置文件,有限读取指定profile
 		for _, cfgName := range configFileName {
 			realCfg, err := app.Context.Parse(cfgName)
@@ -293,6 +338,9 @@ func (app *Applet) Compile(src []byte, placeHolder util.PlaceHolders, fileName s
 		return nil, err
 	}
 	script := tengo.NewScriptWith(src, fileName, app.DefaultExt)
+	if err = script.Add(ExecVar, nil); err != nil {
+		return nil, err
+	}
 	for k, _ := range placeHolder {
 		//编译时，只设置占位符(变量定义，不做实际的值)
 		if err := script.Add(k, nil); err != nil {
@@ -303,11 +351,13 @@ func (app *Applet) Compile(src []byte, placeHolder util.PlaceHolders, fileName s
 	return script.Compile()
 }
 
-func (app *Applet) RunFileContext(ctx context.Context, fileName string, args map[string]interface{}) (*tengo.Compiled, error) {
+func (app *Applet) RunFileContext(ctx context.Context, fileName string, args map[string]interface{}) (compiled *tengo.Compiled, err error) {
+	start := time.Now()
+	defer func() { app.observeRun(fileName, start, err) }()
 	if args == nil {
 		args = map[string]interface{}{}
 	}
-	compiled, err := app.CompileService.GetCompiled(fileName, args)
+	compiled, err = app.CompileService.GetCompiled(fileName, args)
 	if err != nil {
 		return compiled, err
 	}
@@ -316,7 +366,7 @@ func (app *Applet) RunFileContext(ctx context.Context, fileName string, args map
 			return nil, err
 		}
 	}
-	err = compiled.RunContext(ctx)
+	err = app.newExec(ctx, nil).run(compiled, true)
 	return compiled, err
 }
 
@@ -324,11 +374,13 @@ func (app *Applet) RunFile(fileName string, args map[string]interface{}) (*tengo
 	return app.RunFileContext(context.Background(), fileName, args)
 }
 
-func (app *Applet) RunContext(ctx context.Context, src []byte, args map[string]interface{}, fileName string) (*tengo.Compiled, error) {
+func (app *Applet) RunContext(ctx context.Context, src []byte, args map[string]interface{}, fileName string) (compiled *tengo.Compiled, err error) {
+	start := time.Now()
+	defer func() { app.observeRun(fileName, start, err) }()
 	if args == nil {
 		args = map[string]interface{}{}
 	}
-	compiled, err := app.Compile(src, args, fileName)
+	compiled, err = app.Compile(src, args, fileName)
 	if err != nil {
 		return nil, err
 	}
@@ -337,7 +389,7 @@ func (app *Applet) RunContext(ctx context.Context, src []byte, args map[string]i
 			return nil, err
 		}
 	}
-	err = compiled.RunContext(ctx)
+	err = app.newExec(ctx, nil).run(compiled, true)
 	return compiled, err
 }
 
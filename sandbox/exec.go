@@ -0,0 +1,164 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ExecVar 脚本中本次执行上下文的变量名,每个脚本编译时都会定义
+const ExecVar = "ctx"
+
+// Exec 一次脚本执行的上下文:附加了执行字段(请求id、路由、任务名等)的日志、context以及执行结束时的清理。
+// tengo的模块函数无法知道是哪个脚本在调用,Exec以变量ctx传入脚本,需要执行上下文的模块函数以它作为第一个参数,
+// 如log.info(ctx, "msg")、trace.start(ctx, "name")
+type Exec struct {
+	tengo.ObjectImpl
+	ctx      context.Context
+	fields   log.Fields
+	logger   *log.Entry
+	mx       sync.Mutex
+	cleanups []func()
+	ended    bool
+}
+
+type execKey struct{}
+
+// ExecFromContext ctx所属的脚本执行,不在脚本执行中时返回nil
+func ExecFromContext(ctx context.Context) *Exec {
+	e, _ := ctx.Value(execKey{}).(*Exec)
+	return e
+}
+
+// ExecArg 第一个参数为ctx时返回执行上下文和其余参数,否则返回nil和原参数
+func ExecArg(args []tengo.Object) (*Exec, []tengo.Object) {
+	if len(args) > 0 {
+		if e, ok := args[0].(*Exec); ok {
+			return e, args[1:]
+		}
+	}
+	return nil, args
+}
+
+// newExec 创建执行上下文,ctx属于另一个脚本执行时(如txn(ctx, script)中的脚本)合并外层的字段
+func (app *Applet) newExec(ctx context.Context, fields log.Fields) *Exec {
+	merged := log.Fields{}
+	if parent := ExecFromContext(ctx); parent != nil {
+		for k, v := range parent.fields {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	e := &Exec{fields: merged, logger: app.Logger().WithFields(merged)}
+	e.ctx = context.WithValue(ctx, execKey{}, e)
+	return e
+}
+
+// Context 本次执行的context(携带当前的span等)
+func (e *Exec) Context() context.Context {
+	return e.ctx
+}
+
+// Logger 附加了执行字段的日志
+func (e *Exec) Logger() *log.Entry {
+	return e.logger
+}
+
+// OnEnd 注册脚本执行结束时调用的函数(逆序调用),执行已结束时立即调用
+func (e *Exec) OnEnd(fn func()) {
+	e.mx.Lock()
+	if !e.ended {
+		e.cleanups = append(e.cleanups, fn)
+		e.mx.Unlock()
+		return
+	}
+	e.mx.Unlock()
+	fn()
+}
+
+func (e *Exec) end() {
+	e.mx.Lock()
+	e.ended = true
+	cleanups := e.cleanups
+	e.cleanups = nil
+	e.mx.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+// run 执行脚本,abortable时ctx取消会中断脚本(脚本在tengo创建的协程中执行);
+// 无论哪种方式,执行结束后都会调用OnEnd注册的函数
+func (e *Exec) run(compiled *tengo.Compiled, abortable bool) (err error) {
+	defer e.end()
+	//脚本不是由applet编译时没有定义ctx
+	_ = compiled.Set(ExecVar, e)
+	if abortable && e.ctx.Done() != nil {
+		return compiled.RunContext(e.ctx)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return compiled.Run()
+}
+
+func (e *Exec) TypeName() string {
+	return "exec-context"
+}
+
+func (e *Exec) String() string {
+	return fmt.Sprintf("ctx%v", e.fields)
+}
+
+func (e *Exec) Copy() tengo.Object {
+	return e
+}
+
+func (e *Exec) Equals(another tengo.Object) bool {
+	return e == another
+}
+
+func (e *Exec) IndexGet(key tengo.Object) (tengo.Object, error) {
+	name, ok := tengo.ToString(key)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	switch name {
+	case "fields":
+		return tengo.FromInterface(map[string]interface{}(e.fields))
+	}
+	return tengo.UndefinedValue, nil
+}
+
+// RunCompiledWith 在当前协程中执行已编译的脚本,ctx用于传递span等,不会中断脚本;脚本中ctx的日志附加fields
+func (app *Applet) RunCompiledWith(ctx context.Context, fields log.Fields, compiled *tengo.Compiled) (err error) {
+	start := time.Now()
+	defer func() { app.observeRun(compiledFile(compiled), start, err) }()
+	return app.newExec(ctx, fields).run(compiled, false)
+}
+
+// RunFileWith 与RunFile相同,脚本在当前协程中执行,脚本中ctx的日志附加fields
+func (app *Applet) RunFileWith(ctx context.Context, fields log.Fields, fileName string, args map[string]interface{}) (*tengo.Compiled, error) {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	start := time.Now()
+	compiled, err := app.CompileService.GetCompiled(fileName, args)
+	if err != nil {
+		app.observeRun(fileName, start, err)
+		return compiled, err
+	}
+	for k, v := range args {
+		if err = compiled.Set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, app.RunCompiledWith(ctx, fields, compiled)
+}
@@ -0,0 +1,129 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"lightbox/ext/util"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestApplet_RunCompiledWith(t *testing.T) {
+	app, err := NewWithDir("exec", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Initialize()
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	var (
+		mx      sync.Mutex
+		entries []*log.Entry
+	)
+	logger.AddHook(hookFunc(func(e *log.Entry) error {
+		mx.Lock()
+		entries = append(entries, e)
+		mx.Unlock()
+		return nil
+	}))
+	app.setLogger(logger.WithField("sandbox", app.Name))
+	emit := &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+		exec, args := ExecArg(args)
+		s, _ := tengo.ToString(args[0])
+		exec.Logger().Info(s)
+		return nil, nil
+	}}
+	src := []byte(`for i:=0;i<20;i++ { emit(ctx, id) }`)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			compiled, err := app.Compile(src, util.PlaceHolders{"emit": nil, "id": nil}, "exec")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = compiled.Set("emit", emit)
+			_ = compiled.Set("id", id)
+			if err = app.RunCompiledWith(context.Background(), log.Fields{"request_id": id}, compiled); err != nil {
+				t.Error(err)
+			}
+		}(fmt.Sprint("r", i))
+	}
+	wg.Wait()
+	if len(entries) != 80 {
+		t.Fatalf("expect 80 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Data["request_id"] != e.Message || e.Data["sandbox"] != "exec" {
+			t.Fatalf("unexpected fields %v for %s", e.Data, e.Message)
+		}
+	}
+}
+
+func TestExec_NestedFields(t *testing.T) {
+	app, err := NewWithDir("exec", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := app.newExec(context.Background(), log.Fields{"a": 1, "b": 1})
+	inner := app.newExec(outer.Context(), log.Fields{"b": 2})
+	if f := inner.Logger().Data; f["a"] != 1 || f["b"] != 2 {
+		t.Errorf("unexpected nested fields %v", f)
+	}
+	if f := outer.Logger().Data; f["b"] != 1 {
+		t.Errorf("outer fields should not change %v", f)
+	}
+	if ExecFromContext(inner.Context()) != inner || ExecFromContext(context.Background()) != nil {
+		t.Error("ctx should carry its exec")
+	}
+}
+
+func TestExec_OnEnd(t *testing.T) {
+	app, err := NewWithDir("exec", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	on := &tengo.UserFunction{Value: func(args ...tengo.Object) (tengo.Object, error) {
+		exec, args := ExecArg(args)
+		name, _ := tengo.ToString(args[0])
+		exec.OnEnd(func() { order = append(order, name) })
+		return nil, nil
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//可取消的ctx在tengo创建的协程中执行,同样需要清理
+	for _, c := range []context.Context{context.Background(), ctx} {
+		order = nil
+		if _, err = app.RunContext(c, []byte(`on(ctx, "a"); on(ctx, "b")`), map[string]interface{}{"on": on}, "on_end"); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"b", "a"}; !reflect.DeepEqual(order, want) {
+			t.Fatalf("expect %v, got %v", want, order)
+		}
+	}
+
+	exec := app.newExec(context.Background(), nil)
+	exec.end()
+	called := false
+	exec.OnEnd(func() { called = true })
+	if !called {
+		t.Error("cleanup registered after run end should be called immediately")
+	}
+}
+
+type hookFunc func(*log.Entry) error
+
+func (hookFunc) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (f hookFunc) Fire(e *log.Entry) error {
+	return f(e)
+}
@@ -0,0 +1,184 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthKind 健康检查的类型
+type HealthKind int
+
+const (
+	// HealthLive 存活检查,失败时应重启applet
+	HealthLive HealthKind = 1 << iota
+	// HealthReady 就绪检查,失败时不应接收流量
+	HealthReady
+)
+
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// DefaultHealthTimeout 单个检查的默认超时时间
+var DefaultHealthTimeout = 5 * time.Second
+
+func (k HealthKind) String() string {
+	switch k {
+	case HealthLive:
+		return "live"
+	case HealthReady:
+		return "ready"
+	case HealthLive | HealthReady:
+		return "live,ready"
+	}
+	return fmt.Sprintf("HealthKind(%d)", int(k))
+}
+
+// ParseHealthKind 解析检查类型(live/ready,多个以逗号分隔)
+func ParseHealthKind(s string) (HealthKind, error) {
+	var kind HealthKind
+	for _, k := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "live", "liveness":
+			kind |= HealthLive
+		case "ready", "readiness", "":
+			kind |= HealthReady
+		default:
+			return 0, fmt.Errorf("unknown health kind %s", k)
+		}
+	}
+	return kind, nil
+}
+
+// HealthCheckFunc 健康检查,返回nil表示健康
+type HealthCheckFunc func(ctx context.Context) error
+
+type healthCheck struct {
+	kind  HealthKind
+	check HealthCheckFunc
+}
+
+// HealthCheckResult 单个检查的结果
+type HealthCheckResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport applet的健康报告
+type HealthReport struct {
+	Name   string              `json:"name"`
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks,omitempty"`
+}
+
+func (r HealthReport) IsUp() bool {
+	return r.Status == HealthUp
+}
+
+// RegisterHealthCheck 注册(或替换)命名的健康检查,kind可以同时指定live和ready
+func (app *Applet) RegisterHealthCheck(name string, kind HealthKind, check HealthCheckFunc) {
+	app.healthChecks.Store(name, &healthCheck{kind: kind, check: check})
+}
+
+// UnregisterHealthCheck 删除健康检查
+func (app *Applet) UnregisterHealthCheck(name string) {
+	app.healthChecks.Delete(name)
+}
+
+// HealthCheckNames 已注册的健康检查
+func (app *Applet) HealthCheckNames() []string {
+	var names []string
+	app.healthChecks.Range(func(key, _ any) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// CheckHealth 并发执行指定类型的健康检查
+// applet关闭后存活与就绪检查都失败;就绪检查还要求applet已经初始化
+func (app *Applet) CheckHealth(ctx context.Context, kind HealthKind) HealthReport {
+	report := HealthReport{Name: app.Name, Status: HealthUp}
+	app.mx.Lock()
+	stopped, initialized := app.stopped, app.initialized
+	app.mx.Unlock()
+	if stopped {
+		report.Status = HealthDown
+		report.Checks = append(report.Checks, HealthCheckResult{Name: "applet", Status: HealthDown, Error: "applet stopped"})
+		return report
+	}
+	if kind&HealthReady != 0 && !initialized {
+		report.Status = HealthDown
+		report.Checks = append(report.Checks, HealthCheckResult{Name: "applet", Status: HealthDown, Error: "applet not initialized"})
+	}
+	var (
+		wg      sync.WaitGroup
+		mx      sync.Mutex
+		results []HealthCheckResult
+	)
+	app.healthChecks.Range(func(key, value any) bool {
+		hc := value.(*healthCheck)
+		if hc.kind&kind == 0 {
+			return true
+		}
+		wg.Add(1)
+		go func(name string, hc *healthCheck) {
+			defer wg.Done()
+			result := runHealthCheck(ctx, name, hc.check)
+			mx.Lock()
+			results = append(results, result)
+			mx.Unlock()
+		}(key.(string), hc)
+		return true
+	})
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	for _, r := range results {
+		if r.Status != HealthUp {
+			report.Status = HealthDown
+		}
+	}
+	report.Checks = append(report.Checks, results...)
+	return report
+}
+
+func runHealthCheck(ctx context.Context, name string, check HealthCheckFunc) (result HealthCheckResult) {
+	result = HealthCheckResult{Name: name, Status: HealthUp}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultHealthTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if ex := recover(); ex != nil {
+				done <- fmt.Errorf("panic:%v", ex)
+			}
+		}()
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result.Duration = time.Since(start).String()
+	if err != nil {
+		result.Status = HealthDown
+		result.Error = err.Error()
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApplet_CheckHealth(t *testing.T) {
+	app, err := NewWithDir("health", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.RegisterHealthCheck("ok", HealthLive|HealthReady, func(ctx context.Context) error {
+		return nil
+	})
+	app.RegisterHealthCheck("db", HealthReady, func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	if r := app.CheckHealth(context.Background(), HealthLive); !r.IsUp() {
+		t.Errorf("live should be up: %+v", r)
+	}
+	app.Initialize()
+	r := app.CheckHealth(context.Background(), HealthReady)
+	if r.IsUp() || len(r.Checks) != 2 || r.Checks[0].Name != "db" || r.Checks[0].Error != "connection refused" {
+		t.Errorf("ready should be down: %+v", r)
+	}
+	app.UnregisterHealthCheck("db")
+	if r = app.CheckHealth(context.Background(), HealthReady); !r.IsUp() {
+		t.Errorf("ready should be up: %+v", r)
+	}
+
+	app.RegisterHealthCheck("slow", HealthReady, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if r = app.CheckHealth(ctx, HealthReady); r.IsUp() {
+		t.Errorf("slow check should time out: %+v", r)
+	}
+	app.UnregisterHealthCheck("slow")
+
+	app.Shutdown("test")
+	if r = app.CheckHealth(context.Background(), HealthLive); r.IsUp() {
+		t.Errorf("live should be down after shutdown: %+v", r)
+	}
+}
+
+func TestParseHealthKind(t *testing.T) {
+	if k, err := ParseHealthKind("live,ready"); err != nil || k != HealthLive|HealthReady {
+		t.Error(k, err)
+	}
+	if _, err := ParseHealthKind("unknown"); err == nil {
+		t.Error("expect error")
+	}
+}
@@ -0,0 +1,121 @@
+package sandbox
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+)
+
+const logConfigKey = "log"
+
+// LogOption applet独立的日志设置,未设置的项使用全局日志的设置
+// 也可以在application.yml的log节点中配置(Option.Log优先),日志文件的相对路径相对于当前工作目录
+type LogOption struct {
+	File       string `json:"file,omitempty" yaml:"file"`             //日志文件,为空时输出到全局日志
+	Format     string `json:"format,omitempty" yaml:"format"`         //text/json
+	Level      string `json:"level,omitempty" yaml:"level"`           //日志级别
+	MaxSize    int    `json:"maxSize,omitempty" yaml:"maxSize"`       //单个文件最大MB(默认100)
+	MaxAge     int    `json:"maxAge,omitempty" yaml:"maxAge"`         //保留天数
+	MaxBackups int    `json:"maxBackups,omitempty" yaml:"maxBackups"` //保留文件数
+	LocalTime  bool   `json:"localTime,omitempty" yaml:"localTime"`   //使用本地时间
+	Compress   bool   `json:"compress,omitempty" yaml:"compress"`     //压缩
+}
+
+// forwardHook 把applet日志转发给全局日志的hook(日志订阅、存储等),不写入全局日志文件
+type forwardHook struct{}
+
+func (forwardHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (forwardHook) Fire(entry *log.Entry) error {
+	return log.StandardLogger().Hooks.Fire(entry.Level, entry)
+}
+
+// newLogger 根据设置创建applet的日志
+func newLogger(opt *LogOption) (*log.Logger, io.Closer, error) {
+	std := log.StandardLogger()
+	logger := log.New()
+	logger.SetLevel(std.GetLevel())
+	logger.SetFormatter(std.Formatter)
+	logger.SetOutput(std.Out)
+	logger.AddHook(forwardHook{})
+	if opt.Level != "" {
+		lv, err := log.ParseLevel(opt.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.SetLevel(lv)
+	}
+	switch strings.ToLower(opt.Format) {
+	case "":
+	case "json":
+		logger.SetFormatter(&log.JSONFormatter{})
+	case "text":
+		logger.SetFormatter(&log.TextFormatter{})
+	default:
+		return nil, nil, fmt.Errorf("unsupported log format %s", opt.Format)
+	}
+	var closer io.Closer
+	if opt.File != "" {
+		out := &lumberjack.Logger{
+			Filename:   opt.File,
+			MaxSize:    opt.MaxSize,
+			MaxAge:     opt.MaxAge,
+			MaxBackups: opt.MaxBackups,
+			LocalTime:  opt.LocalTime,
+			Compress:   opt.Compress,
+		}
+		logger.SetOutput(out)
+		closer = out
+	}
+	return logger, closer, nil
+}
+
+// SetLogOption 设置applet独立的日志,之后Applet.Logger()返回新的日志
+func (app *Applet) SetLogOption(opt *LogOption) error {
+	logger, closer, err := newLogger(opt)
+	if err != nil {
+		return err
+	}
+	app.mx.Lock()
+	old := app.logCloser
+	app.Option.Log = opt
+	app.setLogger(logger.WithField("sandbox", app.Name))
+	app.logCloser = closer
+	app.mx.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
+// logOptionFromConfig 读取application.yml中的log节点
+func (app *Applet) logOptionFromConfig() (*LogOption, error) {
+	v, ok := app.Config()[logConfigKey]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	opt := &LogOption{}
+	if err = yaml.Unmarshal(data, opt); err != nil {
+		return nil, fmt.Errorf("invalid log config:%s", err)
+	}
+	return opt, nil
+}
+
+func (app *Applet) closeLogger() {
+	app.mx.Lock()
+	closer := app.logCloser
+	app.logCloser = nil
+	app.mx.Unlock()
+	if closer != nil {
+		_ = closer.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type captureHook struct {
+	entries []*log.Entry
+}
+
+func (h *captureHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *captureHook) Fire(entry *log.Entry) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func TestApplet_SetLogOption(t *testing.T) {
+	hook := &captureHook{}
+	log.AddHook(hook)
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	global := &bytes.Buffer{}
+	out := log.StandardLogger().Out
+	log.SetOutput(global)
+	defer log.SetOutput(out)
+
+	file := filepath.Join(t.TempDir(), "noisy.log")
+	app, err := New(Option{Name: "noisy", Log: &LogOption{File: file, Format: "json", Level: "warn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Logger().Info("ignored")
+	app.Logger().WithField("k", "v").Warn("written")
+	app.Shutdown("test")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	m := map[string]interface{}{}
+	if err = json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || m["msg"] != "written" || m["sandbox"] != "noisy" || m["k"] != "v" {
+		t.Errorf("unexpected applet log: %s", data)
+	}
+	if strings.Contains(global.String(), "written") {
+		t.Errorf("applet log should not write to global log: %s", global.String())
+	}
+	forwarded := false
+	for _, e := range hook.entries {
+		if e.Message == "written" {
+			forwarded = true
+		}
+	}
+	if !forwarded {
+		t.Error("applet log should be forwarded to global hooks")
+	}
+
+	if _, err = New(Option{Name: "bad", Log: &LogOption{Level: "loud"}}); err == nil {
+		t.Error("expect invalid level error")
+	}
+}
+
+func TestApplet_LogConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	app, err := NewWithFS("cfg", fstest.MapFS{
+		"application.yml": &fstest.MapFile{Data: []byte("log:\n  file: " + file + "\n  level: error\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Initialize()
+	app.Logger().Warn("ignored")
+	app.Logger().Error("boom")
+	app.Shutdown("test")
+	data, _ := os.ReadFile(file)
+	if !strings.Contains(string(data), "boom") || strings.Contains(string(data), "ignored") {
+		t.Errorf("unexpected applet log: %s", data)
+	}
+}
+
+func TestApplet_SetLogOptionConcurrent(t *testing.T) {
+	app, err := NewWithDir("concurrent", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			app.Logger().WithField("i", i).Trace("reading logger")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err = app.SetLogOption(&LogOption{Level: "error"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if app.Logger().Logger.GetLevel() != log.ErrorLevel {
+		t.Error("logger should be replaced")
+	}
+}
@@ -0,0 +1,53 @@
+package sandbox
+
+import (
+	"github.com/d5/tengo/v2"
+	"lightbox/ext/util"
+	"lightbox/metrics"
+	"time"
+)
+
+var (
+	scriptRuns     = metrics.NewCounter("lightbox_script_runs_total", "Total number of script executions.", "sandbox", "file")
+	scriptErrors   = metrics.NewCounter("lightbox_script_errors_total", "Total number of failed script executions.", "sandbox", "file")
+	scriptDuration = metrics.NewHistogram("lightbox_script_duration_seconds", "Script execution duration in seconds.", nil, "sandbox", "file")
+)
+
+// observeRun 记录脚本执行次数、错误及耗时
+func (app *Applet) observeRun(fileName string, start time.Time, err error) {
+	scriptRuns.With(app.Name, fileName).Inc()
+	if err != nil {
+		scriptErrors.With(app.Name, fileName).Inc()
+	}
+	scriptDuration.With(app.Name, fileName).Observe(time.Since(start).Seconds())
+}
+
+// compiledFile 已编译脚本的文件名(第一个源文件为主脚本)
+func compiledFile(compiled *tengo.Compiled) string {
+	if bc := compiled.ByteCode(); bc != nil && bc.FileSet != nil && len(bc.FileSet.Files) > 0 {
+		return bc.FileSet.Files[0].Name
+	}
+	return ""
+}
+
+func (app *Applet) metricsKey() string {
+	return "sandbox/" + app.Name
+}
+
+// collectCache 脚本缓存命中统计
+func (app *Applet) collectCache() []metrics.Family {
+	cache, ok := app.CompileService.(interface{ Stats() util.CacheStats })
+	if !ok {
+		return nil
+	}
+	stats := cache.Stats()
+	family := func(name, help string, v uint64) metrics.Family {
+		return metrics.Family{Name: name, Help: help, Type: metrics.TypeCounter, Labels: []string{"sandbox"},
+			Samples: []metrics.Sample{{Values: []string{app.Name}, Value: float64(v)}}}
+	}
+	return []metrics.Family{
+		family("lightbox_script_cache_hits_total", "Compiled scripts served from cache.", stats.Hits),
+		family("lightbox_script_cache_misses_total", "Scripts compiled for the first time.", stats.Misses),
+		family("lightbox_script_cache_recompiles_total", "Scripts recompiled after modification.", stats.Recompiles),
+	}
+}
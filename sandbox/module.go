@@ -52,7 +52,7 @@ func (m *moduleRegistry) WithHookScript(scripts ...string) Registry {
 				"signal": signal,
 			})
 			if err != nil {
-				applet.Logger.Info("execute hook script error", err)
+				applet.Logger().Info("execute hook script error", err)
 			}
 		})
 	}
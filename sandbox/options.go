@@ -12,6 +12,7 @@ type Option struct {
 	Volumes    map[string]string `json:"volumes,omitempty"`    //映射卷
 	Environ    map[string]string `json:"environ,omitempty"`    //环境变量
 	Root       string            `json:"rootDir,omitempty"`    //根目录(根目录不等于实际的磁盘目录),有可能是容器的"子目录"
+	Log        *LogOption        `json:"log,omitempty"`        //独立的日志设置,为空时读取application.yml的log节点
 	fileSystem fs.FS             //文件系统
 }
 
@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathEscape 路径超出了applet的根目录
+var ErrPathEscape = errors.New("path escapes applet directory")
+
+// SetBaseDir 设置applet在磁盘上的根目录
+func (app *Applet) SetBaseDir(dir string) *Applet {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	app.baseDir = dir
+	return app
+}
+
+// BaseDir applet在磁盘上的根目录，未设置时为当前工作目录
+func (app *Applet) BaseDir() string {
+	if app.baseDir != "" {
+		return app.baseDir
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
+// ResolvePath 把路径解析为BaseDir下的绝对路径，超出BaseDir时返回ErrPathEscape
+func (app *Applet) ResolvePath(p string) (string, error) {
+	return ResolvePathIn(app.BaseDir(), p)
+}
+
+// ResolvePathIn 把路径解析为root下的绝对路径，相对路径基于root，绝对路径必须位于root下
+// 已存在的部分按符号链接的实际路径检查，root下指向root以外的链接同样视为越界
+func ResolvePathIn(root, p string) (string, error) {
+	root = filepath.Clean(root)
+	target := p
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(root, target)
+	}
+	target = filepath.Clean(target)
+	if !within(root, target) || !within(evalExisting(root), evalExisting(target)) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscape, p)
+	}
+	return target, nil
+}
+
+func within(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// evalExisting 解析路径中最深的已存在部分的符号链接，不存在的部分原样拼接
+// 无法解析的链接(如指向不存在的路径)返回空字符串，不能通过within检查
+func evalExisting(p string) string {
+	var rest []string
+	for dir := p; ; dir = filepath.Dir(dir) {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			for i := len(rest) - 1; i >= 0; i-- {
+				real = filepath.Join(real, rest[i])
+			}
+			return real
+		}
+		if _, e := os.Lstat(dir); e == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return p
+		}
+		rest = append(rest, filepath.Base(dir))
+	}
+}
@@ -0,0 +1,67 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePathIn(t *testing.T) {
+	root := filepath.FromSlash("/srv/app1")
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"data", "/srv/app1/data"},
+		{"./a/../b", "/srv/app1/b"},
+		{"", "/srv/app1"},
+		{"/srv/app1/kv", "/srv/app1/kv"},
+		{"../app2", ""},
+		{"a/../../app2", ""},
+		{"/srv/app10", ""},
+		{"/etc/passwd", ""},
+		{"..data", "/srv/app1/..data"},
+	}
+	for _, c := range cases {
+		got, err := ResolvePathIn(root, c.path)
+		if c.want == "" {
+			if !errors.Is(err, ErrPathEscape) {
+				t.Errorf("%s: expect escape error, got %s %v", c.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(c.want) {
+			t.Errorf("%s: want %s, got %s %v", c.path, c.want, got, err)
+		}
+	}
+}
+
+func TestResolvePathInSymlink(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"out": outside, "dangling": filepath.Join(outside, "missing"), "in": filepath.Join(root, "data")} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip(err)
+		}
+	}
+	for _, p := range []string{"out", "out/db", "out/a/b", "dangling"} {
+		if got, err := ResolvePathIn(root, p); !errors.Is(err, ErrPathEscape) {
+			t.Errorf("%s: expect escape error, got %s %v", p, got, err)
+		}
+	}
+	for _, p := range []string{"in", "in/db", "data/db"} {
+		if _, err := ResolvePathIn(root, p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ResolvePathIn(link, "db"); err != nil || got != filepath.Join(link, "db") {
+		t.Errorf("symlinked root: got %s %v", got, err)
+	}
+}
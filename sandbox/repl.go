@@ -0,0 +1,147 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/parser"
+	"io"
+)
+
+const replPrintln = "__repl_println__"
+
+// REPL 交互式执行环境,使用applet的转译器及模块,多次执行之间保留全局变量
+type REPL struct {
+	app         *Applet
+	fileSet     *parser.SourceFileSet
+	symbolTable *tengo.SymbolTable
+	globals     []tengo.Object
+	constants   []tengo.Object
+	out         io.Writer
+}
+
+func NewREPL(app *Applet, out io.Writer) *REPL {
+	app.Initialize()
+	r := &REPL{
+		app:         app,
+		fileSet:     parser.NewFileSet(),
+		symbolTable: tengo.NewSymbolTable(),
+		globals:     make([]tengo.Object, tengo.GlobalsSize),
+		out:         out,
+	}
+	for idx, fn := range tengo.GetAllBuiltinFunctions() {
+		r.symbolTable.DefineBuiltin(idx, fn.Name)
+	}
+	symbol := r.symbolTable.Define(replPrintln)
+	r.globals[symbol.Index] = &tengo.UserFunction{
+		Name: "println",
+		Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
+			var printArgs []interface{}
+			for _, arg := range args {
+				if _, isUndefined := arg.(*tengo.Undefined); isUndefined {
+					printArgs = append(printArgs, "<undefined>")
+				} else {
+					s, _ := tengo.ToString(arg)
+					printArgs = append(printArgs, s)
+				}
+			}
+			printArgs = append(printArgs, "\n")
+			_, _ = fmt.Fprint(r.out, printArgs...)
+			return
+		},
+	}
+	return r
+}
+
+// SetOutput 设置表达式结果的输出
+func (r *REPL) SetOutput(out io.Writer) {
+	r.out = out
+}
+
+// RuntimeError 执行代码时的错误(区别于转译、编译错误)
+type RuntimeError struct {
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Eval 转译、编译并执行一段代码,表达式及赋值语句的结果会输出到out
+func (r *REPL) Eval(ctx context.Context, src []byte) error {
+	code, err := r.app.Transpile(src)
+	if err != nil {
+		return err
+	}
+	srcFile := r.fileSet.AddFile("repl", -1, len(code))
+	p := parser.NewParser(srcFile, code, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return err
+	}
+	file = addPrints(file)
+	c := tengo.NewCompiler(srcFile, r.symbolTable, r.constants, r.app.modules, nil)
+	if err = c.Compile(file); err != nil {
+		return err
+	}
+	bytecode := c.Bytecode()
+	machine := tengo.NewVM(bytecode, r.globals, -1)
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("%v", r)
+			}
+		}()
+		done <- machine.Run()
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			err = &RuntimeError{Err: err}
+		}
+	case <-ctx.Done():
+		machine.Abort()
+		<-done
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	r.constants = bytecode.Constants
+	return nil
+}
+
+// addPrints 输出表达式及赋值语句的结果
+func addPrints(file *parser.File) *parser.File {
+	var stmts []parser.Stmt
+	for _, s := range file.Stmts {
+		switch s := s.(type) {
+		case *parser.ExprStmt:
+			stmts = append(stmts, &parser.ExprStmt{
+				Expr: &parser.CallExpr{
+					Func: &parser.Ident{Name: replPrintln},
+					Args: []parser.Expr{s.Expr},
+				},
+			})
+		case *parser.AssignStmt:
+			stmts = append(stmts, s)
+			stmts = append(stmts, &parser.ExprStmt{
+				Expr: &parser.CallExpr{
+					Func: &parser.Ident{Name: replPrintln},
+					Args: s.LHS,
+				},
+			})
+		default:
+			stmts = append(stmts, s)
+		}
+	}
+	return &parser.File{
+		InputFile: file.InputFile,
+		Stmts:     stmts,
+	}
+}
@@ -0,0 +1,38 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestREPL_Eval(t *testing.T) {
+	app, err := NewWithDir("repl", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	r := NewREPL(app, out)
+	for _, line := range []string{"a := 1 + 2", "b := a * 10", "b"} {
+		if err = r.Eval(context.Background(), []byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.String() != "3\n30\n30\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	var runtimeErr *RuntimeError
+	if err = r.Eval(context.Background(), []byte("c")); err == nil || errors.As(err, &runtimeErr) {
+		t.Errorf("expect compile error, got %v", err)
+	}
+	if err = r.Eval(context.Background(), []byte("x := 1 / 0")); !errors.As(err, &runtimeErr) {
+		t.Errorf("expect runtime error, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = r.Eval(ctx, []byte("for {}")); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBuffer   = 4096
+	defaultBatch    = 256
+	defaultInterval = 2 * time.Second
+)
+
+// Config 追踪导出设置,span以OTLP/JSON格式写入文件(每行一个请求)或发送到collector
+type Config struct {
+	Service       string            //service.name,默认lego
+	File          string            //导出文件
+	Endpoint      string            //OTLP/HTTP collector地址,如http://localhost:4318(默认路径/v1/traces)
+	Headers       map[string]string //发送到collector的请求头
+	BufferSize    int               //缓冲区满时丢弃新的span
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+type spanWriter interface {
+	write(data []byte) error
+	close() error
+}
+
+type exporter struct {
+	cfg     Config
+	writers []spanWriter
+	ch      chan *Span
+	closed  chan struct{}
+	done    chan struct{}
+	dropped uint64
+	failed  uint64
+}
+
+var (
+	current *exporter
+	enabled int32
+	mx      sync.Mutex
+)
+
+// Enabled 是否已启用追踪
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Setup 启用追踪
+func Setup(cfg Config) error {
+	if cfg.File == "" && cfg.Endpoint == "" {
+		return errors.New("require trace file or endpoint")
+	}
+	if cfg.Service == "" {
+		cfg.Service = "lego"
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultBuffer
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatch
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultInterval
+	}
+	e := &exporter{
+		cfg:    cfg,
+		ch:     make(chan *Span, cfg.BufferSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		e.writers = append(e.writers, &fileWriter{f: f})
+	}
+	if cfg.Endpoint != "" {
+		url := strings.TrimSuffix(cfg.Endpoint, "/")
+		if !strings.HasSuffix(url, "/v1/traces") {
+			url += "/v1/traces"
+		}
+		e.writers = append(e.writers, &httpWriter{url: url, headers: cfg.Headers, client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	if current != nil {
+		e.closeWriters()
+		return errors.New("tracing already enabled")
+	}
+	current = e
+	atomic.StoreInt32(&enabled, 1)
+	go e.run()
+	return nil
+}
+
+// Shutdown 导出缓冲区中的span后停止追踪
+func Shutdown() error {
+	mx.Lock()
+	e := current
+	current = nil
+	atomic.StoreInt32(&enabled, 0)
+	mx.Unlock()
+	if e == nil {
+		return nil
+	}
+	close(e.closed)
+	<-e.done
+	return e.closeWriters()
+}
+
+// Dropped 缓冲区满时丢弃及导出失败的span数量
+func Dropped() uint64 {
+	mx.Lock()
+	defer mx.Unlock()
+	if current == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&current.dropped) + atomic.LoadUint64(&current.failed)
+}
+
+func export(s *Span) {
+	mx.Lock()
+	e := current
+	mx.Unlock()
+	if e == nil {
+		return
+	}
+	select {
+	case e.ch <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, e.cfg.BatchSize)
+	for {
+		select {
+		case s := <-e.ch:
+			batch = append(batch, s)
+			if len(batch) < e.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.closed:
+			e.flush(append(batch, e.drain()...))
+			return
+		}
+		e.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain 取出缓冲区中剩余的span
+func (e *exporter) drain() []*Span {
+	var spans []*Span
+	for {
+		select {
+		case s := <-e.ch:
+			spans = append(spans, s)
+		default:
+			return spans
+		}
+	}
+}
+
+func (e *exporter) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	data, err := json.Marshal(encodeOTLP(e.cfg.Service, batch))
+	if err != nil {
+		atomic.AddUint64(&e.failed, uint64(len(batch)))
+		return
+	}
+	for _, w := range e.writers {
+		if err = w.write(data); err != nil {
+			atomic.AddUint64(&e.failed, uint64(len(batch)))
+			_, _ = fmt.Fprintln(os.Stderr, "export spans error:", err)
+		}
+	}
+}
+
+func (e *exporter) closeWriters() error {
+	var errs []string
+	for _, w := range e.writers {
+		if err := w.close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ";"))
+	}
+	return nil
+}
+
+type fileWriter struct {
+	f *os.File
+}
+
+func (w *fileWriter) write(data []byte) error {
+	_, err := w.f.Write(append(data, '\n'))
+	return err
+}
+
+func (w *fileWriter) close() error {
+	return w.f.Close()
+}
+
+type httpWriter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (w *httpWriter) write(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post spans to %s: %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+func (w *httpWriter) close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+// OTLP/JSON(ExportTraceServiceRequest),trace id/span id使用hex编码,64位整数使用字符串
+
+type otlpValue map[string]interface{}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpValue  `json:"status"`
+}
+
+func attrValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{"stringValue": v}
+	case bool:
+		return otlpValue{"boolValue": v}
+	case int:
+		return otlpValue{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return otlpValue{"intValue": strconv.FormatInt(v, 10)}
+	case uint64:
+		return otlpValue{"intValue": strconv.FormatUint(v, 10)}
+	case float64:
+		return otlpValue{"doubleValue": v}
+	default:
+		return otlpValue{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func encodeSpan(s *Span) otlpSpan {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	o := otlpSpan{
+		TraceID:           s.ctx.TraceID.String(),
+		SpanID:            s.ctx.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpValue{"code": s.status},
+	}
+	if s.parent.IsValid() {
+		o.ParentSpanID = s.parent.String()
+	}
+	if s.statusMsg != "" {
+		o.Status["message"] = s.statusMsg
+	}
+	keys := make([]string, 0, len(s.attrs))
+	for k := range s.attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o.Attributes = append(o.Attributes, otlpAttr{Key: k, Value: attrValue(s.attrs[k])})
+	}
+	return o
+}
+
+func encodeOTLP(service string, spans []*Span) map[string]interface{} {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, encodeSpan(s))
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttr{{Key: "service.name", Value: attrValue(service)}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "lightbox"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const HeaderTraceparent = "traceparent"
+
+// Traceparent W3C traceparent: 00-{trace-id}-{parent-id}-{flags}
+func (c SpanContext) Traceparent() string {
+	if !c.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceID, c.SpanID, flags)
+}
+
+// ParseTraceparent 解析W3C traceparent,格式错误时返回false
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var c SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return c, false
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil {
+		return c, false
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil {
+		return c, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return c, false
+	}
+	c.Sampled = flags[0]&1 == 1
+	return c, c.IsValid()
+}
+
+// Inject 把span写入请求头
+func Inject(s *Span, header http.Header) {
+	if tp := s.Context().Traceparent(); tp != "" {
+		header.Set(HeaderTraceparent, tp)
+	}
+}
+
+// Extract 从请求头读取上游的span
+func Extract(header http.Header) SpanContext {
+	c, _ := ParseTraceparent(header.Get(HeaderTraceparent))
+	return c
+}
@@ -0,0 +1,199 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SpanKind 与OTLP的SpanKind一致
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota + 1
+	KindServer
+	KindClient
+	KindProducer
+	KindConsumer
+)
+
+const (
+	StatusUnset = iota
+	StatusOK
+	StatusError
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext 跨进程传递的追踪信息
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// Span 一次操作的耗时记录,nil的Span的所有方法都不做任何操作(未启用追踪时)
+type Span struct {
+	mx        sync.Mutex
+	ctx       SpanContext
+	parent    SpanID
+	name      string
+	kind      SpanKind
+	start     time.Time
+	end       time.Time
+	attrs     map[string]interface{}
+	status    int
+	statusMsg string
+	ended     bool
+}
+
+type spanKey struct{}
+
+// ContextWithSpan 返回携带span的ctx,下游以它创建子span
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext ctx携带的span,没有时返回nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start 创建span,父span为ctx携带的span
+func Start(ctx context.Context, name string, kind SpanKind) *Span {
+	if !Enabled() {
+		return nil
+	}
+	return StartWithParent(name, kind, FromContext(ctx).Context())
+}
+
+// StartWithParent 以指定的父span(如请求头traceparent)创建span
+func StartWithParent(name string, kind SpanKind, parent SpanContext) *Span {
+	if !Enabled() {
+		return nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		s.ctx.TraceID = parent.TraceID
+		s.ctx.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = true
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	return s
+}
+
+// Context 用于传递到下游的追踪信息
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttr 设置属性
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = value
+}
+
+// SetError 设置错误状态,err为nil时不做操作
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.status = StatusError
+	s.statusMsg = err.Error()
+}
+
+// SetStatus 设置状态
+func (s *Span) SetStatus(code int, msg string) {
+	if s == nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.status = code
+	s.statusMsg = msg
+}
+
+// End 结束并导出span
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mx.Lock()
+	if s.ended {
+		s.mx.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mx.Unlock()
+	if s.ctx.Sampled {
+		export(s)
+	}
+}
+
+// Abandon 结束没有调用End的span(脚本start后没有end)并标记为错误,已结束时不做操作
+func (s *Span) Abandon() {
+	if s == nil {
+		return
+	}
+	s.mx.Lock()
+	if s.ended {
+		s.mx.Unlock()
+		return
+	}
+	s.status = StatusError
+	s.statusMsg = "span not ended"
+	s.mx.Unlock()
+	s.End()
+}
+
+func (s *Span) String() string {
+	if s == nil {
+		return "span[disabled]"
+	}
+	return fmt.Sprintf("span[%s,trace:%s,id:%s]", s.name, s.ctx.TraceID, s.ctx.SpanID)
+}
@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !sc.Sampled {
+		t.Fatalf("parse traceparent failed: %v", sc)
+	}
+	if got := sc.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("unexpected traceparent %s", got)
+	}
+	for _, v := range []string{"", "00-xyz-00f067aa0ba902b7-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x"} {
+		if _, ok := ParseTraceparent(v); ok {
+			t.Errorf("%q should be invalid", v)
+		}
+	}
+}
+
+func TestSpan_Disabled(t *testing.T) {
+	span := Start(context.Background(), "noop", KindInternal)
+	if span != nil || FromContext(ContextWithSpan(context.Background(), span)) != nil {
+		t.Fatal("span should be nil when tracing disabled")
+	}
+	span.SetAttr("k", "v")
+	span.SetError(errors.New("x"))
+	span.End()
+	header := http.Header{}
+	Inject(span, header)
+	if header.Get(HeaderTraceparent) != "" {
+		t.Error("disabled span should not be injected")
+	}
+}
+
+func TestSpan_Export(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.json")
+	if err := Setup(Config{Service: "test", File: file}); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	server := StartWithParent("HTTP GET /", KindServer, Extract(header))
+	ctx := ContextWithSpan(context.Background(), server)
+	if FromContext(ctx) != server {
+		t.Fatal("ctx should carry the server span")
+	}
+	child := Start(ctx, "SQL query", KindClient)
+	child.SetAttr("db.statement", "select 1")
+	child.SetError(errors.New("failed"))
+	child.End()
+	server.SetAttr("http.status_code", 200)
+	server.End()
+	if err := Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var spans []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []otlpAttr `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []map[string]interface{} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err = json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range req.ResourceSpans {
+			if rs.Resource.Attributes[0].Value["stringValue"] != "test" {
+				t.Errorf("unexpected resource %v", rs.Resource.Attributes)
+			}
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans, got %d", len(spans))
+	}
+	sql, srv := spans[0], spans[1]
+	if srv["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || srv["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Errorf("server span should continue upstream trace: %v", srv)
+	}
+	if sql["traceId"] != srv["traceId"] || sql["parentSpanId"] != srv["spanId"] {
+		t.Errorf("sql span should be child of server span: %v", sql)
+	}
+	if status := sql["status"].(map[string]interface{}); status["code"] != float64(StatusError) || status["message"] != "failed" {
+		t.Errorf("unexpected status %v", status)
+	}
+	if sql["kind"] != float64(KindClient) {
+		t.Errorf("unexpected kind %v", sql["kind"])
+	}
+}
+
+func TestAbandon(t *testing.T) {
+	if err := Setup(Config{Service: "test", File: filepath.Join(t.TempDir(), "trace.json")}); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown()
+	ended := Start(context.Background(), "ended", KindInternal)
+	ended.End()
+	ended.Abandon()
+	if ended.status != StatusUnset {
+		t.Error("abandon should not touch an ended span")
+	}
+	leak := Start(context.Background(), "leak", KindInternal)
+	leak.Abandon()
+	if !leak.ended || leak.status != StatusError {
+		t.Error("leaked span should be ended with error")
+	}
+	var disabled *Span
+	disabled.Abandon()
+}
@@ -1,26 +1,77 @@
 package vm
 
 import (
+	"errors"
+	"lightbox/auth"
 	"lightbox/httputil"
+	"lightbox/loghub"
+	"lightbox/sandbox"
 	"net/http"
 )
 
 var (
-	adminAPIs = map[string]http.Handler{
-		"create": httputil.HandleJSONWithRequestAndVars(createApplet),
+	adminAPIs = map[string]guardedAPI{
+		"/create":   {auth.RoleAdmin, httputil.HandleJSONWithRequestAndVars(createApplet)},
+		"/applets":  {auth.RoleViewer, httputil.HandleJSONWithRequestAndVars(listApplets)},
+		"/shutdown": {auth.RoleAdmin, httputil.HandleJSONWithRequestAndVars(shutdownApplet)},
+		//日志转发的状态
+		"/log/sinks": {auth.RoleViewer, loghub.NewSinkStatusHandler()},
+		//日志订阅者的队列及丢弃统计
+		"/log/subscribers": {auth.RoleViewer, http.HandlerFunc(subscriberStats)},
+		//VirtualHost汇总的健康检查
+		"/health/live":  {auth.RoleNone, hostHealth(sandbox.HealthLive)},
+		"/health/ready": {auth.RoleNone, hostHealth(sandbox.HealthReady)},
 	}
 )
 
 type CreateAppletArg struct {
-	Name string
-	Root string
-	Env  map[string]string
+	Name      string
+	Root      string
+	Env       map[string]string
+	Isolation IsolationMode
 }
 type CreateAppletResponse struct {
 	Status  string
 	Message string
 }
 
+type ShutdownAppletArg struct {
+	Name   string
+	Reason string
+}
+
 func createApplet(r *http.Request, vars map[string]string, arg *CreateAppletArg) (*CreateAppletResponse, error) {
-	return nil, nil
+	if arg == nil || arg.Name == "" {
+		return nil, errors.New("require sandbox name")
+	}
+	handle, err := manager.Start(AppOption{
+		Option:    sandbox.Option{Name: arg.Name, Root: arg.Root, Environ: arg.Env},
+		Isolation: arg.Isolation,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CreateAppletResponse{Status: handle.Status().State, Message: "created"}, nil
+}
+
+func listApplets(r *http.Request, vars map[string]string, arg interface{}) ([]AppletStatus, error) {
+	return manager.List(), nil
+}
+
+func shutdownApplet(r *http.Request, vars map[string]string, arg *ShutdownAppletArg) (*CreateAppletResponse, error) {
+	if arg == nil || arg.Name == "" {
+		return nil, errors.New("require sandbox name")
+	}
+	reason := arg.Reason
+	if reason == "" {
+		reason = "console shutdown"
+	}
+	if err := manager.Shutdown(arg.Name, reason); err != nil {
+		return nil, err
+	}
+	return &CreateAppletResponse{Status: StateStopped, Message: "shutdown"}, nil
+}
+
+func subscriberStats(w http.ResponseWriter, r *http.Request) {
+	loghub.NewSubscriberStatsHandler(subscriber).ServeHTTP(w, r)
 }
@@ -1,15 +1,21 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"io/fs"
+	"lightbox/auth"
 	"lightbox/ext"
 	"lightbox/ext/modman"
 	"lightbox/loghub"
 	"lightbox/sandbox"
+	"net/http"
+	"os"
 	"path/filepath"
+	"sort"
+	"time"
 )
 
 const (
@@ -20,21 +26,36 @@ type AppOption struct {
 	sandbox.Option
 	Modules  []string          `json:"stdModules,omitempty" yaml:"stdModules"` //启用的模块
 	Requires []*modman.Require `json:"requires,omitempty" yaml:"requires"`
+	//Isolation 隔离方式,为空时使用VirtualHost的配置
+	Isolation IsolationMode `json:"isolation,omitempty" yaml:"isolation"`
+	//MemoryLimit 子进程内存上限(字节),为0时使用VirtualHost的配置
+	MemoryLimit int64 `json:"memoryLimit,omitempty" yaml:"memoryLimit"`
 }
 
 type VirtualHost struct {
-	manager    ConcurrencyMap[string, *sandbox.Applet]
+	manager    ConcurrencyMap[string, AppletHandle]
 	RepoSource string //仓库目录(zip压缩包的目录）
 	RepoDest   string //导入文件的目录
+	RootDir    string //applet所在的磁盘目录
+	Isolation  IsolationMode
+	Child      ChildOption //子进程隔离配置
 	rootFS     fs.FS
 	publicFS   []fs.FS
 }
 
+func (v *VirtualHost) getRootFS() fs.FS {
+	if v.rootFS == nil {
+		v.rootFS = os.DirFS(v.RootDir)
+	}
+	return v.rootFS
+}
+
+// NewApplet 创建进程内运行的applet(不注册到VirtualHost)
 func (v *VirtualHost) NewApplet(opt AppOption) (*sandbox.Applet, error) {
-	if app, ok := v.manager.Get(opt.Name); ok {
-		return app, fmt.Errorf("sandbox [%s] exists", opt.Name)
+	if _, ok := v.manager.Get(opt.Name); ok {
+		return nil, fmt.Errorf("sandbox [%s] exists", opt.Name)
 	}
-	f, err := fs.Sub(v.rootFS, opt.Root)
+	f, err := fs.Sub(v.getRootFS(), opt.Root)
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +63,17 @@ func (v *VirtualHost) NewApplet(opt AppOption) (*sandbox.Applet, error) {
 	if err != nil {
 		return app, err
 	}
+	app.SetBaseDir(filepath.Join(v.RootDir, opt.Root))
+	if opt.Log != nil {
+		logOpt := *opt.Log
+		//日志文件的相对路径相对于applet的根目录
+		if logOpt.File != "" && !filepath.IsAbs(logOpt.File) {
+			logOpt.File = filepath.Join(v.RootDir, opt.Root, logOpt.File)
+		}
+		if err = app.SetLogOption(&logOpt); err != nil {
+			return nil, err
+		}
+	}
 	mm, transpiler, hooks := ext.RegistryTable.GetAll(app, opt.Modules...)
 	var importers modman.ImportChain
 	//第三方包导入路径初始化
@@ -60,40 +92,158 @@ func (v *VirtualHost) NewApplet(opt AppOption) (*sandbox.Applet, error) {
 	app.WithModule(mm, importers).WithTranspiler(transpiler...).WithHook(hooks...)
 	return app, nil
 }
+
+// Start 按隔离方式启动applet并注册到VirtualHost
+// 子进程模式下子进程加载lego的全部模块,Modules/Requires不生效
+func (v *VirtualHost) Start(opt AppOption) (AppletHandle, error) {
+	if _, ok := v.manager.Get(opt.Name); ok {
+		return nil, fmt.Errorf("sandbox [%s] exists", opt.Name)
+	}
+	isolation := opt.Isolation
+	if isolation == "" {
+		isolation = v.Isolation
+	}
+	var handle AppletHandle
+	switch isolation {
+	case IsolationProcess:
+		root, err := filepath.Abs(filepath.Join(v.RootDir, opt.Root))
+		if err != nil {
+			return nil, err
+		}
+		child := newChildApplet(opt, root, v.Child)
+		if err = child.start(); err != nil {
+			return nil, err
+		}
+		handle = child
+	case IsolationNone, "":
+		app, err := v.NewApplet(opt)
+		if err != nil {
+			return nil, err
+		}
+		for k, val := range opt.Environ {
+			app.Context.Set(k, val)
+		}
+		//启动时初始化,就绪检查不必等到第一次执行
+		app.Initialize()
+		handle = &localApplet{app: app, startedAt: time.Now()}
+	default:
+		return nil, fmt.Errorf("unknown isolation mode %s", isolation)
+	}
+	v.manager.Set(opt.Name, handle)
+	return handle, nil
+}
+
+// Attach 注册已创建的进程内applet
+func (v *VirtualHost) Attach(app *sandbox.Applet) (AppletHandle, error) {
+	if _, ok := v.manager.Get(app.Name); ok {
+		return nil, fmt.Errorf("sandbox [%s] exists", app.Name)
+	}
+	handle := &localApplet{app: app, startedAt: time.Now()}
+	v.manager.Set(app.Name, handle)
+	return handle, nil
+}
+
+// Get 获取applet
+func (v *VirtualHost) Get(name string) (AppletHandle, bool) {
+	return v.manager.Get(name)
+}
+
+// List 所有applet的状态
+func (v *VirtualHost) List() []AppletStatus {
+	var status []AppletStatus
+	v.manager.Range(func(_ string, handle AppletHandle) bool {
+		status = append(status, handle.Status())
+		return true
+	})
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
+
 func (v *VirtualHost) Shutdown(name, reason string) error {
 	app, ok := v.manager.Get(name)
 	if !ok {
 		return fmt.Errorf("sandbox [%s] not exists", name)
 	}
-	app.Shutdown(reason)
 	v.manager.Remove(name)
-	return nil
+	return app.Shutdown(reason)
+}
+
+// ShutdownAll 关闭所有applet
+func (v *VirtualHost) ShutdownAll(reason string) {
+	for _, st := range v.List() {
+		if err := v.Shutdown(st.Name, reason); err != nil {
+			log.WithField(sandboxName, st.Name).Error("shutdown applet error:", err)
+		}
+	}
 }
 
 var manager = new(VirtualHost)
+
+// SetVirtualHost 设置管理API使用的VirtualHost
+func SetVirtualHost(vh *VirtualHost) {
+	manager = vh
+}
+
 var subscriber loghub.LogSubscriber
 
-func RegisterAPI(router *mux.Router) {
+// SetSubscriber 设置日志订阅(/log接口)
+func SetSubscriber(s loghub.LogSubscriber) {
+	subscriber = s
+}
+
+// ErrNoGuard 控制台及applet API必须启用认证,未提供Guard时不注册
+var ErrNoGuard = errors.New("console API requires an auth guard")
+
+// guardedAPI 需要指定角色才能访问的API,RoleNone表示无需认证(如健康检查)
+type guardedAPI struct {
+	role    auth.Role
+	handler http.Handler
+}
+
+func (api guardedAPI) protect(g *auth.Guard, action string) http.Handler {
+	if api.role == auth.RoleNone {
+		return api.handler
+	}
+	return g.Protect(api.role, action, api.handler)
+}
+
+// RegisterAPI 注册控制台及applet API,g为nil时返回ErrNoGuard
+func RegisterAPI(router *mux.Router, g *auth.Guard) error {
 	if router == nil {
-		return
+		return nil
 	}
-	RegisterAdminAPI(router.PathPrefix("/console").Subrouter())
-	RegisterUserAPI(router.PathPrefix("/applet/{sandbox}/").Subrouter())
+	if err := RegisterAdminAPI(router.PathPrefix("/console").Subrouter(), g); err != nil {
+		return err
+	}
+	return RegisterUserAPI(router.PathPrefix(childAppletPrefix+"{sandbox}/").Subrouter(), g)
 }
 
-func RegisterAdminAPI(router *mux.Router) {
+func RegisterAdminAPI(router *mux.Router, g *auth.Guard) error {
 	if router == nil {
-		return
+		return nil
+	}
+	if g == nil {
+		return ErrNoGuard
 	}
-	for p, h := range adminAPIs {
-		router.Handle(p, h)
+	for p, api := range adminAPIs {
+		router.Handle(p, api.protect(g, "console:"+p))
 	}
+	return nil
 }
-func RegisterUserAPI(router *mux.Router) {
+func RegisterUserAPI(router *mux.Router, g *auth.Guard) error {
 	if router == nil {
-		return
+		return nil
 	}
-	for p, h := range appletAPIs {
-		router.Handle(p, h)
+	if g == nil {
+		return ErrNoGuard
 	}
+	for p, api := range appletAPIs {
+		router.Handle(p, api.protect(g, "applet:"+p))
+	}
+	for p, api := range appletConsoleAPIs {
+		router.Handle(p, api.protect(g, "applet:"+p))
+	}
+	return nil
 }
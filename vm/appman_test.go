@@ -1,14 +1,20 @@
 package vm
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/d5/tengo/v2/stdlib"
 	"github.com/gorilla/mux"
+	"lightbox/auth"
 	"lightbox/ext"
 	"lightbox/httputil"
 	"lightbox/sandbox"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -24,20 +30,69 @@ func TestFullJsonHandler(t *testing.T) {
 
 	router := mux.NewRouter()
 	router.Handle("/log/{sandbox}", httputil.HandleJSONWithRequestAndVars(func(request *http.Request, m map[string]string, a arg) (response, error) {
-		fmt.Printf("%+v", a)
 		return response{Vars: m, arg: a}, nil
 	}))
 	router.Handle("/logm/{sandbox}", httputil.HandleJSONWithRequestAndVars(func(request *http.Request, m map[string]string, a *arg) (*response, error) {
-		fmt.Printf("%+v", a)
 		return &response{Vars: m, arg: *a}, nil
 	}))
-	http.ListenAndServe(":8088", router)
+	for _, p := range []string{"/log/box", "/logm/box"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, p, strings.NewReader(`{"name":"tom","age":3}`)))
+		var ret httputil.JSONResponse[response]
+		if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret.Code != 200 || ret.Data.Vars["sandbox"] != "box" || ret.Data.Name != "tom" || ret.Data.Age != 3 {
+			t.Errorf("%s: unexpected response %s", p, w.Body)
+		}
+	}
 }
 
 func TestAPI(t *testing.T) {
 	router := mux.NewRouter()
-	RegisterAPI(router)
-	http.ListenAndServe(":8088", router)
+	if err := RegisterAPI(router, auth.NewGuard(nil)); err != nil {
+		t.Fatal(err)
+	}
+	vars := regexp.MustCompile(`\{[^}]+}`)
+	count := 0
+	if err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		//健康检查不需要认证
+		if err != nil || route.GetHandler() == nil || strings.Contains(tpl, "/health/") {
+			return nil
+		}
+		method := http.MethodGet
+		if methods, _ := route.GetMethods(); len(methods) > 0 {
+			method = methods[0]
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, vars.ReplaceAllString(tpl, "demo"), nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expect 401 without credential, got %d", method, tpl, w.Code)
+		}
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("no api registered")
+	}
+}
+
+func TestRegisterAPIRequiresGuard(t *testing.T) {
+	router := mux.NewRouter()
+	if err := RegisterAPI(router, nil); err != ErrNoGuard {
+		t.Fatalf("expect ErrNoGuard, got %v", err)
+	}
+	if err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if tpl, _ := route.GetPathTemplate(); tpl != "/console" {
+			t.Errorf("route %s should not be registered without guard", tpl)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func TestSubRouter(t *testing.T) {
@@ -50,7 +105,13 @@ func TestSubRouter(t *testing.T) {
 	sub2.HandleFunc("/exec", func(writer http.ResponseWriter, request *http.Request) {
 		writer.Write([]byte(fmt.Sprint(mux.Vars(request))))
 	})
-	http.ListenAndServe(":8089", router)
+	for p, want := range map[string]string{"/a/p1": "p1", "/box/demo/exec": "map[sandbox:demo]"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Body.String() != want {
+			t.Errorf("%s: expect %s, got %s", p, want, w.Body)
+		}
+	}
 }
 
 func TestHost(t *testing.T) {
@@ -79,7 +140,7 @@ func TestVirtualHost_NewApplet(t *testing.T) {
 
 	c, e := app.Run([]byte(`
 import(log,fmt,database)
-`), "main.tengo")
+`), nil, "main.tengo")
 	fmt.Println(c, e)
 	app.WithHook(sandbox.NewHook(sandbox.SigInitialized, func(applet *sandbox.Applet) error {
 		fmt.Println(app.Name, "applet initialize")
@@ -91,3 +152,15 @@ import(log,fmt,database)
 	}))
 	app.Shutdown("shutdown with")
 }
+
+func TestVirtualHost_StartInitialized(t *testing.T) {
+	vh := &VirtualHost{RootDir: t.TempDir()}
+	handle, err := vh.Start(AppOption{Option: sandbox.Option{Name: "ready", Root: "."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Shutdown("test")
+	if report := handle.Health(context.Background(), sandbox.HealthReady); report.Status != sandbox.HealthUp {
+		t.Fatalf("started applet should be ready: %+v", report)
+	}
+}
@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"lightbox/httputil"
+	"lightbox/loghub"
+	"lightbox/sandbox"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// 父子进程之间的本地socket协议(http over unix socket)
+const (
+	childExecPath     = "/exec"
+	childLogPath      = "/log"
+	childHealthPath   = "/health"
+	childShutdownPath = "/shutdown"
+	childLivePath     = "/health/live"
+	childReadyPath    = "/health/ready"
+	childAppletPrefix = "/applet/"
+)
+
+// ChildHealth 子进程健康状态
+type ChildHealth struct {
+	Name       string    `json:"name"`
+	Pid        int       `json:"pid"`
+	StartedAt  time.Time `json:"startedAt"`
+	Goroutines int       `json:"goroutines"`
+	HeapAlloc  uint64    `json:"heapAlloc"`
+}
+
+// ServeChild 子进程模式:在本地socket上提供exec/log/health服务,直到父进程要求关闭或ctx结束
+func ServeChild(ctx context.Context, app *sandbox.Applet, socket string) error {
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	startedAt := time.Now()
+	hub := loghub.NewLogSubscriber()
+
+	app.Initialize()
+	//子进程中只有一个applet,控制台接口由父进程认证后转发
+	vh := &VirtualHost{}
+	if _, err = vh.Attach(app); err != nil {
+		return err
+	}
+	SetVirtualHost(vh)
+	router := mux.NewRouter()
+	appletRouter := router.PathPrefix(childAppletPrefix + "{sandbox}/").Subrouter()
+	for p, api := range appletConsoleAPIs {
+		appletRouter.Handle(p, api.handler)
+	}
+	router.Handle(childExecPath, httputil.HandleJSON(func(arg *RunFileArg) (*RunFileResult, error) {
+		if arg == nil {
+			return nil, errors.New("require file name")
+		}
+		result, err := execFile(ctx, app, arg.FileName, arg.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &RunFileResult{Result: result}, nil
+	}))
+	router.HandleFunc(childHealthPath, func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		httputil.WriteJSON(w, http.StatusOK, &ChildHealth{
+			Name:       app.Name,
+			Pid:        os.Getpid(),
+			StartedAt:  startedAt,
+			Goroutines: runtime.NumGoroutine(),
+			HeapAlloc:  mem.HeapAlloc,
+		})
+	})
+	router.Handle(childLivePath, HealthHandler(func(r *http.Request) (sandbox.HealthReport, bool) {
+		return app.CheckHealth(r.Context(), sandbox.HealthLive), true
+	}))
+	router.Handle(childReadyPath, HealthHandler(func(r *http.Request) (sandbox.HealthReport, bool) {
+		return app.CheckHealth(r.Context(), sandbox.HealthReady), true
+	}))
+	router.HandleFunc(childLogPath, func(w http.ResponseWriter, r *http.Request) {
+		streamLog(w, r, hub)
+	})
+	router.HandleFunc(childShutdownPath, func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteJSON(w, http.StatusOK, &httputil.JSONResponse[interface{}]{Code: 200, Message: "shutting down"})
+		cancel()
+	})
+
+	server := &http.Server{Handler: router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	app.Logger().WithField("socket", socket).Info("serve child applet")
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	app.Shutdown("parent request")
+	_ = os.Remove(socket)
+	return err
+}
+
+// streamLog 以ndjson的方式把日志推送给父进程
+func streamLog(w http.ResponseWriter, r *http.Request, hub loghub.LogSubscriber) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sub := hub.Subscribe("child:"+r.RemoteAddr, loghub.SubscribeOption{Capacity: 1024})
+	defer hub.Unsubscribe(sub)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(loghub.EntryToMap(entry)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
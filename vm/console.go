@@ -0,0 +1,23 @@
+package vm
+
+import (
+	"embed"
+	"github.com/gorilla/mux"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed console
+var consoleFS embed.FS
+
+// RegisterConsole 注册web控制台页面(静态页面无需认证,数据均来自管理API)
+func RegisterConsole(router *mux.Router, prefix string) {
+	if router == nil {
+		return
+	}
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	sub, _ := fs.Sub(consoleFS, "console")
+	router.Handle(strings.TrimSuffix(prefix, "/"), http.RedirectHandler(prefix, http.StatusMovedPermanently))
+	router.PathPrefix(prefix).Handler(http.StripPrefix(prefix, http.FileServer(http.FS(sub))))
+}
@@ -0,0 +1,124 @@
+package vm
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"lightbox/ext/cronlib"
+	"lightbox/httputil"
+	"lightbox/sandbox"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type CronJobArg struct {
+	Service string
+	Job     string
+}
+
+type RunSourceArg struct {
+	Source string
+	Args   map[string]interface{}
+}
+
+// appletHandler 在applet所在的进程中处理请求:进程内applet直接处理,子进程applet转发到子进程的同名接口
+func appletHandler(route string, h func(*sandbox.Applet) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)[sandboxName]
+		handle, ok := manager.Get(name)
+		if !ok {
+			httputil.WriteJSON(w, http.StatusOK, &httputil.JSONResponse[interface{}]{Code: http.StatusNotFound, Message: "sandbox [" + name + "] not found"})
+			return
+		}
+		switch hh := handle.(type) {
+		case *localApplet:
+			h(hh.app).ServeHTTP(w, r)
+		case *childApplet:
+			hh.proxy(childAppletPrefix+name+route).ServeHTTP(w, r)
+		default:
+			httputil.WriteJSON(w, http.StatusOK, &httputil.JSONResponse[interface{}]{Code: http.StatusNotImplemented, Message: "unsupported applet"})
+		}
+	})
+}
+
+// appletJSON 在applet所在的进程中处理json请求
+func appletJSON[TArg any, TResponse any](route string, h func(*sandbox.Applet, *http.Request, TArg) (TResponse, error)) http.Handler {
+	return appletHandler(route, func(app *sandbox.Applet) http.Handler {
+		return httputil.HandleJSONWithRequestAndVars(func(r *http.Request, vars map[string]string, arg TArg) (TResponse, error) {
+			return h(app, r, arg)
+		})
+	})
+}
+
+func appletConfig(app *sandbox.Applet, r *http.Request, arg interface{}) (interface{}, error) {
+	return maskSecrets(app.Config()), nil
+}
+
+func listCron(app *sandbox.Applet, r *http.Request, arg interface{}) ([]cronlib.ServiceInfo, error) {
+	return cronlib.Services(app), nil
+}
+
+func cronAction(action func(*cronlib.CronService, string) error) func(*sandbox.Applet, *http.Request, *CronJobArg) (*CreateAppletResponse, error) {
+	return func(app *sandbox.Applet, r *http.Request, arg *CronJobArg) (*CreateAppletResponse, error) {
+		if arg == nil || arg.Service == "" || arg.Job == "" {
+			return nil, errors.New("require service and job name")
+		}
+		svc, err := cronlib.GetService(app, arg.Service)
+		if err != nil {
+			return nil, err
+		}
+		if err = action(svc, arg.Job); err != nil {
+			return nil, err
+		}
+		return &CreateAppletResponse{Status: "ok"}, nil
+	}
+}
+
+func runSource(app *sandbox.Applet, r *http.Request, arg *RunSourceArg) (*RunFileResult, error) {
+	if arg == nil || strings.TrimSpace(arg.Source) == "" {
+		return nil, errors.New("require source")
+	}
+	result, err := execSource(r.Context(), app, arg.Source, arg.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &RunFileResult{Result: result}, nil
+}
+
+const maskedValue = "******"
+
+var (
+	secretKeyRe    = regexp.MustCompile(`(?i)(pass(word|wd)?|secret|token|credential|private|api_?key|access_?key)`)
+	urlPasswordRe  = regexp.MustCompile(`([\w.%-]+):([^@\s/:]+)@`)
+	urlPasswordRep = "${1}:" + maskedValue + "@"
+)
+
+// maskSecrets 隐藏配置中的敏感信息:敏感的键的值整体隐藏(数字等非字符串的值也隐藏),连接串中的密码部分隐藏
+func maskSecrets(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(vv))
+		for k, item := range vv {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				masked[k] = maskSecrets(item)
+			default:
+				if secretKeyRe.MatchString(k) {
+					masked[k] = maskedValue
+				} else {
+					masked[k] = maskSecrets(item)
+				}
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(vv))
+		for idx, item := range vv {
+			masked[idx] = maskSecrets(item)
+		}
+		return masked
+	case string:
+		return urlPasswordRe.ReplaceAllString(vv, urlPasswordRep)
+	}
+	return v
+}
@@ -18,6 +18,9 @@ func (m *ConcurrencyMap[TKey, TValue]) Get(key TKey) (TValue, bool) {
 func (m *ConcurrencyMap[TKey, TValue]) Set(key TKey, value TValue) {
 	m.rwMutex.Lock()
 	defer m.rwMutex.Unlock()
+	if m.m == nil {
+		m.m = make(map[TKey]TValue)
+	}
 	m.m[key] = value
 }
 func (m *ConcurrencyMap[TKey, TValue]) Remove(key TKey) {
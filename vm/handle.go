@@ -0,0 +1,112 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/d5/tengo/v2"
+	"lightbox/sandbox"
+	"time"
+)
+
+// IsolationMode applet的隔离方式
+type IsolationMode string
+
+const (
+	// IsolationNone 所有applet运行在同一个进程中
+	IsolationNone IsolationMode = "none"
+	// IsolationProcess 每个applet运行在独立的lego子进程中
+	IsolationProcess IsolationMode = "process"
+)
+
+const (
+	StateRunning    = "running"
+	StateStarting   = "starting"
+	StateRestarting = "restarting"
+	StateStopped    = "stopped"
+	StateExited     = "exited"
+)
+
+// AppletStatus applet运行状态
+type AppletStatus struct {
+	Name      string        `json:"name"`
+	Isolation IsolationMode `json:"isolation"`
+	State     string        `json:"state"`
+	Pid       int           `json:"pid,omitempty"`
+	Restarts  int           `json:"restarts"`
+	StartedAt time.Time     `json:"startedAt"`
+	LastError string        `json:"lastError,omitempty"`
+}
+
+// AppletHandle 管理API使用的applet句柄，屏蔽隔离方式的差异
+type AppletHandle interface {
+	Name() string
+	Exec(ctx context.Context, fileName string, args map[string]interface{}) (map[string]interface{}, error)
+	Status() AppletStatus
+	Health(ctx context.Context, kind sandbox.HealthKind) sandbox.HealthReport
+	Shutdown(reason string) error
+}
+
+// localApplet 进程内运行的applet
+type localApplet struct {
+	app       *sandbox.Applet
+	startedAt time.Time
+	stopped   bool
+}
+
+func (l *localApplet) Name() string {
+	return l.app.Name
+}
+
+func (l *localApplet) Exec(ctx context.Context, fileName string, args map[string]interface{}) (map[string]interface{}, error) {
+	return execFile(ctx, l.app, fileName, args)
+}
+
+func (l *localApplet) Status() AppletStatus {
+	state := StateRunning
+	if l.stopped {
+		state = StateStopped
+	}
+	return AppletStatus{Name: l.app.Name, Isolation: IsolationNone, State: state, StartedAt: l.startedAt}
+}
+
+func (l *localApplet) Health(ctx context.Context, kind sandbox.HealthKind) sandbox.HealthReport {
+	return l.app.CheckHealth(ctx, kind)
+}
+
+func (l *localApplet) Shutdown(reason string) error {
+	l.app.Shutdown(reason)
+	l.stopped = true
+	return nil
+}
+
+// execFile 运行脚本并返回脚本中的全局变量
+func execFile(ctx context.Context, app *sandbox.Applet, fileName string, args map[string]interface{}) (map[string]interface{}, error) {
+	compiled, err := app.RunFileContext(ctx, fileName, args)
+	if err != nil {
+		return nil, err
+	}
+	return compiledResult(compiled, args), nil
+}
+
+// execSource 运行脚本源码(控制台的脚本运行器)
+func execSource(ctx context.Context, app *sandbox.Applet, src string, args map[string]interface{}) (map[string]interface{}, error) {
+	compiled, err := app.RunContext(ctx, []byte(src), args, "console.tengo")
+	if err != nil {
+		return nil, err
+	}
+	return compiledResult(compiled, args), nil
+}
+
+// compiledResult 脚本中的全局变量(忽略参数及无法序列化为json的变量,如导入的模块)
+func compiledResult(compiled *tengo.Compiled, args map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, v := range compiled.GetAll() {
+		if _, isArg := args[v.Name()]; isArg {
+			continue
+		}
+		if _, err := json.Marshal(v.Value()); err == nil {
+			result[v.Name()] = v.Value()
+		}
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"lightbox/httputil"
+	"lightbox/sandbox"
+	"net/http"
+	"sync"
+)
+
+// VirtualHostHealth 所有applet的健康状态,任一applet失败即为失败
+type VirtualHostHealth struct {
+	Status  string                 `json:"status"`
+	Applets []sandbox.HealthReport `json:"applets"`
+}
+
+// Health 并发检查所有applet
+func (v *VirtualHost) Health(ctx context.Context, kind sandbox.HealthKind) VirtualHostHealth {
+	var handles []AppletHandle
+	v.manager.Range(func(_ string, handle AppletHandle) bool {
+		handles = append(handles, handle)
+		return true
+	})
+	result := VirtualHostHealth{Status: sandbox.HealthUp, Applets: make([]sandbox.HealthReport, len(handles))}
+	var wg sync.WaitGroup
+	for idx, handle := range handles {
+		wg.Add(1)
+		go func(idx int, handle AppletHandle) {
+			defer wg.Done()
+			result.Applets[idx] = handle.Health(ctx, kind)
+		}(idx, handle)
+	}
+	wg.Wait()
+	for _, r := range result.Applets {
+		if !r.IsUp() {
+			result.Status = sandbox.HealthDown
+		}
+	}
+	return result
+}
+
+// HealthHandler 输出健康报告,失败时返回503
+func HealthHandler(check func(r *http.Request) (sandbox.HealthReport, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ok := check(r)
+		if !ok {
+			httputil.WriteJSON(w, http.StatusNotFound, &httputil.JSONResponse[interface{}]{Code: http.StatusNotFound, Message: "sandbox not found"})
+			return
+		}
+		code := http.StatusOK
+		if !report.IsUp() {
+			code = http.StatusServiceUnavailable
+		}
+		httputil.WriteJSON(w, code, report)
+	})
+}
+
+func appletHealth(kind sandbox.HealthKind) http.Handler {
+	return HealthHandler(func(r *http.Request) (sandbox.HealthReport, bool) {
+		name := mux.Vars(r)[sandboxName]
+		handle, ok := manager.Get(name)
+		if !ok {
+			return sandbox.HealthReport{}, false
+		}
+		return handle.Health(r.Context(), kind), true
+	})
+}
+
+func hostHealth(kind sandbox.HealthKind) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := manager.Health(r.Context(), kind)
+		code := http.StatusOK
+		if result.Status != sandbox.HealthUp {
+			code = http.StatusServiceUnavailable
+		}
+		httputil.WriteJSON(w, code, result)
+	})
+}
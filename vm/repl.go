@@ -0,0 +1,112 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"lightbox/loghub"
+	"lightbox/sandbox"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const replPrompt = ">> "
+
+// REPLResult 远程REPL每次执行的结果
+type REPLResult struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewREPLHandler 基于websocket的远程REPL,每条文本消息为一段代码,返回REPLResult
+// 认证由调用者负责(需要admin权限)
+func NewREPLHandler(app *sandbox.Applet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{CheckOrigin: loghub.CheckOrigin}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		logger := app.Logger().WithField("remote", r.RemoteAddr)
+		logger.Info("remote repl attached")
+		defer logger.Info("remote repl detached")
+		out := &bytes.Buffer{}
+		repl := sandbox.NewREPL(app, out)
+		for {
+			_, src, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			out.Reset()
+			result := REPLResult{}
+			if err = repl.Eval(r.Context(), src); err != nil {
+				result.Error = err.Error()
+			}
+			result.Output = out.String()
+			if err = ws.WriteJSON(&result); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// ServeREPL 在本地socket上提供REPL(按行执行),socket文件只允许当前用户访问
+func ServeREPL(ctx context.Context, app *sandbox.Applet, socket string) error {
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	app.Logger().WithField("socket", socket).Info("serve repl")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				_ = os.Remove(socket)
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go serveREPLConn(ctx, app, conn)
+	}
+}
+
+func serveREPLConn(ctx context.Context, app *sandbox.Applet, conn net.Conn) {
+	defer conn.Close()
+	app.Logger().Info("local repl attached")
+	defer app.Logger().Info("local repl detached")
+	repl := sandbox.NewREPL(app, conn)
+	scanner := bufio.NewScanner(conn)
+	for {
+		if _, err := fmt.Fprint(conn, replPrompt); err != nil {
+			return
+		}
+		if !scanner.Scan() {
+			return
+		}
+		if err := repl.Eval(ctx, scanner.Bytes()); err != nil {
+			if _, err = fmt.Fprintln(conn, err.Error()); err != nil {
+				return
+			}
+		}
+	}
+}
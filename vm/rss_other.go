@@ -0,0 +1,10 @@
+//go:build !linux
+
+package vm
+
+import "errors"
+
+// processRSS 非linux平台暂不支持内存限制
+func processRSS(pid int) (int64, error) {
+	return 0, errors.New("process rss unsupported on this platform")
+}
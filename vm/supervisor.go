@@ -0,0 +1,504 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"lightbox/httputil"
+	"lightbox/sandbox"
+	"net"
+	"net/http"
+	stdhttputil "net/http/httputil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RestartMode 子进程重启策略
+type RestartMode string
+
+const (
+	RestartAlways    RestartMode = "always"
+	RestartOnFailure RestartMode = "on-failure"
+	RestartNever     RestartMode = "never"
+)
+
+// RestartPolicy 重启策略,重启间隔从MinBackoff开始指数增长,最大MaxBackoff
+type RestartPolicy struct {
+	Mode       RestartMode   `json:"mode" yaml:"mode"`
+	MaxRetries int           `json:"maxRetries" yaml:"maxRetries"` //连续重启的最大次数,0不限制
+	MinBackoff time.Duration `json:"minBackoff" yaml:"minBackoff"`
+	MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+	//ResetAfter 子进程稳定运行超过该时间后,重置连续重启计数
+	ResetAfter time.Duration `json:"resetAfter" yaml:"resetAfter"`
+}
+
+var DefaultRestartPolicy = RestartPolicy{
+	Mode:       RestartOnFailure,
+	MinBackoff: time.Second,
+	MaxBackoff: time.Minute,
+	ResetAfter: 5 * time.Minute,
+}
+
+func (p RestartPolicy) shouldRestart(failed bool, retries int) bool {
+	if p.MaxRetries > 0 && retries >= p.MaxRetries {
+		return false
+	}
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure, "":
+		return failed
+	}
+	return false
+}
+
+func (p RestartPolicy) backoff(retries int) time.Duration {
+	d := p.MinBackoff
+	if d <= 0 {
+		d = DefaultRestartPolicy.MinBackoff
+	}
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = DefaultRestartPolicy.MaxBackoff
+	}
+	for i := 0; i < retries && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// ChildOption 子进程隔离的配置
+type ChildOption struct {
+	//Command 子进程可执行文件,默认为当前进程(lego)
+	Command string `json:"command" yaml:"command"`
+	//SocketDir 本地socket所在目录,默认为系统临时目录
+	SocketDir string        `json:"socketDir" yaml:"socketDir"`
+	Restart   RestartPolicy `json:"restart" yaml:"restart"`
+	//MemoryLimit 子进程常驻内存上限(字节),超过后强制结束子进程,0不限制
+	MemoryLimit    int64         `json:"memoryLimit" yaml:"memoryLimit"`
+	HealthInterval time.Duration `json:"healthInterval" yaml:"healthInterval"`
+	StartTimeout   time.Duration `json:"startTimeout" yaml:"startTimeout"`
+}
+
+var socketNameRe = regexp.MustCompile(`[^\w.-]`)
+
+// childApplet 运行在lego子进程中的applet
+type childApplet struct {
+	opt      AppOption
+	root     string
+	option   ChildOption
+	socket   string
+	client   *http.Client
+	logger   *log.Entry
+	mx       sync.Mutex
+	cmd      *exec.Cmd
+	outputs  []io.Closer //子进程stdout/stderr写入日志的管道,进程退出后关闭
+	status   AppletStatus
+	stopping bool
+	stopped  chan struct{} //Shutdown时关闭,中断重启前的等待
+	done     chan struct{}
+}
+
+func newChildApplet(opt AppOption, root string, option ChildOption) *childApplet {
+	if option.Command == "" {
+		option.Command, _ = os.Executable()
+	}
+	if option.SocketDir == "" {
+		option.SocketDir = os.TempDir()
+	}
+	if option.HealthInterval <= 0 {
+		option.HealthInterval = 5 * time.Second
+	}
+	if option.StartTimeout <= 0 {
+		option.StartTimeout = 30 * time.Second
+	}
+	if opt.MemoryLimit > 0 {
+		option.MemoryLimit = opt.MemoryLimit
+	}
+	socket := filepath.Join(option.SocketDir, fmt.Sprintf("lego-%d-%s.sock", os.Getpid(), socketNameRe.ReplaceAllString(opt.Name, "_")))
+	c := &childApplet{
+		opt:     opt,
+		root:    root,
+		option:  option,
+		socket:  socket,
+		logger:  log.WithField(sandboxName, opt.Name),
+		status:  AppletStatus{Name: opt.Name, Isolation: IsolationProcess, State: StateStarting},
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", c.socket)
+			},
+		},
+	}
+	return c
+}
+
+func (c *childApplet) Name() string {
+	return c.opt.Name
+}
+
+func (c *childApplet) Status() AppletStatus {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.status
+}
+
+func (c *childApplet) Exec(ctx context.Context, fileName string, args map[string]interface{}) (map[string]interface{}, error) {
+	if st := c.Status(); st.State != StateRunning {
+		return nil, fmt.Errorf("sandbox [%s] is %s", c.opt.Name, st.State)
+	}
+	body, err := json.Marshal(&RunFileArg{FileName: fileName, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://child"+childExecPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret := &httputil.JSONResponse[*RunFileResult]{}
+	if err = json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, err
+	}
+	if ret.Code != http.StatusOK {
+		return nil, errors.New(ret.Message)
+	}
+	if ret.Data == nil {
+		return nil, nil
+	}
+	return ret.Data.Result, nil
+}
+
+// proxy 转发请求到子进程的path
+func (c *childApplet) proxy(path string) http.Handler {
+	return &stdhttputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = "child"
+			r.URL.Path = path
+			r.URL.RawPath = ""
+		},
+		Transport: c.client.Transport,
+	}
+}
+
+// Health 子进程未运行时直接返回失败,否则由子进程执行检查
+func (c *childApplet) Health(ctx context.Context, kind sandbox.HealthKind) sandbox.HealthReport {
+	report := sandbox.HealthReport{Name: c.opt.Name, Status: sandbox.HealthDown}
+	st := c.Status()
+	if st.State != StateRunning {
+		report.Checks = []sandbox.HealthCheckResult{{Name: "process", Status: sandbox.HealthDown, Error: "child process " + st.State}}
+		return report
+	}
+	path := childReadyPath
+	if kind == sandbox.HealthLive {
+		path = childLivePath
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://child"+path, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = c.client.Do(req); err == nil {
+			defer resp.Body.Close()
+			err = json.NewDecoder(resp.Body).Decode(&report)
+		}
+	}
+	if err != nil {
+		report.Status = sandbox.HealthDown
+		report.Checks = []sandbox.HealthCheckResult{{Name: "process", Status: sandbox.HealthDown, Error: err.Error()}}
+	}
+	return report
+}
+
+func (c *childApplet) Shutdown(reason string) error {
+	c.mx.Lock()
+	if c.stopping {
+		c.mx.Unlock()
+		return nil
+	}
+	c.stopping = true
+	close(c.stopped)
+	cmd := c.cmd
+	c.mx.Unlock()
+	c.logger.WithField("reason", reason).Info("shutting down child process")
+	if cmd != nil && cmd.Process != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://child"+childShutdownPath, nil)
+		if resp, err := c.client.Do(req); err == nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+		select {
+		case <-c.done:
+		case <-time.After(10 * time.Second):
+			c.logger.Warn("child process not exit in time, kill it")
+			_ = cmd.Process.Kill()
+			<-c.done
+		}
+	}
+	return nil
+}
+
+// childLogArgs 子进程只运行一个applet,applet的日志设置直接作为子进程的日志参数
+func childLogArgs(opt *sandbox.LogOption, defaultFile string) []string {
+	if opt == nil {
+		return []string{"-log_file", defaultFile}
+	}
+	file := opt.File
+	if file == "" {
+		file = defaultFile
+	}
+	args := []string{"-log_file", file}
+	if opt.Level != "" {
+		args = append(args, "-log_level", opt.Level)
+	}
+	if opt.Format != "" {
+		args = append(args, "-log_format", opt.Format)
+	}
+	if opt.MaxSize > 0 {
+		args = append(args, "-log_max_size", strconv.Itoa(opt.MaxSize))
+	}
+	if opt.MaxAge > 0 {
+		args = append(args, "-log_max_age", strconv.Itoa(opt.MaxAge))
+	}
+	if opt.MaxBackups > 0 {
+		args = append(args, "-log_max_backups", strconv.Itoa(opt.MaxBackups))
+	}
+	if opt.LocalTime {
+		args = append(args, "-log_localtime")
+	}
+	if opt.Compress {
+		args = append(args, "-log_compress")
+	}
+	return args
+}
+
+// start 启动并监控子进程,直到子进程退出且不再需要重启
+func (c *childApplet) start() error {
+	if err := c.spawn(); err != nil {
+		close(c.done)
+		return err
+	}
+	go c.supervise()
+	return nil
+}
+
+func (c *childApplet) spawn() error {
+	args := []string{
+		"-child_socket", c.socket,
+		"-child_name", c.opt.Name,
+		"-work_dir", c.root,
+	}
+	args = append(args, childLogArgs(c.opt.Log, filepath.Join(c.root, "log", socketNameRe.ReplaceAllString(c.opt.Name, "_")+".log"))...)
+	for k, v := range c.opt.Environ {
+		args = append(args, "-D", k+"="+v)
+	}
+	cmd := exec.Command(c.option.Command, args...)
+	cmd.Env = os.Environ()
+	if c.option.MemoryLimit > 0 {
+		//让子进程的GC尽量在上限以内工作
+		cmd.Env = append(cmd.Env, "GOMEMLIMIT="+strconv.FormatInt(c.option.MemoryLimit*9/10, 10))
+	}
+	stdout, stderr := c.logger.WriterLevel(log.InfoLevel), c.logger.WriterLevel(log.ErrorLevel)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		closeOutputs(stdout, stderr)
+		return err
+	}
+	c.mx.Lock()
+	c.cmd = cmd
+	c.outputs = []io.Closer{stdout, stderr}
+	c.status.Pid = cmd.Process.Pid
+	c.status.StartedAt = time.Now()
+	c.mx.Unlock()
+	c.logger.WithField("pid", cmd.Process.Pid).Info("child process started")
+	return nil
+}
+
+func (c *childApplet) setState(state string, err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.status.State = state
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+}
+
+func (c *childApplet) supervise() {
+	defer close(c.done)
+	retries := 0
+	for {
+		c.mx.Lock()
+		cmd, outputs := c.cmd, c.outputs
+		c.mx.Unlock()
+		exited := make(chan error, 1)
+		go func() {
+			err := cmd.Wait()
+			//Wait返回时输出已经复制完成
+			closeOutputs(outputs...)
+			exited <- err
+		}()
+		err, killReason := c.monitor(cmd, exited)
+		c.mx.Lock()
+		stopping := c.stopping
+		uptime := time.Since(c.status.StartedAt)
+		c.mx.Unlock()
+		failed := err != nil || killReason != nil
+		if killReason != nil {
+			err = killReason
+		}
+		c.logger.WithField("pid", cmd.Process.Pid).WithField("error", err).Info("child process exited")
+		if stopping {
+			c.setState(StateStopped, err)
+			return
+		}
+		if c.option.Restart.ResetAfter > 0 && uptime > c.option.Restart.ResetAfter {
+			retries = 0
+		}
+		if !c.option.Restart.shouldRestart(failed, retries) {
+			c.setState(StateExited, err)
+			return
+		}
+		backoff := c.option.Restart.backoff(retries)
+		retries++
+		c.setState(StateRestarting, err)
+		c.logger.Infof("restart child process in %s (retry %d)", backoff, retries)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-c.stopped:
+			timer.Stop()
+			c.setState(StateStopped, nil)
+			return
+		}
+		if err = c.spawn(); err != nil {
+			c.setState(StateExited, err)
+			return
+		}
+		c.mx.Lock()
+		c.status.Restarts++
+		c.mx.Unlock()
+	}
+}
+
+func closeOutputs(outputs ...io.Closer) {
+	for _, o := range outputs {
+		_ = o.Close()
+	}
+}
+
+// monitor 定时检查子进程的健康状态与内存,直到子进程退出
+func (c *childApplet) monitor(cmd *exec.Cmd, exited chan error) (exitErr error, killReason error) {
+	//启动阶段使用较短的检查间隔,尽快进入running状态
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	startDeadline := time.Now().Add(c.option.StartTimeout)
+	healthFailures := 0
+	for {
+		select {
+		case exitErr = <-exited:
+			return
+		case <-ticker.C:
+		}
+		if c.option.MemoryLimit > 0 {
+			if rss, err := processRSS(cmd.Process.Pid); err == nil && rss > c.option.MemoryLimit {
+				killReason = fmt.Errorf("memory limit exceeded: rss %d > %d", rss, c.option.MemoryLimit)
+				c.logger.Error(killReason)
+				_ = cmd.Process.Kill()
+				continue
+			}
+		}
+		err := c.checkHealth()
+		state := c.Status().State
+		switch {
+		case err == nil:
+			healthFailures = 0
+			if state != StateRunning {
+				c.setState(StateRunning, nil)
+				ticker.Reset(c.option.HealthInterval)
+				go c.followLog()
+			}
+		case state != StateRunning && time.Now().Before(startDeadline):
+			//子进程仍在启动中
+		default:
+			healthFailures++
+			c.logger.Warnf("child health check failed(%d):%s", healthFailures, err)
+			if healthFailures >= 3 && killReason == nil {
+				killReason = fmt.Errorf("health check failed: %w", err)
+				_ = cmd.Process.Kill()
+			}
+		}
+	}
+}
+
+func (c *childApplet) checkHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.option.HealthInterval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://child"+childHealthPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// followLog 转发子进程的日志到当前进程(保留sandbox等字段),使日志订阅与进程内运行时一致
+func (c *childApplet) followLog() {
+	resp, err := c.client.Get("http://child" + childLogPath)
+	if err != nil {
+		c.logger.Warn("follow child log error:", err)
+		return
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		m := map[string]interface{}{}
+		if err = json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			continue
+		}
+		level, err := log.ParseLevel(fmt.Sprint(m["level"]))
+		if err != nil {
+			level = log.InfoLevel
+		}
+		msg := fmt.Sprint(m["message"])
+		entry := log.WithField("pid", c.Status().Pid)
+		if t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(m["time"])); err == nil {
+			entry = entry.WithTime(t)
+		}
+		delete(m, "level")
+		delete(m, "message")
+		delete(m, "time")
+		if _, ok := m[sandboxName]; !ok {
+			m[sandboxName] = c.opt.Name
+		}
+		entry.WithFields(m).Log(level, msg)
+	}
+}
@@ -0,0 +1,30 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	p := RestartPolicy{Mode: RestartOnFailure, MaxRetries: 3, MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	if p.shouldRestart(false, 0) {
+		t.Error("on-failure should not restart after normal exit")
+	}
+	if !p.shouldRestart(true, 2) {
+		t.Error("on-failure should restart after failure")
+	}
+	if p.shouldRestart(true, 3) {
+		t.Error("should not restart after max retries")
+	}
+	if !(RestartPolicy{Mode: RestartAlways}).shouldRestart(false, 100) {
+		t.Error("always should restart")
+	}
+	if (RestartPolicy{Mode: RestartNever}).shouldRestart(true, 0) {
+		t.Error("never should not restart")
+	}
+	for retries, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.backoff(retries); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", retries, got, want)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package vm
 
 import (
+	"errors"
+	"fmt"
+	"lightbox/auth"
+	"lightbox/ext/cronlib"
 	"lightbox/httputil"
 	"lightbox/loghub"
+	"lightbox/sandbox"
 	"net/http"
 )
 
@@ -11,12 +16,44 @@ const (
 )
 
 var (
-	appletAPIs = map[string]http.Handler{
-		"/exec": httputil.HandleJSONWithRequestAndVars(runFile),
-		"/log":  loghub.NewWebsocketSubscribeHandler(subscriber),
+	appletAPIs = map[string]guardedAPI{
+		"/exec":       {auth.RoleDeployer, httputil.HandleJSONWithRequestAndVars(runFile)},
+		"/log":        {auth.RoleViewer, http.HandlerFunc(tailLog)},
+		"/log/sse":    {auth.RoleViewer, http.HandlerFunc(sseLog)},
+		"/log/stream": {auth.RoleViewer, http.HandlerFunc(ndjsonLog)},
+		"/logs":       {auth.RoleViewer, loghub.NewQueryHandler(loghub.DefaultStore)},
+		//健康检查无需认证(供编排系统探测)
+		"/health/live":  {auth.RoleNone, appletHealth(sandbox.HealthLive)},
+		"/health/ready": {auth.RoleNone, appletHealth(sandbox.HealthReady)},
+	}
+	//在applet所在进程中执行的接口(子进程模式下由子进程提供同名接口)
+	appletConsoleAPIs = map[string]guardedAPI{
+		"/config":       {auth.RoleViewer, appletJSON("/config", appletConfig)},
+		"/cron":         {auth.RoleViewer, appletJSON("/cron", listCron)},
+		"/cron/trigger": {auth.RoleDeployer, appletJSON("/cron/trigger", cronAction((*cronlib.CronService).Trigger))},
+		"/cron/pause":   {auth.RoleDeployer, appletJSON("/cron/pause", cronAction((*cronlib.CronService).Pause))},
+		"/cron/resume":  {auth.RoleDeployer, appletJSON("/cron/resume", cronAction((*cronlib.CronService).Resume))},
+		"/cron/remove":  {auth.RoleDeployer, appletJSON("/cron/remove", cronAction((*cronlib.CronService).RemoveJob))},
+		"/run":          {auth.RoleAdmin, appletJSON("/run", runSource)},
+		"/repl":         {auth.RoleAdmin, appletHandler("/repl", NewREPLHandler)},
 	}
 )
 
+// tailLog 通过websocket订阅applet日志(子进程的日志已转发到当前进程)
+func tailLog(w http.ResponseWriter, r *http.Request) {
+	loghub.NewWebsocketSubscribeHandler(subscriber).ServeHTTP(w, r)
+}
+
+// sseLog 通过Server-Sent Events订阅applet日志
+func sseLog(w http.ResponseWriter, r *http.Request) {
+	loghub.NewSSESubscribeHandler(subscriber).ServeHTTP(w, r)
+}
+
+// ndjsonLog 通过NDJSON流订阅applet日志
+func ndjsonLog(w http.ResponseWriter, r *http.Request) {
+	loghub.NewNDJSONSubscribeHandler(subscriber).ServeHTTP(w, r)
+}
+
 type RunFileArg struct {
 	FileName string
 	Args     map[string]interface{}
@@ -26,24 +63,20 @@ type RunFileResult struct {
 }
 
 func runFile(r *http.Request, vars map[string]string, arg *RunFileArg) (*RunFileResult, error) {
-	//sandbox, ok := vars[sandboxName]
-	//if !ok || sandbox == "" {
-	//	return nil, errors.New("require sandbox name")
-	//}
-	//box, ok := manager.Get(sandbox)
-	//if !ok {
-	//	return nil, fmt.Errorf("sandbox [%s] not found", sandbox)
-	//}
-	//compiled, err := box.RunFileWithArgsContext(context.Background(), arg.FileName, arg.Args)
-	//if err != nil {
-	//	return nil, err
-	//}
-	//result := &RunFileResult{
-	//	Result: map[string]interface{}{},
-	//}
-	//for _, v := range compiled.GetAll() {
-	//	result.Result[v.Code()] = v.Value()
-	//}
-	//return result, nil
-	return nil, nil
+	sandbox, ok := vars[sandboxName]
+	if !ok || sandbox == "" {
+		return nil, errors.New("require sandbox name")
+	}
+	if arg == nil || arg.FileName == "" {
+		return nil, errors.New("require file name")
+	}
+	box, ok := manager.Get(sandbox)
+	if !ok {
+		return nil, fmt.Errorf("sandbox [%s] not found", sandbox)
+	}
+	result, err := box.Exec(r.Context(), arg.FileName, arg.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &RunFileResult{Result: result}, nil
 }